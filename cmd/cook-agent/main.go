@@ -3,7 +3,8 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,33 +12,90 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/creack/pty"
 	"github.com/gorilla/websocket"
+	"github.com/justinmoon/cook/internal/agentproto"
+	"github.com/justinmoon/cook/internal/envagent"
+	"github.com/justinmoon/cook/internal/redact"
+	"github.com/justinmoon/cook/internal/terminal"
 )
 
+// version is the agent's release, set at build time with
+// -ldflags "-X main.version=...".
+var version = "0.1.0"
+
 var (
 	listenAddr = flag.String("listen", ":7422", "address to listen on")
+	secretFile = flag.String("secret-file", "", "read the agent secret from this file and remove it (default $"+envagent.SecretEnv+")")
+	insecure   = flag.Bool("insecure", false, "accept unauthenticated connections (development only)")
+	statePath  = flag.String("state", "", "persist session metadata to this file, so a restarted agent reports which sessions died")
 	upgrader   = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+		// Connections are authenticated by credential, not origin
+		CheckOrigin:  func(r *http.Request) bool { return true },
+		Subprotocols: []string{agentproto.Subprotocol},
 	}
+	showVersion = flag.Bool("version", false, "print the version and exit")
 )
 
 func main() {
 	flag.Parse()
+	if *showVersion {
+		fmt.Println(version)
+		return
+	}
 
-	mgr := NewSessionManager()
+	secret, err := loadSecret()
+	if err != nil {
+		log.Fatal(err)
+	}
+	self, err := newAgentProcess(secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var verifier *envagent.Verifier
+	if secret != "" {
+		verifier = envagent.NewVerifier(secret)
+	} else if *insecure {
+		log.Printf("WARNING: no secret; accepting unauthenticated connections")
+	} else {
+		log.Fatalf("no agent secret: set $%s or -secret-file (or -insecure for development)", envagent.SecretEnv)
+	}
+
+	var state *stateFile
+	if *statePath != "" {
+		state = &stateFile{path: *statePath}
+	}
+	mgr := NewSessionManager(state)
+
+	// Liveness only, so it needs no credential; details come from the
+	// status RPC
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if verifier != nil {
+			if err := verifier.Verify(r.Header.Get(envagent.AuthHeader), time.Now()); err != nil {
+				log.Printf("audit: rejected connection from %s: %v", r.RemoteAddr, err)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		log.Printf("audit: accepted connection from %s", r.RemoteAddr)
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Printf("WebSocket upgrade error: %v", err)
 			return
 		}
-		handleConnection(conn, mgr)
+		handleConnection(conn, mgr, self)
 	})
 
 	log.Printf("cook-agent listening on %s (WebSocket)", *listenAddr)
@@ -46,66 +104,116 @@ func main() {
 	}
 }
 
-// Message types for the protocol
-type Message struct {
-	Type      string `json:"type"`
-	SessionID string `json:"session_id,omitempty"`
-	Command   string `json:"command,omitempty"`
-	WorkDir   string `json:"workdir,omitempty"`
-	Data      []byte `json:"data,omitempty"`
-	Rows      int    `json:"rows,omitempty"`
-	Cols      int    `json:"cols,omitempty"`
-	Error     string `json:"error,omitempty"`
-	Sessions  []string `json:"sessions,omitempty"`
+// loadSecret reads the agent secret from -secret-file, which is removed,
+// or the environment, and keeps it out of the environment sessions inherit.
+func loadSecret() (string, error) {
+	secret := os.Getenv(envagent.SecretEnv)
+	os.Unsetenv(envagent.SecretEnv)
+	if *secretFile != "" {
+		data, err := os.ReadFile(*secretFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		os.Remove(*secretFile)
+		secret = strings.TrimSpace(string(data))
+	}
+	return secret, nil
 }
 
-const (
-	MsgCreate  = "create"
-	MsgAttach  = "attach"
-	MsgDetach  = "detach"
-	MsgInput   = "input"
-	MsgOutput  = "output"
-	MsgResize  = "resize"
-	MsgList    = "list"
-	MsgOK      = "ok"
-	MsgError   = "error"
-)
+// endedRetention is how long an ended session is kept so clients can
+// learn its exit status.
+const endedRetention = time.Hour
 
 // Session represents a persistent terminal session
 type Session struct {
-	ID      string
-	Command string
-	WorkDir string
-	Cmd     *exec.Cmd
-	Pty     *os.File
-
-	mu        sync.Mutex
-	clients   map[*websocket.Conn]bool
-	closeOnce sync.Once
+	ID        string
+	Command   string
+	WorkDir   string
+	Cmd       *exec.Cmd
+	Pty       *os.File
+	StartedAt time.Time
+
+	mu       sync.Mutex
+	clients  map[*agentproto.Conn]bool
+	out      terminal.RingBuffer // output replayed to clients that attach
+	exitCode *int                // set once the process has exited
+	endedAt  time.Time
+
+	activity *terminal.ActivityMonitor
+	redact   *redact.Stream // masks the session's env values; used only by its reader
 }
 
-func (s *Session) AddClient(conn *websocket.Conn) {
+// Attach sends c the OK reply and the buffered output, then adds it to the
+// clients receiving live output. Holding the lock throughout keeps output
+// from arriving out of order or before the reply.
+func (s *Session) Attach(c *agentproto.Conn) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.clients[conn] = true
+	if s.exitCode != nil {
+		return fmt.Errorf("session exited with status %d", *s.exitCode)
+	}
+	if err := c.Send(agentproto.Message{Type: agentproto.MsgOK, SessionID: s.ID}); err != nil {
+		return err
+	}
+	if snapshot := s.out.Bytes(); len(snapshot) > 0 {
+		if err := c.Send(agentproto.Message{Type: agentproto.MsgOutput, SessionID: s.ID, Data: snapshot}); err != nil {
+			return err
+		}
+	}
+	s.clients[c] = true
+	return nil
 }
 
-func (s *Session) RemoveClient(conn *websocket.Conn) {
+func (s *Session) RemoveClient(c *agentproto.Conn) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.clients, conn)
+	delete(s.clients, c)
 }
 
 func (s *Session) Broadcast(data []byte) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	msg := Message{Type: MsgOutput, SessionID: s.ID, Data: data}
-	encoded, _ := json.Marshal(msg)
+	s.out.Append(data)
+	msg := agentproto.Message{Type: agentproto.MsgOutput, SessionID: s.ID, Data: data}
+	for c := range s.clients {
+		c.Send(msg)
+	}
+}
 
-	for conn := range s.clients {
-		conn.WriteMessage(websocket.TextMessage, encoded)
+// exited records the process's exit status and tells attached clients.
+func (s *Session) exited(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.exitCode = &code
+	s.endedAt = time.Now()
+	msg := agentproto.Message{Type: agentproto.MsgExit, SessionID: s.ID, ExitCode: &code}
+	for c := range s.clients {
+		c.Send(msg)
 	}
+	s.clients = make(map[*agentproto.Conn]bool)
+}
+
+// Info describes the session.
+func (s *Session) Info() agentproto.SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := agentproto.SessionInfo{
+		ID:        s.ID,
+		Command:   s.Command,
+		State:     agentproto.SessionRunning,
+		StartedAt: s.StartedAt,
+	}
+	if s.Cmd != nil && s.Cmd.Process != nil {
+		info.PID = s.Cmd.Process.Pid
+	}
+	if s.exitCode != nil {
+		code, ended := *s.exitCode, s.endedAt
+		info.State, info.ExitCode, info.EndedAt = agentproto.SessionExited, &code, &ended
+	}
+	return info
 }
 
 func (s *Session) Resize(rows, cols int) error {
@@ -134,25 +242,37 @@ func (s *Session) Resize(rows, cols int) error {
 type SessionManager struct {
 	mu       sync.RWMutex
 	sessions map[string]*Session
+	lost     map[string]agentproto.SessionInfo // sessions a previous agent process was running
+	state    *stateFile                        // nil unless -state is set
 }
 
-func NewSessionManager() *SessionManager {
-	return &SessionManager{
+func NewSessionManager(state *stateFile) *SessionManager {
+	m := &SessionManager{
 		sessions: make(map[string]*Session),
+		lost:     make(map[string]agentproto.SessionInfo),
+		state:    state,
+	}
+	if state != nil {
+		for _, info := range state.loadLost() {
+			m.lost[info.ID] = info
+			m.expire(info.ID, nil, *info.EndedAt)
+		}
+		m.saveLocked()
 	}
+	return m
 }
 
-func (m *SessionManager) Create(id, command, workDir string, rows, cols int) (*Session, error) {
+func (m *SessionManager) Create(id, command, workDir string, env []string, rows, cols int) (*Session, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, exists := m.sessions[id]; exists {
+	if existing, exists := m.sessions[id]; exists && existing.Info().State == agentproto.SessionRunning {
 		return nil, fmt.Errorf("session %s already exists", id)
 	}
 
 	cmd := exec.Command("/bin/sh", "-c", command)
 	cmd.Dir = workDir
-	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	cmd.Env = append(append(os.Environ(), "TERM=xterm-256color"), env...)
 
 	// Use initial size if provided, otherwise default to 24x80
 	var ws *pty.Winsize
@@ -166,18 +286,27 @@ func (m *SessionManager) Create(id, command, workDir string, rows, cols int) (*S
 		return nil, fmt.Errorf("failed to start pty: %w", err)
 	}
 
+	now := time.Now()
 	session := &Session{
-		ID:      id,
-		Command: command,
-		WorkDir: workDir,
-		Cmd:     cmd,
-		Pty:     ptmx,
-		clients: make(map[*websocket.Conn]bool),
+		ID:        id,
+		Command:   command,
+		WorkDir:   workDir,
+		Cmd:       cmd,
+		Pty:       ptmx,
+		StartedAt: now,
+		clients:   make(map[*agentproto.Conn]bool),
+		out:       terminal.NewRingBuffer(terminal.DefaultReplayBufferBytes),
+		activity:  terminal.NewActivityMonitor(now),
+		redact:    redact.New(envValues(env)).Stream(),
 	}
 
+	// An ended session with this ID is replaced
 	m.sessions[id] = session
+	delete(m.lost, id)
+	m.saveLocked()
 
-	// Read from PTY and broadcast to all clients
+	// Read from PTY and broadcast to all clients, masking secrets before
+	// they reach the replay buffer
 	go func() {
 		buf := make([]byte, 4096)
 		for {
@@ -191,43 +320,145 @@ func (m *SessionManager) Create(id, command, workDir string, rows, cols int) (*S
 			if n > 0 {
 				data := make([]byte, n)
 				copy(data, buf[:n])
-				session.Broadcast(data)
+				if data = session.redact.Redact(data); len(data) > 0 {
+					session.activity.Observe(data, time.Now())
+					session.Broadcast(data)
+				}
 			}
 		}
-		// Process ended, clean up
+		if tail := session.redact.Flush(); len(tail) > 0 {
+			session.Broadcast(tail)
+		}
+		// Process ended; keep the session a while to report how
+		cmd.Wait()
+		ptmx.Close()
+		code := cmd.ProcessState.ExitCode()
+		session.exited(code)
+		log.Printf("Session %s ended with status %d", id, code)
+
 		m.mu.Lock()
-		delete(m.sessions, id)
+		m.saveLocked()
 		m.mu.Unlock()
-		log.Printf("Session %s ended", id)
+		m.expire(id, session, time.Now())
 	}()
 
 	log.Printf("Created session %s: %s", id, command)
 	return session, nil
 }
 
+// envValues returns the values of KEY=value environment entries.
+func envValues(env []string) []string {
+	values := make([]string, 0, len(env))
+	for _, kv := range env {
+		if _, v, ok := strings.Cut(kv, "="); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// expire forgets an ended session (or a lost one, if session is nil)
+// endedRetention after it ended, unless it was replaced.
+func (m *SessionManager) expire(id string, session *Session, ended time.Time) {
+	time.AfterFunc(time.Until(ended.Add(endedRetention)), func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if session != nil && m.sessions[id] == session {
+			delete(m.sessions, id)
+		} else if _, ok := m.lost[id]; session == nil && ok && m.sessions[id] == nil {
+			delete(m.lost, id)
+		}
+		m.saveLocked()
+	})
+}
+
+// Get returns a running session.
 func (m *SessionManager) Get(id string) *Session {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.sessions[id]
+	if s := m.sessions[id]; s != nil && s.Info().State == agentproto.SessionRunning {
+		return s
+	}
+	return nil
 }
 
-func (m *SessionManager) List() []string {
+// Lookup returns a session's info, running or ended.
+func (m *SessionManager) Lookup(id string) (agentproto.SessionInfo, *Session, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	ids := make([]string, 0, len(m.sessions))
-	for id := range m.sessions {
-		ids = append(ids, id)
+	if s := m.sessions[id]; s != nil {
+		return s.Info(), s, true
+	}
+	info, ok := m.lost[id]
+	return info, nil, ok
+}
+
+// List returns the IDs of running sessions.
+func (m *SessionManager) List() []string {
+	var ids []string
+	for _, info := range m.Info() {
+		if info.State == agentproto.SessionRunning {
+			ids = append(ids, info.ID)
+		}
 	}
 	return ids
 }
 
-func handleConnection(conn *websocket.Conn, mgr *SessionManager) {
-	defer conn.Close()
+// Info describes every session, running or ended, by ID.
+func (m *SessionManager) Info() []agentproto.SessionInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.infoLocked()
+}
+
+func (m *SessionManager) infoLocked() []agentproto.SessionInfo {
+	infos := make([]agentproto.SessionInfo, 0, len(m.sessions)+len(m.lost))
+	for _, s := range m.sessions {
+		infos = append(infos, s.Info())
+	}
+	for _, info := range m.lost {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// saveLocked persists session metadata, if enabled. Callers hold m.mu.
+func (m *SessionManager) saveLocked() {
+	if m.state == nil {
+		return
+	}
+	if err := m.state.save(m.infoLocked()); err != nil {
+		log.Printf("Failed to save session state: %v", err)
+	}
+}
+
+func handleConnection(ws *websocket.Conn, mgr *SessionManager, self *agentProcess) {
+	defer ws.Close()
 
+	client := agentproto.NewConn(ws)
 	var attachedSession *Session
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rpc := &rpcConn{conn: client, ctx: ctx, mgr: mgr, self: self}
+	defer rpc.close()
+	tunnels := newTunnels(client)
+	defer tunnels.closeAll()
+
+	detach := func() {
+		if attachedSession != nil {
+			attachedSession.RemoveClient(client)
+			attachedSession = nil
+		}
+	}
+
 	for {
-		_, data, err := conn.ReadMessage()
+		msg, err := client.Read()
+		if errors.Is(err, agentproto.ErrMalformed) {
+			log.Printf("Decode error: %v", err)
+			continue
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
@@ -235,50 +466,60 @@ func handleConnection(conn *websocket.Conn, mgr *SessionManager) {
 			break
 		}
 
-		var msg Message
-		if err := json.Unmarshal(data, &msg); err != nil {
-			log.Printf("JSON decode error: %v", err)
-			continue
-		}
-
 		switch msg.Type {
-		case MsgCreate:
-			session, err := mgr.Create(msg.SessionID, msg.Command, msg.WorkDir, msg.Rows, msg.Cols)
+		case agentproto.MsgHello:
+			client.Send(agentproto.Message{Type: agentproto.MsgHello, Version: min(msg.Version, client.Version()), Features: features})
+
+		case agentproto.MsgStat, agentproto.MsgReadFile, agentproto.MsgWriteFile, agentproto.MsgData, agentproto.MsgEOF,
+			agentproto.MsgListFiles, agentproto.MsgExec, agentproto.MsgWatch, agentproto.MsgUnwatch,
+			agentproto.MsgStatus, agentproto.MsgUpdate:
+			rpc.handle(msg)
+
+		case agentproto.MsgTunnelOpen, agentproto.MsgTunnelData, agentproto.MsgTunnelClose:
+			tunnels.handle(msg)
+
+		case agentproto.MsgCreate:
+			session, err := mgr.Create(msg.SessionID, msg.Command, msg.WorkDir, msg.Env, msg.Rows, msg.Cols)
 			if err != nil {
-				sendError(conn, err.Error())
-			} else {
-				session.AddClient(conn)
-				attachedSession = session
-				sendOK(conn, msg.SessionID)
+				sendError(client, err.Error())
+				break
 			}
-
-		case MsgAttach:
-			session := mgr.Get(msg.SessionID)
-			if session == nil {
-				sendError(conn, "session not found")
-			} else {
-				if attachedSession != nil {
-					attachedSession.RemoveClient(conn)
+			detach()
+			if err := session.Attach(client); err != nil {
+				sendError(client, err.Error())
+				break
+			}
+			attachedSession = session
+
+		case agentproto.MsgAttach:
+			info, session, ok := mgr.Lookup(msg.SessionID)
+			switch {
+			case !ok:
+				sendError(client, "session not found")
+			case info.State == agentproto.SessionLost:
+				client.Send(agentproto.Message{Type: agentproto.MsgError, SessionID: msg.SessionID, Error: "session lost when the agent restarted"})
+			case info.State == agentproto.SessionExited:
+				client.Send(agentproto.Message{Type: agentproto.MsgError, SessionID: msg.SessionID, Error: fmt.Sprintf("session exited with status %d", *info.ExitCode), ExitCode: info.ExitCode})
+			default:
+				detach()
+				if err := session.Attach(client); err != nil {
+					sendError(client, err.Error())
+					break
 				}
-				session.AddClient(conn)
 				attachedSession = session
-				sendOK(conn, msg.SessionID)
 			}
 
-		case MsgDetach:
-			if attachedSession != nil {
-				attachedSession.RemoveClient(conn)
-				attachedSession = nil
-			}
-			sendOK(conn, "")
+		case agentproto.MsgDetach:
+			detach()
+			client.Send(agentproto.Message{Type: agentproto.MsgOK})
 
-		case MsgInput:
+		case agentproto.MsgInput:
 			session := mgr.Get(msg.SessionID)
 			if session != nil && session.Pty != nil {
 				session.Pty.Write(msg.Data)
 			}
 
-		case MsgResize:
+		case agentproto.MsgResize:
 			session := mgr.Get(msg.SessionID)
 			if session != nil {
 				if err := session.Resize(msg.Rows, msg.Cols); err != nil {
@@ -286,31 +527,25 @@ func handleConnection(conn *websocket.Conn, mgr *SessionManager) {
 				}
 			}
 
-		case MsgList:
-			sendList(conn, mgr.List())
+		case agentproto.MsgList:
+			client.Send(agentproto.Message{Type: agentproto.MsgList, Sessions: mgr.List(), Info: mgr.Info()})
+
+		case agentproto.MsgActivity:
+			session := mgr.Get(msg.SessionID)
+			if session == nil {
+				sendError(client, "session not found")
+			} else {
+				cfg := terminal.StuckConfig{IdleAfter: time.Duration(msg.IdleAfter) * time.Second}
+				report := session.activity.Check(cfg, time.Now())
+				client.Send(agentproto.Message{Type: agentproto.MsgActivity, SessionID: msg.SessionID, Activity: &report})
+			}
 		}
 	}
 
 	// Clean up on disconnect
-	if attachedSession != nil {
-		attachedSession.RemoveClient(conn)
-	}
-}
-
-func sendError(conn *websocket.Conn, errMsg string) {
-	msg := Message{Type: MsgError, Error: errMsg}
-	encoded, _ := json.Marshal(msg)
-	conn.WriteMessage(websocket.TextMessage, encoded)
-}
-
-func sendOK(conn *websocket.Conn, sessionID string) {
-	msg := Message{Type: MsgOK, SessionID: sessionID}
-	encoded, _ := json.Marshal(msg)
-	conn.WriteMessage(websocket.TextMessage, encoded)
+	detach()
 }
 
-func sendList(conn *websocket.Conn, sessions []string) {
-	msg := Message{Type: MsgList, Sessions: sessions}
-	encoded, _ := json.Marshal(msg)
-	conn.WriteMessage(websocket.TextMessage, encoded)
+func sendError(c *agentproto.Conn, errMsg string) {
+	c.Send(agentproto.Message{Type: agentproto.MsgError, Error: errMsg})
 }
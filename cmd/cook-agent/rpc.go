@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+)
+
+// features are the RPCs this agent serves, reported in its hello.
+var features = []string{
+	agentproto.FeatureFiles, agentproto.FeatureExec, agentproto.FeatureWatch, agentproto.FeatureTunnel,
+	agentproto.FeatureStatus, agentproto.FeatureUpdate,
+}
+
+const (
+	// readChunkSize is how much of a file each data message carries.
+	readChunkSize = 256 * 1024
+
+	// watchInterval is how often watched trees are scanned for changes.
+	watchInterval = 2 * time.Second
+)
+
+// rpcConn serves file, exec and maintenance RPCs on a connection. Exec
+// and watch run in the background until they finish or the connection
+// closes.
+type rpcConn struct {
+	conn *agentproto.Conn
+	ctx  context.Context // done when the connection closes
+	mgr  *SessionManager
+	self *agentProcess
+
+	upload    *upload
+	stopWatch func() // stops the watch and waits for it to finish
+}
+
+// upload is a write_file or update in progress. Data goes to a temporary
+// file that replaces the target at eof, so readers never see a partial
+// file.
+type upload struct {
+	path   string
+	mode   os.FileMode
+	tmp    *os.File
+	err    error
+	check  func(path string) error // if set, the temporary file must pass it
+	update bool
+}
+
+func (r *rpcConn) handle(msg *agentproto.Message) {
+	switch msg.Type {
+	case agentproto.MsgStat:
+		info, err := os.Stat(msg.Path)
+		if err != nil {
+			r.sendError(err)
+			return
+		}
+		file := fileInfo(msg.Path, info)
+		r.conn.Send(agentproto.Message{Type: agentproto.MsgStat, File: &file})
+
+	case agentproto.MsgReadFile:
+		r.readFile(msg.Path)
+
+	case agentproto.MsgWriteFile:
+		r.abortUpload()
+		mode := os.FileMode(msg.Mode).Perm()
+		if mode == 0 {
+			mode = 0644
+		}
+		r.upload = &upload{path: msg.Path, mode: mode}
+		dir := filepath.Dir(msg.Path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			r.upload.err = err
+			return
+		}
+		r.upload.tmp, r.upload.err = os.CreateTemp(dir, ".cook-write-*")
+
+	case agentproto.MsgUpdate:
+		r.abortUpload()
+		r.upload = &upload{path: r.self.exe, mode: 0755, check: checkUpdate(msg.Checksum), update: true}
+		r.upload.tmp, r.upload.err = os.CreateTemp(filepath.Dir(r.self.exe), ".cook-agent-update-*")
+
+	case agentproto.MsgData:
+		if u := r.upload; u != nil && u.err == nil {
+			_, u.err = u.tmp.Write(msg.Data)
+		}
+
+	case agentproto.MsgEOF:
+		if r.upload == nil {
+			return
+		}
+		update := r.upload.update
+		if err := r.finishUpload(); err != nil {
+			r.sendError(err)
+			return
+		}
+		r.conn.Send(agentproto.Message{Type: agentproto.MsgOK})
+		if update {
+			log.Printf("Installed update to %s", r.self.exe)
+			r.self.restartWhenIdle(r.mgr)
+		}
+
+	case agentproto.MsgListFiles:
+		var files []agentproto.FileInfo
+		var err error
+		if msg.Recursive {
+			files, err = listTree(msg.Path)
+		} else {
+			files, err = listDir(msg.Path)
+		}
+		if err != nil {
+			r.sendError(err)
+			return
+		}
+		r.conn.Send(agentproto.Message{Type: agentproto.MsgListFiles, Files: files})
+
+	case agentproto.MsgExec:
+		go r.exec(msg.Command, msg.WorkDir, msg.Env)
+
+	case agentproto.MsgWatch:
+		if _, err := os.Stat(msg.Path); err != nil {
+			r.sendError(err)
+			return
+		}
+		r.unwatch()
+		ctx, cancel := context.WithCancel(r.ctx)
+		done := make(chan struct{})
+		r.stopWatch = func() {
+			cancel()
+			<-done
+		}
+		r.conn.Send(agentproto.Message{Type: agentproto.MsgOK})
+		go func() {
+			defer close(done)
+			r.watch(ctx, msg.Path)
+		}()
+
+	case agentproto.MsgUnwatch:
+		// Once stopped, no events follow the reply
+		r.unwatch()
+		r.conn.Send(agentproto.Message{Type: agentproto.MsgOK})
+
+	case agentproto.MsgStatus:
+		status := r.self.status(r.mgr)
+		r.conn.Send(agentproto.Message{Type: agentproto.MsgStatus, Status: &status})
+	}
+}
+
+// close cleans up after the connection closes.
+func (r *rpcConn) close() {
+	r.abortUpload()
+	r.unwatch()
+}
+
+func (r *rpcConn) unwatch() {
+	if r.stopWatch != nil {
+		r.stopWatch()
+		r.stopWatch = nil
+	}
+}
+
+func (r *rpcConn) sendError(err error) {
+	msg := agentproto.Message{Type: agentproto.MsgError, Error: err.Error()}
+	if errors.Is(err, fs.ErrNotExist) {
+		msg.Code = agentproto.CodeNotExist
+	}
+	r.conn.Send(msg)
+}
+
+func (r *rpcConn) readFile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		r.sendError(err)
+		return
+	}
+	defer f.Close()
+
+	buf := make([]byte, readChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := r.conn.Send(agentproto.Message{Type: agentproto.MsgData, Data: buf[:n]}); err != nil {
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.sendError(err)
+			return
+		}
+	}
+	r.conn.Send(agentproto.Message{Type: agentproto.MsgEOF})
+}
+
+func (r *rpcConn) finishUpload() error {
+	u := r.upload
+	r.upload = nil
+	if u.err != nil {
+		if u.tmp != nil {
+			u.tmp.Close()
+			os.Remove(u.tmp.Name())
+		}
+		return u.err
+	}
+
+	err := u.tmp.Close()
+	if err == nil {
+		err = os.Chmod(u.tmp.Name(), u.mode)
+	}
+	if err == nil && u.check != nil {
+		err = u.check(u.tmp.Name())
+	}
+	if err == nil {
+		err = os.Rename(u.tmp.Name(), u.path)
+	}
+	if err != nil {
+		os.Remove(u.tmp.Name())
+	}
+	return err
+}
+
+func (r *rpcConn) abortUpload() {
+	if r.upload != nil && r.upload.tmp != nil {
+		r.upload.tmp.Close()
+		os.Remove(r.upload.tmp.Name())
+	}
+	r.upload = nil
+}
+
+// exec runs command to completion, streaming its output, and reports its
+// exit status. It is killed if the connection closes first.
+func (r *rpcConn) exec(command, workDir string, env []string) {
+	// An update waits for the command before restarting the agent
+	r.self.execs.Add(1)
+	defer r.self.execs.Add(-1)
+
+	cmd := exec.CommandContext(r.ctx, "/bin/sh", "-c", command)
+	cmd.Dir = workDir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &streamWriter{conn: r.conn, typ: agentproto.MsgStdout}
+	cmd.Stderr = &streamWriter{conn: r.conn, typ: agentproto.MsgStderr}
+	// Kill everything the command started, not just the shell
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't wait on background processes that inherited the output
+	cmd.WaitDelay = 5 * time.Second
+
+	if err := cmd.Start(); err != nil {
+		r.sendError(err)
+		return
+	}
+	cmd.Wait()
+	code := cmd.ProcessState.ExitCode()
+	r.conn.Send(agentproto.Message{Type: agentproto.MsgExit, ExitCode: &code})
+}
+
+// streamWriter sends a command's stdout or stderr.
+type streamWriter struct {
+	conn *agentproto.Conn
+	typ  string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	data := append([]byte(nil), p...)
+	if err := w.conn.Send(agentproto.Message{Type: w.typ, Data: data}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// watch reports changes under root until ctx is done.
+func (r *rpcConn) watch(ctx context.Context, root string) {
+	prev := scanTree(root)
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		next := scanTree(root)
+		if events := diffTrees(prev, next); len(events) > 0 {
+			r.conn.Send(agentproto.Message{Type: agentproto.MsgFileEvents, Path: root, Events: events})
+		}
+		prev = next
+	}
+}
+
+func fileInfo(path string, info os.FileInfo) agentproto.FileInfo {
+	return agentproto.FileInfo{
+		Name:    info.Name(),
+		Path:    path,
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		Mode:    uint32(info.Mode().Perm()),
+		ModTime: info.ModTime(),
+	}
+}
+
+func listDir(dir string) ([]agentproto.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]agentproto.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, fileInfo(filepath.Join(dir, entry.Name()), info))
+	}
+	return files, nil
+}
+
+// listTree lists every file and directory under root. In a git checkout it
+// leaves out what .gitignore does, along with .git itself.
+func listTree(root string) ([]agentproto.FileInfo, error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+
+	paths, ok := gitFiles(root)
+	if !ok {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || path == root {
+				return nil
+			}
+			if d.IsDir() && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			rel, _ := filepath.Rel(root, path)
+			paths = append(paths, rel)
+			return nil
+		})
+	}
+
+	files := make([]agentproto.FileInfo, 0, len(paths))
+	for _, rel := range paths {
+		path := filepath.Join(root, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue // deleted but still in the index
+		}
+		files = append(files, fileInfo(path, info))
+	}
+	return files, nil
+}
+
+// gitFiles returns the paths under root, relative to it, that git doesn't
+// ignore, including their parent directories. It reports false if root is
+// not in a git work tree.
+func gitFiles(root string) ([]string, bool) {
+	cmd := exec.Command("git", "ls-files", "--cached", "--others", "--exclude-standard", "-z")
+	cmd.Dir = root
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, false
+	}
+
+	seen := make(map[string]bool)
+	for _, rel := range bytes.Split(output, []byte{0}) {
+		if len(rel) == 0 {
+			continue
+		}
+		path := filepath.FromSlash(string(rel))
+		seen[path] = true
+		for dir := filepath.Dir(path); dir != "." && !seen[dir]; dir = filepath.Dir(dir) {
+			seen[dir] = true
+		}
+	}
+	paths := make([]string, 0, len(seen))
+	for path := range seen {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, true
+}
+
+// treeEntry is what a watch compares between scans.
+type treeEntry struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+}
+
+func scanTree(root string) map[string]treeEntry {
+	tree := make(map[string]treeEntry)
+	files, err := listTree(root)
+	if err != nil {
+		log.Printf("watch %s: %v", root, err)
+		return tree
+	}
+	for _, f := range files {
+		tree[f.Path] = treeEntry{modTime: f.ModTime, size: f.Size, isDir: f.IsDir}
+	}
+	return tree
+}
+
+// diffTrees returns the changes from prev to next, sorted by path.
+// Directories only change by appearing or disappearing.
+func diffTrees(prev, next map[string]treeEntry) []agentproto.FileEvent {
+	var events []agentproto.FileEvent
+	for path, entry := range next {
+		old, ok := prev[path]
+		switch {
+		case !ok:
+			events = append(events, agentproto.FileEvent{Path: path, Op: agentproto.OpCreate})
+		case !entry.isDir && (!entry.modTime.Equal(old.modTime) || entry.size != old.size):
+			events = append(events, agentproto.FileEvent{Path: path, Op: agentproto.OpModify})
+		}
+	}
+	for path := range prev {
+		if _, ok := next[path]; !ok {
+			events = append(events, agentproto.FileEvent{Path: path, Op: agentproto.OpRemove})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+)
+
+func TestListTree(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		".gitignore":         "build/\n*.log\n",
+		"main.go":            "package main\n",
+		"pkg/util.go":        "package pkg\n",
+		"build/out":          "binary",
+		"debug.log":          "noise",
+		"pkg/nested/x.txt":   "x",
+		".git/config-marker": "not a repo file",
+	} {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	relPaths := func() []string {
+		files, err := listTree(root)
+		if err != nil {
+			t.Fatalf("listTree: %v", err)
+		}
+		var paths []string
+		for _, f := range files {
+			rel, _ := filepath.Rel(root, f.Path)
+			paths = append(paths, rel)
+		}
+		return paths
+	}
+
+	// Outside a git work tree everything but .git is listed
+	got := relPaths()
+	want := []string{".gitignore", "build", "build/out", "debug.log", "main.go", "pkg", "pkg/nested", "pkg/nested/x.txt", "pkg/util.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("without git: %v, want %v", got, want)
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	os.RemoveAll(filepath.Join(root, ".git"))
+	if out, err := exec.Command("git", "init", "-q", root).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	got = relPaths()
+	want = []string{".gitignore", "main.go", "pkg", "pkg/nested", "pkg/nested/x.txt", "pkg/util.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("in git work tree: %v, want %v", got, want)
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	now := time.Now()
+	prev := map[string]treeEntry{
+		"/w/a":   {modTime: now, size: 1},
+		"/w/b":   {modTime: now, size: 1},
+		"/w/dir": {modTime: now, isDir: true},
+		"/w/old": {modTime: now, size: 1},
+	}
+	next := map[string]treeEntry{
+		"/w/a":   {modTime: now, size: 1},
+		"/w/b":   {modTime: now.Add(time.Second), size: 2},
+		"/w/dir": {modTime: now.Add(time.Second), isDir: true},
+		"/w/new": {modTime: now, size: 1},
+	}
+	want := []agentproto.FileEvent{
+		{Path: "/w/b", Op: agentproto.OpModify},
+		{Path: "/w/new", Op: agentproto.OpCreate},
+		{Path: "/w/old", Op: agentproto.OpRemove},
+	}
+	if got := diffTrees(prev, next); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffTrees = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+)
+
+// stateFile persists session metadata across agent restarts. PTYs don't
+// survive the agent, so sessions it was running are reported as lost.
+type stateFile struct {
+	path string
+}
+
+// loadLost returns the sessions a previous agent process left: the ones it
+// was running, now lost, and the ones that had ended recently enough to
+// still report.
+func (f *stateFile) loadLost() []agentproto.SessionInfo {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil
+	}
+	var infos []agentproto.SessionInfo
+	if err := json.Unmarshal(data, &infos); err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	var kept []agentproto.SessionInfo
+	for _, info := range infos {
+		if info.State == agentproto.SessionRunning {
+			info.State = agentproto.SessionLost
+			info.EndedAt = &now
+		}
+		if info.EndedAt == nil || now.Sub(*info.EndedAt) > endedRetention {
+			continue
+		}
+		kept = append(kept, info)
+	}
+	return kept
+}
+
+// save replaces the file with infos.
+func (f *stateFile) save(infos []agentproto.SessionInfo) error {
+	data, err := json.Marshal(infos)
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
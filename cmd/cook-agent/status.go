@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+)
+
+// clockTicks is the unit of CPU times in /proc (USER_HZ), which is 100 on
+// every Linux platform cook runs on.
+const clockTicks = 100
+
+// status reports on the agent and its sessions.
+func (p *agentProcess) status(mgr *SessionManager) agentproto.AgentStatus {
+	sessions := mgr.Info()
+	usage := sessionUsage()
+	for i, info := range sessions {
+		if u, ok := usage[info.PID]; ok && info.State == agentproto.SessionRunning {
+			sessions[i].Usage = &u
+		}
+	}
+	return agentproto.AgentStatus{
+		Version:        version,
+		Checksum:       p.checksum,
+		PID:            os.Getpid(),
+		StartedAt:      p.startedAt,
+		Uptime:         time.Since(p.startedAt).Seconds(),
+		Sessions:       sessions,
+		RestartPending: p.restartPending.Load(),
+	}
+}
+
+// sessionUsage totals the resources of every process by Unix session ID.
+// Each terminal session's shell leads its own Unix session, so this
+// counts everything it started. It returns nil without /proc.
+func sessionUsage() map[int]agentproto.ProcessUsage {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	pageSize := int64(os.Getpagesize())
+	usage := make(map[int]agentproto.ProcessUsage)
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue // exited since the listing
+		}
+		stat, ok := parseProcStat(string(data))
+		if !ok {
+			continue
+		}
+		u := usage[stat.session]
+		u.Processes++
+		u.CPUSeconds += float64(stat.utime+stat.stime) / clockTicks
+		u.RSSBytes += stat.rssPages * pageSize
+		usage[stat.session] = u
+	}
+	return usage
+}
+
+// procStat is what sessionUsage needs from /proc/<pid>/stat.
+type procStat struct {
+	session      int
+	utime, stime int64 // clock ticks
+	rssPages     int64
+}
+
+// parseProcStat parses a /proc/<pid>/stat line. The command name in
+// parentheses may contain spaces and parentheses itself, so fields are
+// counted from the last ')'.
+func parseProcStat(line string) (procStat, bool) {
+	i := strings.LastIndexByte(line, ')')
+	if i < 0 {
+		return procStat{}, false
+	}
+	// fields[0] is the state, field 3 in proc(5)
+	fields := strings.Fields(line[i+1:])
+	if len(fields) < 22 {
+		return procStat{}, false
+	}
+	session, err1 := strconv.Atoi(fields[3])
+	utime, err2 := strconv.ParseInt(fields[11], 10, 64)
+	stime, err3 := strconv.ParseInt(fields[12], 10, 64)
+	rss, err4 := strconv.ParseInt(fields[21], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return procStat{}, false
+	}
+	return procStat{session: session, utime: utime, stime: stime, rssPages: rss}, true
+}
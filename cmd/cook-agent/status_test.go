@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	// The command name holds the separators a naive split trips on
+	line := "4242 (my (odd) cmd) S 1 4242 4100 34816 4242 4194560 1200 0 0 0 350 75 0 0 20 0 3 0 99 12345678 2048 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 2 0 0 0 0 0\n"
+	stat, ok := parseProcStat(line)
+	if !ok {
+		t.Fatal("parseProcStat failed")
+	}
+	want := procStat{session: 4100, utime: 350, stime: 75, rssPages: 2048}
+	if stat != want {
+		t.Errorf("parseProcStat = %+v, want %+v", stat, want)
+	}
+
+	for _, bad := range []string{"", "4242 (cmd", "4242 (cmd) S 1 2"} {
+		if _, ok := parseProcStat(bad); ok {
+			t.Errorf("parseProcStat(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSessionUsage(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc")
+	}
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, ok := parseProcStat(string(data))
+	if !ok {
+		t.Fatal("parseProcStat failed on /proc/self/stat")
+	}
+	usage := sessionUsage()[self.session]
+	if usage.Processes < 1 || usage.RSSBytes <= 0 {
+		t.Errorf("usage of this test's session = %+v", usage)
+	}
+}
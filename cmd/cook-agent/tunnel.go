@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+)
+
+// tunnelDialTimeout bounds connecting to a tunnel's target.
+const tunnelDialTimeout = 10 * time.Second
+
+// tunnels relays TCP connections a client opens in the environment. Each
+// tunnel's bytes travel as tunnel_data messages on the client's connection.
+type tunnels struct {
+	conn *agentproto.Conn
+
+	mu   sync.Mutex
+	open map[string]net.Conn
+}
+
+func newTunnels(conn *agentproto.Conn) *tunnels {
+	return &tunnels{conn: conn, open: make(map[string]net.Conn)}
+}
+
+func (t *tunnels) handle(msg *agentproto.Message) {
+	switch msg.Type {
+	case agentproto.MsgTunnelOpen:
+		go t.dial(msg.Tunnel, msg.Addr)
+
+	case agentproto.MsgTunnelData:
+		t.mu.Lock()
+		c := t.open[msg.Tunnel]
+		t.mu.Unlock()
+		if c == nil {
+			return
+		}
+		if _, err := c.Write(msg.Data); err != nil && t.remove(msg.Tunnel) {
+			t.conn.Send(agentproto.Message{Type: agentproto.MsgTunnelClose, Tunnel: msg.Tunnel})
+		}
+
+	case agentproto.MsgTunnelClose:
+		t.remove(msg.Tunnel)
+	}
+}
+
+func (t *tunnels) dial(id, addr string) {
+	c, err := net.DialTimeout("tcp", addr, tunnelDialTimeout)
+	if err != nil {
+		t.conn.Send(agentproto.Message{Type: agentproto.MsgError, Tunnel: id, Error: err.Error()})
+		return
+	}
+	t.mu.Lock()
+	t.open[id] = c
+	t.mu.Unlock()
+
+	// The client sends no data before the reply
+	if err := t.conn.Send(agentproto.Message{Type: agentproto.MsgOK, Tunnel: id}); err != nil {
+		t.remove(id)
+		return
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			data := append([]byte(nil), buf[:n]...)
+			if err := t.conn.Send(agentproto.Message{Type: agentproto.MsgTunnelData, Tunnel: id, Data: data}); err != nil {
+				t.remove(id)
+				return
+			}
+		}
+		if err != nil {
+			// Tell the client unless it closed the tunnel itself
+			if t.remove(id) {
+				t.conn.Send(agentproto.Message{Type: agentproto.MsgTunnelClose, Tunnel: id})
+			}
+			return
+		}
+	}
+}
+
+// remove closes a tunnel, reporting whether it was open.
+func (t *tunnels) remove(id string) bool {
+	t.mu.Lock()
+	c, ok := t.open[id]
+	delete(t.open, id)
+	t.mu.Unlock()
+	if ok {
+		c.Close()
+	}
+	return ok
+}
+
+// closeAll closes every tunnel, once the client has gone.
+func (t *tunnels) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, c := range t.open {
+		c.Close()
+		delete(t.open, id)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+	"github.com/justinmoon/cook/internal/envagent"
+)
+
+const (
+	// checkBinaryTimeout bounds running an update's binary to check it
+	// works here.
+	checkBinaryTimeout = 10 * time.Second
+
+	// restartPoll is how often an agent with an update installed checks
+	// whether it can restart into it.
+	restartPoll = 2 * time.Second
+)
+
+// agentProcess is the running agent: what it reports about itself, and
+// how it restarts into an update.
+type agentProcess struct {
+	exe       string   // the agent's binary
+	checksum  string   // hex SHA-256 of the binary it started from
+	args      []string // command line to restart with
+	env       []string // environment to restart with
+	startedAt time.Time
+
+	execs          atomic.Int32 // exec RPCs running
+	restartPending atomic.Bool
+}
+
+func newAgentProcess(secret string) (*agentProcess, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := fileChecksum(exe)
+	if err != nil {
+		return nil, err
+	}
+
+	// The secret file is gone by now, so a restarted agent takes the
+	// secret from its environment instead
+	args := []string{exe}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "secret-file" {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	env := os.Environ()
+	if secret != "" {
+		env = append(env, envagent.SecretEnv+"="+secret)
+	}
+
+	return &agentProcess{exe: exe, checksum: checksum, args: args, env: env, startedAt: time.Now()}, nil
+}
+
+// checkUpdate returns the check an update's binary, written to a
+// temporary file, must pass before it replaces the agent's: it must match
+// checksum and run on this machine.
+func checkUpdate(checksum string) func(path string) error {
+	return func(path string) error {
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		if sum != checksum {
+			return fmt.Errorf("update checksum mismatch: got %s, want %s", sum, checksum)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), checkBinaryTimeout)
+		defer cancel()
+		if output, err := exec.CommandContext(ctx, path, "-version").CombinedOutput(); err != nil {
+			return fmt.Errorf("updated binary does not run: %w: %s", err, output)
+		}
+		return nil
+	}
+}
+
+// restartWhenIdle restarts the agent into its installed update once no
+// session or exec is running. Open tunnels and watches are dropped, and
+// their clients redial.
+func (p *agentProcess) restartWhenIdle(mgr *SessionManager) {
+	if p.restartPending.Swap(true) {
+		return
+	}
+	go func() {
+		for {
+			if p.execs.Load() == 0 {
+				// Holding the lock keeps a session from starting meanwhile
+				mgr.mu.Lock()
+				if !mgr.runningLocked() {
+					log.Printf("Restarting into updated binary")
+					mgr.saveLocked()
+					err := syscall.Exec(p.exe, p.args, p.env)
+					mgr.mu.Unlock()
+					log.Printf("Restart failed: %v", err)
+					p.restartPending.Store(false)
+					return
+				}
+				mgr.mu.Unlock()
+			}
+			time.Sleep(restartPoll)
+		}
+	}()
+}
+
+// runningLocked reports whether any session is running.
+func (m *SessionManager) runningLocked() bool {
+	for _, s := range m.sessions {
+		if s.Info().State == agentproto.SessionRunning {
+			return true
+		}
+	}
+	return false
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
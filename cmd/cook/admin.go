@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/config"
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/spf13/cobra"
+)
+
+func newAdminCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Administer the cook instance",
+	}
+
+	cmd.AddCommand(newAdminGCCmd())
+
+	return cmd
+}
+
+func newAdminGCCmd() *cobra.Command {
+	var dryRun bool
+	var backends []string
+	var minAge time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Destroy leaked containers, sandboxes, sprites and machines",
+		Long: `List the resources cook created on each backend (docker and podman
+containers by label, Modal sandboxes, sprites, fly machines) and destroy the
+ones no active branch, warm pool entry or named environment uses, e.g.
+leftovers from failed starts or crashed tests.
+
+Resources younger than --min-age are spared, since a branch still
+provisioning hasn't recorded its resource yet. Backends without
+credentials are skipped.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			database, err := openDatabase(cfg)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			opts := branch.GCOptions{DryRun: dryRun, MinAge: minAge}
+			for _, b := range backends {
+				opts.Backends = append(opts.Backends, env.Type(b))
+			}
+
+			store := branch.NewStore(database, cfg.Server.DataDir)
+			report, err := store.GC(context.Background(), opts)
+			if err != nil {
+				return err
+			}
+
+			skipped := make([]string, 0, len(report.Errors))
+			for backend := range report.Errors {
+				skipped = append(skipped, backend)
+			}
+			sort.Strings(skipped)
+			for _, backend := range skipped {
+				fmt.Printf("Skipped %s: %s\n", backend, report.Errors[backend])
+			}
+
+			if len(report.Orphans) == 0 {
+				fmt.Println("No leaked resources.")
+				return nil
+			}
+			failed := 0
+			for _, o := range report.Orphans {
+				desc := o.ID
+				if o.Name != "" && o.Name != o.ID {
+					desc = fmt.Sprintf("%s (%s)", o.ID, o.Name)
+				}
+				age := time.Since(o.CreatedAt).Round(time.Minute)
+				switch {
+				case dryRun:
+					fmt.Printf("Would destroy %s %s, created %v ago\n", o.Backend, desc, age)
+				case o.Destroyed:
+					fmt.Printf("Destroyed %s %s, created %v ago\n", o.Backend, desc, age)
+				default:
+					failed++
+					fmt.Printf("Failed to destroy %s %s: %s\n", o.Backend, desc, o.Error)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to destroy %d of %d leaked resources", failed, len(report.Orphans))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report leaked resources without destroying them")
+	cmd.Flags().StringSliceVar(&backends, "backend", nil, "Backends to check (default: docker, podman, modal, sprites, fly-machines)")
+	cmd.Flags().DurationVar(&minAge, "min-age", branch.DefaultGCMinAge, "Spare resources younger than this")
+
+	return cmd
+}
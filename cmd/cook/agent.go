@@ -121,6 +121,9 @@ func newAgentShowCmd() *cobra.Command {
 			fmt.Printf("Branch: %s/%s\n", session.BranchRepo, session.BranchName)
 			fmt.Printf("Agent: %s\n", session.AgentType)
 			fmt.Printf("Status: %s\n", session.Status)
+			if session.HelpReason != "" {
+				fmt.Printf("Needs Help: %s\n", session.HelpReason)
+			}
 
 			if session.PID != nil {
 				running := agent.IsRunning(*session.PID)
@@ -131,6 +134,10 @@ func newAgentShowCmd() *cobra.Command {
 				fmt.Printf("Exit Code: %d\n", *session.ExitCode)
 			}
 
+			if session.TokensUsed > 0 || session.CostUSD > 0 {
+				fmt.Printf("Usage: %d tokens, $%.2f\n", session.TokensUsed, session.CostUSD)
+			}
+
 			fmt.Printf("Started: %s\n", session.StartedAt.Format(time.RFC3339))
 			if session.EndedAt != nil {
 				fmt.Printf("Ended: %s\n", session.EndedAt.Format(time.RFC3339))
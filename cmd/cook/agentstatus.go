@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/envagent"
+	"github.com/spf13/cobra"
+)
+
+func newBranchAgentStatusCmd() *cobra.Command {
+	var update bool
+
+	cmd := &cobra.Command{
+		Use:   "agent-status <repo/name>",
+		Short: "Show the health of a branch's cook-agent",
+		Long: `Report the version, uptime and sessions of the cook-agent in a
+branch's environment, with each running session's processes, CPU time and
+memory.
+
+With --update, push this host's cook-agent binary if the agent runs a
+different one. The agent restarts into it once no session is running.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, b, database, err := openActiveBranch(args[0])
+			if err != nil {
+				return err
+			}
+			backend, err := b.Backend()
+			database.Close()
+			if err != nil {
+				return err
+			}
+			addr, secret, ok := env.CookAgent(backend)
+			if !ok {
+				return fmt.Errorf("branch %s/%s (%s) has no cook-agent", b.Repo, b.Name, b.Environment.Backend)
+			}
+
+			if update {
+				updated, err := env.UpdateAgent(addr, secret)
+				if err != nil {
+					return err
+				}
+				if updated {
+					fmt.Println("Pushed update; the agent restarts once no session is running.")
+				} else {
+					fmt.Println("Agent is up to date.")
+				}
+			}
+
+			client, err := envagent.Dial(addr, secret)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			if !client.HasFeature(agentproto.FeatureStatus) {
+				return fmt.Errorf("cook-agent at %s predates status reports", addr)
+			}
+			status, err := client.Status()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Agent: %s (%s)\n", addr, b.Environment.Backend)
+			fmt.Printf("Version: %s (%.12s)\n", status.Version, status.Checksum)
+			fmt.Printf("PID: %d\n", status.PID)
+			fmt.Printf("Uptime: %s\n", (time.Duration(status.Uptime) * time.Second).String())
+			if status.RestartPending {
+				fmt.Println("Update installed; restarts once no session is running")
+			}
+
+			if len(status.Sessions) == 0 {
+				fmt.Println("\nNo sessions.")
+				return nil
+			}
+			fmt.Printf("\n%-20s %-8s %-8s %-6s %-10s %s\n", "SESSION", "STATE", "PID", "PROCS", "CPU", "MEMORY")
+			for _, s := range status.Sessions {
+				procs, cpu, mem := "-", "-", "-"
+				if s.Usage != nil {
+					procs = fmt.Sprintf("%d", s.Usage.Processes)
+					cpu = fmt.Sprintf("%.1fs", s.Usage.CPUSeconds)
+					mem = fmt.Sprintf("%.1f MB", float64(s.Usage.RSSBytes)/(1<<20))
+				}
+				pid := "-"
+				if s.PID != 0 {
+					pid = fmt.Sprintf("%d", s.PID)
+				}
+				fmt.Printf("%-20s %-8s %-8s %-6s %-10s %s\n", s.ID, s.State, pid, procs, cpu, mem)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&update, "update", false, "Push this host's cook-agent binary if the agent runs a different one")
+
+	return cmd
+}
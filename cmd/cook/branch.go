@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	stdExec "os/exec"
@@ -12,7 +13,9 @@ import (
 	"github.com/justinmoon/cook/internal/config"
 	"github.com/justinmoon/cook/internal/events"
 	"github.com/justinmoon/cook/internal/gate"
+	"github.com/justinmoon/cook/internal/prompt"
 	"github.com/justinmoon/cook/internal/repo"
+	"github.com/justinmoon/cook/internal/review"
 	"github.com/justinmoon/cook/internal/task"
 	"github.com/spf13/cobra"
 )
@@ -27,16 +30,26 @@ func newBranchCmd() *cobra.Command {
 	cmd.AddCommand(newBranchListCmd())
 	cmd.AddCommand(newBranchShowCmd())
 	cmd.AddCommand(newBranchAbandonCmd())
+	cmd.AddCommand(newBranchResumeCmd())
 	cmd.AddCommand(newBranchMergeCmd())
+	cmd.AddCommand(newBranchSnapshotCmd())
+	cmd.AddCommand(newBranchRestoreCmd())
+	cmd.AddCommand(newBranchAgentStatusCmd())
 
 	return cmd
 }
 
+// branchCreateOptions are the flags shared by `cook branch create` and `cook task start`.
+type branchCreateOptions struct {
+	TaskID    string
+	EnvSpec   string
+	AgentType string
+	Prompt    string
+	Template  string
+}
+
 func newBranchCreateCmd() *cobra.Command {
-	var taskID string
-	var envSpec string
-	var agentType string
-	var prompt string
+	var opts branchCreateOptions
 
 	cmd := &cobra.Command{
 		Use:   "create <repo> <name>",
@@ -51,212 +64,255 @@ Agent types: claude, codex, opencode
 `,
 		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			repoName := args[0]
-			branchName := args[1]
-
-			cfg, err := config.Load()
-			if err != nil {
-				return err
-			}
-
-			if err := cfg.EnsureDataDir(); err != nil {
-				return err
-			}
-
-			database, err := openDatabase(cfg)
-			if err != nil {
-				return err
-			}
-			defer database.Close()
-
-			// Verify repo exists (repoName is owner/name format)
-			repoOwner, repoShortName, err := repo.ParseRepoRef(repoName)
-			if err != nil {
-				return err
-			}
-			repoStore := repo.NewStore(cfg.Server.DataDir)
-			r, err := repoStore.Get(repoOwner, repoShortName)
-			if err != nil {
-				return err
-			}
-			if r == nil {
-				return fmt.Errorf("repository %s not found", repoName)
-			}
-
-			// Parse environment spec
-			env, err := parseEnvSpec(envSpec, branchName, cfg.Server.DataDir)
-			if err != nil {
-				return err
-			}
+			return runBranchCreate(args[0], args[1], opts)
+		},
+	}
 
-			// Get base revision
-			baseRev := ""
-			// Try to get master HEAD, ok if it fails (empty repo)
-			if rev, err := getRevision(r.Path, "master"); err == nil {
-				baseRev = rev
-			}
+	cmd.Flags().StringVar(&opts.TaskID, "task", "", "Link to a task")
+	cmd.Flags().StringVar(&opts.EnvSpec, "env", "local", "Environment spec (local, local:/path)")
+	cmd.Flags().StringVar(&opts.AgentType, "agent", "", "Agent to spawn (claude, codex, opencode)")
+	cmd.Flags().StringVar(&opts.Prompt, "prompt", "", "Initial prompt for the agent")
+	cmd.Flags().StringVar(&opts.Template, "template", "", "Prompt template for the task brief (TASK.md)")
 
-			branchStore := branch.NewStore(database, cfg.Server.DataDir)
+	return cmd
+}
 
-			// Check if branch already exists
-			existing, err := branchStore.Get(repoName, branchName)
-			if err != nil {
-				return err
-			}
-			if existing != nil {
-				return fmt.Errorf("branch %s/%s already exists", repoName, branchName)
-			}
+// runBranchCreate creates a branch with a local checkout, optionally linked
+// to a task and with an agent attached to the terminal.
+func runBranchCreate(repoName, branchName string, opts branchCreateOptions) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
 
-			// Validate task BEFORE creating checkout
-			var taskStore *task.Store
-			var taskRepo, taskSlug string
-			if taskID != "" {
-				// Task can be "slug" (same repo) or "repo/slug"
-				taskRepo, taskSlug = parseRef(taskID)
-				if taskRepo == "" {
-					taskRepo = repoName
-					taskSlug = taskID
-				}
+	if err := cfg.EnsureDataDir(); err != nil {
+		return err
+	}
 
-				taskStore = task.NewStore(database)
-				t, err := taskStore.Get(taskRepo, taskSlug)
-				if err != nil {
-					return err
-				}
-				if t == nil {
-					return fmt.Errorf("task %s/%s not found", taskRepo, taskSlug)
-				}
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
 
-				// Check if task is blocked
-				blocked, blockers, err := taskStore.IsBlocked(t)
-				if err != nil {
-					return err
-				}
-				if blocked {
-					return fmt.Errorf("task %s/%s is blocked by: %s", taskRepo, taskSlug, strings.Join(blockers, ", "))
-				}
-			}
+	// Verify repo exists (repoName is owner/name format)
+	repoOwner, repoShortName, err := repo.ParseRepoRef(repoName)
+	if err != nil {
+		return err
+	}
+	repoStore := repo.NewStore(cfg.Server.DataDir)
+	r, err := repoStore.Get(repoOwner, repoShortName)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return fmt.Errorf("repository %s not found", repoName)
+	}
 
-			// Create the local checkout
-			fmt.Printf("Creating checkout at %s...\n", env.Path)
-			if err := branchStore.CreateLocalCheckout(r.Path, branchName, env.Path); err != nil {
-				return fmt.Errorf("failed to create checkout: %w", err)
-			}
+	// Parse environment spec
+	env, err := parseEnvSpec(opts.EnvSpec, branchName, cfg.Server.DataDir)
+	if err != nil {
+		return err
+	}
 
-			// Get head rev after checkout
-			headRev := baseRev
-			if rev, err := getRevision(env.Path, "HEAD"); err == nil {
-				headRev = rev
-			}
+	// Get base revision
+	baseRev := ""
+	// Try to get master HEAD, ok if it fails (empty repo)
+	if rev, err := getRevision(r.Path, "master"); err == nil {
+		baseRev = rev
+	}
 
-			// Create branch record
-			b := &branch.Branch{
-				Name:        branchName,
-				Repo:        repoName,
-				BaseRev:     baseRev,
-				HeadRev:     headRev,
-				Environment: env,
-				Status:      branch.StatusActive,
-			}
+	branchStore := branch.NewStore(database, cfg.Server.DataDir)
 
-			if taskID != "" {
-				b.TaskRepo = &taskRepo
-				b.TaskSlug = &taskSlug
+	// Check if branch already exists
+	existing, err := branchStore.Get(repoName, branchName)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("branch %s/%s already exists", repoName, branchName)
+	}
 
-				// Update task status
-				if err := taskStore.UpdateStatus(taskRepo, taskSlug, task.StatusInProgress); err != nil {
-					return err
-				}
-			}
+	// Validate task BEFORE creating checkout
+	if opts.Template != "" && opts.TaskID == "" {
+		return fmt.Errorf("--template requires --task")
+	}
 
-			if err := branchStore.Create(b); err != nil {
-				return err
-			}
+	var taskStore *task.Store
+	var linkedTask *task.Task
+	var taskRepo, taskSlug string
+	if opts.TaskID != "" {
+		// Task can be "slug" (same repo) or "repo/slug"
+		taskRepo, taskSlug = parseRef(opts.TaskID)
+		if taskRepo == "" {
+			taskRepo = repoName
+			taskSlug = opts.TaskID
+		}
+
+		taskStore = task.NewStore(database)
+		t, err := taskStore.Get(taskRepo, taskSlug)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return fmt.Errorf("task %s/%s not found", taskRepo, taskSlug)
+		}
+
+		// Check if task is blocked
+		blocked, blockers, err := taskStore.IsBlocked(t)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return fmt.Errorf("task %s/%s is blocked by: %s", taskRepo, taskSlug, strings.Join(blockers, ", "))
+		}
+		linkedTask = t
+	}
 
-			// Publish event
-			if bus := getEventBus(cfg); bus != nil {
-				defer bus.Close()
-				publishEvent(bus, events.Event{
-					Type:   events.EventBranchCreated,
-					Branch: branchName,
-					Repo:   repoName,
-				})
-			}
+	// Create the local checkout
+	fmt.Printf("Creating checkout at %s...\n", env.Path)
+	if err := branchStore.CreateLocalCheckout(r.Path, branchName, env.Path); err != nil {
+		return fmt.Errorf("failed to create checkout: %w", err)
+	}
 
-			fmt.Printf("Created branch: %s\n", branchName)
-			fmt.Printf("  Repo: %s\n", repoName)
-			fmt.Printf("  Checkout: %s\n", env.Path)
-			if taskID != "" {
-				fmt.Printf("  Task: %s\n", taskID)
-			}
+	// Write TASK.md from the repo's prompt template
+	if linkedTask != nil {
+		if err := writeTaskBrief(taskStore, linkedTask, branchName, env.Path, opts.Template); err != nil {
+			branchStore.RemoveLocalCheckout(env.Path)
+			return err
+		}
+		if opts.Prompt == "" {
+			opts.Prompt = taskBriefPrompt
+		}
+	}
 
-			// Spawn agent if requested
-			if agentType != "" {
-				agentStore := agent.NewStore(database)
-				session := &agent.Session{
-					BranchRepo: repoName,
-					BranchName: branchName,
-					AgentType:  agent.AgentType(agentType),
-					Prompt:     prompt,
-				}
+	// Get head rev after checkout
+	headRev := baseRev
+	if rev, err := getRevision(env.Path, "HEAD"); err == nil {
+		headRev = rev
+	}
 
-				if err := agentStore.Create(session); err != nil {
-					return fmt.Errorf("failed to create agent session: %w", err)
-				}
+	// Create branch record
+	b := &branch.Branch{
+		Name:        branchName,
+		Repo:        repoName,
+		BaseRev:     baseRev,
+		HeadRev:     headRev,
+		Environment: env,
+		Status:      branch.StatusActive,
+	}
 
-				// Spawn the agent process
-				agentCmd, err := agent.Spawn(agent.AgentType(agentType), env.Path, prompt, repoName, branchName)
-				if err != nil {
-					return fmt.Errorf("failed to spawn agent: %w", err)
-				}
+	if opts.TaskID != "" {
+		b.TaskRepo = &taskRepo
+		b.TaskSlug = &taskSlug
 
-				// Connect to stdio for interactive use
-				agentCmd.Stdin = os.Stdin
-				agentCmd.Stdout = os.Stdout
-				agentCmd.Stderr = os.Stderr
+		// Update task status
+		if err := taskStore.UpdateStatus(taskRepo, taskSlug, task.StatusInProgress); err != nil {
+			return err
+		}
+	}
 
-				fmt.Printf("  Agent: %s\n", agentType)
-				fmt.Println("\nStarting agent...")
+	if err := branchStore.Create(b); err != nil {
+		return err
+	}
 
-				if err := agentCmd.Start(); err != nil {
-					return fmt.Errorf("failed to start agent: %w", err)
-				}
+	// Publish event
+	if bus := getEventBus(cfg); bus != nil {
+		defer bus.Close()
+		publishEvent(bus, events.Event{
+			Type:   events.EventBranchCreated,
+			Branch: branchName,
+			Repo:   repoName,
+		})
+	}
 
-				pid := agentCmd.Process.Pid
-				session.PID = &pid
-				session.Status = agent.StatusRunning
-				agentStore.Update(session)
+	fmt.Printf("Created branch: %s\n", branchName)
+	fmt.Printf("  Repo: %s\n", repoName)
+	fmt.Printf("  Checkout: %s\n", env.Path)
+	if opts.TaskID != "" {
+		fmt.Printf("  Task: %s\n", opts.TaskID)
+	}
 
-				// Wait for agent to complete
-				err = agentCmd.Wait()
+	// Spawn agent if requested
+	if opts.AgentType != "" {
+		agentStore := agent.NewStore(database)
+		session := &agent.Session{
+			BranchRepo: repoName,
+			BranchName: branchName,
+			AgentType:  agent.AgentType(opts.AgentType),
+			Prompt:     opts.Prompt,
+		}
+
+		if err := agentStore.Create(session); err != nil {
+			return fmt.Errorf("failed to create agent session: %w", err)
+		}
+
+		// Spawn the agent process
+		agentCmd, err := agent.Spawn(agent.AgentType(opts.AgentType), env.Path, opts.Prompt, repoName, branchName)
+		if err != nil {
+			return fmt.Errorf("failed to spawn agent: %w", err)
+		}
+
+		// Connect to stdio for interactive use
+		agentCmd.Stdin = os.Stdin
+		agentCmd.Stdout = os.Stdout
+		agentCmd.Stderr = os.Stderr
+
+		fmt.Printf("  Agent: %s\n", opts.AgentType)
+		fmt.Println("\nStarting agent...")
+
+		if err := agentCmd.Start(); err != nil {
+			return fmt.Errorf("failed to start agent: %w", err)
+		}
+
+		pid := agentCmd.Process.Pid
+		session.PID = &pid
+		session.Status = agent.StatusRunning
+		agentStore.Update(session)
+
+		// Wait for agent to complete
+		err = agentCmd.Wait()
+
+		// Update session status
+		if err != nil {
+			if exitErr, ok := err.(*stdExec.ExitError); ok {
+				code := exitErr.ExitCode()
+				session.ExitCode = &code
+			}
+			session.Status = agent.StatusFailed
+		} else {
+			code := 0
+			session.ExitCode = &code
+			session.Status = agent.StatusCompleted
+		}
+		agentStore.Update(session)
+
+		fmt.Printf("\nAgent exited with status: %s\n", session.Status)
+	}
 
-				// Update session status
-				if err != nil {
-					if exitErr, ok := err.(*stdExec.ExitError); ok {
-						code := exitErr.ExitCode()
-						session.ExitCode = &code
-					}
-					session.Status = agent.StatusFailed
-				} else {
-					code := 0
-					session.ExitCode = &code
-					session.Status = agent.StatusCompleted
-				}
-				agentStore.Update(session)
+	return nil
+}
 
-				fmt.Printf("\nAgent exited with status: %s\n", session.Status)
-			}
+// taskBriefPrompt is the agent prompt used when a task brief was written.
+const taskBriefPrompt = "Complete the task described in TASK.md. When done, commit your changes."
 
-			return nil
-		},
+// writeTaskBrief renders the task into TASK.md in the checkout.
+func writeTaskBrief(taskStore *task.Store, t *task.Task, branchName, checkoutPath, templateName string) error {
+	repoCfg, err := gate.LoadRepoConfig(checkoutPath)
+	if err != nil {
+		return fmt.Errorf("failed to load cook.toml: %w", err)
 	}
+	src := prompt.DirSource(checkoutPath)
 
-	cmd.Flags().StringVar(&taskID, "task", "", "Link to a task")
-	cmd.Flags().StringVar(&envSpec, "env", "local", "Environment spec (local, local:/path)")
-	cmd.Flags().StringVar(&agentType, "agent", "", "Agent to spawn (claude, codex, opencode)")
-	cmd.Flags().StringVar(&prompt, "prompt", "", "Initial prompt for the agent")
-
-	return cmd
+	data, err := prompt.Gather(taskStore, nil, t, branchName, src)
+	if err != nil {
+		return err
+	}
+	content, err := prompt.Load(src, repoCfg.Prompts).Render(templateName, data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(checkoutPath, "TASK.md"), []byte(content), 0644)
 }
 
 func newBranchListCmd() *cobra.Command {
@@ -352,6 +408,9 @@ func newBranchShowCmd() *cobra.Command {
 			fmt.Printf("Head:     %s\n", truncateRev(b.HeadRev))
 			fmt.Printf("Backend:  %s\n", b.Environment.Backend)
 			fmt.Printf("Path:     %s\n", b.Environment.Path)
+			if b.Environment.StoppedReason != "" {
+				fmt.Printf("Stopped:  %s\n", b.Environment.StoppedReason)
+			}
 			fmt.Printf("Created:  %s\n", b.CreatedAt.Format("2006-01-02 15:04:05"))
 			if b.TaskRepo != nil && b.TaskSlug != nil {
 				fmt.Printf("Task:     %s/%s\n", *b.TaskRepo, *b.TaskSlug)
@@ -478,6 +537,9 @@ func newBranchMergeCmd() *cobra.Command {
 					currentRev, _ := getRevision(b.Environment.Path, "HEAD")
 
 					for _, g := range repoConfig.Gates {
+						if !g.Runnable() {
+							continue
+						}
 						run, err := gateStore.GetLatestRun(repoName, name, g.Name)
 						if err != nil {
 							return err
@@ -489,6 +551,7 @@ func newBranchMergeCmd() *cobra.Command {
 						}
 
 						if run.Status != gate.StatusPassed {
+							printFindings(run.Findings)
 							return fmt.Errorf("gate %q has not passed (status: %s); use 'cook gate run %s' to retry", g.Name, run.Status, branchRef)
 						}
 
@@ -501,6 +564,27 @@ func newBranchMergeCmd() *cobra.Command {
 				}
 			}
 
+			// Check required review approvals. The requirement comes from the
+			// base branch, so the branch under review can't lower it.
+			baseConfig, err := gate.LoadRepoConfigFromBareRepo(r.Path)
+			if err != nil {
+				return fmt.Errorf("failed to load review requirement: %w", err)
+			}
+			if required := baseConfig.Review.RequiredApprovals; required > 0 {
+				head, err := getRevision(b.Environment.Path, "HEAD")
+				if err != nil {
+					return err
+				}
+				summary, err := review.NewStore(database).Summary(repoName, name, head)
+				if err != nil {
+					return err
+				}
+				if err := summary.CheckApprovals(required); err != nil {
+					return fmt.Errorf("review: %w", err)
+				}
+				fmt.Printf("Approved by %d reviewer(s)\n", len(summary.Approvals))
+			}
+
 			// Push branch to bare repo
 			fmt.Println("Pushing branch to repository...")
 			_, err = runGit(b.Environment.Path, "push", "origin", name)
@@ -678,3 +762,31 @@ func newBranchAbandonCmd() *cobra.Command {
 
 	return cmd
 }
+
+func newBranchResumeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume <repo/name>",
+		Short: "Restart a branch environment cook stopped",
+		Long: `Restart a branch's environment after cook stopped it for going over its
+budget, so its terminals and agent can run again. The agent itself is not
+restarted. Environments that can't be stopped are torn down instead and
+can't be resumed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, b, database, err := openActiveBranch(args[0])
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			if b.Environment.StoppedReason == "" {
+				return fmt.Errorf("branch %s/%s is not stopped", b.Repo, b.Name)
+			}
+			if err := store.ResumeStopped(context.Background(), b); err != nil {
+				return err
+			}
+			fmt.Printf("Resumed %s/%s\n", b.Repo, b.Name)
+			return nil
+		},
+	}
+}
@@ -5,11 +5,16 @@ import (
 
 	"github.com/justinmoon/cook/internal/config"
 	"github.com/justinmoon/cook/internal/db"
+	"github.com/justinmoon/cook/internal/envagent"
 )
 
 func openDatabase(cfg *config.Config) (*db.DB, error) {
 	if cfg.Server.DatabaseURL == "" {
 		return nil, fmt.Errorf("COOK_DATABASE_URL is required")
 	}
+	// Commands that open the database may start or connect to environments,
+	// whose cook-agent needs the server's agent key. Without it (e.g. an
+	// unreadable data dir) only those operations fail.
+	_ = envagent.LoadKey(cfg.Server.DataDir)
 	return db.Open(cfg.Server.DatabaseURL)
 }
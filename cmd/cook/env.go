@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/config"
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/spf13/cobra"
+)
+
+func newEnvCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Manage persistent named environments",
+		Long: `Named environments are long-lived remote machines that branches attach
+to instead of provisioning their own. Datasets, caches and installed tools
+survive from branch to branch; only the checkout is replaced. Each belongs
+to an owner, and only the owner's repos can attach to it.
+
+Pick one when creating a branch or starting a task in the web UI, or pass
+backend=env:<name> to the API.`,
+	}
+
+	cmd.AddCommand(newEnvCreateCmd())
+	cmd.AddCommand(newEnvListCmd())
+	cmd.AddCommand(newEnvDestroyCmd())
+
+	return cmd
+}
+
+func newEnvCreateCmd() *cobra.Command {
+	var backend string
+	var profile string
+	var idleTimeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "create <owner>/<name>",
+		Short: "Provision a named environment",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, name, err := parseEnvRef(args[0])
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+
+			var resources *env.Resources
+			if profile != "" {
+				r, ok := cfg.Server.Profiles[profile]
+				if !ok {
+					return fmt.Errorf("unknown resource profile %q", profile)
+				}
+				resources = &r
+			} else if r, ok := cfg.Server.Profiles["default"]; ok {
+				profile = "default"
+				resources = &r
+			}
+
+			database, err := openDatabase(cfg)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			fmt.Printf("Provisioning %s environment %s...\n", backend, args[0])
+			store := branch.NewStore(database, cfg.Server.DataDir)
+			e, err := store.CreateEnvironment(context.Background(), owner, name, backend, profile, resources, idleTimeout)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Created environment: %s/%s\n", e.Owner, e.Name)
+			fmt.Printf("  Backend: %s (%s)\n", e.Backend, e.EnvID)
+			if e.Profile != "" {
+				fmt.Printf("  Profile: %s\n", e.Profile)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&backend, "backend", string(env.TypeModal), "Backend: modal, sprites or fly-machines")
+	cmd.Flags().StringVar(&profile, "profile", "", "Resource profile from the server config")
+	cmd.Flags().DurationVar(&idleTimeout, "idle-timeout", 0, "Suspend after this long with no branch attached (0 = never)")
+
+	return cmd
+}
+
+func newEnvListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list [owner]",
+		Short: "List named environments",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+
+			database, err := openDatabase(cfg)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			store := branch.NewStore(database, cfg.Server.DataDir)
+			var envs []branch.NamedEnvironment
+			if len(args) == 1 {
+				envs, err = store.ListOwnerEnvironments(args[0])
+			} else {
+				envs, err = store.ListEnvironments()
+			}
+			if err != nil {
+				return err
+			}
+
+			if len(envs) == 0 {
+				fmt.Println("No environments found.")
+				return nil
+			}
+
+			for _, e := range envs {
+				state := "idle"
+				switch {
+				case e.Attached():
+					state = fmt.Sprintf("attached to %s/%s", e.AttachedRepo, e.AttachedBranch)
+				case e.SuspendedAt != nil:
+					state = "suspended"
+				}
+				fmt.Printf("%s/%s\t%s\t%s\n", e.Owner, e.Name, e.Backend, state)
+			}
+			return nil
+		},
+	}
+}
+
+func newEnvDestroyCmd() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "destroy <owner>/<name>",
+		Short: "Tear down a named environment",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, name, err := parseEnvRef(args[0])
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+
+			database, err := openDatabase(cfg)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			store := branch.NewStore(database, cfg.Server.DataDir)
+			if err := store.DestroyEnvironment(context.Background(), owner, name, force); err != nil {
+				return err
+			}
+
+			fmt.Printf("Destroyed environment: %s\n", args[0])
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "Forget the environment even if tearing it down fails")
+
+	return cmd
+}
+
+// parseEnvRef splits "owner/name". Environments created before they had
+// owners, and never attached since, have an empty owner: "/name".
+func parseEnvRef(ref string) (owner, name string, err error) {
+	owner, name, found := strings.Cut(ref, "/")
+	if !found || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid environment %q (use owner/name)", ref)
+	}
+	return owner, name, nil
+}
@@ -31,7 +31,9 @@ func newGateRunCmd() *cobra.Command {
 		Use:   "run <repo/branch>",
 		Short: "Run gates for a branch",
 		Long: `Run gates for a branch. If --gate is specified, only that gate is run.
-Otherwise, all gates defined in cook.toml are run in sequence.`,
+Otherwise, all gates defined in cook.toml are run in sequence. Transform
+gates run first; any changes they make are committed on the branch and
+the remaining gates run on the new commit.`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			repoName, branchName, err := requireRef(args[0], "branch")
@@ -81,6 +83,11 @@ Otherwise, all gates defined in cook.toml are run in sequence.`,
 			}
 
 			gateStore := gate.NewStore(database, cfg.Server.DataDir)
+			secrets, err := gate.ResolveSecrets(database, cfg.Server.SecretsKey, repoName, repoConfig.Secrets)
+			if err != nil {
+				return fmt.Errorf("failed to resolve secrets: %w", err)
+			}
+			gateStore.SetSecrets(secrets)
 
 			// Filter gates if --gate specified
 			var gatesToRun []gate.Gate
@@ -104,11 +111,18 @@ Otherwise, all gates defined in cook.toml are run in sequence.`,
 				defer bus.Close()
 			}
 
-			// Run gates
+			// Run gates (transform gates first, so validation sees their commits)
 			allPassed := true
-			for _, g := range gatesToRun {
+			for _, g := range gate.Ordered(gatesToRun) {
+				if !g.Runnable() {
+					continue
+				}
 				fmt.Printf("Running gate: %s\n", g.Name)
-				fmt.Printf("  Command: %s\n", g.Command)
+				if g.IsReview() {
+					fmt.Printf("  Review: %s..%s\n", truncateRev(b.BaseRev), truncateRev(rev))
+				} else {
+					fmt.Printf("  Command: %s\n", g.Command)
+				}
 
 				// Publish gate started
 				publishEvent(bus, events.Event{
@@ -118,13 +132,20 @@ Otherwise, all gates defined in cook.toml are run in sequence.`,
 					GateName: g.Name,
 				})
 
-				run, err := gateStore.RunGate(g, repoName, branchName, rev, b.Environment.Path)
+				run, err := gateStore.Run(g, repoName, branchName, b.BaseRev, rev, b.Environment.Path)
 				if err != nil {
 					return fmt.Errorf("failed to run gate %s: %w", g.Name, err)
 				}
 
 				if run.Status == gate.StatusPassed {
 					fmt.Printf("  Result: PASSED\n")
+					if g.IsTransform() && run.Rev != rev {
+						fmt.Printf("  Committed: %s\n", truncateRev(run.Rev))
+						if err := branchStore.UpdateHeadRev(repoName, branchName, run.Rev); err != nil {
+							return fmt.Errorf("failed to update head rev: %w", err)
+						}
+						rev = run.Rev
+					}
 					publishEvent(bus, events.Event{
 						Type:     events.EventGatePassed,
 						Branch:   branchName,
@@ -132,7 +153,12 @@ Otherwise, all gates defined in cook.toml are run in sequence.`,
 						GateName: g.Name,
 					})
 				} else {
-					fmt.Printf("  Result: FAILED (exit code: %d)\n", *run.ExitCode)
+					if run.ExitCode != nil {
+						fmt.Printf("  Result: FAILED (exit code: %d)\n", *run.ExitCode)
+					} else {
+						fmt.Printf("  Result: FAILED\n")
+					}
+					printFindings(run.Findings)
 					fmt.Printf("  Log: %s\n", run.LogPath)
 					allPassed = false
 					publishEvent(bus, events.Event{
@@ -147,6 +173,12 @@ Otherwise, all gates defined in cook.toml are run in sequence.`,
 			if !allPassed {
 				return fmt.Errorf("some gates failed")
 			}
+			// Every gate passing ends any fix loop's run of attempts
+			if gateName == "" {
+				if err := branchStore.ResetFixIterations(repoName, branchName); err != nil {
+					return fmt.Errorf("failed to reset fix iterations: %w", err)
+				}
+			}
 
 			fmt.Println("\nAll gates passed!")
 			return nil
@@ -251,3 +283,14 @@ func newGateStatusCmd() *cobra.Command {
 		},
 	}
 }
+
+// printFindings lists review gate findings under a gate result.
+func printFindings(findings []gate.Finding) {
+	for _, f := range findings {
+		loc := f.File
+		if f.Line > 0 {
+			loc = fmt.Sprintf("%s:%d", f.File, f.Line)
+		}
+		fmt.Printf("  [%s] %s: %s\n", f.Severity, loc, f.Message)
+	}
+}
@@ -122,6 +122,10 @@ func main() {
 	rootCmd.AddCommand(newLogoutCmd())
 	rootCmd.AddCommand(newWhoamiCmd())
 	rootCmd.AddCommand(newPreviewCmd())
+	rootCmd.AddCommand(newEnvCmd())
+	rootCmd.AddCommand(newPortForwardCmd())
+	rootCmd.AddCommand(newSecretCmd())
+	rootCmd.AddCommand(newAdminCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
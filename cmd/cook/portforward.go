@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/envagent"
+	"github.com/spf13/cobra"
+)
+
+func newPortForwardCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "port-forward <repo/name> [local:]remote...",
+		Short: "Forward local ports to a branch's environment",
+		Long: `Listen on local ports and forward connections to ports inside a
+branch's environment, through its cook-agent. Works with every backend
+that runs one, without exposing the ports or joining a tailnet.
+
+Example:
+  cook port-forward owner/repo/feature 3000
+  cook port-forward owner/repo/feature 8080:3000 5432:5432`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			forwards := make([]portForward, 0, len(args)-1)
+			for _, spec := range args[1:] {
+				fwd, err := parsePortForward(spec)
+				if err != nil {
+					return err
+				}
+				forwards = append(forwards, fwd)
+			}
+
+			_, b, database, err := openActiveBranch(args[0])
+			if err != nil {
+				return err
+			}
+			backend, err := b.Backend()
+			database.Close()
+			if err != nil {
+				return err
+			}
+			addr, secret, ok := env.CookAgent(backend)
+			if !ok {
+				return fmt.Errorf("branch %s/%s (%s) has no cook-agent to forward through", b.Repo, b.Name, b.Environment.Backend)
+			}
+
+			forwarder := envagent.NewForwarder(addr, secret)
+			defer forwarder.Close()
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			var wg sync.WaitGroup
+			for _, fwd := range forwards {
+				ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(fwd.local)))
+				if err != nil {
+					return err
+				}
+				context.AfterFunc(ctx, func() { ln.Close() })
+				fmt.Printf("Forwarding %s -> %d\n", ln.Addr(), fwd.remote)
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					serveForward(ctx, ln, forwarder, net.JoinHostPort("127.0.0.1", strconv.Itoa(fwd.remote)))
+				}()
+			}
+			wg.Wait()
+			return nil
+		},
+	}
+}
+
+// portForward is a local port forwarded to one in the environment.
+type portForward struct {
+	local, remote int
+}
+
+// parsePortForward parses "remote" or "local:remote".
+func parsePortForward(spec string) (portForward, error) {
+	localStr, remoteStr, found := strings.Cut(spec, ":")
+	if !found {
+		remoteStr = localStr
+	}
+	local, err := strconv.Atoi(localStr)
+	if err != nil || local < 0 || local > 65535 {
+		return portForward{}, fmt.Errorf("invalid port forward %q: expected [local:]remote", spec)
+	}
+	remote, err := strconv.Atoi(remoteStr)
+	if err != nil || remote < 1 || remote > 65535 {
+		return portForward{}, fmt.Errorf("invalid port forward %q: expected [local:]remote", spec)
+	}
+	return portForward{local: local, remote: remote}, nil
+}
+
+// serveForward relays connections accepted on ln to target until ln is
+// closed.
+func serveForward(ctx context.Context, ln net.Listener, forwarder *envagent.Forwarder, target string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("accept: %v", err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			remote, err := forwarder.Dial(ctx, target)
+			if err != nil {
+				log.Printf("forward to %s: %v", target, err)
+				return
+			}
+			defer remote.Close()
+
+			done := make(chan struct{})
+			go func() {
+				io.Copy(remote, conn)
+				remote.Close()
+				close(done)
+			}()
+			io.Copy(conn, remote)
+			conn.Close()
+			<-done
+		}()
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParsePortForward(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    portForward
+		wantErr bool
+	}{
+		{"3000", portForward{3000, 3000}, false},
+		{"8080:3000", portForward{8080, 3000}, false},
+		{"0:5432", portForward{0, 5432}, false},
+
+		{"", portForward{}, true},
+		{"web", portForward{}, true},
+		{"3000:", portForward{}, true},
+		{"3000:0", portForward{}, true},
+		{"70000:3000", portForward{}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := parsePortForward(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parsePortForward(%q) error = %v, wantErr %v", tc.input, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("parsePortForward(%q) = %+v, want %+v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
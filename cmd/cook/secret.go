@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/justinmoon/cook/internal/config"
+	"github.com/justinmoon/cook/internal/secret"
+	"github.com/spf13/cobra"
+)
+
+func newSecretCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Manage encrypted secrets",
+		Long: `Secrets are stored encrypted with the server's secrets_key and set on an
+owner (every repo they own) or a single owner/repo, which wins.
+
+A repo chooses the secrets its environments and gates get in cook.toml:
+
+  [secrets]
+  env = ["NPM_TOKEN"]
+
+  [secrets.files]
+  GCP_CREDENTIALS = "gcp.json"
+
+Values are masked in gate logs and terminal replays.`,
+	}
+
+	cmd.AddCommand(newSecretSetCmd())
+	cmd.AddCommand(newSecretListCmd())
+	cmd.AddCommand(newSecretRmCmd())
+	cmd.AddCommand(newSecretKeygenCmd())
+
+	return cmd
+}
+
+// openSecretStore opens the secret store using the configured secrets key.
+func openSecretStore(run func(*secret.Store) error) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	store, err := secret.NewStore(database, cfg.Server.SecretsKey)
+	if err != nil {
+		return err
+	}
+	return run(store)
+}
+
+// parseSecretRef splits "owner/NAME" or "owner/repo/NAME".
+func parseSecretRef(ref string) (scope, name string, err error) {
+	i := strings.LastIndex(ref, "/")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid secret %q (use owner/NAME or owner/repo/NAME)", ref)
+	}
+	scope, name = ref[:i], ref[i+1:]
+	if err := secret.ValidateScope(scope); err != nil {
+		return "", "", err
+	}
+	if err := secret.ValidateName(name); err != nil {
+		return "", "", err
+	}
+	return scope, name, nil
+}
+
+func newSecretSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set <owner[/repo]>/<NAME>",
+		Short: "Set a secret, reading its value from stdin",
+		Example: `  echo -n "$NPM_TOKEN" | cook secret set alice/NPM_TOKEN
+  cook secret set alice/app/GCP_CREDENTIALS < gcp.json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scope, name, err := parseSecretRef(args[0])
+			if err != nil {
+				return err
+			}
+			value, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("failed to read value: %w", err)
+			}
+			if len(value) == 0 {
+				return fmt.Errorf("no value on stdin")
+			}
+
+			return openSecretStore(func(store *secret.Store) error {
+				if err := store.Set(scope, name, string(value)); err != nil {
+					return err
+				}
+				fmt.Printf("Set secret %s/%s\n", scope, name)
+				return nil
+			})
+		},
+	}
+}
+
+func newSecretListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list <owner[/repo]>",
+		Short: "List the secrets set on an owner or repo",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := secret.ValidateScope(args[0]); err != nil {
+				return err
+			}
+			return openSecretStore(func(store *secret.Store) error {
+				secrets, err := store.List(args[0])
+				if err != nil {
+					return err
+				}
+				if len(secrets) == 0 {
+					fmt.Println("No secrets")
+					return nil
+				}
+				for _, s := range secrets {
+					fmt.Printf("%s\tupdated %s\n", s.Name, s.UpdatedAt.Format("2006-01-02 15:04"))
+				}
+				return nil
+			})
+		},
+	}
+}
+
+func newSecretRmCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rm <owner[/repo]>/<NAME>",
+		Short: "Remove a secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scope, name, err := parseSecretRef(args[0])
+			if err != nil {
+				return err
+			}
+			return openSecretStore(func(store *secret.Store) error {
+				if err := store.Delete(scope, name); err != nil {
+					return err
+				}
+				fmt.Printf("Removed secret %s/%s\n", scope, name)
+				return nil
+			})
+		},
+	}
+}
+
+func newSecretKeygenCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a secrets_key for the server config",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := secret.GenerateKey()
+			if err != nil {
+				return err
+			}
+			fmt.Println(key)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/config"
+	"github.com/justinmoon/cook/internal/db"
+	"github.com/spf13/cobra"
+)
+
+// openActiveBranch loads an active branch for the snapshot commands.
+func openActiveBranch(ref string) (*branch.Store, *branch.Branch, *db.DB, error) {
+	repoName, name, err := requireRef(ref, "branch")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	store := branch.NewStore(database, cfg.Server.DataDir)
+	b, err := store.Get(repoName, name)
+	if err != nil {
+		database.Close()
+		return nil, nil, nil, err
+	}
+	if b == nil {
+		database.Close()
+		return nil, nil, nil, fmt.Errorf("branch %s/%s not found", repoName, name)
+	}
+	if b.Status != branch.StatusActive {
+		database.Close()
+		return nil, nil, nil, fmt.Errorf("branch %s/%s is not active (status: %s)", repoName, name, b.Status)
+	}
+	return store, b, database, nil
+}
+
+func newBranchSnapshotCmd() *cobra.Command {
+	var label string
+	var list bool
+	var remove string
+
+	cmd := &cobra.Command{
+		Use:   "snapshot <repo/name>",
+		Short: "Snapshot a branch's environment",
+		Long: `Checkpoint a branch's environment so it can be rolled back with
+'cook branch restore', e.g. before letting an agent do something risky.
+
+Local and sandbox checkouts are snapshotted with btrfs, reflink copies or
+tarballs; docker commits the container; sprites uses checkpoints.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, b, database, err := openActiveBranch(args[0])
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			if list {
+				snapshots, err := store.ListSnapshots(b.Repo, b.Name)
+				if err != nil {
+					return err
+				}
+				if len(snapshots) == 0 {
+					fmt.Println("No snapshots.")
+					return nil
+				}
+				for _, snap := range snapshots {
+					labelInfo := ""
+					if snap.Label != "" {
+						labelInfo = fmt.Sprintf(" (%s)", snap.Label)
+					}
+					fmt.Printf("%s  %s%s\n", snap.CreatedAt.Format("2006-01-02 15:04:05"), snap.SnapshotID, labelInfo)
+				}
+				return nil
+			}
+
+			if remove != "" {
+				snap, err := store.GetSnapshot(b.Repo, b.Name, remove)
+				if err != nil {
+					return err
+				}
+				if snap == nil {
+					return fmt.Errorf("snapshot %s not found", remove)
+				}
+				if err := store.DeleteSnapshot(context.Background(), b, snap); err != nil {
+					return err
+				}
+				fmt.Printf("Deleted snapshot %s\n", snap.SnapshotID)
+				return nil
+			}
+
+			fmt.Printf("Snapshotting %s/%s (%s)...\n", b.Repo, b.Name, b.Environment.Backend)
+			snap, err := store.CreateSnapshot(context.Background(), b, label)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Created snapshot %s\n", snap.SnapshotID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&label, "label", "m", "", "Label to restore the snapshot by")
+	cmd.Flags().BoolVar(&list, "list", false, "List the branch's snapshots")
+	cmd.Flags().StringVar(&remove, "rm", "", "Delete a snapshot (ID or label)")
+
+	return cmd
+}
+
+func newBranchRestoreCmd() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "restore <repo/name> [snapshot]",
+		Short: "Roll a branch's environment back to a snapshot",
+		Long:  "Restore a branch's environment from a snapshot (ID or label; default: the latest). Changes since the snapshot are lost.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, b, database, err := openActiveBranch(args[0])
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			ref := ""
+			if len(args) == 2 {
+				ref = args[1]
+			}
+			snap, err := store.GetSnapshot(b.Repo, b.Name, ref)
+			if err != nil {
+				return err
+			}
+			if snap == nil {
+				if ref == "" {
+					return fmt.Errorf("branch %s/%s has no snapshots", b.Repo, b.Name)
+				}
+				return fmt.Errorf("snapshot %s not found", ref)
+			}
+
+			// Confirm unless --force
+			if !force {
+				fmt.Printf("Restore %s/%s to snapshot %s from %s? Changes since then will be lost. [y/N]: ",
+					b.Repo, b.Name, snap.SnapshotID, snap.CreatedAt.Format("2006-01-02 15:04:05"))
+				var response string
+				fmt.Scanln(&response)
+				response = strings.ToLower(strings.TrimSpace(response))
+				if response != "y" && response != "yes" {
+					fmt.Println("Aborted.")
+					return nil
+				}
+			}
+
+			if err := store.RestoreSnapshot(context.Background(), b, snap); err != nil {
+				return err
+			}
+			fmt.Printf("Restored %s/%s to snapshot %s\n", b.Repo, b.Name, snap.SnapshotID)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "Skip confirmation")
+
+	return cmd
+}
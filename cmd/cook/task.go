@@ -19,6 +19,7 @@ func newTaskCmd() *cobra.Command {
 	cmd.AddCommand(newTaskListCmd())
 	cmd.AddCommand(newTaskCreateCmd())
 	cmd.AddCommand(newTaskShowCmd())
+	cmd.AddCommand(newTaskStartCmd())
 	cmd.AddCommand(newTaskCloseCmd())
 
 	return cmd
@@ -204,6 +205,41 @@ func newTaskShowCmd() *cobra.Command {
 	}
 }
 
+func newTaskStartCmd() *cobra.Command {
+	var opts branchCreateOptions
+
+	cmd := &cobra.Command{
+		Use:   "start <repo/slug>",
+		Short: "Start a task on a new branch named after its slug",
+		Long: `Start a task: create a branch named after the task slug, write TASK.md
+from the repo's prompt template and optionally spawn an agent.
+
+Prompt templates are Go templates defined in cook.toml:
+
+  [prompts]
+  bugfix = "Fix {{.Task.Title}}..."
+
+or as .cook/prompts/<name>.md files. A template named "default" replaces
+the built-in format.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoRef, slug, err := requireRef(args[0], "task")
+			if err != nil {
+				return err
+			}
+			opts.TaskID = repoRef + "/" + slug
+			return runBranchCreate(repoRef, slug, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Template, "template", "", "Prompt template for TASK.md (default: repo's \"default\" or built-in)")
+	cmd.Flags().StringVar(&opts.EnvSpec, "env", "local", "Environment spec (local, local:/path)")
+	cmd.Flags().StringVar(&opts.AgentType, "agent", "", "Agent to spawn (claude, codex, opencode)")
+	cmd.Flags().StringVar(&opts.Prompt, "prompt", "", "Initial prompt for the agent (default: work on TASK.md)")
+
+	return cmd
+}
+
 func newTaskCloseCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "close <repo/slug>",
@@ -16,6 +16,7 @@ require (
 	github.com/superfly/fly-go v0.2.2
 	github.com/superfly/sprites-go v0.0.0-20260127152949-03279f690e44
 	github.com/zalando/go-keyring v0.2.6
+	golang.org/x/crypto v0.44.0
 )
 
 require (
@@ -101,7 +102,6 @@ require (
 	go.opentelemetry.io/otel/metric v1.39.0 // indirect
 	go.opentelemetry.io/otel/trace v1.39.0 // indirect
 	golang.org/x/arch v0.15.0 // indirect
-	golang.org/x/crypto v0.44.0 // indirect
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394 // indirect
 	golang.org/x/mod v0.29.0 // indirect
 	golang.org/x/net v0.47.0 // indirect
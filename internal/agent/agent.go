@@ -28,6 +28,7 @@ const (
 	StatusCompleted SessionStatus = "completed"
 	StatusFailed    SessionStatus = "failed"
 	StatusNeedsHelp SessionStatus = "needs_help"
+	StatusSuspended SessionStatus = "suspended"
 )
 
 type Session struct {
@@ -39,6 +40,9 @@ type Session struct {
 	Status     SessionStatus `json:"status"`
 	PID        *int          `json:"pid,omitempty"`
 	ExitCode   *int          `json:"exit_code,omitempty"`
+	TokensUsed int64         `json:"tokens_used"`
+	CostUSD    float64       `json:"cost_usd"`
+	HelpReason string        `json:"help_reason,omitempty"` // why the session was flagged needs_help
 	StartedAt  time.Time     `json:"started_at"`
 	EndedAt    *time.Time    `json:"ended_at,omitempty"`
 }
@@ -75,15 +79,38 @@ func (s *Store) Create(session *Session) error {
 func (s *Store) Update(session *Session) error {
 	_, err := s.db.Exec(`
 		UPDATE agent_sessions 
-		SET status = $1, pid = $2, exit_code = $3, ended_at = $4
-		WHERE id = $5
-	`, session.Status, session.PID, session.ExitCode, session.EndedAt, session.ID)
+		SET status = $1, pid = $2, exit_code = $3, ended_at = $4, help_reason = $5
+		WHERE id = $6
+	`, session.Status, session.PID, session.ExitCode, session.EndedAt, session.HelpReason, session.ID)
 	return err
 }
 
+// AddUsage records tokens and dollars spent by a session.
+// Agents report usage incrementally, so values are added to the running totals.
+func (s *Store) AddUsage(id int64, tokens int64, costUSD float64) error {
+	result, err := s.db.Exec(`
+		UPDATE agent_sessions
+		SET tokens_used = tokens_used + $1, cost_usd = cost_usd + $2
+		WHERE id = $3
+	`, tokens, costUSD, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("agent session %d not found", id)
+	}
+
+	return nil
+}
+
 func (s *Store) Get(id int64) (*Session, error) {
 	row := s.db.QueryRow(`
-		SELECT id, branch_repo, branch_name, agent_type, prompt, status, pid, exit_code, started_at, ended_at
+		SELECT id, branch_repo, branch_name, agent_type, prompt, status, pid, exit_code, tokens_used, cost_usd, help_reason, started_at, ended_at
 		FROM agent_sessions WHERE id = $1
 	`, id)
 	return scanSession(row)
@@ -91,7 +118,7 @@ func (s *Store) Get(id int64) (*Session, error) {
 
 func (s *Store) GetByBranch(repo, branchName string) (*Session, error) {
 	row := s.db.QueryRow(`
-		SELECT id, branch_repo, branch_name, agent_type, prompt, status, pid, exit_code, started_at, ended_at
+		SELECT id, branch_repo, branch_name, agent_type, prompt, status, pid, exit_code, tokens_used, cost_usd, help_reason, started_at, ended_at
 		FROM agent_sessions 
 		WHERE branch_repo = $1 AND branch_name = $2 AND status IN ('starting', 'running', 'needs_help')
 		ORDER BY id DESC
@@ -104,7 +131,7 @@ func (s *Store) GetByBranch(repo, branchName string) (*Session, error) {
 // This is used to resume sessions after server restart.
 func (s *Store) GetLatest(repo, branchName string) (*Session, error) {
 	row := s.db.QueryRow(`
-		SELECT id, branch_repo, branch_name, agent_type, prompt, status, pid, exit_code, started_at, ended_at
+		SELECT id, branch_repo, branch_name, agent_type, prompt, status, pid, exit_code, tokens_used, cost_usd, help_reason, started_at, ended_at
 		FROM agent_sessions 
 		WHERE branch_repo = $1 AND branch_name = $2
 		ORDER BY id DESC
@@ -115,7 +142,7 @@ func (s *Store) GetLatest(repo, branchName string) (*Session, error) {
 
 func (s *Store) List(repo, branchName string) ([]Session, error) {
 	query := `
-		SELECT id, branch_repo, branch_name, agent_type, prompt, status, pid, exit_code, started_at, ended_at
+		SELECT id, branch_repo, branch_name, agent_type, prompt, status, pid, exit_code, tokens_used, cost_usd, help_reason, started_at, ended_at
 		FROM agent_sessions WHERE 1=1
 	`
 	args := []interface{}{}
@@ -156,7 +183,7 @@ func scanSession(row *sql.Row) (*Session, error) {
 
 	err := row.Scan(
 		&session.ID, &session.BranchRepo, &session.BranchName, &session.AgentType, &session.Prompt,
-		&session.Status, &pid, &exitCode, &session.StartedAt, &endedAt,
+		&session.Status, &pid, &exitCode, &session.TokensUsed, &session.CostUSD, &session.HelpReason, &session.StartedAt, &endedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -187,7 +214,7 @@ func scanSessionRows(rows *sql.Rows) (*Session, error) {
 
 	err := rows.Scan(
 		&session.ID, &session.BranchRepo, &session.BranchName, &session.AgentType, &session.Prompt,
-		&session.Status, &pid, &exitCode, &session.StartedAt, &endedAt,
+		&session.Status, &pid, &exitCode, &session.TokensUsed, &session.CostUSD, &session.HelpReason, &session.StartedAt, &endedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -208,6 +235,39 @@ func scanSessionRows(rows *sql.Rows) (*Session, error) {
 	return &session, nil
 }
 
+// HeadlessCommand returns a shell command that runs an agent
+// non-interactively on prompt and exits when done.
+func HeadlessCommand(agentType AgentType, prompt string) (string, error) {
+	escapedPrompt := strings.ReplaceAll(prompt, "'", "'\"'\"'")
+	switch agentType {
+	case AgentClaude:
+		return fmt.Sprintf("claude --dangerously-skip-permissions -p '%s'", escapedPrompt), nil
+	case AgentCodex:
+		return fmt.Sprintf("codex exec --full-auto '%s'", escapedPrompt), nil
+	case AgentOpenCode:
+		return fmt.Sprintf("opencode run '%s'", escapedPrompt), nil
+	default:
+		return "", fmt.Errorf("unknown agent type: %s", agentType)
+	}
+}
+
+// SpawnHeadless creates (but does not start) a non-interactive agent process.
+func SpawnHeadless(agentType AgentType, checkoutPath, prompt, repoRef, branchName string) (*exec.Cmd, error) {
+	shellCmd, err := HeadlessCommand(agentType, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("sh", "-c", shellCmd)
+	cmd.Dir = checkoutPath
+	cmd.Env = append(os.Environ(),
+		"COOK_BRANCH="+checkoutPath,
+		"COOK_BRANCH_REPO="+repoRef,
+		"COOK_BRANCH_NAME="+branchName,
+	)
+	return cmd, nil
+}
+
 // Spawn creates an agent command to run in the given checkout directory.
 // Commands are wrapped in a shell to avoid macOS PTY permission issues.
 // repoRef is "owner/repo" and branchName is the branch name for environment variables.
@@ -0,0 +1,90 @@
+package agentproto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrMalformed is returned by Read for a message that can't be decoded.
+// The connection is still usable.
+var ErrMalformed = errors.New("malformed message")
+
+// Conn sends and reads messages on a WebSocket in the negotiated protocol
+// version. Send is safe for concurrent use; Read is not.
+type Conn struct {
+	ws      *websocket.Conn
+	version int
+
+	mu sync.Mutex // serializes writes
+}
+
+// NewConn wraps ws, using version 2 if it was negotiated as the
+// subprotocol.
+func NewConn(ws *websocket.Conn) *Conn {
+	version := 1
+	if ws.Subprotocol() == Subprotocol {
+		version = 2
+	}
+	return &Conn{ws: ws, version: version}
+}
+
+// Version returns the protocol version in use.
+func (c *Conn) Version() int {
+	return c.version
+}
+
+// Send writes msg, as a binary frame if the version supports it.
+func (c *Conn) Send(msg Message) error {
+	messageType := websocket.TextMessage
+	var data []byte
+	var err error
+	if c.version >= 2 && frameType(msg.Type) != 0 {
+		messageType = websocket.BinaryMessage
+		data, err = EncodeFrame(msg)
+	} else {
+		data, err = json.Marshal(msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteMessage(messageType, data)
+}
+
+// Read reads the next message.
+func (c *Conn) Read() (*Message, error) {
+	messageType, data, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if messageType == websocket.BinaryMessage {
+		msg, err := DecodeFrame(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		return msg, nil
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return &msg, nil
+}
+
+// SetReadDeadline sets the deadline for Read.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.ws.Close()
+}
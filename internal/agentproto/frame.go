@@ -0,0 +1,80 @@
+package agentproto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Binary frames carry raw bytes: a session's terminal input or output,
+// file contents and command output for RPCs (with no ID), or a tunnel's
+// data (with the tunnel ID):
+//
+//	type (1 byte) | ID length (2 bytes, big endian) | ID | data
+//
+// Every other message stays JSON.
+const frameHeaderSize = 3
+
+var frameTypes = []string{
+	1: MsgInput,
+	2: MsgOutput,
+	3: MsgData,
+	4: MsgStdout,
+	5: MsgStderr,
+	6: MsgTunnelData,
+}
+
+// frameType returns the frame type for messages of type typ, or 0 if they
+// are sent as JSON.
+func frameType(typ string) byte {
+	for i, t := range frameTypes {
+		if t != "" && t == typ {
+			return byte(i)
+		}
+	}
+	return 0
+}
+
+// EncodeFrame encodes an input or output message as a binary frame.
+func EncodeFrame(msg Message) ([]byte, error) {
+	typ := frameType(msg.Type)
+	if typ == 0 {
+		return nil, fmt.Errorf("%s messages have no binary frame", msg.Type)
+	}
+	id := msg.SessionID
+	if msg.Type == MsgTunnelData {
+		id = msg.Tunnel
+	}
+	if len(id) > 0xffff {
+		return nil, fmt.Errorf("ID too long")
+	}
+
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(id)+len(msg.Data))
+	frame[0] = typ
+	binary.BigEndian.PutUint16(frame[1:], uint16(len(id)))
+	frame = append(frame, id...)
+	return append(frame, msg.Data...), nil
+}
+
+// DecodeFrame decodes a binary frame. The message's data aliases frame.
+func DecodeFrame(frame []byte) (*Message, error) {
+	if len(frame) < frameHeaderSize {
+		return nil, fmt.Errorf("short frame")
+	}
+	var msg Message
+	if int(frame[0]) >= len(frameTypes) || frameTypes[frame[0]] == "" {
+		return nil, fmt.Errorf("unknown frame type %d", frame[0])
+	}
+	msg.Type = frameTypes[frame[0]]
+	idLen := int(binary.BigEndian.Uint16(frame[1:]))
+	if len(frame) < frameHeaderSize+idLen {
+		return nil, fmt.Errorf("short frame")
+	}
+	id := string(frame[frameHeaderSize : frameHeaderSize+idLen])
+	if msg.Type == MsgTunnelData {
+		msg.Tunnel = id
+	} else {
+		msg.SessionID = id
+	}
+	msg.Data = frame[frameHeaderSize+idLen:]
+	return &msg, nil
+}
@@ -0,0 +1,172 @@
+// Package agentproto defines the protocol between cook and cook-agent.
+//
+// Messages are JSON in WebSocket text frames. Clients and agents that both
+// speak version 2 negotiate it as the WebSocket subprotocol and then
+// exchange hello messages; terminal input and output then travel as binary
+// frames (see EncodeFrame) instead of base64 inside JSON. A peer that
+// offers or accepts no subprotocol speaks version 1, JSON only, so old
+// agents and clients keep working.
+package agentproto
+
+import (
+	"time"
+
+	"github.com/justinmoon/cook/internal/terminal"
+)
+
+// Version is the newest protocol version.
+const Version = 2
+
+// Subprotocol is the WebSocket subprotocol for version 2.
+const Subprotocol = "cook-agent.v2"
+
+// Message types
+const (
+	MsgHello    = "hello"
+	MsgCreate   = "create"
+	MsgAttach   = "attach"
+	MsgDetach   = "detach"
+	MsgInput    = "input"
+	MsgOutput   = "output"
+	MsgResize   = "resize"
+	MsgList     = "list"
+	MsgActivity = "activity"
+	MsgExit     = "exit"
+	MsgOK       = "ok"
+	MsgError    = "error"
+
+	// File and exec RPCs, for agents with the matching Feature
+	MsgStat       = "stat"       // Path -> File
+	MsgReadFile   = "read_file"  // Path -> data..., eof
+	MsgWriteFile  = "write_file" // Path, Mode, then data..., eof -> ok
+	MsgListFiles  = "list_files" // Path, Recursive -> Files
+	MsgExec       = "exec"       // Command, WorkDir, Env -> stdout/stderr..., exit
+	MsgWatch      = "watch"      // Path -> ok, file_events... until unwatch -> ok
+	MsgUnwatch    = "unwatch"
+	MsgData       = "data"
+	MsgEOF        = "eof"
+	MsgStdout     = "stdout"
+	MsgStderr     = "stderr"
+	MsgFileEvents = "file_events"
+
+	// TCP tunnels, for agents with FeatureTunnel. Either side closes a
+	// tunnel with tunnel_close.
+	MsgTunnelOpen  = "tunnel_open" // Tunnel, Addr -> ok or error with Tunnel
+	MsgTunnelData  = "tunnel_data"
+	MsgTunnelClose = "tunnel_close"
+
+	// Agent maintenance, for agents with FeatureStatus and FeatureUpdate
+	MsgStatus = "status" // -> Status
+	MsgUpdate = "update" // Checksum, then data..., eof -> ok
+)
+
+// Features an agent reports in its hello.
+const (
+	FeatureFiles  = "files" // stat, read_file, write_file, list_files
+	FeatureExec   = "exec"
+	FeatureWatch  = "watch"
+	FeatureTunnel = "tunnel"
+	FeatureStatus = "status"
+	FeatureUpdate = "update"
+)
+
+// Error codes, for errors callers handle.
+const (
+	CodeNotExist = "not_exist"
+)
+
+// Message is the wire format for agent communication
+type Message struct {
+	Type      string                `json:"type"`
+	Version   int                   `json:"version,omitempty"`  // hello: protocol version
+	Features  []string              `json:"features,omitempty"` // hello: what the agent supports
+	SessionID string                `json:"session_id,omitempty"`
+	Command   string                `json:"command,omitempty"`
+	WorkDir   string                `json:"workdir,omitempty"`
+	Data      []byte                `json:"data,omitempty"`
+	Rows      int                   `json:"rows,omitempty"`
+	Cols      int                   `json:"cols,omitempty"`
+	Error     string                `json:"error,omitempty"`
+	Code      string                `json:"code,omitempty"` // error: e.g. CodeNotExist
+	Env       []string              `json:"env,omitempty"`  // create: extra KEY=value environment, values masked in output
+	Sessions  []string              `json:"sessions,omitempty"`
+	IdleAfter int                   `json:"idle_after,omitempty"` // activity request: idle threshold in seconds
+	Activity  *terminal.StuckReport `json:"activity,omitempty"`
+	ExitCode  *int                  `json:"exit_code,omitempty"` // exit: the session's exit status
+	Info      []SessionInfo         `json:"info,omitempty"`      // list: every session the agent knows of
+	Path      string                `json:"path,omitempty"`
+	Mode      uint32                `json:"mode,omitempty"` // write_file: permission bits (default 0644)
+	Recursive bool                  `json:"recursive,omitempty"`
+	File      *FileInfo             `json:"file,omitempty"`
+	Files     []FileInfo            `json:"files,omitempty"`
+	Events    []FileEvent           `json:"events,omitempty"`
+	Tunnel    string                `json:"tunnel,omitempty"` // tunnel ID, chosen by the client
+	Addr      string                `json:"addr,omitempty"`   // tunnel_open: host:port to dial in the environment
+	Status    *AgentStatus          `json:"status,omitempty"`
+	Checksum  string                `json:"checksum,omitempty"` // update: hex SHA-256 of the new binary
+}
+
+// FileInfo describes a file in the environment.
+type FileInfo struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	Mode    uint32    `json:"mode"` // permission bits
+	ModTime time.Time `json:"mod_time"`
+}
+
+// File event operations
+const (
+	OpCreate = "create"
+	OpModify = "modify"
+	OpRemove = "remove"
+)
+
+// FileEvent is a change to a watched file.
+type FileEvent struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+}
+
+// Session states reported in SessionInfo.
+const (
+	SessionRunning = "running"
+	SessionExited  = "exited"
+	SessionLost    = "lost" // running when a previous agent process stopped
+)
+
+// SessionInfo describes a cook-agent session. Ended sessions are kept for
+// a while so clients can learn how they ended.
+type SessionInfo struct {
+	ID        string     `json:"id"`
+	Command   string     `json:"command"`
+	PID       int        `json:"pid,omitempty"`
+	State     string     `json:"state"`
+	ExitCode  *int       `json:"exit_code,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+
+	Usage *ProcessUsage `json:"usage,omitempty"` // status: running sessions only
+}
+
+// ProcessUsage is the resources a running session's processes use.
+type ProcessUsage struct {
+	Processes  int     `json:"processes"`
+	CPUSeconds float64 `json:"cpu_seconds"` // user and system time so far
+	RSSBytes   int64   `json:"rss_bytes"`
+}
+
+// AgentStatus is an agent's report on itself.
+type AgentStatus struct {
+	Version   string        `json:"version"`
+	Checksum  string        `json:"checksum"` // hex SHA-256 of the agent's binary
+	PID       int           `json:"pid"`
+	StartedAt time.Time     `json:"started_at"`
+	Uptime    float64       `json:"uptime"` // seconds, by the agent's clock
+	Sessions  []SessionInfo `json:"sessions"`
+
+	// RestartPending is set once an update is installed, until the agent
+	// restarts into it when no session or exec is running.
+	RestartPending bool `json:"restart_pending,omitempty"`
+}
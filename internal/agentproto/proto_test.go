@@ -0,0 +1,134 @@
+package agentproto
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, msg := range []Message{
+		{Type: MsgInput, SessionID: "alice/app/main", Data: []byte("ls\r")},
+		{Type: MsgOutput, SessionID: "s", Data: []byte{0x1b, '[', 'H', 0}},
+		{Type: MsgOutput, SessionID: "", Data: nil},
+		{Type: MsgStderr, Data: []byte("oops\n")},
+		{Type: MsgTunnelData, Tunnel: "7", Data: []byte("GET / HTTP/1.1\r\n")},
+	} {
+		frame, err := EncodeFrame(msg)
+		if err != nil {
+			t.Fatalf("EncodeFrame(%+v): %v", msg, err)
+		}
+		if want := frameHeaderSize + len(msg.SessionID) + len(msg.Tunnel) + len(msg.Data); len(frame) != want {
+			t.Errorf("frame is %d bytes, want %d", len(frame), want)
+		}
+		got, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatalf("DecodeFrame: %v", err)
+		}
+		if got.Type != msg.Type || got.SessionID != msg.SessionID || got.Tunnel != msg.Tunnel || !bytes.Equal(got.Data, msg.Data) {
+			t.Errorf("round trip = %+v, want %+v", got, msg)
+		}
+	}
+
+	if _, err := EncodeFrame(Message{Type: MsgResize}); err == nil {
+		t.Error("EncodeFrame(resize) succeeded")
+	}
+	for _, frame := range [][]byte{{}, {2, 0}, {2, 0, 5, 'a'}, {0, 0, 0}, {9, 0, 0}} {
+		if _, err := DecodeFrame(frame); err == nil {
+			t.Errorf("DecodeFrame(%v) succeeded", frame)
+		}
+	}
+}
+
+// echoServer echoes messages back on a Conn, recording whether they
+// arrived as binary frames.
+func echoServer(t *testing.T, binary chan<- bool) *httptest.Server {
+	upgrader := websocket.Upgrader{Subprotocols: []string{Subprotocol}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		conn := NewConn(ws)
+		for {
+			messageType, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			binary <- messageType == websocket.BinaryMessage
+			var msg *Message
+			if messageType == websocket.BinaryMessage {
+				msg, err = DecodeFrame(data)
+			} else {
+				msg = &Message{Type: MsgOK}
+			}
+			if err != nil {
+				return
+			}
+			conn.Send(*msg)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestConnVersions(t *testing.T) {
+	binary := make(chan bool, 1)
+	srv := echoServer(t, binary)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	for _, tc := range []struct {
+		name         string
+		subprotocols []string
+		version      int
+	}{
+		{"negotiated", []string{Subprotocol}, 2},
+		{"json only", nil, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: tc.subprotocols}
+			ws, _, err := dialer.Dial(url, nil)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			conn := NewConn(ws)
+			defer conn.Close()
+			if conn.Version() != tc.version {
+				t.Fatalf("Version() = %d, want %d", conn.Version(), tc.version)
+			}
+
+			if err := conn.Send(Message{Type: MsgInput, SessionID: "s", Data: []byte("hi")}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if got := <-binary; got != (tc.version == 2) {
+				t.Errorf("input sent as binary = %v", got)
+			}
+			msg, err := conn.Read()
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if tc.version == 2 && (msg.Type != MsgInput || string(msg.Data) != "hi") {
+				t.Errorf("echoed %+v", msg)
+			}
+			if tc.version == 1 && msg.Type != MsgOK {
+				t.Errorf("reply %+v, want ok", msg)
+			}
+
+			// Other messages stay JSON in either version
+			if err := conn.Send(Message{Type: MsgList}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if <-binary {
+				t.Error("list sent as binary")
+			}
+			if _, err := conn.Read(); err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -46,8 +47,18 @@ func (b *Branch) TaskFullName() string {
 }
 
 // Backend returns a Backend for this branch's environment.
-// Returns an error if the branch has no environment configured.
+// Returns an error if the branch has no environment configured, or cook
+// stopped it until someone resumes it (see ResumeStopped).
 func (b *Branch) Backend() (env.Backend, error) {
+	if b.Environment.StoppedReason != "" {
+		return nil, fmt.Errorf("branch environment stopped: %s", b.Environment.StoppedReason)
+	}
+	return b.backend()
+}
+
+// backend is Backend without the stop check, for tearing down or resuming
+// a stopped environment.
+func (b *Branch) backend() (env.Backend, error) {
 	if b.Environment.Path == "" {
 		return nil, fmt.Errorf("branch has no checkout path")
 	}
@@ -66,6 +77,14 @@ func (b *Branch) Backend() (env.Backend, error) {
 		return env.NewDockerBackendFromContainerID(b.Environment.ContainerID, b.Environment.Path)
 	}
 
+	// For Podman backend, reconnect using container ID
+	if b.Environment.Backend == "podman" {
+		if b.Environment.ContainerID == "" {
+			return nil, fmt.Errorf("podman backend has no container ID")
+		}
+		return env.NewPodmanBackendFromExisting(b.Environment.ContainerID, b.Environment.Path)
+	}
+
 	// For Modal backend, reconnect using sandbox ID
 	if b.Environment.Backend == "modal" {
 		if b.Environment.SandboxID == "" {
@@ -90,24 +109,48 @@ func (b *Branch) Backend() (env.Backend, error) {
 		return env.NewFlyMachinesBackendFromMachineID(b.Environment.MachineID, b.Environment.Path)
 	}
 
+	// For SSH backend, reconnect using the host
+	if b.Environment.Backend == "ssh" {
+		if b.Environment.Host == "" {
+			return nil, fmt.Errorf("ssh backend has no host")
+		}
+		return env.NewSSHBackendFromHost(b.Environment.Host, b.Environment.Path)
+	}
+
+	// Plugin backends reconnect from the state their setup returned
+	if env.IsPlugin(b.Environment.Backend) && b.Environment.BackendState == nil {
+		return nil, fmt.Errorf("%s backend has no plugin state", b.Environment.Backend)
+	}
+
 	cfg := env.Config{
 		WorkDir:  b.Environment.Path,
 		Dotfiles: b.Environment.Dotfiles,
+		Network:  b.Environment.Network,
+		State:    b.Environment.BackendState,
 	}
 	return env.NewBackend(env.Type(b.Environment.Backend), cfg)
 }
 
 type EnvironmentSpec struct {
-	Backend           string `json:"backend"`                      // "local", "docker", "modal", "sprites", "fly-machines"
-	Path              string `json:"path"`                         // checkout path (host path for docker)
-	Image             string `json:"image,omitempty"`              // docker image (optional)
-	Dotfiles          string `json:"dotfiles,omitempty"`           // git URL for dotfiles repo (optional)
-	ContainerID       string `json:"container_id,omitempty"`       // docker container ID
-	SandboxID         string `json:"sandbox_id,omitempty"`         // modal sandbox ID
-	SpriteName        string `json:"sprite_name,omitempty"`        // sprites sprite name
-	MachineID         string `json:"machine_id,omitempty"`         // fly machines machine ID
-	Provisioning      bool   `json:"provisioning,omitempty"`       // async setup in progress
-	ProvisioningError string `json:"provisioning_error,omitempty"` // async setup error
+	Backend           string          `json:"backend"`                      // "local", "sandbox", "docker", "podman", "modal", "sprites", "fly-machines", "ssh", or a plugin name
+	Path              string          `json:"path"`                         // checkout path (host path for docker, remote path for ssh)
+	Image             string          `json:"image,omitempty"`              // docker image (optional; set when built from devcontainer.json)
+	Dotfiles          string          `json:"dotfiles,omitempty"`           // git URL for dotfiles repo (optional)
+	ContainerID       string          `json:"container_id,omitempty"`       // docker/podman container ID
+	SandboxID         string          `json:"sandbox_id,omitempty"`         // modal sandbox ID
+	SpriteName        string          `json:"sprite_name,omitempty"`        // sprites sprite name
+	MachineID         string          `json:"machine_id,omitempty"`         // fly machines machine ID
+	Host              string          `json:"host,omitempty"`               // ssh destination
+	Network           []string        `json:"network,omitempty"`            // sandbox network policy ("none" or allowed hosts)
+	BackendState      json.RawMessage `json:"backend_state,omitempty"`      // plugin backend's opaque reconnection state
+	Provisioning      bool            `json:"provisioning,omitempty"`       // async setup in progress
+	ProvisioningError string          `json:"provisioning_error,omitempty"` // async setup error
+	StoppedReason     string          `json:"stopped_reason,omitempty"`     // why cook stopped the environment (e.g. budget exceeded)
+	SuspendedAt       *time.Time      `json:"suspended_at,omitempty"`       // when cook suspended the idle environment (resumed on next use)
+	Profile           string          `json:"profile,omitempty"`            // resource profile name from the server config
+	Resources         *env.Resources  `json:"resources,omitempty"`          // the profile's limits when the environment was created
+	Named             string          `json:"named,omitempty"`              // named environment hosting the checkout (see NamedEnvironment)
+	ForwardPorts      []int           `json:"forward_ports,omitempty"`      // ports to offer in the web UI (devcontainer.json forwardPorts)
 }
 
 const (
@@ -158,12 +201,31 @@ func (s *Store) UpdateEnvironment(repo, name string, envSpec EnvironmentSpec) er
 	if err != nil {
 		return err
 	}
-	_, err = s.db.Exec(`UPDATE branches SET environment_json = ? WHERE repo = ? AND name = ?`, string(envJSON), repo, name)
+	_, err = s.db.Exec(`UPDATE branches SET environment_json = $1 WHERE repo = $2 AND name = $3`, string(envJSON), repo, name)
 	return err
 }
 
 // CreateWithCheckout creates a branch with a cloned checkout directory
 func (s *Store) CreateWithCheckout(b *Branch, bareRepoPath string, dotfiles string) error {
+	return s.createWithLocalCheckout(b, bareRepoPath, EnvironmentSpec{
+		Backend:  "local",
+		Dotfiles: dotfiles,
+	})
+}
+
+// CreateWithSandboxCheckout creates a branch with a local checkout whose
+// commands run in a bubblewrap sandbox with the given network policy.
+func (s *Store) CreateWithSandboxCheckout(b *Branch, bareRepoPath string, dotfiles string, network []string) error {
+	return s.createWithLocalCheckout(b, bareRepoPath, EnvironmentSpec{
+		Backend:  "sandbox",
+		Dotfiles: dotfiles,
+		Network:  network,
+	})
+}
+
+// createWithLocalCheckout clones a checkout on this host for envSpec's
+// backend and sets up its isolated home.
+func (s *Store) createWithLocalCheckout(b *Branch, bareRepoPath string, envSpec EnvironmentSpec) error {
 	// Validate branch name
 	if strings.Contains(b.Name, "/") {
 		return fmt.Errorf("branch name cannot contain '/'")
@@ -200,11 +262,10 @@ func (s *Store) CreateWithCheckout(b *Branch, bareRepoPath string, dotfiles stri
 		return fmt.Errorf("git checkout -b failed: %s: %w", string(output), err)
 	}
 
-	b.Environment = EnvironmentSpec{
-		Backend:  "local",
-		Path:     checkoutPath,
-		Dotfiles: dotfiles,
-	}
+	envSpec.Path = checkoutPath
+	envSpec.Profile = b.Environment.Profile
+	envSpec.Resources = b.Environment.Resources
+	b.Environment = envSpec
 	b.Status = StatusActive
 
 	// Set up the isolated home environment (and dotfiles if specified)
@@ -213,8 +274,12 @@ func (s *Store) CreateWithCheckout(b *Branch, bareRepoPath string, dotfiles stri
 		os.RemoveAll(checkoutPath)
 		return fmt.Errorf("failed to create backend: %w", err)
 	}
-	lb := backend.(*env.LocalBackend)
-	if err := lb.SetupHome(context.Background()); err != nil {
+	hb, ok := backend.(interface{ SetupHome(context.Context) error })
+	if !ok {
+		os.RemoveAll(checkoutPath)
+		return fmt.Errorf("%s backend has no local home", envSpec.Backend)
+	}
+	if err := hb.SetupHome(context.Background()); err != nil {
 		os.RemoveAll(checkoutPath)
 		return fmt.Errorf("failed to setup home: %w", err)
 	}
@@ -230,6 +295,22 @@ func (s *Store) CreateWithCheckout(b *Branch, bareRepoPath string, dotfiles stri
 
 // CreateWithDockerCheckout creates a branch with a Docker container environment
 func (s *Store) CreateWithDockerCheckout(b *Branch, bareRepoPath string, dotfiles string) error {
+	return s.createWithContainerCheckout(b, bareRepoPath, dotfiles, env.TypeDocker)
+}
+
+// CreateWithPodmanCheckout creates a branch with a rootless Podman container environment
+func (s *Store) CreateWithPodmanCheckout(b *Branch, bareRepoPath string, dotfiles string) error {
+	return s.createWithContainerCheckout(b, bareRepoPath, dotfiles, env.TypePodman)
+}
+
+// containerBackend is a backend that runs in a local container.
+type containerBackend interface {
+	env.Backend
+	ContainerID() string
+	DevContainer() *env.DevContainer
+}
+
+func (s *Store) createWithContainerCheckout(b *Branch, bareRepoPath, dotfiles string, backendType env.Type) error {
 	// Validate branch name
 	if strings.Contains(b.Name, "/") {
 		return fmt.Errorf("branch name cannot contain '/'")
@@ -249,7 +330,7 @@ func (s *Store) CreateWithDockerCheckout(b *Branch, bareRepoPath string, dotfile
 	// Remove if exists (clean slate)
 	os.RemoveAll(checkoutPath)
 
-	// Create Docker backend config
+	// Create container backend config
 	containerName := strings.ReplaceAll(b.Repo, "/", "-") + "-" + b.Name
 	cfg := env.Config{
 		Name:       containerName,
@@ -257,24 +338,36 @@ func (s *Store) CreateWithDockerCheckout(b *Branch, bareRepoPath string, dotfile
 		BranchName: b.Name,
 		WorkDir:    checkoutPath,
 		Dotfiles:   dotfiles,
+		Resources:  b.Environment.resources(),
 	}
 
-	backend, err := env.NewDockerBackend(cfg)
+	var backend containerBackend
+	if backendType == env.TypePodman {
+		backend, err = env.NewPodmanBackend(cfg)
+	} else {
+		backend, err = env.NewDockerBackend(cfg)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create docker backend: %w", err)
+		return fmt.Errorf("failed to create %s backend: %w", backendType, err)
 	}
 
 	// Setup the container (this clones repo, starts container, sets up dotfiles)
 	if err := backend.Setup(context.Background()); err != nil {
 		backend.Teardown(context.Background())
-		return fmt.Errorf("failed to setup docker environment: %w", err)
+		return fmt.Errorf("failed to setup %s environment: %w", backendType, err)
 	}
 
 	b.Environment = EnvironmentSpec{
-		Backend:     "docker",
+		Backend:     string(backendType),
 		Path:        checkoutPath,
 		Dotfiles:    dotfiles,
 		ContainerID: backend.ContainerID(),
+		Profile:     b.Environment.Profile,
+		Resources:   b.Environment.Resources,
+	}
+	if dc := backend.DevContainer(); dc != nil {
+		b.Environment.Image = dc.ImageName()
+		b.Environment.ForwardPorts = dc.Ports()
 	}
 	b.Status = StatusActive
 
@@ -307,6 +400,41 @@ func (s *Store) CreateProvisioningRemoteBranch(b *Branch, bareRepoPath, backendT
 		Path:         checkoutPath,
 		Dotfiles:     dotfiles,
 		Provisioning: true,
+		Profile:      b.Environment.Profile,
+		Resources:    b.Environment.Resources,
+	}
+	b.Status = StatusActive
+
+	return s.Create(b)
+}
+
+// CreateProvisioningSSHBranch records a branch whose checkout will be
+// provisioned on host under remoteRoot by ProvisionRemoteBranch.
+func (s *Store) CreateProvisioningSSHBranch(b *Branch, bareRepoPath, host, remoteRoot string) error {
+	// Validate branch name
+	if strings.Contains(b.Name, "/") {
+		return fmt.Errorf("branch name cannot contain '/'")
+	}
+	if host == "" {
+		return fmt.Errorf("ssh backend requires a host (set [server.ssh] host)")
+	}
+
+	// Get base rev from master
+	baseRev, err := getHeadRev(bareRepoPath, "master")
+	if err != nil {
+		return fmt.Errorf("failed to get master HEAD: %w (does the repo have commits?)", err)
+	}
+	b.BaseRev = baseRev
+	b.HeadRev = baseRev
+
+	// The checkout lives on the remote host; remoteRoot may be relative to its home
+	b.Environment = EnvironmentSpec{
+		Backend:      "ssh",
+		Path:         path.Join(remoteRoot, b.Repo, b.Name),
+		Host:         host,
+		Provisioning: true,
+		Profile:      b.Environment.Profile,
+		Resources:    b.Environment.Resources,
 	}
 	b.Status = StatusActive
 
@@ -343,17 +471,23 @@ func (s *Store) CreateWithModalCheckout(b *Branch, bareRepoPath, repoURL, dotfil
 		BranchName:  b.Name,
 		WorkDir:     checkoutPath,
 		Dotfiles:    dotfiles,
+		Resources:   b.Environment.resources(),
 	}
 
-	backend, err := env.NewModalBackend(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to create modal backend: %w", err)
-	}
+	backend, warm := claimWarm(context.Background(), "modal", cfg).(*env.ModalBackend)
+	if warm {
+		defer s.releaseWarm("modal", backend.SandboxID())
+	} else {
+		backend, err = env.NewModalBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create modal backend: %w", err)
+		}
 
-	// Setup the sandbox (this clones repo, starts cook-agent, sets up dotfiles)
-	if err := backend.Setup(context.Background()); err != nil {
-		backend.Teardown(context.Background())
-		return fmt.Errorf("failed to setup modal environment: %w", err)
+		// Setup the sandbox (this clones repo, starts cook-agent, sets up dotfiles)
+		if err := backend.Setup(context.Background()); err != nil {
+			backend.Teardown(context.Background())
+			return fmt.Errorf("failed to setup modal environment: %w", err)
+		}
 	}
 
 	b.Environment = EnvironmentSpec{
@@ -361,6 +495,8 @@ func (s *Store) CreateWithModalCheckout(b *Branch, bareRepoPath, repoURL, dotfil
 		Path:      checkoutPath,
 		Dotfiles:  dotfiles,
 		SandboxID: backend.SandboxID(),
+		Profile:   b.Environment.Profile,
+		Resources: b.Environment.Resources,
 	}
 	b.Status = StatusActive
 
@@ -403,17 +539,23 @@ func (s *Store) CreateWithSpritesCheckout(b *Branch, bareRepoPath, repoURL, dotf
 		BranchName:  b.Name,
 		WorkDir:     checkoutPath,
 		Dotfiles:    dotfiles,
+		Resources:   b.Environment.resources(),
 	}
 
-	backend, err := env.NewSpritesBackend(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to create sprites backend: %w", err)
-	}
+	backend, warm := claimWarm(context.Background(), "sprites", cfg).(*env.SpritesBackend)
+	if warm {
+		defer s.releaseWarm("sprites", backend.SpriteName())
+	} else {
+		backend, err = env.NewSpritesBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create sprites backend: %w", err)
+		}
 
-	// Setup the sprite (this clones repo, starts cook-agent, sets up dotfiles)
-	if err := backend.Setup(context.Background()); err != nil {
-		backend.Teardown(context.Background())
-		return fmt.Errorf("failed to setup sprites environment: %w", err)
+		// Setup the sprite (this clones repo, starts cook-agent, sets up dotfiles)
+		if err := backend.Setup(context.Background()); err != nil {
+			backend.Teardown(context.Background())
+			return fmt.Errorf("failed to setup sprites environment: %w", err)
+		}
 	}
 
 	b.Environment = EnvironmentSpec{
@@ -421,6 +563,8 @@ func (s *Store) CreateWithSpritesCheckout(b *Branch, bareRepoPath, repoURL, dotf
 		Path:       checkoutPath,
 		Dotfiles:   dotfiles,
 		SpriteName: backend.SpriteName(),
+		Profile:    b.Environment.Profile,
+		Resources:  b.Environment.Resources,
 	}
 	b.Status = StatusActive
 
@@ -463,17 +607,23 @@ func (s *Store) CreateWithFlyMachinesCheckout(b *Branch, bareRepoPath, repoURL,
 		BranchName:  b.Name,
 		WorkDir:     checkoutPath,
 		Dotfiles:    dotfiles,
+		Resources:   b.Environment.resources(),
 	}
 
-	backend, err := env.NewFlyMachinesBackend(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to create fly machines backend: %w", err)
-	}
+	backend, warm := claimWarm(context.Background(), "fly-machines", cfg).(*env.FlyMachinesBackend)
+	if warm {
+		defer s.releaseWarm("fly-machines", backend.MachineID())
+	} else {
+		backend, err = env.NewFlyMachinesBackend(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create fly machines backend: %w", err)
+		}
 
-	// Setup the machine (this clones repo, starts cook-agent, sets up dotfiles)
-	if err := backend.Setup(context.Background()); err != nil {
-		backend.Teardown(context.Background())
-		return fmt.Errorf("failed to setup fly machines environment: %w", err)
+		// Setup the machine (this clones repo, starts cook-agent, sets up dotfiles)
+		if err := backend.Setup(context.Background()); err != nil {
+			backend.Teardown(context.Background())
+			return fmt.Errorf("failed to setup fly machines environment: %w", err)
+		}
 	}
 
 	b.Environment = EnvironmentSpec{
@@ -481,6 +631,8 @@ func (s *Store) CreateWithFlyMachinesCheckout(b *Branch, bareRepoPath, repoURL,
 		Path:      checkoutPath,
 		Dotfiles:  dotfiles,
 		MachineID: backend.MachineID(),
+		Profile:   b.Environment.Profile,
+		Resources: b.Environment.Resources,
 	}
 	b.Status = StatusActive
 
@@ -500,6 +652,9 @@ func (s *Store) ProvisionRemoteBranch(ctx context.Context, b *Branch, bareRepoPa
 	if repoURL == "" {
 		repoURL = bareRepoPath
 	}
+	if b.Environment.Named != "" {
+		return s.provisionInEnvironment(ctx, b, repoURL, taskMdContent)
+	}
 
 	var backend env.Backend
 	var err error
@@ -515,16 +670,21 @@ func (s *Store) ProvisionRemoteBranch(ctx context.Context, b *Branch, bareRepoPa
 			BranchName:  b.Name,
 			WorkDir:     envSpec.Path,
 			Dotfiles:    envSpec.Dotfiles,
+			Resources:   envSpec.resources(),
 		}
-		var mb *env.ModalBackend
-		mb, err = env.NewModalBackend(cfg)
-		backend = mb
-		if err == nil {
-			err = mb.Setup(ctx)
+		mb, warm := claimWarm(ctx, "modal", cfg).(*env.ModalBackend)
+		if warm {
+			defer s.releaseWarm("modal", mb.SandboxID())
+		} else {
+			mb, err = env.NewModalBackend(cfg)
 			if err == nil {
-				envSpec.SandboxID = mb.SandboxID()
+				err = mb.Setup(ctx)
 			}
 		}
+		backend = mb
+		if err == nil {
+			envSpec.SandboxID = mb.SandboxID()
+		}
 	case "sprites":
 		spriteName := spritesNameForBranch(b.Repo, b.Name)
 		cfg := env.Config{
@@ -534,16 +694,21 @@ func (s *Store) ProvisionRemoteBranch(ctx context.Context, b *Branch, bareRepoPa
 			BranchName:  b.Name,
 			WorkDir:     envSpec.Path,
 			Dotfiles:    envSpec.Dotfiles,
+			Resources:   envSpec.resources(),
 		}
-		var sb *env.SpritesBackend
-		sb, err = env.NewSpritesBackend(cfg)
-		backend = sb
-		if err == nil {
-			err = sb.Setup(ctx)
+		sb, warm := claimWarm(ctx, "sprites", cfg).(*env.SpritesBackend)
+		if warm {
+			defer s.releaseWarm("sprites", sb.SpriteName())
+		} else {
+			sb, err = env.NewSpritesBackend(cfg)
 			if err == nil {
-				envSpec.SpriteName = sb.SpriteName()
+				err = sb.Setup(ctx)
 			}
 		}
+		backend = sb
+		if err == nil {
+			envSpec.SpriteName = sb.SpriteName()
+		}
 	case "fly-machines":
 		machineName := flyMachineNameForBranch(b.Repo, b.Name)
 		cfg := env.Config{
@@ -553,18 +718,55 @@ func (s *Store) ProvisionRemoteBranch(ctx context.Context, b *Branch, bareRepoPa
 			BranchName:  b.Name,
 			WorkDir:     envSpec.Path,
 			Dotfiles:    envSpec.Dotfiles,
+			Resources:   envSpec.resources(),
 		}
-		var fb *env.FlyMachinesBackend
-		fb, err = env.NewFlyMachinesBackend(cfg)
-		backend = fb
-		if err == nil {
-			err = fb.Setup(ctx)
+		fb, warm := claimWarm(ctx, "fly-machines", cfg).(*env.FlyMachinesBackend)
+		if warm {
+			defer s.releaseWarm("fly-machines", fb.MachineID())
+		} else {
+			fb, err = env.NewFlyMachinesBackend(cfg)
 			if err == nil {
-				envSpec.MachineID = fb.MachineID()
+				err = fb.Setup(ctx)
 			}
 		}
+		backend = fb
+		if err == nil {
+			envSpec.MachineID = fb.MachineID()
+		}
+	case "ssh":
+		// Push from the bare repo over ssh; the host may not reach cook
+		cfg := env.Config{
+			Name:       b.Repo + "/" + b.Name,
+			RepoURL:    bareRepoPath,
+			BranchName: b.Name,
+			WorkDir:    envSpec.Path,
+			Host:       envSpec.Host,
+		}
+		var sb *env.SSHBackend
+		sb, err = env.NewSSHBackend(cfg)
+		backend = sb
+		if err == nil {
+			err = sb.Setup(ctx)
+		}
 	default:
-		return fmt.Errorf("unsupported backend for async provisioning: %s", envSpec.Backend)
+		if !env.IsPlugin(envSpec.Backend) {
+			return fmt.Errorf("unsupported backend for async provisioning: %s", envSpec.Backend)
+		}
+		cfg := env.Config{
+			Name:       b.Repo + "/" + b.Name,
+			RepoURL:    repoURL,
+			BranchName: b.Name,
+			WorkDir:    envSpec.Path,
+			Dotfiles:   envSpec.Dotfiles,
+			Resources:  envSpec.resources(),
+		}
+		var pb *env.PluginBackend
+		pb, err = env.NewPluginBackend(envSpec.Backend, cfg)
+		if err == nil {
+			backend = pb
+			err = pb.Setup(ctx)
+			envSpec.BackendState = pb.State()
+		}
 	}
 
 	if err != nil {
@@ -611,9 +813,17 @@ func (s *Store) RemoveCheckout(b *Branch) error {
 		return nil
 	}
 
-	// For Docker backend, teardown the container first
-	if b.Environment.Backend == "docker" && b.Environment.ContainerID != "" {
-		backend, err := b.Backend()
+	// Snapshots go first; some backends need the environment to find them
+	s.deleteSnapshots(b)
+
+	// Named environments outlive their branches
+	if b.Environment.Named != "" {
+		return s.detachEnvironment(b)
+	}
+
+	// For Docker/Podman backends, teardown the container first
+	if (b.Environment.Backend == "docker" || b.Environment.Backend == "podman") && b.Environment.ContainerID != "" {
+		backend, err := b.backend()
 		if err == nil {
 			backend.Teardown(context.Background())
 		}
@@ -621,7 +831,7 @@ func (s *Store) RemoveCheckout(b *Branch) error {
 
 	// For Modal backend, teardown the sandbox
 	if b.Environment.Backend == "modal" && b.Environment.SandboxID != "" {
-		backend, err := b.Backend()
+		backend, err := b.backend()
 		if err == nil {
 			backend.Teardown(context.Background())
 		}
@@ -629,7 +839,7 @@ func (s *Store) RemoveCheckout(b *Branch) error {
 
 	// For Sprites backend, teardown the sprite
 	if b.Environment.Backend == "sprites" && b.Environment.SpriteName != "" {
-		backend, err := b.Backend()
+		backend, err := b.backend()
 		if err == nil {
 			backend.Teardown(context.Background())
 		}
@@ -637,14 +847,33 @@ func (s *Store) RemoveCheckout(b *Branch) error {
 
 	// For Fly Machines backend, teardown the machine
 	if b.Environment.Backend == "fly-machines" && b.Environment.MachineID != "" {
-		backend, err := b.Backend()
+		backend, err := b.backend()
+		if err == nil {
+			backend.Teardown(context.Background())
+		}
+	}
+
+	// For SSH backend, remove the remote checkout
+	if b.Environment.Backend == "ssh" && b.Environment.Host != "" {
+		backend, err := b.backend()
 		if err == nil {
 			backend.Teardown(context.Background())
 		}
 	}
 
-	// Remove the directory (for local/docker) - Modal/Sprites/Fly don't have local files
-	if b.Environment.Backend != "modal" && b.Environment.Backend != "sprites" && b.Environment.Backend != "fly-machines" {
+	// Plugins own their checkout; teardown is theirs to do
+	if env.IsPlugin(b.Environment.Backend) {
+		if b.Environment.BackendState != nil {
+			backend, err := b.backend()
+			if err == nil {
+				backend.Teardown(context.Background())
+			}
+		}
+		return nil
+	}
+
+	// Remove the directory (for local/docker) - Modal/Sprites/Fly/SSH don't have local files
+	if b.Environment.Backend != "modal" && b.Environment.Backend != "sprites" && b.Environment.Backend != "fly-machines" && b.Environment.Backend != "ssh" {
 		return os.RemoveAll(b.Environment.Path)
 	}
 	return nil
@@ -737,6 +966,35 @@ func (s *Store) UpdateHeadRev(repo, name, rev string) error {
 	return nil
 }
 
+// IncrementFixIterations bumps the count of automatic gate-fix attempts
+// and returns the new value. The count restarts at 1 if head isn't the rev
+// the last attempt left (see RecordFixRev): someone else has committed
+// since.
+func (s *Store) IncrementFixIterations(repo, name, head string) (int, error) {
+	var n int
+	err := s.db.QueryRow(`
+		UPDATE branches SET fix_iterations = CASE WHEN fix_rev = $3 THEN fix_iterations + 1 ELSE 1 END
+		WHERE repo = $1 AND name = $2
+		RETURNING fix_iterations
+	`, repo, name, head).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("branch %s/%s not found", repo, name)
+	}
+	return n, err
+}
+
+// RecordFixRev records the head a fix attempt left the branch at.
+func (s *Store) RecordFixRev(repo, name, rev string) error {
+	_, err := s.db.Exec(`UPDATE branches SET fix_rev = $1 WHERE repo = $2 AND name = $3`, rev, repo, name)
+	return err
+}
+
+// ResetFixIterations clears the fix attempt count (e.g. once gates pass).
+func (s *Store) ResetFixIterations(repo, name string) error {
+	_, err := s.db.Exec(`UPDATE branches SET fix_iterations = 0, fix_rev = '' WHERE repo = $1 AND name = $2`, repo, name)
+	return err
+}
+
 func (s *Store) UpdateBaseRev(repo, name, rev string) error {
 	result, err := s.db.Exec(`
 		UPDATE branches SET base_rev = $1 WHERE repo = $2 AND name = $3
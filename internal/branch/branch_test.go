@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/testutil"
 )
 
 func TestBranch_Backend(t *testing.T) {
@@ -88,4 +89,58 @@ func TestBranch_Backend(t *testing.T) {
 			t.Error("Backend() expected error for empty path")
 		}
 	})
+
+	t.Run("stopped environment", func(t *testing.T) {
+		b := &Branch{
+			Name: "test-branch",
+			Repo: "owner/repo",
+			Environment: EnvironmentSpec{
+				Backend:       "local",
+				Path:          t.TempDir(),
+				StoppedReason: "budget exceeded",
+			},
+		}
+
+		if _, err := b.Backend(); err == nil {
+			t.Error("Backend() expected error for a stopped environment")
+		}
+		// Teardown still reaches it
+		if _, err := b.backend(); err != nil {
+			t.Errorf("backend() error = %v", err)
+		}
+	})
+}
+
+func TestStore_FixIterations(t *testing.T) {
+	database, cleanup := testutil.OpenTestDB(t)
+	defer cleanup()
+
+	store := NewStore(database, t.TempDir())
+	b := &Branch{Repo: "o/r", Name: "feature", BaseRev: "abc", HeadRev: "abc", Environment: EnvironmentSpec{Backend: "local", Path: t.TempDir()}}
+	if err := store.Create(b); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	increment := func(head string, want int) {
+		t.Helper()
+		n, err := store.IncrementFixIterations(b.Repo, b.Name, head)
+		if err != nil || n != want {
+			t.Fatalf("IncrementFixIterations(%s) = %d, %v, want %d", head, n, err, want)
+		}
+	}
+
+	// Attempts count up while the loop's own commits are the head
+	increment("abc", 1)
+	store.RecordFixRev(b.Repo, b.Name, "fix1")
+	increment("fix1", 2)
+	store.RecordFixRev(b.Repo, b.Name, "fix2")
+
+	// Someone else committed: the count starts over
+	increment("human", 1)
+
+	store.RecordFixRev(b.Repo, b.Name, "fix3")
+	if err := store.ResetFixIterations(b.Repo, b.Name); err != nil {
+		t.Fatalf("ResetFixIterations: %v", err)
+	}
+	increment("fix3", 1)
 }
@@ -0,0 +1,400 @@
+package branch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/justinmoon/cook/internal/env"
+)
+
+// NamedEnvironment is a long-lived remote environment ("my dev machine")
+// that branches attach to instead of provisioning their own. It hosts one
+// branch's checkout at a time; everything outside the checkout (datasets,
+// caches, installed tools) persists from branch to branch. Only its
+// owner's branches can attach to it.
+type NamedEnvironment struct {
+	ID             int64          `json:"id"`
+	Owner          string         `json:"owner"`
+	Name           string         `json:"name"`
+	Backend        string         `json:"backend"`
+	EnvID          string         `json:"env_id"` // sandbox ID, sprite name or machine ID
+	Profile        string         `json:"profile,omitempty"`
+	Resources      *env.Resources `json:"resources,omitempty"`
+	IdleTimeout    time.Duration  `json:"idle_timeout,omitempty"` // suspend after this long with no branch (0 = never)
+	AttachedRepo   string         `json:"attached_repo,omitempty"`
+	AttachedBranch string         `json:"attached_branch,omitempty"`
+	SuspendedAt    *time.Time     `json:"suspended_at,omitempty"`
+	LastUsedAt     time.Time      `json:"last_used_at"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// Attached reports whether a branch is using the environment.
+func (e *NamedEnvironment) Attached() bool {
+	return e.AttachedBranch != ""
+}
+
+// Idle reports whether the environment has gone unused past its idle
+// timeout and should be suspended.
+func (e *NamedEnvironment) Idle(now time.Time) bool {
+	return e.IdleTimeout > 0 && !e.Attached() && e.SuspendedAt == nil && now.Sub(e.LastUsedAt) > e.IdleTimeout
+}
+
+// namedEnvPrefix prefixes the backend resource names of named environments.
+const namedEnvPrefix = "cook-env-"
+
+var validEnvName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// envResourceName names the backend resource of owner's environment name.
+// Names are only unique per owner, so the resource name carries a prefix
+// of the owner's.
+func envResourceName(owner, name string) string {
+	var prefix []rune
+	for _, r := range strings.ToLower(owner) {
+		if len(prefix) == 8 {
+			break
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			prefix = append(prefix, r)
+		}
+	}
+	if len(prefix) == 0 {
+		return namedEnvPrefix + name
+	}
+	return namedEnvPrefix + string(prefix) + "-" + name
+}
+
+// newWarmer and openWarmer are replaced in tests
+var (
+	newWarmer  = env.NewWarmer
+	openWarmer = env.OpenWarmer
+)
+
+// CreateEnvironment provisions owner's named environment on backendType,
+// which must support warm pools: the environment is warmed without a repo
+// and each attaching branch claims it.
+func (s *Store) CreateEnvironment(ctx context.Context, owner, name, backendType, profile string, resources *env.Resources, idleTimeout time.Duration) (*NamedEnvironment, error) {
+	if owner == "" {
+		return nil, fmt.Errorf("environment %s needs an owner", name)
+	}
+	if !validEnvName.MatchString(name) {
+		return nil, fmt.Errorf("invalid environment name %q (use lowercase letters, digits and dashes)", name)
+	}
+	if !env.Warmable(env.Type(backendType)) {
+		return nil, fmt.Errorf("%s backend does not support named environments", backendType)
+	}
+	existing, err := s.GetEnvironment(owner, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("environment %s already exists", name)
+	}
+
+	resourceName := envResourceName(owner, name)
+	cfg := env.Config{Name: resourceName, SandboxName: resourceName}
+	if resources != nil {
+		cfg.Resources = *resources
+	}
+	w, err := newWarmer(env.Type(backendType), cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Warm(ctx); err != nil {
+		w.Teardown(context.Background())
+		return nil, fmt.Errorf("failed to provision environment: %w", err)
+	}
+
+	e := &NamedEnvironment{
+		Owner:       owner,
+		Name:        name,
+		Backend:     backendType,
+		EnvID:       w.EnvID(),
+		Profile:     profile,
+		Resources:   resources,
+		IdleTimeout: idleTimeout,
+	}
+	var resourcesJSON string
+	if resources != nil {
+		data, err := json.Marshal(resources)
+		if err != nil {
+			return nil, err
+		}
+		resourcesJSON = string(data)
+	}
+	err = s.db.QueryRow(`
+		INSERT INTO environments (owner, name, backend, env_id, profile, resources_json, idle_timeout_seconds)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, last_used_at, created_at
+	`, e.Owner, e.Name, e.Backend, e.EnvID, e.Profile, resourcesJSON, int64(idleTimeout/time.Second)).Scan(&e.ID, &e.LastUsedAt, &e.CreatedAt)
+	if err != nil {
+		w.Teardown(context.Background())
+		return nil, err
+	}
+	return e, nil
+}
+
+const environmentColumns = `id, owner, name, backend, env_id, profile, resources_json, idle_timeout_seconds, attached_repo, attached_branch, suspended_at, last_used_at, created_at`
+
+// GetEnvironment returns owner's environment name, or nil if there is none.
+func (s *Store) GetEnvironment(owner, name string) (*NamedEnvironment, error) {
+	row := s.db.QueryRow(`SELECT `+environmentColumns+` FROM environments WHERE owner = $1 AND name = $2`, owner, name)
+	e, err := scanEnvironment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return e, err
+}
+
+// ListEnvironments returns every owner's named environments, by owner and
+// name.
+func (s *Store) ListEnvironments() ([]NamedEnvironment, error) {
+	return s.queryEnvironments(`SELECT ` + environmentColumns + ` FROM environments ORDER BY owner, name`)
+}
+
+// ListOwnerEnvironments returns owner's named environments by name.
+func (s *Store) ListOwnerEnvironments(owner string) ([]NamedEnvironment, error) {
+	return s.queryEnvironments(`SELECT `+environmentColumns+` FROM environments WHERE owner = $1 ORDER BY name`, owner)
+}
+
+func (s *Store) queryEnvironments(query string, args ...any) ([]NamedEnvironment, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envs []NamedEnvironment
+	for rows.Next() {
+		e, err := scanEnvironment(rows)
+		if err != nil {
+			return nil, err
+		}
+		envs = append(envs, *e)
+	}
+	return envs, rows.Err()
+}
+
+func scanEnvironment(row interface{ Scan(...any) error }) (*NamedEnvironment, error) {
+	var e NamedEnvironment
+	var resourcesJSON string
+	var idleSeconds int64
+	var suspendedAt sql.NullTime
+	err := row.Scan(&e.ID, &e.Owner, &e.Name, &e.Backend, &e.EnvID, &e.Profile, &resourcesJSON, &idleSeconds,
+		&e.AttachedRepo, &e.AttachedBranch, &suspendedAt, &e.LastUsedAt, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if resourcesJSON != "" {
+		e.Resources = &env.Resources{}
+		if err := json.Unmarshal([]byte(resourcesJSON), e.Resources); err != nil {
+			return nil, err
+		}
+	}
+	e.IdleTimeout = time.Duration(idleSeconds) * time.Second
+	if suspendedAt.Valid {
+		e.SuspendedAt = &suspendedAt.Time
+	}
+	return &e, nil
+}
+
+// DestroyEnvironment tears down owner's environment name and forgets it.
+// It refuses while a branch is attached. With force, the record is removed
+// even if the backend resource can't be torn down (e.g. it's already gone).
+func (s *Store) DestroyEnvironment(ctx context.Context, owner, name string, force bool) error {
+	e, err := s.GetEnvironment(owner, name)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return fmt.Errorf("environment %s not found", name)
+	}
+	if e.Attached() {
+		return fmt.Errorf("environment %s is in use by %s/%s; merge or abandon it first", name, e.AttachedRepo, e.AttachedBranch)
+	}
+
+	w, err := openWarmer(env.Type(e.Backend), e.EnvID)
+	if err == nil {
+		err = w.Teardown(ctx)
+	}
+	if err != nil && !force {
+		return fmt.Errorf("failed to tear down environment %s: %w", name, err)
+	}
+
+	_, err = s.db.Exec(`DELETE FROM environments WHERE id = $1`, e.ID)
+	return err
+}
+
+// SuspendEnvironment suspends an unattached environment until a branch
+// next attaches. Backends that can't suspend are destroyed instead, and
+// destroyed reports which happened.
+func (s *Store) SuspendEnvironment(ctx context.Context, e *NamedEnvironment) (destroyed bool, err error) {
+	w, err := openWarmer(env.Type(e.Backend), e.EnvID)
+	if err != nil {
+		return false, err
+	}
+	susp, ok := w.(env.Suspender)
+	if !ok {
+		return true, s.DestroyEnvironment(ctx, e.Owner, e.Name, true)
+	}
+	if err := susp.Suspend(ctx); err != nil {
+		return false, fmt.Errorf("suspend failed: %w", err)
+	}
+	_, err = s.db.Exec(`UPDATE environments SET suspended_at = NOW() WHERE id = $1 AND attached_branch = ''`, e.ID)
+	return false, err
+}
+
+// CreateProvisioningEnvironmentBranch records a branch attached to the
+// named environment envName, which must belong to the repo's owner.
+// ProvisionRemoteBranch then replaces the environment's checkout with the
+// branch's. An environment hosts one branch at a time.
+func (s *Store) CreateProvisioningEnvironmentBranch(b *Branch, bareRepoPath, envName, dotfiles string) error {
+	// Validate branch name
+	if strings.Contains(b.Name, "/") {
+		return fmt.Errorf("branch name cannot contain '/'")
+	}
+
+	e, err := s.GetEnvironment(repoOwner(b.Repo), envName)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return fmt.Errorf("environment %s not found", envName)
+	}
+
+	// Get base rev from master
+	baseRev, err := getHeadRev(bareRepoPath, "master")
+	if err != nil {
+		return fmt.Errorf("failed to get master HEAD: %w (does the repo have commits?)", err)
+	}
+	b.BaseRev = baseRev
+	b.HeadRev = baseRev
+
+	res, err := s.db.Exec(`
+		UPDATE environments SET attached_repo = $1, attached_branch = $2, last_used_at = NOW()
+		WHERE id = $3 AND attached_branch = ''
+	`, b.Repo, b.Name, e.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if e, _ := s.GetEnvironment(repoOwner(b.Repo), envName); e != nil && e.Attached() {
+			return fmt.Errorf("environment %s is in use by %s/%s", envName, e.AttachedRepo, e.AttachedBranch)
+		}
+		return fmt.Errorf("environment %s is in use", envName)
+	}
+
+	b.Environment = EnvironmentSpec{
+		Backend:      e.Backend,
+		Path:         filepath.Join(s.dataDir, "checkouts", b.Repo, b.Name),
+		Dotfiles:     dotfiles,
+		Provisioning: true,
+		Named:        e.Name,
+		Profile:      e.Profile,
+		Resources:    e.Resources,
+	}
+	setEnvID(&b.Environment, e.EnvID)
+	b.Status = StatusActive
+
+	if err := s.Create(b); err != nil {
+		s.detachEnvironment(b)
+		return err
+	}
+	return nil
+}
+
+// setEnvID records a warm environment's ID in the spec field its backend
+// reconnects with.
+func setEnvID(spec *EnvironmentSpec, id string) {
+	switch env.Type(spec.Backend) {
+	case env.TypeModal:
+		spec.SandboxID = id
+	case env.TypeSprites:
+		spec.SpriteName = id
+	case env.TypeFlyMachines:
+		spec.MachineID = id
+	}
+}
+
+// provisionInEnvironment checks b out in its named environment, waking the
+// environment if it was suspended. The environment is never torn down:
+// on failure it's left for the next branch.
+func (s *Store) provisionInEnvironment(ctx context.Context, b *Branch, repoURL, taskMdContent string) error {
+	envSpec := b.Environment
+	var backend env.Warmer
+	err := func() error {
+		e, err := s.GetEnvironment(repoOwner(b.Repo), envSpec.Named)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return fmt.Errorf("environment %s not found", envSpec.Named)
+		}
+		backend, err = openWarmer(env.Type(e.Backend), e.EnvID)
+		if err != nil {
+			return fmt.Errorf("failed to open environment %s: %w", e.Name, err)
+		}
+		if e.SuspendedAt != nil {
+			if susp, ok := backend.(env.Suspender); ok {
+				if err := susp.Resume(ctx); err != nil {
+					return fmt.Errorf("resume failed: %w", err)
+				}
+			}
+			if _, err := s.db.Exec(`UPDATE environments SET suspended_at = NULL WHERE id = $1`, e.ID); err != nil {
+				return err
+			}
+		}
+
+		// Clear the previous branch's checkout, keeping the directory so
+		// backends that run commands from it still can
+		if _, err := backend.Exec(ctx, fmt.Sprintf("find '%s' -mindepth 1 -delete", backend.WorkDir())); err != nil {
+			return fmt.Errorf("failed to clear previous checkout: %w", err)
+		}
+		return backend.Claim(ctx, env.Config{
+			Name:       b.Repo + "/" + b.Name,
+			RepoURL:    repoURL,
+			BranchName: b.Name,
+			WorkDir:    envSpec.Path,
+			Dotfiles:   envSpec.Dotfiles,
+			Resources:  envSpec.resources(),
+		})
+	}()
+
+	envSpec.Provisioning = false
+	if err != nil {
+		envSpec.ProvisioningError = err.Error()
+		_ = s.UpdateEnvironment(b.Repo, b.Name, envSpec)
+		return err
+	}
+
+	if taskMdContent != "" {
+		taskMdPath := filepath.Join(envSpec.Path, "TASK.md")
+		if err := backend.WriteFile(ctx, taskMdPath, []byte(taskMdContent)); err != nil {
+			fmt.Printf("Warning: failed to write TASK.md for %s/%s: %v\n", b.Repo, b.Name, err)
+		}
+	}
+
+	envSpec.ProvisioningError = ""
+	return s.UpdateEnvironment(b.Repo, b.Name, envSpec)
+}
+
+// detachEnvironment frees b's named environment for the next branch. An
+// environment whose branch was suspended stays suspended.
+func (s *Store) detachEnvironment(b *Branch) error {
+	_, err := s.db.Exec(`
+		UPDATE environments SET attached_repo = '', attached_branch = '', last_used_at = NOW(), suspended_at = $1
+		WHERE owner = $2 AND name = $3 AND attached_repo = $4 AND attached_branch = $5
+	`, b.Environment.SuspendedAt, repoOwner(b.Repo), b.Environment.Named, b.Repo, b.Name)
+	return err
+}
+
+// repoOwner returns the owner of repo "owner/name".
+func repoOwner(repo string) string {
+	owner, _, _ := strings.Cut(repo, "/")
+	return owner
+}
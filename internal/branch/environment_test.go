@@ -0,0 +1,167 @@
+package branch
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/testutil"
+)
+
+// namedEnvWarmer is a local backend standing in for a remote named
+// environment.
+type namedEnvWarmer struct {
+	*env.LocalBackend
+	id       string
+	claimed  *env.Config
+	tornDown bool
+}
+
+func (w *namedEnvWarmer) Warm(ctx context.Context) error { return nil }
+
+func (w *namedEnvWarmer) Claim(ctx context.Context, cfg env.Config) error {
+	w.claimed = &cfg
+	return nil
+}
+
+func (w *namedEnvWarmer) EnvID() string { return w.id }
+
+func (w *namedEnvWarmer) Teardown(ctx context.Context) error {
+	w.tornDown = true
+	return nil
+}
+
+func TestNamedEnvironment_Idle(t *testing.T) {
+	now := time.Now()
+	e := NamedEnvironment{IdleTimeout: time.Hour, LastUsedAt: now.Add(-2 * time.Hour)}
+	if !e.Idle(now) {
+		t.Error("unattached environment past its timeout should be idle")
+	}
+	e.AttachedBranch = "feature"
+	if e.Idle(now) {
+		t.Error("attached environment should not be idle")
+	}
+	e.AttachedBranch = ""
+	e.SuspendedAt = &now
+	if e.Idle(now) {
+		t.Error("suspended environment should not be idle")
+	}
+	e.SuspendedAt = nil
+	e.IdleTimeout = 0
+	if e.Idle(now) {
+		t.Error("environment without a timeout should never be idle")
+	}
+}
+
+func TestStore_NamedEnvironments(t *testing.T) {
+	database, cleanup := testutil.OpenTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store := NewStore(database, t.TempDir())
+
+	bareRepo := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master", bareRepo},
+		{"-C", bareRepo, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	warmers := map[string]*namedEnvWarmer{}
+	newWarmer = func(backendType env.Type, cfg env.Config) (env.Warmer, error) {
+		w := &namedEnvWarmer{LocalBackend: env.NewLocalBackendFromPath(t.TempDir()), id: cfg.Name}
+		warmers[cfg.Name] = w
+		return w, nil
+	}
+	openWarmer = func(backendType env.Type, id string) (env.Warmer, error) {
+		return warmers[id], nil
+	}
+	defer func() {
+		newWarmer = env.NewWarmer
+		openWarmer = env.OpenWarmer
+	}()
+
+	if _, err := store.CreateEnvironment(ctx, "o", "box", "docker", "", nil, 0); err == nil {
+		t.Error("expected error for a backend without warm support")
+	}
+	if _, err := store.CreateEnvironment(ctx, "o", "Bad_Name", "modal", "", nil, 0); err == nil {
+		t.Error("expected error for an invalid name")
+	}
+
+	resources := &env.Resources{CPUs: 8, GPU: "a10"}
+	e, err := store.CreateEnvironment(ctx, "o", "box", "modal", "gpu", resources, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	if e.EnvID != "cook-env-o-box" {
+		t.Errorf("EnvID = %q, want cook-env-o-box", e.EnvID)
+	}
+	if _, err := store.CreateEnvironment(ctx, "o", "box", "modal", "", nil, 0); err == nil {
+		t.Error("expected error for a duplicate name")
+	}
+
+	// Names are unique per owner, and other owners can't see or attach to
+	// o's environments
+	if _, err := store.CreateEnvironment(ctx, "mallory", "box", "modal", "", nil, 0); err != nil {
+		t.Fatalf("CreateEnvironment for another owner: %v", err)
+	}
+	if envs, _ := store.ListOwnerEnvironments("o"); len(envs) != 1 || envs[0].Owner != "o" {
+		t.Errorf("ListOwnerEnvironments(o) = %+v, want only o's box", envs)
+	}
+	if err := store.DestroyEnvironment(ctx, "mallory", "box", false); err != nil {
+		t.Fatalf("DestroyEnvironment: %v", err)
+	}
+	if err := store.CreateProvisioningEnvironmentBranch(&Branch{Repo: "mallory/r", Name: "steal"}, bareRepo, "box", ""); err == nil {
+		t.Error("expected error attaching to another owner's environment")
+	}
+
+	b := &Branch{Repo: "o/r", Name: "feature"}
+	if err := store.CreateProvisioningEnvironmentBranch(b, bareRepo, "box", ""); err != nil {
+		t.Fatalf("CreateProvisioningEnvironmentBranch: %v", err)
+	}
+	if b.Environment.SandboxID != "cook-env-o-box" || b.Environment.Named != "box" || b.Environment.Resources == nil || b.Environment.Resources.CPUs != 8 {
+		t.Errorf("Environment = %+v, want the box sandbox with its resources", b.Environment)
+	}
+	if err := store.CreateProvisioningEnvironmentBranch(&Branch{Repo: "o/r", Name: "other"}, bareRepo, "box", ""); err == nil {
+		t.Error("expected error attaching a second branch")
+	}
+	if err := store.DestroyEnvironment(ctx, "o", "box", false); err == nil {
+		t.Error("expected error destroying an attached environment")
+	}
+
+	if err := store.ProvisionRemoteBranch(ctx, b, bareRepo, "https://example.com/o/r.git", ""); err != nil {
+		t.Fatalf("ProvisionRemoteBranch: %v", err)
+	}
+	w := warmers["cook-env-o-box"]
+	if w.claimed == nil || w.claimed.BranchName != "feature" || w.claimed.Resources.GPU != "a10" {
+		t.Errorf("claimed = %+v, want feature with the box's resources", w.claimed)
+	}
+
+	if err := store.detachEnvironment(b); err != nil {
+		t.Fatalf("detachEnvironment: %v", err)
+	}
+	got, err := store.GetEnvironment("o", "box")
+	if err != nil || got == nil || got.Attached() {
+		t.Fatalf("GetEnvironment after detach = %+v, %v", got, err)
+	}
+	if w.tornDown {
+		t.Error("detaching should not tear the environment down")
+	}
+
+	// The fake can't suspend, so suspending destroys it
+	destroyed, err := store.SuspendEnvironment(ctx, got)
+	if err != nil || !destroyed {
+		t.Fatalf("SuspendEnvironment = %v, %v, want destroyed", destroyed, err)
+	}
+	if !w.tornDown {
+		t.Error("expected the environment to be torn down")
+	}
+	if envs, _ := store.ListEnvironments(); len(envs) != 0 {
+		t.Errorf("ListEnvironments = %+v, want none", envs)
+	}
+}
@@ -0,0 +1,136 @@
+package branch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/justinmoon/cook/internal/env"
+)
+
+// DefaultGCMinAge is how old a resource must be before gc treats it as
+// leaked. A fresh setup can take up to 15 minutes before the branch records
+// the resource's ID. Warm pool environments can be older than this when
+// claimed, so their pool row protects them until the branch has the ID
+// (see releaseWarm).
+const DefaultGCMinAge = 30 * time.Minute
+
+// GCOptions configures a gc run.
+type GCOptions struct {
+	DryRun   bool          // report orphans without destroying them
+	Backends []env.Type    // backends to check (default env.GCBackends)
+	MinAge   time.Duration // spare resources younger than this (default DefaultGCMinAge)
+}
+
+// Orphan is a resource no active branch, warm pool entry or named
+// environment uses.
+type Orphan struct {
+	env.Resource
+	Destroyed bool   `json:"destroyed"`
+	Error     string `json:"error,omitempty"` // why destroying it failed
+}
+
+// GCReport is the result of a gc run.
+type GCReport struct {
+	Orphans []Orphan          `json:"orphans"`
+	Errors  map[string]string `json:"errors,omitempty"` // backends that couldn't be listed
+}
+
+// listResources and destroyResource are replaced in tests
+var (
+	listResources   = env.ListResources
+	destroyResource = env.DestroyResource
+)
+
+// GC finds containers, sandboxes, sprites and machines that no active
+// branch, warm pool entry or named environment uses, and destroys them
+// unless opts.DryRun is set. Resources younger than opts.MinAge, or whose age the backend doesn't
+// report, are spared. A backend that can't be listed (e.g. no credentials)
+// is recorded in the report's Errors and skipped.
+func (s *Store) GC(ctx context.Context, opts GCOptions) (*GCReport, error) {
+	if len(opts.Backends) == 0 {
+		opts.Backends = env.GCBackends
+	}
+	if opts.MinAge <= 0 {
+		opts.MinAge = DefaultGCMinAge
+	}
+
+	branches, err := s.List("", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	inUse, err := s.pooledEnvIDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list warm and named environments: %w", err)
+	}
+	spriteNames := make(map[string]bool)
+	for _, b := range branches {
+		spriteNames[spritesNameForBranch(b.Repo, b.Name)] = true
+		if b.Environment.SpriteName != "" {
+			spriteNames[b.Environment.SpriteName] = true
+		}
+		if b.Status != StatusActive {
+			continue
+		}
+		for _, id := range []string{b.Environment.ContainerID, b.Environment.SandboxID, b.Environment.SpriteName, b.Environment.MachineID} {
+			if id != "" {
+				inUse[resourceKey(env.Type(b.Environment.Backend), id)] = true
+			}
+		}
+	}
+
+	report := &GCReport{Errors: make(map[string]string)}
+	now := time.Now()
+	for _, backend := range opts.Backends {
+		resources, err := listResources(ctx, backend)
+		if err != nil {
+			report.Errors[string(backend)] = err.Error()
+			continue
+		}
+		for _, r := range resources {
+			if inUse[resourceKey(r.Backend, r.ID)] || r.CreatedAt.IsZero() || now.Sub(r.CreatedAt) < opts.MinAge {
+				continue
+			}
+			// The sprites account may hold sprites cook didn't create
+			if r.Backend == env.TypeSprites && !spriteNames[r.ID] && !strings.HasPrefix(r.ID, "cook-") {
+				continue
+			}
+
+			orphan := Orphan{Resource: r}
+			if !opts.DryRun {
+				if err := destroyResource(ctx, r); err != nil {
+					orphan.Error = err.Error()
+				} else {
+					orphan.Destroyed = true
+				}
+			}
+			report.Orphans = append(report.Orphans, orphan)
+		}
+	}
+	return report, nil
+}
+
+// pooledEnvIDs returns the warm pool's and named environments, keyed by
+// resourceKey.
+func (s *Store) pooledEnvIDs() (map[string]bool, error) {
+	rows, err := s.db.Query(`SELECT backend, env_id FROM env_pool UNION SELECT backend, env_id FROM environments`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var backend, id string
+		if err := rows.Scan(&backend, &id); err != nil {
+			return nil, err
+		}
+		ids[resourceKey(env.Type(backend), id)] = true
+	}
+	return ids, rows.Err()
+}
+
+func resourceKey(backend env.Type, id string) string {
+	return string(backend) + "/" + id
+}
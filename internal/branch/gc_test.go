@@ -0,0 +1,123 @@
+package branch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/testutil"
+)
+
+func TestStore_GC(t *testing.T) {
+	database, cleanup := testutil.OpenTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store := NewStore(database, t.TempDir())
+
+	for _, b := range []*Branch{
+		{Repo: "o/r", Name: "live", BaseRev: "abc", HeadRev: "abc", Environment: EnvironmentSpec{Backend: "docker", ContainerID: "c-live"}},
+		{Repo: "o/r", Name: "sprite", BaseRev: "abc", HeadRev: "abc", Environment: EnvironmentSpec{Backend: "sprites", SpriteName: "s-live"}},
+		{Repo: "o/r", Name: "merged", BaseRev: "abc", HeadRev: "abc", Environment: EnvironmentSpec{Backend: "docker", ContainerID: "c-merged"}},
+	} {
+		if err := store.Create(b); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	if err := store.UpdateStatus("o/r", "merged", StatusMerged); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+	if _, err := database.Exec(`INSERT INTO env_pool (backend, env_id) VALUES ('sprites', 'cook-pool-aaaa')`); err != nil {
+		t.Fatalf("insert env_pool: %v", err)
+	}
+	// Claimed by a branch that hasn't recorded it yet
+	if _, err := database.Exec(`INSERT INTO env_pool (backend, env_id, claimed_at) VALUES ('sprites', 'cook-pool-cccc', NOW())`); err != nil {
+		t.Fatalf("insert env_pool: %v", err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	listResources = func(ctx context.Context, backend env.Type) ([]env.Resource, error) {
+		switch backend {
+		case env.TypeDocker:
+			return []env.Resource{
+				{Backend: backend, ID: "c-live", CreatedAt: old},
+				{Backend: backend, ID: "c-merged", CreatedAt: old},
+				{Backend: backend, ID: "c-new", CreatedAt: time.Now()},
+				{Backend: backend, ID: "c-unknown-age"},
+			}, nil
+		case env.TypeSprites:
+			return []env.Resource{
+				{Backend: backend, ID: "s-live", CreatedAt: old},
+				{Backend: backend, ID: "cook-pool-aaaa", CreatedAt: old},
+				{Backend: backend, ID: "cook-pool-bbbb", CreatedAt: old},
+				{Backend: backend, ID: "cook-pool-cccc", CreatedAt: old},
+				{Backend: backend, ID: spritesNameForBranch("o/r", "merged"), CreatedAt: old},
+				{Backend: backend, ID: "someone-elses-sprite", CreatedAt: old},
+			}, nil
+		}
+		return nil, errors.New("no credentials")
+	}
+	var destroyed []string
+	destroyResource = func(ctx context.Context, r env.Resource) error {
+		destroyed = append(destroyed, r.ID)
+		return nil
+	}
+	defer func() {
+		listResources = env.ListResources
+		destroyResource = env.DestroyResource
+	}()
+
+	backends := []env.Type{env.TypeDocker, env.TypeSprites, env.TypeModal}
+	report, err := store.GC(ctx, GCOptions{DryRun: true, Backends: backends})
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	want := []string{"c-merged", "cook-pool-bbbb", spritesNameForBranch("o/r", "merged")}
+	var got []string
+	for _, o := range report.Orphans {
+		got = append(got, o.ID)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("orphans = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("orphans = %v, want %v", got, want)
+			break
+		}
+	}
+	if len(destroyed) != 0 {
+		t.Errorf("dry run destroyed %v", destroyed)
+	}
+	if report.Errors["modal"] == "" {
+		t.Errorf("expected an error for the unconfigured modal backend, got %v", report.Errors)
+	}
+
+	report, err = store.GC(ctx, GCOptions{Backends: backends})
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if len(destroyed) != len(want) {
+		t.Errorf("destroyed %v, want %v", destroyed, want)
+	}
+	for _, o := range report.Orphans {
+		if !o.Destroyed {
+			t.Errorf("orphan %s not marked destroyed", o.ID)
+		}
+	}
+	// Once released, a claimed environment is the branch's to protect
+	store.releaseWarm("sprites", "cook-pool-cccc")
+	report, err = store.GC(ctx, GCOptions{DryRun: true, Backends: []env.Type{env.TypeSprites}})
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	var released bool
+	for _, o := range report.Orphans {
+		released = released || o.ID == "cook-pool-cccc"
+	}
+	if !released {
+		t.Errorf("orphans = %+v, want cook-pool-cccc once released", report.Orphans)
+	}
+}
@@ -0,0 +1,40 @@
+package branch
+
+import (
+	"context"
+
+	"github.com/justinmoon/cook/internal/env"
+)
+
+// WarmPool hands out pre-provisioned remote environments (see internal/pool).
+type WarmPool interface {
+	// Claim returns a warm environment of backendType prepared for cfg,
+	// or nil if none is available
+	Claim(ctx context.Context, backendType string, cfg env.Config) env.Backend
+}
+
+var warmPool WarmPool
+
+// SetWarmPool makes remote branch creation claim environments from p
+// before falling back to a full Setup.
+func SetWarmPool(p WarmPool) {
+	warmPool = p
+}
+
+// claimWarm returns a warm environment claimed for cfg, or nil. Warm
+// environments have the backend's default size, so branches with a
+// resource profile always get a fresh one. Callers must releaseWarm a
+// claimed environment once the branch records its ID or it's torn down.
+func claimWarm(ctx context.Context, backendType string, cfg env.Config) env.Backend {
+	if warmPool == nil || cfg.Resources != (env.Resources{}) {
+		return nil
+	}
+	return warmPool.Claim(ctx, backendType, cfg)
+}
+
+// releaseWarm deletes a claimed warm environment's pool row, which kept
+// gc off it until now: warm environments can be older than gc's minimum
+// age by the time they're claimed.
+func (s *Store) releaseWarm(backendType, envID string) {
+	s.db.Exec(`DELETE FROM env_pool WHERE backend = $1 AND env_id = $2 AND claimed_at IS NOT NULL`, backendType, envID)
+}
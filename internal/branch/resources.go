@@ -0,0 +1,19 @@
+package branch
+
+import "github.com/justinmoon/cook/internal/env"
+
+// SetProfile selects the named resource profile for b's environment. Call
+// it before creating the branch; the Create functions keep it.
+func (b *Branch) SetProfile(name string, r env.Resources) {
+	b.Environment.Profile = name
+	b.Environment.Resources = &r
+}
+
+// resources returns the environment's resource limits, or zero limits if
+// no profile was selected.
+func (e EnvironmentSpec) resources() env.Resources {
+	if e.Resources == nil {
+		return env.Resources{}
+	}
+	return *e.Resources
+}
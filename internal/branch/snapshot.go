@@ -0,0 +1,172 @@
+package branch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/justinmoon/cook/internal/env"
+)
+
+// Snapshot is a checkpoint of a branch's environment.
+type Snapshot struct {
+	ID         int64     `json:"id"`
+	BranchRepo string    `json:"branch_repo"`
+	BranchName string    `json:"branch_name"`
+	SnapshotID string    `json:"snapshot_id"` // backend-specific ID
+	Backend    string    `json:"backend"`
+	Label      string    `json:"label,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// snapshotter returns the branch's backend as a Snapshotter.
+func snapshotter(b *Branch) (env.Snapshotter, error) {
+	backend, err := b.Backend()
+	if err != nil {
+		return nil, err
+	}
+	snap, ok := backend.(env.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("%s backend does not support snapshots", b.Environment.Backend)
+	}
+	return snap, nil
+}
+
+// CreateSnapshot checkpoints the branch's environment and records it.
+func (s *Store) CreateSnapshot(ctx context.Context, b *Branch, label string) (*Snapshot, error) {
+	snap, err := snapshotter(b)
+	if err != nil {
+		return nil, err
+	}
+	id, err := snap.Snapshot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	record := &Snapshot{
+		BranchRepo: b.Repo,
+		BranchName: b.Name,
+		SnapshotID: id,
+		Backend:    b.Environment.Backend,
+		Label:      label,
+	}
+	err = s.db.QueryRow(`
+		INSERT INTO snapshots (branch_repo, branch_name, snapshot_id, backend, label)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, record.BranchRepo, record.BranchName, record.SnapshotID, record.Backend, record.Label).Scan(&record.ID, &record.CreatedAt)
+	if err != nil {
+		snap.DeleteSnapshot(ctx, id)
+		return nil, err
+	}
+	return record, nil
+}
+
+// ListSnapshots returns a branch's snapshots, newest first.
+func (s *Store) ListSnapshots(repo, name string) ([]Snapshot, error) {
+	rows, err := s.db.Query(`
+		SELECT id, branch_repo, branch_name, snapshot_id, backend, label, created_at
+		FROM snapshots
+		WHERE branch_repo = $1 AND branch_name = $2
+		ORDER BY id DESC
+	`, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		var snap Snapshot
+		if err := rows.Scan(&snap.ID, &snap.BranchRepo, &snap.BranchName, &snap.SnapshotID, &snap.Backend, &snap.Label, &snap.CreatedAt); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snap)
+	}
+	return snapshots, rows.Err()
+}
+
+// GetSnapshot returns a branch's snapshot by its snapshot ID or label, or
+// the latest snapshot if ref is empty. Returns nil if there is no match.
+func (s *Store) GetSnapshot(repo, name, ref string) (*Snapshot, error) {
+	query := `
+		SELECT id, branch_repo, branch_name, snapshot_id, backend, label, created_at
+		FROM snapshots
+		WHERE branch_repo = $1 AND branch_name = $2`
+	args := []interface{}{repo, name}
+	if ref != "" {
+		query += ` AND (snapshot_id = $3 OR label = $3)`
+		args = append(args, ref)
+	}
+	query += ` ORDER BY id DESC LIMIT 1`
+
+	var snap Snapshot
+	err := s.db.QueryRow(query, args...).Scan(&snap.ID, &snap.BranchRepo, &snap.BranchName, &snap.SnapshotID, &snap.Backend, &snap.Label, &snap.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// RestoreSnapshot rolls the branch's environment back to snap. Backends
+// that recreate their container on restore get the new ID recorded.
+func (s *Store) RestoreSnapshot(ctx context.Context, b *Branch, snap *Snapshot) error {
+	if snap.Backend != b.Environment.Backend {
+		return fmt.Errorf("snapshot was taken on the %s backend, branch uses %s", snap.Backend, b.Environment.Backend)
+	}
+	backend, err := snapshotter(b)
+	if err != nil {
+		return err
+	}
+	if err := backend.RestoreSnapshot(ctx, snap.SnapshotID); err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	if db, ok := backend.(*env.DockerBackend); ok && db.ContainerID() != b.Environment.ContainerID {
+		b.Environment.ContainerID = db.ContainerID()
+		return s.UpdateEnvironment(b.Repo, b.Name, b.Environment)
+	}
+	return nil
+}
+
+// DeleteSnapshot discards a snapshot and its record.
+func (s *Store) DeleteSnapshot(ctx context.Context, b *Branch, snap *Snapshot) error {
+	backend, err := snapshotter(b)
+	if err != nil {
+		return err
+	}
+	if err := backend.DeleteSnapshot(ctx, snap.SnapshotID); err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`DELETE FROM snapshots WHERE id = $1`, snap.ID)
+	return err
+}
+
+// deleteSnapshots discards all of a branch's snapshots. It is best-effort:
+// it runs while the branch's environment is being removed.
+func (s *Store) deleteSnapshots(b *Branch) {
+	snapshots, err := s.ListSnapshots(b.Repo, b.Name)
+	if err == nil && len(snapshots) > 0 {
+		// A stopped environment still has snapshots to discard
+		backend, err := b.backend()
+		if snapper, ok := backend.(env.Snapshotter); err == nil && ok {
+			for _, snap := range snapshots {
+				if err := snapper.DeleteSnapshot(context.Background(), snap.SnapshotID); err != nil {
+					fmt.Printf("Warning: failed to delete snapshot %s: %v\n", snap.SnapshotID, err)
+				}
+			}
+		}
+		s.db.Exec(`DELETE FROM snapshots WHERE branch_repo = $1 AND branch_name = $2`, b.Repo, b.Name)
+	}
+
+	// Host checkouts keep their snapshots next to the checkout
+	switch b.Environment.Backend {
+	case "", "local", "sandbox", "docker":
+		os.RemoveAll(env.SnapshotDir(b.Environment.Path))
+	}
+}
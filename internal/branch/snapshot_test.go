@@ -0,0 +1,70 @@
+package branch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/testutil"
+)
+
+func TestStore_Snapshots(t *testing.T) {
+	database, cleanup := testutil.OpenTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	store := NewStore(database, dataDir)
+
+	checkout := filepath.Join(dataDir, "checkouts", "o/r", "feature")
+	if err := os.MkdirAll(checkout, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(checkout, "a.txt")
+	os.WriteFile(file, []byte("before"), 0644)
+
+	b := &Branch{
+		Repo:        "o/r",
+		Name:        "feature",
+		BaseRev:     "abc",
+		HeadRev:     "abc",
+		Environment: EnvironmentSpec{Backend: "local", Path: checkout},
+	}
+	if err := store.Create(b); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	snap, err := store.CreateSnapshot(ctx, b, "pre-refactor")
+	if err != nil {
+		t.Fatalf("CreateSnapshot: %v", err)
+	}
+	os.WriteFile(file, []byte("after"), 0644)
+
+	got, err := store.GetSnapshot(b.Repo, b.Name, "pre-refactor")
+	if err != nil || got == nil || got.SnapshotID != snap.SnapshotID {
+		t.Fatalf("GetSnapshot by label = %+v, %v", got, err)
+	}
+	if latest, _ := store.GetSnapshot(b.Repo, b.Name, ""); latest == nil || latest.ID != snap.ID {
+		t.Fatalf("GetSnapshot latest = %+v", latest)
+	}
+
+	if err := store.RestoreSnapshot(ctx, b, got); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	if content, _ := os.ReadFile(file); string(content) != "before" {
+		t.Errorf("a.txt after restore = %q, want before", content)
+	}
+
+	// Removing the checkout removes its snapshots
+	if err := store.RemoveCheckout(b); err != nil {
+		t.Fatalf("RemoveCheckout: %v", err)
+	}
+	if snapshots, _ := store.ListSnapshots(b.Repo, b.Name); len(snapshots) != 0 {
+		t.Errorf("ListSnapshots after RemoveCheckout = %d snapshots", len(snapshots))
+	}
+	if _, err := os.Stat(env.SnapshotDir(checkout)); !os.IsNotExist(err) {
+		t.Errorf("snapshot dir survived RemoveCheckout")
+	}
+}
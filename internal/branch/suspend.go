@@ -0,0 +1,82 @@
+package branch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/justinmoon/cook/internal/env"
+)
+
+// suspender returns the branch's backend as a Suspender.
+func suspender(b *Branch) (env.Suspender, error) {
+	backend, err := b.Backend()
+	if err != nil {
+		return nil, err
+	}
+	susp, ok := backend.(env.Suspender)
+	if !ok {
+		return nil, fmt.Errorf("%s backend does not support suspend", b.Environment.Backend)
+	}
+	return susp, nil
+}
+
+// Suspend puts an idle branch's environment to sleep and records it, so the
+// next Resume wakes it.
+func (s *Store) Suspend(ctx context.Context, b *Branch) error {
+	if b.Environment.SuspendedAt != nil {
+		return nil
+	}
+	susp, err := suspender(b)
+	if err != nil {
+		return err
+	}
+	if err := susp.Suspend(ctx); err != nil {
+		return fmt.Errorf("suspend failed: %w", err)
+	}
+
+	now := time.Now()
+	b.Environment.SuspendedAt = &now
+	return s.UpdateEnvironment(b.Repo, b.Name, b.Environment)
+}
+
+// ResumeStopped brings back an environment cook stopped, e.g. for going
+// over its budget, and clears the stop so it can be used again. One that
+// was torn down rather than stopped can't be brought back.
+func (s *Store) ResumeStopped(ctx context.Context, b *Branch) error {
+	if b.Environment.StoppedReason == "" {
+		return nil
+	}
+	backend, err := b.backend()
+	if err != nil {
+		return err
+	}
+	if stopper, ok := env.AsStopper(backend); ok {
+		if err := stopper.Start(ctx); err != nil {
+			return fmt.Errorf("start failed: %w", err)
+		}
+	} else if !env.IsLocalCheckout(env.Type(b.Environment.Backend)) && b.Environment.Named == "" {
+		return fmt.Errorf("%s environment was torn down when it was stopped; abandon the branch and create a new one", b.Environment.Backend)
+	}
+
+	b.Environment.StoppedReason = ""
+	return s.UpdateEnvironment(b.Repo, b.Name, b.Environment)
+}
+
+// Resume wakes a suspended branch environment. It does nothing if the
+// environment isn't suspended.
+func (s *Store) Resume(ctx context.Context, b *Branch) error {
+	if b.Environment.SuspendedAt == nil {
+		return nil
+	}
+	susp, err := suspender(b)
+	if err != nil {
+		return err
+	}
+	if err := susp.Resume(ctx); err != nil {
+		return fmt.Errorf("resume failed: %w", err)
+	}
+
+	b.Environment.SuspendedAt = nil
+	return s.UpdateEnvironment(b.Repo, b.Name, b.Environment)
+}
@@ -0,0 +1,68 @@
+package branch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/justinmoon/cook/internal/testutil"
+)
+
+func TestStore_SuspendUnsupported(t *testing.T) {
+	store := NewStore(nil, t.TempDir())
+	b := &Branch{
+		Repo:        "o/r",
+		Name:        "feature",
+		Environment: EnvironmentSpec{Backend: "local", Path: t.TempDir()},
+	}
+
+	// Nothing to resume: no backend or database access needed
+	if err := store.Resume(context.Background(), b); err != nil {
+		t.Fatalf("Resume of a running environment: %v", err)
+	}
+
+	err := store.Suspend(context.Background(), b)
+	if err == nil || !strings.Contains(err.Error(), "does not support suspend") {
+		t.Fatalf("Suspend on local = %v, want unsupported error", err)
+	}
+	if b.Environment.SuspendedAt != nil {
+		t.Error("SuspendedAt set after a failed suspend")
+	}
+
+	// Already suspended: Suspend is a no-op
+	now := time.Now()
+	b.Environment.SuspendedAt = &now
+	if err := store.Suspend(context.Background(), b); err != nil {
+		t.Errorf("Suspend of a suspended environment: %v", err)
+	}
+}
+
+func TestStore_ResumeStopped(t *testing.T) {
+	database, cleanup := testutil.OpenTestDB(t)
+	defer cleanup()
+
+	store := NewStore(database, t.TempDir())
+	b := &Branch{Repo: "o/r", Name: "feature", BaseRev: "abc", HeadRev: "abc", Environment: EnvironmentSpec{Backend: "local", Path: t.TempDir()}}
+	if err := store.Create(b); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	b.Environment.StoppedReason = "budget exceeded: wall clock 4h0m0s/4h0m0s"
+	if err := store.UpdateEnvironment(b.Repo, b.Name, b.Environment); err != nil {
+		t.Fatalf("UpdateEnvironment: %v", err)
+	}
+
+	if err := store.ResumeStopped(context.Background(), b); err != nil {
+		t.Fatalf("ResumeStopped: %v", err)
+	}
+	got, err := store.Get(b.Repo, b.Name)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Environment.StoppedReason != "" {
+		t.Errorf("StoppedReason = %q after resume", got.Environment.StoppedReason)
+	}
+	if _, err := got.Backend(); err != nil {
+		t.Errorf("Backend() after resume: %v", err)
+	}
+}
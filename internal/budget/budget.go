@@ -0,0 +1,128 @@
+// Package budget defines spend limits for agent sessions and evaluates
+// recorded usage against them.
+package budget
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultWarnAt is the fraction of a limit at which a warning is raised.
+const DefaultWarnAt = 0.8
+
+// Limits caps what a single agent session on a branch may consume.
+// Zero values mean "no limit".
+type Limits struct {
+	MaxTokens    int64         `toml:"max_tokens" json:"max_tokens,omitempty"`
+	MaxDollars   float64       `toml:"max_dollars" json:"max_dollars,omitempty"`
+	MaxWallClock time.Duration `toml:"max_wall_clock" json:"max_wall_clock,omitempty"` // e.g. "4h"
+	WarnAt       float64       `toml:"warn_at" json:"warn_at,omitempty"`               // fraction of a limit (default 0.8)
+}
+
+// RepoBudget is the [budget] section of a repo's cook.toml.
+// Branch-specific limits override the repo-wide ones field by field.
+type RepoBudget struct {
+	Limits
+	Branches map[string]Limits `toml:"branches"`
+}
+
+// IsZero returns true if no limit is configured.
+func (l Limits) IsZero() bool {
+	return l.MaxTokens == 0 && l.MaxDollars == 0 && l.MaxWallClock == 0
+}
+
+// Merge returns l with every non-zero field of override applied on top.
+func (l Limits) Merge(override Limits) Limits {
+	if override.MaxTokens != 0 {
+		l.MaxTokens = override.MaxTokens
+	}
+	if override.MaxDollars != 0 {
+		l.MaxDollars = override.MaxDollars
+	}
+	if override.MaxWallClock != 0 {
+		l.MaxWallClock = override.MaxWallClock
+	}
+	if override.WarnAt != 0 {
+		l.WarnAt = override.WarnAt
+	}
+	return l
+}
+
+// Resolve computes the effective limits for a branch: server defaults,
+// then the repo's [budget], then [budget.branches.<name>].
+func Resolve(server Limits, repo RepoBudget, branchName string) Limits {
+	limits := server.Merge(repo.Limits)
+	if branchLimits, ok := repo.Branches[branchName]; ok {
+		limits = limits.Merge(branchLimits)
+	}
+	return limits
+}
+
+// Usage is what an agent session has consumed so far.
+type Usage struct {
+	Tokens    int64
+	Dollars   float64
+	WallClock time.Duration
+}
+
+// State is the result of evaluating usage against limits.
+type State string
+
+const (
+	StateOK       State = "ok"
+	StateWarning  State = "warning"
+	StateExceeded State = "exceeded"
+)
+
+// Verdict explains which limit (if any) was hit.
+type Verdict struct {
+	State  State  `json:"state"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Evaluate compares usage against limits. Exceeding any limit wins over
+// a warning on another.
+func Evaluate(limits Limits, usage Usage) Verdict {
+	warnAt := limits.WarnAt
+	if warnAt <= 0 || warnAt >= 1 {
+		warnAt = DefaultWarnAt
+	}
+
+	type check struct {
+		used, max float64
+		reason    string
+	}
+	var checks []check
+	if limits.MaxTokens > 0 {
+		checks = append(checks, check{
+			used:   float64(usage.Tokens),
+			max:    float64(limits.MaxTokens),
+			reason: fmt.Sprintf("tokens %d/%d", usage.Tokens, limits.MaxTokens),
+		})
+	}
+	if limits.MaxDollars > 0 {
+		checks = append(checks, check{
+			used:   usage.Dollars,
+			max:    limits.MaxDollars,
+			reason: fmt.Sprintf("spend $%.2f/$%.2f", usage.Dollars, limits.MaxDollars),
+		})
+	}
+	if limits.MaxWallClock > 0 {
+		checks = append(checks, check{
+			used:   float64(usage.WallClock),
+			max:    float64(limits.MaxWallClock),
+			reason: fmt.Sprintf("wall clock %s/%s", usage.WallClock.Truncate(time.Second), limits.MaxWallClock),
+		})
+	}
+
+	verdict := Verdict{State: StateOK}
+	for _, c := range checks {
+		if c.used >= c.max {
+			return Verdict{State: StateExceeded, Reason: c.reason}
+		}
+		if verdict.State == StateOK && c.used >= c.max*warnAt {
+			verdict = Verdict{State: StateWarning, Reason: c.reason}
+		}
+	}
+	return verdict
+}
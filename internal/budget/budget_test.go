@@ -0,0 +1,74 @@
+package budget
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestEvaluate(t *testing.T) {
+	limits := Limits{
+		MaxTokens:    1000,
+		MaxDollars:   10,
+		MaxWallClock: time.Hour,
+	}
+
+	tests := []struct {
+		name  string
+		usage Usage
+		want  State
+	}{
+		{"nothing used", Usage{}, StateOK},
+		{"under warn threshold", Usage{Tokens: 500, Dollars: 5, WallClock: 30 * time.Minute}, StateOK},
+		{"tokens near limit", Usage{Tokens: 850}, StateWarning},
+		{"dollars exceeded", Usage{Dollars: 10}, StateExceeded},
+		{"wall clock exceeded", Usage{WallClock: 2 * time.Hour}, StateExceeded},
+		{"exceeded wins over warning", Usage{Tokens: 900, WallClock: 90 * time.Minute}, StateExceeded},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Evaluate(limits, tc.usage)
+			if got.State != tc.want {
+				t.Errorf("Evaluate() = %+v, want state %s", got, tc.want)
+			}
+			if got.State != StateOK && got.Reason == "" {
+				t.Errorf("Evaluate() returned %s without a reason", got.State)
+			}
+		})
+	}
+}
+
+func TestEvaluateNoLimits(t *testing.T) {
+	got := Evaluate(Limits{}, Usage{Tokens: 1 << 40, Dollars: 1e6, WallClock: 1000 * time.Hour})
+	if got.State != StateOK {
+		t.Errorf("Evaluate() with no limits = %+v, want ok", got)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	var repo RepoBudget
+	_, err := toml.Decode(`
+max_dollars = 20
+max_wall_clock = "4h"
+
+[branches.cheap]
+max_dollars = 2
+`, &repo)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	server := Limits{MaxTokens: 5000, MaxDollars: 50}
+
+	got := Resolve(server, repo, "feature")
+	if got.MaxTokens != 5000 || got.MaxDollars != 20 || got.MaxWallClock != 4*time.Hour {
+		t.Errorf("Resolve(feature) = %+v", got)
+	}
+
+	got = Resolve(server, repo, "cheap")
+	if got.MaxDollars != 2 || got.MaxWallClock != 4*time.Hour {
+		t.Errorf("Resolve(cheap) = %+v", got)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/justinmoon/cook/internal/budget"
+	"github.com/justinmoon/cook/internal/env"
 )
 
 // stripANSI removes ANSI escape codes from a string
@@ -33,6 +36,56 @@ type ServerConfig struct {
 	AllowedPubkeys []string `toml:"allowed_pubkeys"` // empty = allow all, otherwise whitelist
 	Owner          string   `toml:"owner"`           // hex pubkey of instance owner (empty = first login claims)
 	PublicURL      string   `toml:"public_url"`      // public base URL for remote sandboxes (optional)
+
+	Budget         budget.Limits `toml:"budget"`           // default spend limits for agent sessions (zero = unlimited)
+	StuckIdleAfter time.Duration `toml:"stuck_idle_after"` // flag agents needs_help after this long without output (default 10m)
+
+	SuspendIdleAfter time.Duration `toml:"suspend_idle_after"` // suspend docker/fly/sprites environments unused this long (0 = never)
+
+	SSH     SSHConfig     `toml:"ssh"`     // remote host for the ssh backend
+	Sandbox SandboxConfig `toml:"sandbox"` // bubblewrap sandbox backend settings
+
+	Backends map[string]BackendPlugin `toml:"backends"` // out-of-process backend plugins, by backend name
+
+	Pools map[string]int `toml:"pools"` // warm environments to keep per remote backend, e.g. modal = 2
+
+	GC GCConfig `toml:"gc"` // periodic cleanup of leaked sandboxes and machines
+
+	// Profiles are named resource limits that cook.toml selects per branch
+	// or task; "default" applies when it selects none
+	Profiles map[string]env.Resources `toml:"profiles"`
+
+	SecretsKey string `toml:"secrets_key"` // base64 key encrypting stored secrets (see 'cook secret keygen')
+}
+
+// GCConfig configures the server's periodic gc of containers, sandboxes,
+// sprites and machines no branch uses (see 'cook admin gc').
+type GCConfig struct {
+	Interval time.Duration `toml:"interval"` // how often to run (0 = never)
+	DryRun   bool          `toml:"dry_run"`  // only log leaked resources
+	MinAge   time.Duration `toml:"min_age"`  // spare resources younger than this (default 30m)
+}
+
+// BackendPlugin configures an out-of-process backend plugin. Set Command
+// to have cook start it, or Socket to connect to one already running.
+type BackendPlugin struct {
+	Command []string `toml:"command"` // e.g. ["cook-backend-lxd", "--serve"]
+	Socket  string   `toml:"socket"`  // unix socket path
+}
+
+// SandboxConfig configures the sandbox backend's network policy.
+type SandboxConfig struct {
+	// Network is empty to share the host network, ["none"] to disable it,
+	// or an allowlist of hosts reachable over HTTP(S), e.g.
+	// ["api.anthropic.com", "github.com", "*.githubusercontent.com"]
+	Network []string `toml:"network"`
+}
+
+// SSHConfig configures the ssh backend, which runs branches on an existing
+// machine (a workstation or build box) reachable with non-interactive ssh.
+type SSHConfig struct {
+	Host    string `toml:"host"`     // ssh destination, e.g. "me@workstation" or a ~/.ssh/config alias
+	WorkDir string `toml:"work_dir"` // checkout root on the host, relative to its home (default "cook/checkouts")
 }
 
 type ClientConfig struct {
@@ -52,6 +105,9 @@ func DefaultConfig() *Config {
 			DataDir:        dataDir,
 			Auth:           "none", // "none" or "nostr"
 			AllowedPubkeys: []string{},
+			SSH: SSHConfig{
+				WorkDir: "cook/checkouts",
+			},
 		},
 		Client: ClientConfig{
 			ServerURL: "http://127.0.0.1:7420",
@@ -116,6 +172,18 @@ func Load() (*Config, error) {
 		cfg.Server.PublicURL = publicURL
 	}
 
+	if network := os.Getenv("COOK_SANDBOX_NETWORK"); network != "" {
+		cfg.Server.Sandbox.Network = splitList(network)
+	}
+
+	if secretsKey := os.Getenv("COOK_SECRETS_KEY"); secretsKey != "" {
+		cfg.Server.SecretsKey = secretsKey
+	}
+
+	if sshHost := os.Getenv("COOK_SSH_HOST"); sshHost != "" {
+		cfg.Server.SSH.Host = sshHost
+	}
+
 	if host := os.Getenv("COOK_HOST"); host != "" {
 		cfg.Server.Host = host
 	}
@@ -209,6 +209,107 @@ func (db *DB) migrate() error {
 			UNIQUE(pubkey, name)
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_dotfiles_pubkey ON dotfiles(pubkey)`,
+
+		// Budgets: usage reported by agent sessions
+		`ALTER TABLE agent_sessions ADD COLUMN IF NOT EXISTS tokens_used BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE agent_sessions ADD COLUMN IF NOT EXISTS cost_usd DOUBLE PRECISION NOT NULL DEFAULT 0`,
+
+		// Stuck detection: why an agent session was flagged needs_help
+		`ALTER TABLE agent_sessions ADD COLUMN IF NOT EXISTS help_reason TEXT NOT NULL DEFAULT ''`,
+
+		// Fix loop: consecutive automatic fix attempts for failing gates
+		`ALTER TABLE branches ADD COLUMN IF NOT EXISTS fix_iterations INTEGER NOT NULL DEFAULT 0`,
+		// and the head the last attempt left, so outside commits restart the count
+		`ALTER TABLE branches ADD COLUMN IF NOT EXISTS fix_rev TEXT NOT NULL DEFAULT ''`,
+
+		// Review gates: structured findings stored with the run
+		`ALTER TABLE gate_runs ADD COLUMN IF NOT EXISTS findings_json TEXT NOT NULL DEFAULT '[]'`,
+
+		// Code review: inline comments and reviewer verdicts, keyed by nostr pubkey
+		`CREATE TABLE IF NOT EXISTS review_comments (
+			id BIGSERIAL PRIMARY KEY,
+			branch_repo TEXT NOT NULL,
+			branch_name TEXT NOT NULL,
+			path TEXT NOT NULL,
+			line INTEGER NOT NULL,
+			rev TEXT NOT NULL,
+			line_text TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL,
+			author TEXT NOT NULL,
+			outdated BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			FOREIGN KEY (branch_repo, branch_name) REFERENCES branches(repo, name)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_review_comments_branch ON review_comments(branch_repo, branch_name)`,
+		`CREATE TABLE IF NOT EXISTS reviews (
+			id BIGSERIAL PRIMARY KEY,
+			branch_repo TEXT NOT NULL,
+			branch_name TEXT NOT NULL,
+			reviewer TEXT NOT NULL,
+			state TEXT NOT NULL,
+			rev TEXT NOT NULL,
+			body TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			FOREIGN KEY (branch_repo, branch_name) REFERENCES branches(repo, name)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_reviews_branch ON reviews(branch_repo, branch_name)`,
+
+		// Environment snapshots: backend-specific checkpoints a branch can roll back to
+		`CREATE TABLE IF NOT EXISTS snapshots (
+			id BIGSERIAL PRIMARY KEY,
+			branch_repo TEXT NOT NULL,
+			branch_name TEXT NOT NULL,
+			snapshot_id TEXT NOT NULL,
+			backend TEXT NOT NULL,
+			label TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			UNIQUE(branch_repo, branch_name, snapshot_id),
+			FOREIGN KEY (branch_repo, branch_name) REFERENCES branches(repo, name) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_snapshots_branch ON snapshots(branch_repo, branch_name)`,
+
+		// Warm environment pool: remote environments provisioned ahead of
+		// branch creation, recorded so a restart can tear down leftovers
+		`CREATE TABLE IF NOT EXISTS env_pool (
+			id BIGSERIAL PRIMARY KEY,
+			backend TEXT NOT NULL,
+			env_id TEXT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		)`,
+		// Claimed entries stay until the claiming branch records the ID,
+		// so gc never sees them unowned
+		`ALTER TABLE env_pool ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ`,
+
+		// Named environments: long-lived remote environments that host one
+		// branch's checkout at a time, owned by the user who created them
+		`CREATE TABLE IF NOT EXISTS environments (
+			id BIGSERIAL PRIMARY KEY,
+			owner TEXT NOT NULL DEFAULT '',
+			name TEXT NOT NULL,
+			backend TEXT NOT NULL,
+			env_id TEXT NOT NULL,
+			profile TEXT NOT NULL DEFAULT '',
+			resources_json TEXT NOT NULL DEFAULT '',
+			idle_timeout_seconds BIGINT NOT NULL DEFAULT 0,
+			attached_repo TEXT NOT NULL DEFAULT '',
+			attached_branch TEXT NOT NULL DEFAULT '',
+			suspended_at TIMESTAMPTZ,
+			last_used_at TIMESTAMPTZ DEFAULT NOW(),
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			UNIQUE(owner, name)
+		)`,
+
+		// Secrets: values encrypted with the server's secrets key, scoped
+		// to an owner ("alice") or one of their repos ("alice/app")
+		`CREATE TABLE IF NOT EXISTS secrets (
+			id BIGSERIAL PRIMARY KEY,
+			scope TEXT NOT NULL,
+			name TEXT NOT NULL,
+			ciphertext BYTEA NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			UNIQUE(scope, name)
+		)`,
 	}
 
 	for _, m := range migrations {
@@ -0,0 +1,207 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+	"github.com/justinmoon/cook/internal/envagent"
+)
+
+// Remote backends serve files and commands through their cook-agent when
+// it's reachable, rather than running cat, base64 and ls through their
+// provider's exec API and parsing the output.
+
+// errAgentUnavailable means a backend's cook-agent can't serve an RPC: it
+// isn't running yet, can't be reached, or predates the RPC. Callers fall
+// back to their provider's API.
+var errAgentUnavailable = errors.New("cook-agent unavailable")
+
+// agentRetryAfter is how long an agent that couldn't be reached is left
+// alone, so every file operation doesn't wait on it.
+const agentRetryAfter = time.Minute
+
+var (
+	unreachableMu sync.Mutex
+	unreachable   = make(map[string]time.Time) // agent address -> when dialing it failed
+)
+
+// CookAgent returns the address of the cook-agent running in backend's
+// environment and the secret it requires. It reports false for backends
+// without one.
+func CookAgent(backend Backend) (addr, secret string, ok bool) {
+	switch be := backend.(type) {
+	case *DockerBackend:
+		return be.AgentAddr(), be.AgentSecret(), true
+	case *PodmanBackend:
+		return be.AgentAddr(), be.AgentSecret(), true
+	case *ModalBackend:
+		return be.AgentAddr(), be.AgentSecret(), true
+	case *SpritesBackend:
+		return be.AgentAddr(), be.AgentSecret(), true
+	case *FlyMachinesBackend:
+		return be.AgentAddr(), be.AgentSecret(), true
+	case *SSHBackend:
+		return be.AgentAddr(), be.AgentSecret(), true
+	case *PluginBackend:
+		addr := be.AgentAddr()
+		return addr, be.AgentSecret(), addr != ""
+	}
+	return "", "", false
+}
+
+// withAgent runs fn on a new connection to the cook-agent at addr if the
+// agent serves feature, and returns errAgentUnavailable without running it
+// otherwise.
+func withAgent(addr, secret, feature string, fn func(*envagent.Client) error) error {
+	if addr == "" {
+		return errAgentUnavailable
+	}
+	unreachableMu.Lock()
+	failedAt, failed := unreachable[addr]
+	unreachableMu.Unlock()
+	if failed && time.Since(failedAt) < agentRetryAfter {
+		return errAgentUnavailable
+	}
+
+	client, err := envagent.Dial(addr, secret)
+	unreachableMu.Lock()
+	if err != nil {
+		unreachable[addr] = time.Now()
+	} else {
+		delete(unreachable, addr)
+	}
+	unreachableMu.Unlock()
+	if err != nil {
+		return errAgentUnavailable
+	}
+	defer client.Close()
+
+	if !client.HasFeature(feature) {
+		return errAgentUnavailable
+	}
+	return fn(client)
+}
+
+func agentReadFile(addr, secret, path string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := withAgent(addr, secret, agentproto.FeatureFiles, func(c *envagent.Client) error {
+		return c.ReadFile(path, &buf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func agentWriteFile(addr, secret, path string, content []byte) error {
+	return withAgent(addr, secret, agentproto.FeatureFiles, func(c *envagent.Client) error {
+		return c.WriteFile(path, 0644, bytes.NewReader(content))
+	})
+}
+
+func agentListFiles(addr, secret, dir string) ([]FileInfo, error) {
+	var files []FileInfo
+	err := withAgent(addr, secret, agentproto.FeatureFiles, func(c *envagent.Client) error {
+		infos, err := c.ListFiles(dir, false)
+		for _, info := range infos {
+			files = append(files, FileInfo{Name: info.Name, Path: info.Path, IsDir: info.IsDir, Size: info.Size})
+		}
+		return err
+	})
+	return files, err
+}
+
+// agentExec runs cmdStr in dir and returns its combined output, failing if
+// it exits non-zero. Once the command has been sent, errors are returned
+// rather than errAgentUnavailable, so it never runs twice.
+func agentExec(ctx context.Context, addr, secret, dir, cmdStr string, env []string) ([]byte, error) {
+	var output, stderr bytes.Buffer
+	var code int
+	err := withAgent(addr, secret, agentproto.FeatureExec, func(c *envagent.Client) error {
+		var err error
+		code, err = c.Exec(ctx, cmdStr, dir, env, &output, io.MultiWriter(&output, &stderr))
+		return err
+	})
+	if err != nil {
+		return output.Bytes(), err
+	}
+	if code != 0 {
+		return output.Bytes(), fmt.Errorf("command failed (exit %d): %s", code, stderr.String())
+	}
+	return output.Bytes(), nil
+}
+
+// agentWatch calls fn with changes under dir until ctx ends.
+func agentWatch(ctx context.Context, addr, secret, dir string, fn func([]FileEvent)) error {
+	return withAgent(addr, secret, agentproto.FeatureWatch, func(c *envagent.Client) error {
+		return c.Watch(ctx, dir, func(events []agentproto.FileEvent) {
+			converted := make([]FileEvent, len(events))
+			for i, e := range events {
+				converted[i] = FileEvent{Path: e.Path, Op: e.Op}
+			}
+			fn(converted)
+		})
+	})
+}
+
+// UpdateAgent pushes this host's cook-agent binary to the agent at addr if
+// it's running a different one, and reports whether it did. The agent
+// restarts into the update once its sessions have ended.
+func UpdateAgent(addr, secret string) (bool, error) {
+	path, err := findAgentBinary()
+	if err != nil {
+		return false, err
+	}
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := envagent.Dial(addr, secret)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+	if !client.HasFeature(agentproto.FeatureStatus) || !client.HasFeature(agentproto.FeatureUpdate) {
+		return false, fmt.Errorf("cook-agent at %s predates self-update (recreate the environment to update it)", addr)
+	}
+	status, err := client.Status()
+	if err != nil {
+		return false, err
+	}
+	if status.Checksum == checksum || status.RestartPending {
+		return false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if err := client.Update(f, checksum); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -4,8 +4,10 @@ package env
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"os/exec"
+	"time"
 )
 
 // Backend handles the lifecycle of an execution environment.
@@ -82,9 +84,29 @@ type Config struct {
 	// Dotfiles is an optional git URL for dotfiles repo
 	Dotfiles string
 
+	// Host is the ssh destination (ssh backend only)
+	Host string
+
+	// Network is the sandbox backend's network policy: empty shares the
+	// host network, ["none"] disables it, otherwise an allowlist of hosts
+	Network []string
+
 	// SandboxName overrides the backend resource name (sprite/machine/sandbox)
 	SandboxName string
 
+	// WarmFor is how long a warm pool environment may wait to be claimed.
+	// Backends with a fixed lifetime add it on, so a claimed environment
+	// lives as long as a freshly set up one.
+	WarmFor time.Duration
+
+	// Resources are the environment's CPU, memory, disk, GPU and lifetime
+	// limits (zero fields use the backend's defaults)
+	Resources Resources
+
+	// State is a plugin backend's opaque state from a previous Setup,
+	// used to reconnect to an existing environment
+	State json.RawMessage
+
 	// Secrets contains agent auth, API keys, etc.
 	Secrets map[string]string
 }
@@ -100,13 +122,67 @@ type PTYAttacher interface {
 	ResizePTY(rows, cols int) error
 }
 
+// Stopper is an optional interface for backends that can halt their
+// environment without destroying it (the checkout and container survive).
+// Start brings a stopped environment back.
+type Stopper interface {
+	Stop(ctx context.Context) error
+	Start(ctx context.Context) error
+}
+
+// AsStopper returns backend as a Stopper if it can stop its environment.
+// Use it rather than a type assertion: plugins implement Stopper but only
+// stop if their handshake advertised the stop capability.
+func AsStopper(backend Backend) (Stopper, bool) {
+	if pb, ok := backend.(*PluginBackend); ok && !pb.CanStop() {
+		return nil, false
+	}
+	stopper, ok := backend.(Stopper)
+	return stopper, ok
+}
+
+// Suspender is an optional interface for backends that can be put to sleep
+// while idle and woken on the next use, keeping the environment's state.
+type Suspender interface {
+	Suspend(ctx context.Context) error
+	Resume(ctx context.Context) error
+}
+
+// FileWatcher is an optional interface for backends that can report
+// changes to files in their environment.
+type FileWatcher interface {
+	// WatchFiles calls fn with changes under dir until ctx ends.
+	WatchFiles(ctx context.Context, dir string, fn func([]FileEvent)) error
+}
+
+// FileEvent is a change to a watched file. Op is "create", "modify" or
+// "remove".
+type FileEvent struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+}
+
 // Type represents the backend type
 type Type string
 
 const (
 	TypeLocal       Type = "local"
+	TypeSandbox     Type = "sandbox"
 	TypeDocker      Type = "docker"
+	TypePodman      Type = "podman"
 	TypeModal       Type = "modal"
 	TypeSprites     Type = "sprites"
 	TypeFlyMachines Type = "fly-machines"
+	TypeSSH         Type = "ssh"
 )
+
+// IsLocalCheckout reports whether backendType runs in a checkout on this
+// machine, with its terminals in cook's own terminal manager. An empty type
+// is local, for branches recorded before backends had types.
+func IsLocalCheckout(backendType Type) bool {
+	switch backendType {
+	case "", TypeLocal, TypeSandbox:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,559 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// devContainerPaths are where a checkout's devcontainer.json may live,
+// in the order the devcontainer CLI looks for them.
+var devContainerPaths = []string{
+	filepath.Join(".devcontainer", "devcontainer.json"),
+	".devcontainer.json",
+}
+
+// DevContainer is the subset of a devcontainer.json (containers.dev) that
+// the docker and podman backends honor: the image or Dockerfile, features,
+// containerEnv, postCreateCommand and forwardPorts. Other properties are
+// ignored.
+type DevContainer struct {
+	Image                       string             `json:"image"`
+	Build                       *DevContainerBuild `json:"build"`
+	DockerFile                  string             `json:"dockerFile"` // pre-"build" spelling of build.dockerfile
+	Context                     string             `json:"context"`    // pre-"build" spelling of build.context
+	DockerComposeFile           any                `json:"dockerComposeFile"`
+	Features                    map[string]any     `json:"features"`
+	OverrideFeatureInstallOrder []string           `json:"overrideFeatureInstallOrder"`
+	ContainerEnv                map[string]string  `json:"containerEnv"`
+	PostCreateCommand           any                `json:"postCreateCommand"` // string, array or object of either
+	ForwardPorts                []any              `json:"forwardPorts"`      // ports or "host:port" strings
+
+	root  string // the checkout, with symlinks resolved
+	dir   string // directory holding devcontainer.json
+	hash  string // content hash naming the built image
+	image string // image the container runs, once prepared
+}
+
+// DevContainerBuild is a devcontainer.json "build" section.
+type DevContainerBuild struct {
+	Dockerfile string            `json:"dockerfile"`
+	Context    string            `json:"context"`
+	Args       map[string]string `json:"args"`
+	Target     string            `json:"target"`
+}
+
+// LoadDevContainer reads the devcontainer.json in checkout, returning nil
+// if there is none.
+func LoadDevContainer(checkout string) (*DevContainer, error) {
+	for _, rel := range devContainerPaths {
+		path := filepath.Join(checkout, rel)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		dc, err := ParseDevContainer(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+		if dc.root, err = filepath.EvalSymlinks(checkout); err != nil {
+			return nil, err
+		}
+		dc.dir = filepath.Join(dc.root, filepath.Dir(rel))
+		if _, err := dc.checkoutPath(filepath.Base(rel)); err != nil {
+			return nil, err
+		}
+		if dockerfile := dc.dockerfile(); dockerfile != "" {
+			// The built image depends on the Dockerfile too
+			dockerfilePath, err := dc.checkoutPath(dockerfile)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", rel, err)
+			}
+			content, err := os.ReadFile(dockerfilePath)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", rel, err)
+			}
+			h := fnv.New64a()
+			h.Write(data)
+			h.Write(content)
+			dc.hash = fmt.Sprintf("%x", h.Sum64())
+		}
+		return dc, nil
+	}
+	return nil, nil
+}
+
+// ParseDevContainer parses devcontainer.json content, which may contain
+// comments and trailing commas.
+func ParseDevContainer(data []byte) (*DevContainer, error) {
+	var dc DevContainer
+	if err := json.Unmarshal(stripJSONC(data), &dc); err != nil {
+		return nil, fmt.Errorf("invalid devcontainer.json: %w", err)
+	}
+	if dc.DockerComposeFile != nil {
+		return nil, fmt.Errorf("dockerComposeFile is not supported; use image or build")
+	}
+	if dc.Image == "" && dc.dockerfile() == "" {
+		return nil, fmt.Errorf("devcontainer.json needs an image or a build.dockerfile")
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	dc.hash = fmt.Sprintf("%x", h.Sum64())
+	return &dc, nil
+}
+
+func (dc *DevContainer) dockerfile() string {
+	if dc.Build != nil && dc.Build.Dockerfile != "" {
+		return dc.Build.Dockerfile
+	}
+	return dc.DockerFile
+}
+
+func (dc *DevContainer) buildContext() string {
+	if dc.Build != nil && dc.Build.Context != "" {
+		return dc.Build.Context
+	}
+	if dc.Context != "" {
+		return dc.Context
+	}
+	return "."
+}
+
+// checkoutPath resolves rel, relative to devcontainer.json, refusing
+// anything outside the checkout, whether by ".." or through a symlink, so
+// a repo can't build the server's files into its image.
+func (dc *DevContainer) checkoutPath(rel string) (string, error) {
+	path, err := filepath.EvalSymlinks(filepath.Join(dc.dir, rel))
+	if err != nil {
+		return "", err
+	}
+	if path != dc.root && !strings.HasPrefix(path, dc.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the checkout", rel)
+	}
+	return path, nil
+}
+
+// ImageName returns the image prepared for dc, or "" before Setup.
+func (dc *DevContainer) ImageName() string {
+	return dc.image
+}
+
+// Env returns containerEnv as KEY=VALUE pairs in key order.
+func (dc *DevContainer) Env() []string {
+	keys := make([]string, 0, len(dc.ContainerEnv))
+	for k := range dc.ContainerEnv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+dc.ContainerEnv[k])
+	}
+	return env
+}
+
+// Ports returns the forwardPorts. Entries naming another host (a compose
+// service) are skipped, since the backends run a single container.
+func (dc *DevContainer) Ports() []int {
+	var ports []int
+	for _, p := range dc.ForwardPorts {
+		var port int
+		switch v := p.(type) {
+		case float64:
+			port = int(v)
+		case string:
+			host, portStr, ok := strings.Cut(v, ":")
+			if !ok {
+				portStr, host = host, ""
+			}
+			if host != "" && host != "localhost" && host != "127.0.0.1" {
+				continue
+			}
+			port, _ = strconv.Atoi(portStr)
+		}
+		if port > 0 && port <= 65535 {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// PostCreateCommands returns postCreateCommand as shell commands. An array
+// is one command run without a shell; an object is several commands,
+// which are run one after another in key order.
+func (dc *DevContainer) PostCreateCommands() ([]string, error) {
+	return lifecycleCommands(dc.PostCreateCommand)
+}
+
+func lifecycleCommands(v any) ([]string, error) {
+	switch c := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if c == "" {
+			return nil, nil
+		}
+		return []string{c}, nil
+	case []any:
+		args := make([]string, 0, len(c))
+		for _, arg := range c {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("command arguments must be strings")
+			}
+			args = append(args, shellQuote(s))
+		}
+		if len(args) == 0 {
+			return nil, nil
+		}
+		return []string{strings.Join(args, " ")}, nil
+	case map[string]any:
+		keys := make([]string, 0, len(c))
+		for k := range c {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var cmds []string
+		for _, k := range keys {
+			sub, err := lifecycleCommands(c[k])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			cmds = append(cmds, sub...)
+		}
+		return cmds, nil
+	default:
+		return nil, fmt.Errorf("command must be a string, array or object")
+	}
+}
+
+// runPostCreate runs dc's postCreateCommand in a freshly created container.
+func runPostCreate(ctx context.Context, dc *DevContainer, run containerExec) error {
+	cmds, err := dc.PostCreateCommands()
+	if err != nil {
+		return fmt.Errorf("invalid postCreateCommand: %w", err)
+	}
+	for _, cmd := range cmds {
+		fmt.Printf("Running postCreateCommand: %s\n", cmd)
+		if output, err := run(ctx, cmd); err != nil {
+			return fmt.Errorf("postCreateCommand failed: %s: %w", strings.TrimSpace(string(output)), err)
+		}
+	}
+	return nil
+}
+
+// shellQuote single-quotes s for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// prepareDevContainerImage pulls or builds dc's image with the given
+// container CLI ("docker" or "podman"), installs its features on top, and
+// returns the image to run.
+func prepareDevContainerImage(ctx context.Context, cli string, dc *DevContainer) (string, error) {
+	image, err := buildDevContainerImage(ctx, cli, dc)
+	if err != nil {
+		return "", err
+	}
+	dc.image = image
+	return image, nil
+}
+
+func buildDevContainerImage(ctx context.Context, cli string, dc *DevContainer) (string, error) {
+	image := dc.Image
+	if dockerfile := dc.dockerfile(); dockerfile != "" {
+		image = "cook-devcontainer:" + dc.hash
+		dockerfilePath, err := dc.checkoutPath(dockerfile)
+		if err != nil {
+			return "", err
+		}
+		buildContext, err := dc.checkoutPath(dc.buildContext())
+		if err != nil {
+			return "", err
+		}
+		args := []string{"build",
+			"-f", dockerfilePath,
+			"-t", image,
+		}
+		if dc.Build != nil {
+			keys := make([]string, 0, len(dc.Build.Args))
+			for k := range dc.Build.Args {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				args = append(args, "--build-arg", k+"="+dc.Build.Args[k])
+			}
+			if dc.Build.Target != "" {
+				args = append(args, "--target", dc.Build.Target)
+			}
+		}
+		args = append(args, buildContext)
+
+		fmt.Printf("Building devcontainer image: %s\n", image)
+		if err := runContainerCLI(ctx, cli, args...); err != nil {
+			return "", fmt.Errorf("%s build failed: %w", cli, err)
+		}
+	} else if exec.CommandContext(ctx, cli, "image", "inspect", image).Run() != nil {
+		fmt.Printf("Pulling devcontainer image: %s\n", image)
+		if err := runContainerCLI(ctx, cli, "pull", image); err != nil {
+			return "", fmt.Errorf("%s pull failed: %w", cli, err)
+		}
+	}
+
+	if len(dc.Features) == 0 {
+		return image, nil
+	}
+	return buildFeatureImage(ctx, cli, dc, image)
+}
+
+// runContainerCLI runs a docker or podman command, streaming its output.
+func runContainerCLI(ctx context.Context, cli string, args ...string) error {
+	cmd := exec.CommandContext(ctx, cli, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+var (
+	featureEnvInvalid = regexp.MustCompile(`[^\w_]`)
+	featureEnvLeading = regexp.MustCompile(`^[\d_]+`)
+)
+
+// featureEnvName converts a feature option name to the environment
+// variable its install.sh reads, as the devcontainer CLI does.
+func featureEnvName(option string) string {
+	name := featureEnvInvalid.ReplaceAllString(option, "_")
+	return strings.ToUpper(featureEnvLeading.ReplaceAllString(name, ""))
+}
+
+// featureMetadata is the part of a feature's devcontainer-feature.json
+// cook uses.
+type featureMetadata struct {
+	ID      string `json:"id"`
+	Options map[string]struct {
+		Default any `json:"default"`
+	} `json:"options"`
+	ContainerEnv map[string]string `json:"containerEnv"`
+}
+
+// featureOrder returns dc's feature IDs in install order:
+// overrideFeatureInstallOrder first, then the rest by ID. Dependencies
+// between features (dependsOn, installsAfter) are not resolved.
+func (dc *DevContainer) featureOrder() []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range dc.OverrideFeatureInstallOrder {
+		if _, ok := dc.Features[id]; ok && !seen[id] {
+			ids = append(ids, id)
+			seen[id] = true
+		}
+	}
+	var rest []string
+	for id := range dc.Features {
+		if !seen[id] {
+			rest = append(rest, id)
+		}
+	}
+	sort.Strings(rest)
+	return append(ids, rest...)
+}
+
+// buildFeatureImage installs dc's features on base, tagging the result
+// cook-devcontainer:<hash>-features. Local features (./path, relative to
+// devcontainer.json) are copied; others are fetched from their OCI
+// registry.
+func buildFeatureImage(ctx context.Context, cli string, dc *DevContainer, base string) (string, error) {
+	buildDir, err := os.MkdirTemp("", "cook-features-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(buildDir)
+
+	var dockerfile bytes.Buffer
+	fmt.Fprintf(&dockerfile, "FROM %s\nUSER root\n", base)
+	for i, id := range dc.featureOrder() {
+		featureDir := filepath.Join(buildDir, strconv.Itoa(i))
+		if strings.HasPrefix(id, "./") || strings.HasPrefix(id, "../") {
+			var src string
+			if src, err = dc.checkoutPath(id); err == nil {
+				err = copyDir(src, featureDir)
+			}
+		} else {
+			err = fetchOCIFeature(ctx, id, featureDir)
+		}
+		if err != nil {
+			return "", fmt.Errorf("feature %s: %w", id, err)
+		}
+
+		install, err := featureInstallStep(id, dc.Features[id], featureDir)
+		if err != nil {
+			return "", fmt.Errorf("feature %s: %w", id, err)
+		}
+		fmt.Fprintf(&dockerfile, "COPY %d /tmp/cook-features/%d\n%s", i, i, install)
+	}
+	dockerfile.WriteString("RUN rm -rf /tmp/cook-features\n")
+
+	if err := os.WriteFile(filepath.Join(buildDir, "Dockerfile"), dockerfile.Bytes(), 0644); err != nil {
+		return "", err
+	}
+
+	image := "cook-devcontainer:" + dc.hash + "-features"
+	fmt.Printf("Installing devcontainer features: %s\n", image)
+	if err := runContainerCLI(ctx, cli, "build", "-t", image, buildDir); err != nil {
+		return "", fmt.Errorf("%s build failed: %w", cli, err)
+	}
+	return image, nil
+}
+
+// featureInstallStep returns the Dockerfile lines that run the feature in
+// featureDir (copied to /tmp/cook-features/<dir>) with the given options:
+// a version string, true for defaults, or an object.
+func featureInstallStep(id string, value any, featureDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(featureDir, "devcontainer-feature.json"))
+	if err != nil {
+		return "", err
+	}
+	var meta featureMetadata
+	if err := json.Unmarshal(stripJSONC(data), &meta); err != nil {
+		return "", fmt.Errorf("invalid devcontainer-feature.json: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(featureDir, "install.sh")); err != nil {
+		return "", fmt.Errorf("install.sh not found")
+	}
+
+	options := make(map[string]any)
+	for name, opt := range meta.Options {
+		if opt.Default != nil {
+			options[name] = opt.Default
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		options["version"] = v
+	case bool, nil:
+	case map[string]any:
+		for name, opt := range v {
+			options[name] = opt
+		}
+	default:
+		return "", fmt.Errorf("options must be a string or an object")
+	}
+
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	keys := make([]string, 0, len(meta.ContainerEnv))
+	for k := range meta.ContainerEnv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "ENV %s=%s\n", k, strconv.Quote(meta.ContainerEnv[k]))
+	}
+
+	dir := "/tmp/cook-features/" + filepath.Base(featureDir)
+	fmt.Fprintf(&b, "RUN cd %s && chmod +x install.sh && env _REMOTE_USER=root _REMOTE_USER_HOME=/root _CONTAINER_USER=root", dir)
+	for _, name := range names {
+		fmt.Fprintf(&b, " %s", shellQuote(featureEnvName(name)+"="+fmt.Sprint(options[name])))
+	}
+	b.WriteString(" ./install.sh\n")
+	return b.String(), nil
+}
+
+// copyDir copies the regular files under src to dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, info.Mode().Perm())
+	})
+}
+
+// stripJSONC removes // and /* */ comments and trailing commas from
+// JSON-with-comments, leaving string contents alone.
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				i = len(data)
+			} else {
+				i += end + 3
+			}
+		case c == '}' || c == ']':
+			// Drop a trailing comma before the closing bracket
+			j := len(out) - 1
+			for j >= 0 && (out[j] == ' ' || out[j] == '\t' || out[j] == '\n' || out[j] == '\r') {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package env
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// featureManifest is the part of an OCI image manifest that locates a
+// feature's tarball.
+type featureManifest struct {
+	Layers []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"layers"`
+}
+
+// fetchOCIFeature downloads a feature such as
+// "ghcr.io/devcontainers/features/node:1" from its registry and unpacks it
+// into dir. Only registries that allow anonymous pulls are supported.
+func fetchOCIFeature(ctx context.Context, ref, dir string) error {
+	registry, repo, tag, err := parseFeatureRef(ref)
+	if err != nil {
+		return err
+	}
+
+	var token string
+	get := func(url, accept string) (*http.Response, error) {
+		for attempt := 0; ; attempt++ {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Accept", accept)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+				challenge := resp.Header.Get("WWW-Authenticate")
+				resp.Body.Close()
+				if token, err = registryToken(ctx, challenge); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+			}
+			return resp, nil
+		}
+	}
+
+	base := fmt.Sprintf("https://%s/v2/%s", registry, repo)
+	resp, err := get(base+"/manifests/"+tag, "application/vnd.oci.image.manifest.v1+json")
+	if err != nil {
+		return err
+	}
+	var manifest featureManifest
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	if len(manifest.Layers) == 0 {
+		return fmt.Errorf("manifest has no layers")
+	}
+
+	layer := manifest.Layers[0]
+	resp, err = get(base+"/blobs/"+layer.Digest, layer.MediaType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r io.Reader = resp.Body
+	if strings.HasSuffix(layer.MediaType, "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return untar(r, dir)
+}
+
+// parseFeatureRef splits "registry/path/feature:tag" (or @digest).
+func parseFeatureRef(ref string) (registry, repo, tag string, err error) {
+	registry, rest, ok := strings.Cut(ref, "/")
+	if !ok || (!strings.ContainsAny(registry, ".:") && registry != "localhost") {
+		return "", "", "", fmt.Errorf("unsupported feature reference (use a registry path like ghcr.io/devcontainers/features/node:1 or a ./local path)")
+	}
+	if repo, tag, ok = strings.Cut(rest, "@"); ok {
+		return registry, repo, tag, nil
+	}
+	repo, tag = rest, "latest"
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		repo, tag = rest[:i], rest[i+1:]
+	}
+	return registry, repo, tag, nil
+}
+
+// registryToken fetches an anonymous pull token for a Bearer challenge,
+// e.g. `Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="..."`.
+func registryToken(ctx context.Context, challenge string) (string, error) {
+	params, ok := strings.CutPrefix(challenge, "Bearer ")
+	if !ok {
+		return "", fmt.Errorf("registry requires unsupported authentication: %q", challenge)
+	}
+	fields := make(map[string]string)
+	for _, p := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		fields[k] = strings.Trim(v, `"`)
+	}
+	if fields["realm"] == "" {
+		return "", fmt.Errorf("registry challenge has no realm")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fields["realm"], nil)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	for _, k := range []string{"service", "scope"} {
+		if fields[k] != "" {
+			q.Set(k, fields[k])
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// untar extracts the regular files and directories in r under dir,
+// rejecting entries that escape it.
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, hdr.Name)
+		if target != dir && !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			return fmt.Errorf("tar entry %q escapes the feature directory", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
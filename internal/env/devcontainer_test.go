@@ -0,0 +1,136 @@
+package env
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDevContainer(t *testing.T) {
+	dc, err := ParseDevContainer([]byte(`{
+		// Comments and trailing commas are allowed
+		"name": "app", /* ignored */
+		"image": "mcr.microsoft.com/devcontainers/go:1",
+		"containerEnv": {"B": "2", "A": "http://x//y",},
+		"forwardPorts": [3000, "5173", "localhost:8080", "db:5432", 0],
+		"postCreateCommand": {"b": ["go", "mod", "download"], "a": "npm ci"},
+	}`))
+	if err != nil {
+		t.Fatalf("ParseDevContainer: %v", err)
+	}
+
+	if want := []string{"A=http://x//y", "B=2"}; !reflect.DeepEqual(dc.Env(), want) {
+		t.Errorf("Env = %v, want %v", dc.Env(), want)
+	}
+	if want := []int{3000, 5173, 8080}; !reflect.DeepEqual(dc.Ports(), want) {
+		t.Errorf("Ports = %v, want %v", dc.Ports(), want)
+	}
+	cmds, err := dc.PostCreateCommands()
+	if want := []string{"npm ci", "'go' 'mod' 'download'"}; err != nil || !reflect.DeepEqual(cmds, want) {
+		t.Errorf("PostCreateCommands = %v, %v, want %v", cmds, err, want)
+	}
+
+	for _, bad := range []string{
+		`{}`,
+		`{"dockerComposeFile": "compose.yml", "service": "app"}`,
+		`{"image": "x", "postCreateCommand": 1}`,
+	} {
+		dc, err := ParseDevContainer([]byte(bad))
+		if err == nil {
+			_, err = dc.PostCreateCommands()
+		}
+		if err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestLoadDevContainer(t *testing.T) {
+	checkout := t.TempDir()
+	if dc, err := LoadDevContainer(checkout); dc != nil || err != nil {
+		t.Fatalf("LoadDevContainer without a config = %v, %v, want nil", dc, err)
+	}
+
+	dir := filepath.Join(checkout, ".devcontainer")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "devcontainer.json"), []byte(`{"build": {"dockerfile": "Dockerfile", "context": ".."}}`), 0644)
+	if _, err := LoadDevContainer(checkout); err == nil {
+		t.Error("expected error for a missing Dockerfile")
+	}
+
+	os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM alpine\n"), 0644)
+	dc, err := LoadDevContainer(checkout)
+	if err != nil || dc == nil {
+		t.Fatalf("LoadDevContainer = %v, %v", dc, err)
+	}
+	root, _ := filepath.EvalSymlinks(checkout)
+	if buildContext, err := dc.checkoutPath(dc.buildContext()); dc.dockerfile() != "Dockerfile" || buildContext != root {
+		t.Errorf("dockerfile = %q, context = %q, %v", dc.dockerfile(), buildContext, err)
+	}
+
+	// Paths must stay inside the checkout
+	outside := t.TempDir()
+	os.Symlink(outside, filepath.Join(checkout, "escape"))
+	for _, rel := range []string{"../..", "../escape", "/../../etc"} {
+		if path, err := dc.checkoutPath(rel); err == nil {
+			t.Errorf("checkoutPath(%q) = %q, want error", rel, path)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "devcontainer.json"), []byte(`{"build": {"dockerfile": "Dockerfile", "context": "../.."}}`), 0644)
+	if dc, err := LoadDevContainer(checkout); err != nil {
+		t.Fatal(err)
+	} else if _, err := buildDevContainerImage(context.Background(), "false", dc); err == nil || !strings.Contains(err.Error(), "outside the checkout") {
+		t.Errorf("build with a context outside the checkout: %v", err)
+	}
+}
+
+func TestFeatureInstallStep(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "0")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "install.sh"), []byte("#!/bin/sh\n"), 0755)
+	os.WriteFile(filepath.Join(dir, "devcontainer-feature.json"), []byte(`{
+		"id": "node",
+		"options": {"version": {"default": "lts"}, "install-yarn": {"default": true}},
+		"containerEnv": {"NVM_DIR": "/usr/local/share/nvm"}
+	}`), 0644)
+
+	step, err := featureInstallStep("./node", map[string]any{"version": "20"}, dir)
+	if err != nil {
+		t.Fatalf("featureInstallStep: %v", err)
+	}
+	for _, want := range []string{
+		`ENV NVM_DIR="/usr/local/share/nvm"`,
+		"RUN cd /tmp/cook-features/0 && chmod +x install.sh",
+		"'INSTALL_YARN=true' 'VERSION=20' ./install.sh",
+	} {
+		if !strings.Contains(step, want) {
+			t.Errorf("install step missing %q:\n%s", want, step)
+		}
+	}
+
+	if got := featureEnvName("1st-option.name"); got != "ST_OPTION_NAME" {
+		t.Errorf("featureEnvName = %q, want ST_OPTION_NAME", got)
+	}
+}
+
+func TestParseFeatureRef(t *testing.T) {
+	tests := []struct {
+		ref, registry, repo, tag string
+	}{
+		{"ghcr.io/devcontainers/features/node:1", "ghcr.io", "devcontainers/features/node", "1"},
+		{"ghcr.io/devcontainers/features/go", "ghcr.io", "devcontainers/features/go", "latest"},
+		{"localhost:5000/features/x@sha256:abc", "localhost:5000", "features/x", "sha256:abc"},
+	}
+	for _, tt := range tests {
+		registry, repo, tag, err := parseFeatureRef(tt.ref)
+		if err != nil || registry != tt.registry || repo != tt.repo || tag != tt.tag {
+			t.Errorf("parseFeatureRef(%q) = %q, %q, %q, %v", tt.ref, registry, repo, tag, err)
+		}
+	}
+	if _, _, _, err := parseFeatureRef("node"); err == nil {
+		t.Error("expected error for a legacy short feature name")
+	}
+}
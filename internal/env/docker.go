@@ -4,15 +4,18 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/justinmoon/cook/internal/envagent"
 )
 
 const (
@@ -31,6 +34,8 @@ type DockerBackend struct {
 	hostWorkDir string // path on host (for bind mount)
 	agentPort   int    // port cook-agent listens on inside container
 	imageName   string // docker image to use
+
+	devContainer *DevContainer // the checkout's devcontainer.json, if any
 }
 
 // NewDockerBackend creates a new Docker backend with the given config.
@@ -67,13 +72,10 @@ func NewDockerBackendFromExisting(containerID, hostWorkDir string) (*DockerBacke
 	}, nil
 }
 
-// Setup provisions the Docker container with the repo cloned.
+// Setup provisions the Docker container with the repo cloned. If the repo
+// has a devcontainer.json, the container is built from it instead of the
+// nix sandbox image.
 func (b *DockerBackend) Setup(ctx context.Context) error {
-	// Build or pull the Docker image
-	if err := b.prepareImage(ctx); err != nil {
-		return fmt.Errorf("failed to prepare image: %w", err)
-	}
-
 	// Create host work directory if it doesn't exist
 	if err := os.MkdirAll(b.hostWorkDir, 0755); err != nil {
 		return fmt.Errorf("failed to create host work dir: %w", err)
@@ -86,6 +88,17 @@ func (b *DockerBackend) Setup(ctx context.Context) error {
 		}
 	}
 
+	dc, err := LoadDevContainer(b.hostWorkDir)
+	if err != nil {
+		return err
+	}
+	b.devContainer = dc
+
+	// Build or pull the Docker image
+	if err := b.prepareImage(ctx); err != nil {
+		return fmt.Errorf("failed to prepare image: %w", err)
+	}
+
 	// Create and start container
 	if err := b.createContainer(ctx); err != nil {
 		return fmt.Errorf("failed to create container: %w", err)
@@ -108,11 +121,26 @@ func (b *DockerBackend) Setup(ctx context.Context) error {
 		}
 	}
 
+	if b.devContainer != nil {
+		if err := runPostCreate(ctx, b.devContainer, b.execChecked); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // prepareImage ensures the Docker image exists (builds from nix if needed)
 func (b *DockerBackend) prepareImage(ctx context.Context) error {
+	if b.devContainer != nil {
+		image, err := prepareDevContainerImage(ctx, "docker", b.devContainer)
+		if err != nil {
+			return err
+		}
+		b.imageName = image
+		return nil
+	}
+
 	// Check if image already exists
 	_, _, err := b.client.ImageInspectWithRaw(ctx, b.imageName)
 	if err == nil {
@@ -126,8 +154,14 @@ func (b *DockerBackend) prepareImage(ctx context.Context) error {
 }
 
 func (b *DockerBackend) buildDefaultImage(ctx context.Context) error {
+	return loadNixImage(ctx, "docker", b.imageName)
+}
+
+// loadNixImage builds the nix sandbox image and loads it with the given
+// container CLI ("docker" or "podman"), tagging it as imageName.
+func loadNixImage(ctx context.Context, cli, imageName string) error {
 	if _, err := exec.LookPath("nix"); err != nil {
-		return fmt.Errorf("nix not found; install nix or pre-load the image %s", b.imageName)
+		return fmt.Errorf("nix not found; install nix or pre-load the image %s", imageName)
 	}
 
 	var out bytes.Buffer
@@ -149,19 +183,19 @@ func (b *DockerBackend) buildDefaultImage(ctx context.Context) error {
 		return fmt.Errorf("nix build returned empty output")
 	}
 
-	loadCmd := exec.CommandContext(ctx, "docker", "load", "--input", imagePath)
+	loadCmd := exec.CommandContext(ctx, cli, "load", "--input", imagePath)
 	loadCmd.Stdout = os.Stdout
 	loadCmd.Stderr = os.Stderr
 	if err := loadCmd.Run(); err != nil {
-		return fmt.Errorf("docker load failed: %w", err)
+		return fmt.Errorf("%s load failed: %w", cli, err)
 	}
 
-	if b.imageName != nixSandboxImage {
-		tagCmd := exec.CommandContext(ctx, "docker", "tag", nixSandboxImage, b.imageName)
+	if imageName != nixSandboxImage {
+		tagCmd := exec.CommandContext(ctx, cli, "tag", nixSandboxImage, imageName)
 		tagCmd.Stdout = os.Stdout
 		tagCmd.Stderr = os.Stderr
 		if err := tagCmd.Run(); err != nil {
-			return fmt.Errorf("docker tag failed: %w", err)
+			return fmt.Errorf("%s tag failed: %w", cli, err)
 		}
 	}
 
@@ -190,15 +224,20 @@ func findFlakeDir() (string, error) {
 }
 
 func (b *DockerBackend) cloneRepo(ctx context.Context) error {
-	// Clone to host directory (will be bind-mounted into container)
-	cmd := exec.CommandContext(ctx, "git", "clone", b.config.RepoURL, b.hostWorkDir)
+	return cloneToHost(ctx, b.config, b.hostWorkDir)
+}
+
+// cloneToHost clones cfg.RepoURL into hostWorkDir (later bind-mounted into
+// a container) and creates cfg.BranchName.
+func cloneToHost(ctx context.Context, cfg Config, hostWorkDir string) error {
+	cmd := exec.CommandContext(ctx, "git", "clone", cfg.RepoURL, hostWorkDir)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("git clone failed: %s: %w", string(output), err)
 	}
 
 	// Create and checkout branch if specified
-	if b.config.BranchName != "" {
-		cmd = exec.CommandContext(ctx, "git", "-C", b.hostWorkDir, "checkout", "-b", b.config.BranchName)
+	if cfg.BranchName != "" {
+		cmd = exec.CommandContext(ctx, "git", "-C", hostWorkDir, "checkout", "-b", cfg.BranchName)
 		if output, err := cmd.CombinedOutput(); err != nil {
 			return fmt.Errorf("git checkout -b failed: %s: %w", string(output), err)
 		}
@@ -239,6 +278,11 @@ func (b *DockerBackend) createContainer(ctx context.Context) error {
 		},
 	}
 
+	var env []string
+	if b.devContainer != nil {
+		env = b.devContainer.Env()
+	}
+
 	// Create new container with host network for easy port access
 	resp, err := b.client.ContainerCreate(ctx, &container.Config{
 		Image:        b.imageName,
@@ -249,9 +293,12 @@ func (b *DockerBackend) createContainer(ctx context.Context) error {
 		AttachStdout: true,
 		AttachStderr: true,
 		OpenStdin:    true,
+		Env:          env,
+		Labels:       map[string]string{managedLabel: "true"},
 	}, &container.HostConfig{
 		NetworkMode: "host",
 		Mounts:      mounts,
+		Resources:   dockerResources(b.config.Resources),
 	}, nil, nil, containerName)
 	if err != nil {
 		return fmt.Errorf("failed to create container: %w", err)
@@ -268,6 +315,41 @@ func (b *DockerBackend) createContainer(ctx context.Context) error {
 }
 
 func (b *DockerBackend) setupAgent(ctx context.Context) error {
+	return startContainerAgent(ctx, "docker", b.containerID, b.agentPort, b.Exec)
+}
+
+// agentStatePath is where cook-agent records its sessions, so after a
+// restart it can report which ones died with it.
+const agentStatePath = "/tmp/cook-agent-sessions.json"
+
+// agentSecret returns the secret cook-agent in the environment envID
+// requires, or "" if the agent key isn't loaded.
+func agentSecret(envID string) string {
+	secret, _ := envagent.Secret(envID)
+	return secret
+}
+
+// agentSecretEnv returns the assignment that passes cook-agent its secret,
+// to prefix the command that starts it.
+func agentSecretEnv(envID string) (string, error) {
+	secret, err := envagent.Secret(envID)
+	if err != nil {
+		return "", fmt.Errorf("cannot start cook-agent: %w", err)
+	}
+	return envagent.SecretEnv + "=" + secret + " ", nil
+}
+
+// containerExec runs a shell command in a container and returns its output.
+type containerExec func(ctx context.Context, cmd string) ([]byte, error)
+
+// startContainerAgent copies cook-agent into a container with the given
+// CLI and starts it listening on port.
+func startContainerAgent(ctx context.Context, cli, containerID string, port int, run containerExec) error {
+	secretEnv, err := agentSecretEnv(cli + ":" + containerID)
+	if err != nil {
+		return err
+	}
+
 	// Find cook-agent binary - look in same directory as cook binary first
 	agentBinary, err := findAgentBinary()
 	if err != nil {
@@ -275,22 +357,22 @@ func (b *DockerBackend) setupAgent(ctx context.Context) error {
 	}
 
 	// Copy cook-agent into the container (use /tmp which always exists)
-	copyCmd := exec.CommandContext(ctx, "docker", "cp", agentBinary, b.containerID+":/tmp/cook-agent")
+	copyCmd := exec.CommandContext(ctx, cli, "cp", agentBinary, containerID+":/tmp/cook-agent")
 	if output, err := copyCmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to copy cook-agent: %s: %w", string(output), err)
 	}
 
 	// Make it executable
-	_, err = b.Exec(ctx, "chmod +x /tmp/cook-agent")
+	_, err = run(ctx, "chmod +x /tmp/cook-agent")
 	if err != nil {
 		return fmt.Errorf("failed to chmod cook-agent: %w", err)
 	}
 
 	// Start cook-agent in the background
 	// Using nohup and redirecting to a log file so it persists
-	_, err = b.Exec(ctx, fmt.Sprintf(
-		"nohup /tmp/cook-agent -listen :%d > /tmp/cook-agent.log 2>&1 &",
-		b.agentPort,
+	_, err = run(ctx, fmt.Sprintf(
+		"%snohup /tmp/cook-agent -listen :%d -state %s > /tmp/cook-agent.log 2>&1 &",
+		secretEnv, port, agentStatePath,
 	))
 	if err != nil {
 		return fmt.Errorf("failed to start cook-agent: %w", err)
@@ -338,14 +420,25 @@ func (b *DockerBackend) AgentAddr() string {
 	return fmt.Sprintf("localhost:%d", b.agentPort)
 }
 
+// AgentSecret returns the secret the container's cook-agent requires.
+func (b *DockerBackend) AgentSecret() string {
+	return agentSecret("docker:" + b.containerID)
+}
+
 func (b *DockerBackend) copyClaudeAuth(ctx context.Context) error {
+	return copyClaudeAuthTo(ctx, "docker", b.containerID, "/root", b.Exec, b.ExecAsRoot)
+}
+
+// copyClaudeAuthTo copies the host's Claude credentials into home inside a
+// container, using cli for file copies.
+func copyClaudeAuthTo(ctx context.Context, cli, containerID, home string, run, runAsRoot containerExec) error {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return err
 	}
 
 	// Create .claude directory in container
-	if _, err := b.Exec(ctx, "mkdir -p /root/.claude"); err != nil {
+	if _, err := run(ctx, "mkdir -p "+home+"/.claude"); err != nil {
 		return fmt.Errorf("failed to create .claude dir: %w", err)
 	}
 
@@ -378,19 +471,19 @@ func (b *DockerBackend) copyClaudeAuth(ctx context.Context) error {
 
 		// Copy to /tmp in container first (avoids permission issues)
 		tmpDst := "/tmp/" + filepath.Base(dst)
-		cmd := exec.CommandContext(ctx, "docker", "cp", tmpFile.Name(), b.containerID+":"+tmpDst)
+		cmd := exec.CommandContext(ctx, cli, "cp", tmpFile.Name(), containerID+":"+tmpDst)
 		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("docker cp: %s: %w", string(output), err)
+			return fmt.Errorf("%s cp: %s: %w", cli, string(output), err)
 		}
 
 		// Move to final location as root and fix permissions
 		mvCmd := fmt.Sprintf("cp %s %s && chmod 600 %s && rm %s", tmpDst, dst, dst, tmpDst)
-		if _, err := b.Exec(ctx, mvCmd); err != nil {
+		if _, err := run(ctx, mvCmd); err != nil {
 			return fmt.Errorf("move file: %w", err)
 		}
 
 		// Verify copy succeeded by comparing file sizes
-		output, err := b.ExecAsRoot(ctx, fmt.Sprintf("wc -c < %s", dst))
+		output, err := runAsRoot(ctx, fmt.Sprintf("wc -c < %s", dst))
 		if err != nil {
 			return fmt.Errorf("verify file size: %w", err)
 		}
@@ -405,7 +498,7 @@ func (b *DockerBackend) copyClaudeAuth(ctx context.Context) error {
 
 	// Copy ~/.claude.json (contains oauthAccount)
 	claudeJsonPath := filepath.Join(homeDir, ".claude.json")
-	if err := copyFile(claudeJsonPath, "/root/.claude.json"); err != nil {
+	if err := copyFile(claudeJsonPath, home+"/.claude.json"); err != nil {
 		fmt.Printf("Note: ~/.claude.json not copied: %v\n", err)
 	}
 
@@ -414,9 +507,9 @@ func (b *DockerBackend) copyClaudeAuth(ctx context.Context) error {
 	cmd := exec.CommandContext(ctx, "security", "find-generic-password", "-s", "Claude Code-credentials", "-w")
 	if keychainData, err := cmd.Output(); err == nil && len(keychainData) > 0 {
 		// Write credentials to container
-		credPath := "/root/.claude/.credentials.json"
+		credPath := home + "/.claude/.credentials.json"
 		escaped := strings.ReplaceAll(string(keychainData), "'", "'\"'\"'")
-		if _, err := b.Exec(ctx, fmt.Sprintf("echo '%s' > %s && chmod 600 %s",
+		if _, err := run(ctx, fmt.Sprintf("echo '%s' > %s && chmod 600 %s",
 			strings.TrimSpace(escaped), credPath, credPath)); err != nil {
 			fmt.Printf("Warning: failed to copy keychain credentials: %v\n", err)
 		}
@@ -427,7 +520,7 @@ func (b *DockerBackend) copyClaudeAuth(ctx context.Context) error {
 	authFiles := []string{".credentials.json", "settings.json", "settings.local.json"}
 	for _, filename := range authFiles {
 		srcPath := filepath.Join(claudeDir, filename)
-		dstPath := "/root/.claude/" + filename
+		dstPath := home + "/.claude/" + filename
 		if err := copyFile(srcPath, dstPath); err != nil {
 			// Not an error - file might not exist
 			continue
@@ -438,16 +531,22 @@ func (b *DockerBackend) copyClaudeAuth(ctx context.Context) error {
 }
 
 func (b *DockerBackend) setupDotfiles(ctx context.Context) error {
-	dotfilesDir := "/root/.dotfiles"
+	return setupContainerDotfiles(ctx, b.config.Dotfiles, "/root", b.Exec)
+}
+
+// setupContainerDotfiles clones a dotfiles repo into home inside a container
+// and symlinks its files into place.
+func setupContainerDotfiles(ctx context.Context, dotfiles, home string, run containerExec) error {
+	dotfilesDir := home + "/.dotfiles"
 
 	// Clone dotfiles repo inside container
-	_, err := b.Exec(ctx, fmt.Sprintf("git clone %s %s", b.config.Dotfiles, dotfilesDir))
+	_, err := run(ctx, fmt.Sprintf("git clone %s %s", dotfiles, dotfilesDir))
 	if err != nil {
 		return fmt.Errorf("failed to clone dotfiles: %w", err)
 	}
 
 	// Symlink dotfiles to home (excluding git and readme files)
-	_, err = b.Exec(ctx, fmt.Sprintf(`
+	_, err = run(ctx, fmt.Sprintf(`
 		cd %s
 		for f in $(ls -A | grep -v -E '^(\.git|README\.md|LICENSE)$'); do
 			rm -rf ~/"$f" 2>/dev/null || true
@@ -472,6 +571,17 @@ func (b *DockerBackend) ExecAsRoot(ctx context.Context, cmdStr string) ([]byte,
 }
 
 func (b *DockerBackend) execWithUser(ctx context.Context, cmdStr string, user string) ([]byte, error) {
+	return b.runExec(ctx, cmdStr, user, false)
+}
+
+// execChecked is Exec, but fails if the command exits non-zero.
+func (b *DockerBackend) execChecked(ctx context.Context, cmdStr string) ([]byte, error) {
+	return b.runExec(ctx, cmdStr, "", true)
+}
+
+// runExec runs cmdStr in the container and returns its combined output. With
+// checkExit, a non-zero exit status is an error.
+func (b *DockerBackend) runExec(ctx context.Context, cmdStr string, user string, checkExit bool) ([]byte, error) {
 	if b.containerID == "" {
 		return nil, fmt.Errorf("container not initialized")
 	}
@@ -504,6 +614,16 @@ func (b *DockerBackend) execWithUser(ctx context.Context, cmdStr string, user st
 
 	// Combine stdout and stderr
 	combined := append(stdout.Bytes(), stderr.Bytes()...)
+
+	if checkExit {
+		inspect, err := b.client.ContainerExecInspect(ctx, execID.ID)
+		if err != nil {
+			return combined, fmt.Errorf("failed to inspect exec: %w", err)
+		}
+		if inspect.ExitCode != 0 {
+			return combined, fmt.Errorf("exit status %d", inspect.ExitCode)
+		}
+	}
 	return combined, nil
 }
 
@@ -524,16 +644,31 @@ func (b *DockerBackend) Command(ctx context.Context, name string, args ...string
 
 // ReadFile reads a file from the container (via bind mount on host).
 func (b *DockerBackend) ReadFile(ctx context.Context, path string) ([]byte, error) {
-	absPath, err := b.resolvePath(path)
+	return readHostFile(b.hostWorkDir, path)
+}
+
+// WriteFile writes a file to the container (via bind mount on host).
+func (b *DockerBackend) WriteFile(ctx context.Context, path string, content []byte) error {
+	return writeHostFile(b.hostWorkDir, path, content)
+}
+
+// ListFiles lists files in a directory.
+func (b *DockerBackend) ListFiles(ctx context.Context, dir string) ([]FileInfo, error) {
+	return listHostFiles(b.hostWorkDir, dir)
+}
+
+// readHostFile reads a file from a bind-mounted host checkout.
+func readHostFile(root, path string) ([]byte, error) {
+	absPath, err := resolveHostPath(root, path)
 	if err != nil {
 		return nil, err
 	}
 	return os.ReadFile(absPath)
 }
 
-// WriteFile writes a file to the container (via bind mount on host).
-func (b *DockerBackend) WriteFile(ctx context.Context, path string, content []byte) error {
-	absPath, err := b.resolvePath(path)
+// writeHostFile writes a file to a bind-mounted host checkout.
+func writeHostFile(root, path string, content []byte) error {
+	absPath, err := resolveHostPath(root, path)
 	if err != nil {
 		return err
 	}
@@ -546,12 +681,12 @@ func (b *DockerBackend) WriteFile(ctx context.Context, path string, content []by
 	return os.WriteFile(absPath, content, 0644)
 }
 
-// ListFiles lists files in a directory.
-func (b *DockerBackend) ListFiles(ctx context.Context, dir string) ([]FileInfo, error) {
-	absDir := b.hostWorkDir
+// listHostFiles lists a directory of a bind-mounted host checkout.
+func listHostFiles(root, dir string) ([]FileInfo, error) {
+	absDir := root
 	if dir != "" && dir != "." {
 		var err error
-		absDir, err = b.resolvePath(dir)
+		absDir, err = resolveHostPath(root, dir)
 		if err != nil {
 			return nil, err
 		}
@@ -590,6 +725,12 @@ func (b *DockerBackend) WorkDir() string {
 	return b.hostWorkDir
 }
 
+// DevContainer returns the devcontainer.json the container was built
+// from, or nil. It is only known to the backend that ran Setup.
+func (b *DockerBackend) DevContainer() *DevContainer {
+	return b.devContainer
+}
+
 // ContainerID returns the Docker container ID.
 func (b *DockerBackend) ContainerID() string {
 	return b.containerID
@@ -623,6 +764,38 @@ func (b *DockerBackend) Status(ctx context.Context) (Status, error) {
 	}, nil
 }
 
+// Stop stops the container but keeps it (and the host checkout) around.
+func (b *DockerBackend) Stop(ctx context.Context) error {
+	if b.containerID == "" {
+		return nil
+	}
+	if err := b.client.ContainerStop(ctx, b.containerID, container.StopOptions{}); err != nil {
+		return fmt.Errorf("failed to stop container: %w", err)
+	}
+	return nil
+}
+
+// Start starts a stopped container and restarts cook-agent in it.
+func (b *DockerBackend) Start(ctx context.Context) error {
+	return b.Resume(ctx)
+}
+
+// Suspend stops the idle container; Resume starts it again.
+func (b *DockerBackend) Suspend(ctx context.Context) error {
+	return b.Stop(ctx)
+}
+
+// Resume starts a suspended container and restarts cook-agent in it.
+func (b *DockerBackend) Resume(ctx context.Context) error {
+	if b.containerID == "" {
+		return fmt.Errorf("backend not initialized: call Setup() first")
+	}
+	if err := b.client.ContainerStart(ctx, b.containerID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+	return b.setupAgent(ctx)
+}
+
 // Teardown stops and removes the container.
 func (b *DockerBackend) Teardown(ctx context.Context) error {
 	if b.containerID == "" {
@@ -646,9 +819,100 @@ func (b *DockerBackend) Teardown(ctx context.Context) error {
 	return nil
 }
 
-// resolvePath resolves a relative path within the host work directory.
-func (b *DockerBackend) resolvePath(path string) (string, error) {
-	root, err := filepath.EvalSymlinks(b.hostWorkDir)
+// snapshotImage is the image a snapshot of this branch's container is
+// committed to. It is keyed by the checkout path, which (unlike the
+// container ID) survives restores.
+func (b *DockerBackend) snapshotImage(id string) string {
+	h := fnv.New32a()
+	h.Write([]byte(b.hostWorkDir))
+	return fmt.Sprintf("cook-snapshot:%08x-%s", h.Sum32(), id)
+}
+
+// Snapshot commits the container to an image and snapshots the
+// bind-mounted checkout, which docker commit doesn't include.
+func (b *DockerBackend) Snapshot(ctx context.Context) (string, error) {
+	if b.containerID == "" {
+		return "", fmt.Errorf("container not initialized")
+	}
+	id := newSnapshotID()
+	if _, err := b.client.ContainerCommit(ctx, b.containerID, container.CommitOptions{
+		Reference: b.snapshotImage(id),
+		Comment:   "cook snapshot",
+		Pause:     true,
+	}); err != nil {
+		return "", fmt.Errorf("docker commit failed: %w", err)
+	}
+	if err := snapshotWorkDir(ctx, b.hostWorkDir, id); err != nil {
+		b.client.ImageRemove(ctx, b.snapshotImage(id), image.RemoveOptions{})
+		return "", err
+	}
+	return id, nil
+}
+
+// RestoreSnapshot rolls the checkout back and replaces the container with
+// one created from the snapshot image. The container ID changes. The old
+// container is set aside and removed only once the new one is running and
+// the checkout is restored, so a failed restore leaves the branch as it
+// was.
+func (b *DockerBackend) RestoreSnapshot(ctx context.Context, id string) error {
+	if b.containerID == "" {
+		return fmt.Errorf("container not initialized")
+	}
+	snapshotImage := b.snapshotImage(id)
+	if _, _, err := b.client.ImageInspectWithRaw(ctx, snapshotImage); err != nil {
+		return fmt.Errorf("snapshot image %s not found: %w", snapshotImage, err)
+	}
+	info, err := b.client.ContainerInspect(ctx, b.containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	name := strings.TrimPrefix(info.Name, "/")
+
+	// Free the name for the replacement
+	oldID, oldName, oldImage := b.containerID, b.config.Name, b.imageName
+	if err := b.client.ContainerRename(ctx, oldID, name+"-replaced"); err != nil {
+		return fmt.Errorf("failed to rename container: %w", err)
+	}
+	rollBack := func() {
+		if b.containerID != oldID {
+			b.client.ContainerRemove(ctx, b.containerID, container.RemoveOptions{Force: true})
+		}
+		b.containerID, b.config.Name, b.imageName = oldID, oldName, oldImage
+		if err := b.client.ContainerRename(ctx, oldID, name); err != nil {
+			fmt.Printf("Warning: failed to rename container %s back to %s: %v\n", oldID, name, err)
+		}
+	}
+
+	// Create the replacement under the same name from the snapshot image
+	b.config.Name = strings.TrimPrefix(name, containerPrefix)
+	b.imageName = snapshotImage
+	if err := b.createContainer(ctx); err != nil {
+		rollBack()
+		return fmt.Errorf("failed to recreate container: %w", err)
+	}
+	if err := restoreWorkDir(ctx, b.hostWorkDir, id); err != nil {
+		rollBack()
+		return err
+	}
+
+	// The old container's agent holds the agent port on the host network
+	if err := b.client.ContainerRemove(ctx, oldID, container.RemoveOptions{Force: true}); err != nil {
+		return fmt.Errorf("failed to remove replaced container: %w", err)
+	}
+	return b.setupAgent(ctx)
+}
+
+// DeleteSnapshot removes a snapshot's image and checkout copy.
+func (b *DockerBackend) DeleteSnapshot(ctx context.Context, id string) error {
+	if _, err := b.client.ImageRemove(ctx, b.snapshotImage(id), image.RemoveOptions{}); err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to remove snapshot image: %w", err)
+	}
+	return deleteWorkDirSnapshot(ctx, b.hostWorkDir, id)
+}
+
+// resolveHostPath resolves a relative path within a host work directory.
+func resolveHostPath(hostWorkDir, path string) (string, error) {
+	root, err := filepath.EvalSymlinks(hostWorkDir)
 	if err != nil {
 		return "", fmt.Errorf("invalid working directory: %w", err)
 	}
@@ -671,5 +935,27 @@ func (b *DockerBackend) Close() error {
 	return nil
 }
 
-// Ensure DockerBackend implements Backend
-var _ Backend = (*DockerBackend)(nil)
+// dockerResources maps r onto container limits. Docker can't cap disk
+// on most storage drivers or bound a container's lifetime, so DiskGB and
+// Timeout are ignored.
+func dockerResources(r Resources) container.Resources {
+	res := container.Resources{
+		NanoCPUs: int64(r.CPUs) * 1e9,
+		Memory:   int64(r.MemoryMB) << 20,
+	}
+	if r.HasGPU() {
+		res.DeviceRequests = []container.DeviceRequest{{
+			Count:        -1,
+			Capabilities: [][]string{{"gpu"}},
+		}}
+	}
+	return res
+}
+
+// Ensure DockerBackend implements Backend, Stopper, Suspender and Snapshotter
+var (
+	_ Backend     = (*DockerBackend)(nil)
+	_ Stopper     = (*DockerBackend)(nil)
+	_ Suspender   = (*DockerBackend)(nil)
+	_ Snapshotter = (*DockerBackend)(nil)
+)
@@ -7,15 +7,24 @@ func NewBackend(backendType Type, cfg Config) (Backend, error) {
 	switch backendType {
 	case TypeLocal, "":
 		return NewLocalBackend(cfg), nil
+	case TypeSandbox:
+		return NewSandboxBackend(cfg)
 	case TypeDocker:
 		return NewDockerBackend(cfg)
+	case TypePodman:
+		return NewPodmanBackend(cfg)
 	case TypeModal:
 		return NewModalBackend(cfg)
 	case TypeSprites:
 		return NewSpritesBackend(cfg)
 	case TypeFlyMachines:
 		return NewFlyMachinesBackend(cfg)
+	case TypeSSH:
+		return NewSSHBackend(cfg)
 	default:
+		if IsPlugin(string(backendType)) {
+			return NewPluginBackend(string(backendType), cfg)
+		}
 		return nil, fmt.Errorf("unknown backend type: %s", backendType)
 	}
 }
@@ -26,9 +35,14 @@ func NewBackendFromPath(backendType Type, workDir string) (Backend, error) {
 	switch backendType {
 	case TypeLocal, "":
 		return NewLocalBackendFromPath(workDir), nil
+	case TypeSandbox:
+		return NewSandboxBackend(Config{WorkDir: workDir})
 	case TypeDocker:
 		// For Docker, we need the container ID to reconnect
 		return nil, fmt.Errorf("docker backend requires container ID for existing environments")
+	case TypePodman:
+		// For Podman, we need the container ID to reconnect
+		return nil, fmt.Errorf("podman backend requires container ID for existing environments")
 	case TypeModal:
 		// For Modal, we need the sandbox ID to reconnect
 		return nil, fmt.Errorf("modal backend requires sandbox ID for existing environments")
@@ -38,7 +52,13 @@ func NewBackendFromPath(backendType Type, workDir string) (Backend, error) {
 	case TypeFlyMachines:
 		// For Fly Machines, we need the machine ID to reconnect
 		return nil, fmt.Errorf("fly machines backend requires machine ID for existing environments")
+	case TypeSSH:
+		// For SSH, we need the host to reconnect
+		return nil, fmt.Errorf("ssh backend requires a host for existing environments")
 	default:
+		if IsPlugin(string(backendType)) {
+			return nil, fmt.Errorf("%s backend requires plugin state for existing environments", backendType)
+		}
 		return nil, fmt.Errorf("unknown backend type: %s", backendType)
 	}
 }
@@ -3,6 +3,7 @@ package env
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -95,6 +96,14 @@ func NewFlyMachinesBackendFromMachineID(machineID, hostWorkDir string) (*FlyMach
 
 // Setup provisions the machine with the repo cloned and tools installed.
 func (b *FlyMachinesBackend) Setup(ctx context.Context) error {
+	if err := b.Warm(ctx); err != nil {
+		return err
+	}
+	return b.Claim(ctx, b.config)
+}
+
+// Warm launches the machine and starts cook-agent without cloning a repo.
+func (b *FlyMachinesBackend) Warm(ctx context.Context) error {
 	if b.flapsClient == nil {
 		return fmt.Errorf("fly machines client not initialized")
 	}
@@ -131,12 +140,6 @@ func (b *FlyMachinesBackend) Setup(ctx context.Context) error {
 		return fmt.Errorf("failed to setup tailnet: %w", err)
 	}
 
-	if b.config.RepoURL != "" {
-		if err := b.cloneRepo(ctx); err != nil {
-			return fmt.Errorf("failed to clone repo: %w", err)
-		}
-	}
-
 	if err := b.setupAgent(ctx); err != nil {
 		return fmt.Errorf("failed to setup agent: %w", err)
 	}
@@ -145,24 +148,33 @@ func (b *FlyMachinesBackend) Setup(ctx context.Context) error {
 		fmt.Printf("Warning: failed to copy Claude auth: %v\n", err)
 	}
 
+	b.agentAddr = flyMachinesAgentAddr(b.appName)
+	return nil
+}
+
+// Claim clones cfg's repo into a warm machine and applies its dotfiles.
+func (b *FlyMachinesBackend) Claim(ctx context.Context, cfg Config) error {
+	b.config = cfg
+
+	if b.config.RepoURL != "" {
+		if err := b.cloneRepo(ctx); err != nil {
+			return fmt.Errorf("failed to clone repo: %w", err)
+		}
+	}
+
 	if b.config.Dotfiles != "" {
 		if err := b.setupDotfiles(ctx); err != nil {
 			return fmt.Errorf("failed to setup dotfiles: %w", err)
 		}
 	}
 
-	b.agentAddr = flyMachinesAgentAddr(b.appName)
 	return nil
 }
 
 func (b *FlyMachinesBackend) buildMachineConfig() *fly.MachineConfig {
 	config := &fly.MachineConfig{
 		Image: flyMachinesImage(b.appName),
-		Guest: &fly.MachineGuest{
-			CPUKind:  flyMachinesCPUKind(),
-			CPUs:     flyMachinesCPUs(),
-			MemoryMB: flyMachinesMemoryMB(),
-		},
+		Guest: flyMachinesGuest(b.config.Resources),
 		Env: map[string]string{
 			"HOME":    "/root",
 			"TERM":    "xterm-256color",
@@ -343,6 +355,11 @@ func (b *FlyMachinesBackend) cloneRepo(ctx context.Context) error {
 }
 
 func (b *FlyMachinesBackend) setupAgent(ctx context.Context) error {
+	secretEnv, err := agentSecretEnv("fly-machines:" + b.machineID)
+	if err != nil {
+		return err
+	}
+
 	agentCmd := "cook-agent"
 	if _, err := b.execWithDir(ctx, "/", "command -v cook-agent"); err != nil {
 		agentPath, err := findAgentBinary()
@@ -381,7 +398,7 @@ func (b *FlyMachinesBackend) setupAgent(ctx context.Context) error {
 		agentCmd = "/tmp/cook-agent"
 	}
 
-	startCmd := fmt.Sprintf("nohup %s -listen :%d > /tmp/cook-agent.log 2>&1 &", agentCmd, flyAgentPort)
+	startCmd := fmt.Sprintf("%snohup %s -listen :%d -state %s > /tmp/cook-agent.log 2>&1 &", secretEnv, agentCmd, flyAgentPort, agentStatePath)
 	if _, err := b.execWithDir(ctx, "/", startCmd); err != nil {
 		return fmt.Errorf("failed to start agent: %w", err)
 	}
@@ -395,8 +412,10 @@ func (b *FlyMachinesBackend) setupAgent(ctx context.Context) error {
 }
 
 func (b *FlyMachinesBackend) waitForAgent(ctx context.Context) error {
+	// Prefer the agent's health check; a bare port probe is the fallback
 	probeCmd := fmt.Sprintf(
-		`if command -v nc >/dev/null 2>&1; then nc -z localhost %d; else bash -lc "echo > /dev/tcp/localhost/%d"; fi`,
+		`if command -v curl >/dev/null 2>&1; then curl -fsS http://localhost:%d/healthz; elif command -v nc >/dev/null 2>&1; then nc -z localhost %d; else bash -lc "echo > /dev/tcp/localhost/%d"; fi`,
+		flyAgentPort,
 		flyAgentPort,
 		flyAgentPort,
 	)
@@ -471,6 +490,10 @@ func (b *FlyMachinesBackend) setupDotfiles(ctx context.Context) error {
 
 // Exec runs a command in the machine and returns combined output.
 func (b *FlyMachinesBackend) Exec(ctx context.Context, cmdStr string) ([]byte, error) {
+	output, err := agentExec(ctx, b.agentAddr, b.AgentSecret(), b.workDir, "sh -lc "+shellEscape(cmdStr), nil)
+	if !errors.Is(err, errAgentUnavailable) {
+		return output, err
+	}
 	return b.execWithDir(ctx, b.workDir, cmdStr)
 }
 
@@ -482,6 +505,9 @@ func (b *FlyMachinesBackend) Command(ctx context.Context, name string, args ...s
 // ReadFile reads a file from the machine.
 func (b *FlyMachinesBackend) ReadFile(ctx context.Context, filePath string) ([]byte, error) {
 	resolved := b.resolvePath(filePath)
+	if data, err := agentReadFile(b.agentAddr, b.AgentSecret(), resolved); !errors.Is(err, errAgentUnavailable) {
+		return data, err
+	}
 	output, err := b.execWithDir(ctx, "/", fmt.Sprintf("cat %s", shellEscape(resolved)))
 	if err != nil {
 		return nil, err
@@ -492,6 +518,9 @@ func (b *FlyMachinesBackend) ReadFile(ctx context.Context, filePath string) ([]b
 // WriteFile writes a file to the machine.
 func (b *FlyMachinesBackend) WriteFile(ctx context.Context, filePath string, content []byte) error {
 	resolved := b.resolvePath(filePath)
+	if err := agentWriteFile(b.agentAddr, b.AgentSecret(), resolved, content); !errors.Is(err, errAgentUnavailable) {
+		return err
+	}
 	dir := path.Dir(resolved)
 	if _, err := b.execWithDir(ctx, "/", fmt.Sprintf("mkdir -p %s", shellEscape(dir))); err != nil {
 		return err
@@ -529,6 +558,9 @@ func (b *FlyMachinesBackend) WriteFile(ctx context.Context, filePath string, con
 // ListFiles lists files in a directory.
 func (b *FlyMachinesBackend) ListFiles(ctx context.Context, dir string) ([]FileInfo, error) {
 	resolved := b.resolvePath(dir)
+	if files, err := agentListFiles(b.agentAddr, b.AgentSecret(), resolved); !errors.Is(err, errAgentUnavailable) {
+		return files, err
+	}
 	output, err := b.execWithDir(ctx, "/", fmt.Sprintf("ls -la %s", shellEscape(resolved)))
 	if err != nil {
 		return nil, err
@@ -537,6 +569,11 @@ func (b *FlyMachinesBackend) ListFiles(ctx context.Context, dir string) ([]FileI
 	return parseLsOutput(output, resolved), nil
 }
 
+// WatchFiles reports changes under dir through the machine's cook-agent.
+func (b *FlyMachinesBackend) WatchFiles(ctx context.Context, dir string, fn func([]FileEvent)) error {
+	return agentWatch(ctx, b.agentAddr, b.AgentSecret(), b.resolvePath(dir), fn)
+}
+
 // WorkDir returns the working directory path.
 func (b *FlyMachinesBackend) WorkDir() string {
 	return b.workDir
@@ -582,6 +619,39 @@ func (b *FlyMachinesBackend) Teardown(ctx context.Context) error {
 	}, "")
 }
 
+// Suspend suspends the machine, keeping its memory so cook-agent and
+// running processes survive. Machines that can't be suspended (e.g. too
+// much memory) are stopped instead.
+func (b *FlyMachinesBackend) Suspend(ctx context.Context) error {
+	if b.machineID == "" {
+		return nil
+	}
+	if err := b.flapsClient.Suspend(ctx, b.appName, b.machineID, ""); err == nil {
+		return nil
+	}
+	if err := b.flapsClient.Stop(ctx, b.appName, fly.StopMachineInput{ID: b.machineID}, ""); err != nil {
+		return fmt.Errorf("failed to stop machine: %w", err)
+	}
+	return nil
+}
+
+// Resume starts a suspended or stopped machine, restarting cook-agent if
+// the machine was stopped.
+func (b *FlyMachinesBackend) Resume(ctx context.Context) error {
+	if b.machineID == "" {
+		return fmt.Errorf("machine not initialized")
+	}
+	if err := b.ensureStarted(ctx); err != nil {
+		return err
+	}
+	if err := b.waitForAgent(ctx); err != nil {
+		if err := b.setupAgent(ctx); err != nil {
+			return fmt.Errorf("failed to setup agent: %w", err)
+		}
+	}
+	return nil
+}
+
 // MachineID returns the machine ID.
 func (b *FlyMachinesBackend) MachineID() string {
 	return b.machineID
@@ -592,6 +662,11 @@ func (b *FlyMachinesBackend) AgentAddr() string {
 	return b.agentAddr
 }
 
+// AgentSecret returns the secret the machine's cook-agent requires.
+func (b *FlyMachinesBackend) AgentSecret() string {
+	return agentSecret("fly-machines:" + b.machineID)
+}
+
 func (b *FlyMachinesBackend) proxyEnvPrefix() string {
 	if b.tailnetProxy == "" {
 		return ""
@@ -753,18 +828,33 @@ func flyMachinesCPUKind() string {
 	return "shared"
 }
 
-func flyMachinesCPUs() int {
+// flyMachinesGuest maps r onto the machine size. Unset fields default to
+// $FLY_MACHINES_CPUS and $FLY_MACHINES_MEMORY_MB, then 1 CPU and 1GB. Disk
+// lives on volumes and machines have no lifetime limit, so DiskGB and
+// Timeout are ignored.
+func flyMachinesGuest(r Resources) *fly.MachineGuest {
+	guest := &fly.MachineGuest{
+		CPUKind:  flyMachinesCPUKind(),
+		CPUs:     1,
+		MemoryMB: 1024,
+	}
 	if cpus := envInt("FLY_MACHINES_CPUS", "COOK_FLY_MACHINES_CPUS"); cpus > 0 {
-		return cpus
+		guest.CPUs = cpus
 	}
-	return 1
-}
-
-func flyMachinesMemoryMB() int {
 	if mem := envInt("FLY_MACHINES_MEMORY_MB", "COOK_FLY_MACHINES_MEMORY_MB"); mem > 0 {
-		return mem
+		guest.MemoryMB = mem
 	}
-	return 1024
+	if r.CPUs > 0 {
+		guest.CPUs = r.CPUs
+	}
+	if r.MemoryMB > 0 {
+		guest.MemoryMB = r.MemoryMB
+	}
+	if r.HasGPU() {
+		guest.GPUKind = r.GPU
+		guest.GPUs = 1
+	}
+	return guest
 }
 
 func flyMachinesAutoDestroy() bool {
@@ -856,5 +946,8 @@ func sanitizeFlyMachineName(name string) string {
 	return cleaned
 }
 
-// Ensure FlyMachinesBackend implements Backend
-var _ Backend = (*FlyMachinesBackend)(nil)
+// Ensure FlyMachinesBackend implements Backend and Suspender
+var (
+	_ Backend   = (*FlyMachinesBackend)(nil)
+	_ Suspender = (*FlyMachinesBackend)(nil)
+)
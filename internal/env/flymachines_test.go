@@ -29,6 +29,9 @@ func TestFlyMachinesBackend_Integration(t *testing.T) {
 	}
 	defer backend.Teardown(context.Background())
 
+	if err := envagent.LoadKey(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
 	if err := backend.Setup(ctx); err != nil {
 		t.Fatalf("Setup failed: %v", err)
 	}
@@ -54,7 +57,7 @@ func TestFlyMachinesBackend_Integration(t *testing.T) {
 		t.Fatalf("AgentAddr is empty")
 	}
 
-	client, err := envagent.Dial(agentAddr)
+	client, err := envagent.Dial(agentAddr, backend.AgentSecret())
 	if err != nil {
 		t.Fatalf("Failed to connect to cook-agent: %v", err)
 	}
@@ -0,0 +1,238 @@
+package env
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/modal-labs/libmodal/modal-go"
+	fly "github.com/superfly/fly-go"
+)
+
+// managedLabel marks docker and podman containers created by cook, so gc
+// never touches anyone else's containers.
+const managedLabel = "cook.managed"
+
+// GCBackends are the backends whose resources ListResources can find.
+var GCBackends = []Type{TypeDocker, TypePodman, TypeModal, TypeSprites, TypeFlyMachines}
+
+// Resource is a container, sandbox, sprite or machine that exists on a
+// backend, whether or not a branch still uses it.
+type Resource struct {
+	Backend   Type      `json:"backend"`
+	ID        string    `json:"id"` // matches the EnvironmentSpec ID field for the backend
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"` // zero when the backend doesn't say
+}
+
+// ListResources lists the resources cook created on backendType. Docker and
+// podman containers are found by label, Modal sandboxes and fly machines by
+// cook's dedicated app. Sprites have no labels, so every sprite on the
+// account is listed and the caller must decide which are cook's.
+func ListResources(ctx context.Context, backendType Type) ([]Resource, error) {
+	switch backendType {
+	case TypeDocker:
+		return listDockerResources(ctx)
+	case TypePodman:
+		return listPodmanResources(ctx)
+	case TypeModal:
+		return listModalResources(ctx)
+	case TypeSprites:
+		return listSpritesResources(ctx)
+	case TypeFlyMachines:
+		return listFlyMachinesResources(ctx)
+	default:
+		return nil, fmt.Errorf("%s backend does not support gc", backendType)
+	}
+}
+
+// DestroyResource removes r from its backend.
+func DestroyResource(ctx context.Context, r Resource) error {
+	switch r.Backend {
+	case TypeDocker:
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			return fmt.Errorf("failed to create Docker client: %w", err)
+		}
+		defer cli.Close()
+		return cli.ContainerRemove(ctx, r.ID, container.RemoveOptions{Force: true})
+	case TypePodman:
+		_, err := (&PodmanBackend{}).podman(ctx, "rm", "-f", r.ID)
+		return err
+	case TypeModal:
+		mc, err := modal.NewClient()
+		if err != nil {
+			return fmt.Errorf("failed to create modal client: %w", err)
+		}
+		sandbox, err := mc.Sandboxes.FromID(ctx, r.ID)
+		if err != nil {
+			return err
+		}
+		return sandbox.Terminate(ctx)
+	case TypeSprites:
+		sc, err := newSpritesClient(Config{})
+		if err != nil {
+			return err
+		}
+		return sc.DeleteSprite(ctx, r.ID)
+	case TypeFlyMachines:
+		fc, appName, err := newFlyMachinesClient(Config{})
+		if err != nil {
+			return err
+		}
+		return fc.Destroy(ctx, appName, fly.RemoveMachineInput{ID: r.ID, Kill: true}, "")
+	default:
+		return fmt.Errorf("%s backend does not support gc", r.Backend)
+	}
+}
+
+func listDockerResources(ctx context.Context) ([]Resource, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer cli.Close()
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", managedLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]Resource, 0, len(containers))
+	for _, c := range containers {
+		var name string
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		resources = append(resources, Resource{
+			Backend:   TypeDocker,
+			ID:        c.ID,
+			Name:      name,
+			CreatedAt: time.Unix(c.Created, 0),
+		})
+	}
+	return resources, nil
+}
+
+func listPodmanResources(ctx context.Context) ([]Resource, error) {
+	if _, err := exec.LookPath("podman"); err != nil {
+		return nil, fmt.Errorf("podman not found: %w", err)
+	}
+	output, err := (&PodmanBackend{}).podman(ctx, "ps", "-a", "--filter", "label="+managedLabel, "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []struct {
+		ID      string   `json:"Id"`
+		Names   []string `json:"Names"`
+		Created int64    `json:"Created"`
+	}
+	if err := json.Unmarshal(output, &containers); err != nil {
+		return nil, fmt.Errorf("failed to parse podman ps: %w", err)
+	}
+
+	resources := make([]Resource, 0, len(containers))
+	for _, c := range containers {
+		var name string
+		if len(c.Names) > 0 {
+			name = c.Names[0]
+		}
+		resources = append(resources, Resource{
+			Backend:   TypePodman,
+			ID:        c.ID,
+			Name:      name,
+			CreatedAt: time.Unix(c.Created, 0),
+		})
+	}
+	return resources, nil
+}
+
+func listModalResources(ctx context.Context) ([]Resource, error) {
+	mc, err := modal.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create modal client: %w", err)
+	}
+	app, err := mc.Apps.FromName(ctx, modalAppName, &modal.AppFromNameParams{CreateIfMissing: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get modal app: %w", err)
+	}
+	sandboxes, err := mc.Sandboxes.List(ctx, &modal.SandboxListParams{AppID: app.AppID})
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []Resource
+	for sandbox, err := range sandboxes {
+		if err != nil {
+			return nil, err
+		}
+		r := Resource{Backend: TypeModal, ID: sandbox.SandboxID}
+		if tags, err := sandbox.GetTags(ctx); err == nil {
+			if created, err := strconv.ParseInt(tags[modalCreatedTag], 10, 64); err == nil {
+				r.CreatedAt = time.Unix(created, 0)
+			}
+		}
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
+func listSpritesResources(ctx context.Context) ([]Resource, error) {
+	if shouldKeepSprite() {
+		return nil, fmt.Errorf("SPRITES_KEEP is set")
+	}
+	sc, err := newSpritesClient(Config{})
+	if err != nil {
+		return nil, err
+	}
+	all, err := sc.ListAllSprites(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]Resource, 0, len(all))
+	for _, s := range all {
+		resources = append(resources, Resource{
+			Backend:   TypeSprites,
+			ID:        s.Name(),
+			Name:      s.Name(),
+			CreatedAt: s.CreatedAt,
+		})
+	}
+	return resources, nil
+}
+
+func listFlyMachinesResources(ctx context.Context) ([]Resource, error) {
+	fc, appName, err := newFlyMachinesClient(Config{})
+	if err != nil {
+		return nil, err
+	}
+	machines, err := fc.List(ctx, appName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]Resource, 0, len(machines))
+	for _, m := range machines {
+		if m.State == fly.MachineStateDestroyed {
+			continue
+		}
+		r := Resource{Backend: TypeFlyMachines, ID: m.ID, Name: m.Name}
+		if created, err := time.Parse(time.RFC3339, m.CreatedAt); err == nil {
+			r.CreatedAt = created
+		}
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
@@ -2,11 +2,13 @@ package env
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,6 +18,13 @@ import (
 const (
 	modalAppName   = "cook-sandbox"
 	modalAgentPort = 7422
+
+	// modalCreatedTag records a sandbox's creation time (unix seconds)
+	modalCreatedTag = "cook.created"
+
+	// modalSandboxTimeout is a sandbox's lifetime unless its resource
+	// profile sets one
+	modalSandboxTimeout = time.Hour
 )
 
 // ModalBackend runs commands in a Modal sandbox.
@@ -86,6 +95,14 @@ func NewModalBackendFromSandboxID(sandboxID string, hostWorkDir string) (*ModalB
 
 // Setup provisions the Modal sandbox with the repo cloned.
 func (b *ModalBackend) Setup(ctx context.Context) error {
+	if err := b.Warm(ctx); err != nil {
+		return err
+	}
+	return b.Claim(ctx, b.config)
+}
+
+// Warm creates the sandbox and starts cook-agent without cloning a repo.
+func (b *ModalBackend) Warm(ctx context.Context) error {
 	// Get or create the Modal app
 	app, err := b.client.Apps.FromName(ctx, modalAppName, &modal.AppFromNameParams{CreateIfMissing: true})
 	if err != nil {
@@ -101,9 +118,7 @@ func (b *ModalBackend) Setup(ctx context.Context) error {
 		sandboxName = b.config.Name
 	}
 
-	start := time.Now()
-	fmt.Printf("Creating Modal sandbox...\n")
-	sandbox, err := b.client.Sandboxes.Create(ctx, app, image, &modal.SandboxCreateParams{
+	params := &modal.SandboxCreateParams{
 		Name: sandboxName,
 		// Add environment variables
 		Env: map[string]string{
@@ -113,9 +128,15 @@ func (b *ModalBackend) Setup(ctx context.Context) error {
 		},
 		// Expose cook-agent port via tunnel
 		EncryptedPorts: []int{modalAgentPort},
-		// Set reasonable timeout (1 hour)
-		Timeout: time.Hour,
-	})
+		// Modal can't extend a sandbox's lifetime once it's running, so a
+		// warm sandbox starts with its wait for a claim on top
+		Timeout: modalSandboxTimeout + b.config.WarmFor,
+	}
+	applyModalResources(params, b.config.Resources)
+
+	start := time.Now()
+	fmt.Printf("Creating Modal sandbox...\n")
+	sandbox, err := b.client.Sandboxes.Create(ctx, app, image, params)
 	if err != nil {
 		return fmt.Errorf("failed to create sandbox: %w", err)
 	}
@@ -123,6 +144,11 @@ func (b *ModalBackend) Setup(ctx context.Context) error {
 	b.sandboxID = sandbox.SandboxID
 	fmt.Printf("Modal sandbox created: %s (took %v)\n", b.sandboxID, time.Since(start))
 
+	// Modal doesn't report a sandbox's age when listing, so record it for gc
+	if err := sandbox.SetTags(ctx, map[string]string{modalCreatedTag: strconv.FormatInt(start.Unix(), 10)}); err != nil {
+		fmt.Printf("Warning: failed to tag sandbox: %v\n", err)
+	}
+
 	// Create workspace directory
 	start = time.Now()
 	if _, err := b.Exec(ctx, "mkdir -p "+b.workDir); err != nil {
@@ -134,13 +160,6 @@ func (b *ModalBackend) Setup(ctx context.Context) error {
 		return fmt.Errorf("failed to setup tailnet: %w", err)
 	}
 
-	// Clone the repo
-	start = time.Now()
-	if err := b.cloneRepo(ctx); err != nil {
-		return fmt.Errorf("failed to clone repo: %w", err)
-	}
-	fmt.Printf("Cloned repo (took %v)\n", time.Since(start))
-
 	// Copy and start cook-agent
 	start = time.Now()
 	if err := b.setupAgent(ctx); err != nil {
@@ -153,6 +172,21 @@ func (b *ModalBackend) Setup(ctx context.Context) error {
 		fmt.Printf("Warning: failed to copy Claude auth: %v\n", err)
 	}
 
+	return nil
+}
+
+// Claim clones cfg's repo into a warm sandbox and applies its dotfiles.
+func (b *ModalBackend) Claim(ctx context.Context, cfg Config) error {
+	b.config = cfg
+
+	if b.config.RepoURL != "" {
+		start := time.Now()
+		if err := b.cloneRepo(ctx); err != nil {
+			return fmt.Errorf("failed to clone repo: %w", err)
+		}
+		fmt.Printf("Cloned repo (took %v)\n", time.Since(start))
+	}
+
 	// Setup dotfiles if specified
 	if b.config.Dotfiles != "" {
 		if err := b.setupDotfiles(ctx); err != nil {
@@ -262,6 +296,11 @@ func (b *ModalBackend) cloneRepo(ctx context.Context) error {
 }
 
 func (b *ModalBackend) setupAgent(ctx context.Context) error {
+	secretEnv, err := agentSecretEnv("modal:" + b.sandboxID)
+	if err != nil {
+		return err
+	}
+
 	agentCmd := "cook-agent"
 	if _, err := b.Exec(ctx, "command -v cook-agent"); err != nil {
 		agentPath, err := findAgentBinary()
@@ -299,7 +338,7 @@ func (b *ModalBackend) setupAgent(ctx context.Context) error {
 		agentCmd = "/tmp/cook-agent"
 	}
 
-	_, err := b.Exec(ctx, fmt.Sprintf("nohup %s > /tmp/cook-agent.log 2>&1 &", agentCmd))
+	_, err = b.Exec(ctx, fmt.Sprintf("%snohup %s -state %s > /tmp/cook-agent.log 2>&1 &", secretEnv, agentCmd, agentStatePath))
 	if err != nil {
 		return fmt.Errorf("failed to start agent: %w", err)
 	}
@@ -449,6 +488,9 @@ func (b *ModalBackend) Exec(ctx context.Context, cmdStr string) ([]byte, error)
 	if b.sandbox == nil {
 		return nil, fmt.Errorf("sandbox not initialized")
 	}
+	if output, err := agentExec(ctx, b.agentTunnel, b.AgentSecret(), "", cmdStr, nil); !errors.Is(err, errAgentUnavailable) {
+		return output, err
+	}
 
 	proc, err := b.sandbox.Exec(ctx, []string{"sh", "-c", cmdStr}, nil)
 	if err != nil {
@@ -484,6 +526,9 @@ func (b *ModalBackend) Command(ctx context.Context, name string, args ...string)
 
 // ReadFile reads a file from the sandbox.
 func (b *ModalBackend) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	if data, err := agentReadFile(b.agentTunnel, b.AgentSecret(), path); !errors.Is(err, errAgentUnavailable) {
+		return data, err
+	}
 	output, err := b.Exec(ctx, fmt.Sprintf("cat '%s'", path))
 	if err != nil {
 		return nil, err
@@ -493,6 +538,9 @@ func (b *ModalBackend) ReadFile(ctx context.Context, path string) ([]byte, error
 
 // WriteFile writes a file to the sandbox.
 func (b *ModalBackend) WriteFile(ctx context.Context, path string, content []byte) error {
+	if err := agentWriteFile(b.agentTunnel, b.AgentSecret(), path, content); !errors.Is(err, errAgentUnavailable) {
+		return err
+	}
 	escaped := strings.ReplaceAll(string(content), "'", "'\"'\"'")
 	_, err := b.Exec(ctx, fmt.Sprintf("echo '%s' > '%s'", escaped, path))
 	return err
@@ -500,6 +548,9 @@ func (b *ModalBackend) WriteFile(ctx context.Context, path string, content []byt
 
 // ListFiles lists files in a directory.
 func (b *ModalBackend) ListFiles(ctx context.Context, dir string) ([]FileInfo, error) {
+	if files, err := agentListFiles(b.agentTunnel, b.AgentSecret(), dir); !errors.Is(err, errAgentUnavailable) {
+		return files, err
+	}
 	output, err := b.Exec(ctx, fmt.Sprintf("ls -la '%s'", dir))
 	if err != nil {
 		return nil, err
@@ -559,7 +610,34 @@ func (b *ModalBackend) SandboxID() string {
 	return b.sandboxID
 }
 
+// WatchFiles reports changes under dir through the sandbox's cook-agent.
+func (b *ModalBackend) WatchFiles(ctx context.Context, dir string, fn func([]FileEvent)) error {
+	return agentWatch(ctx, b.agentTunnel, b.AgentSecret(), dir, fn)
+}
+
 // AgentAddr returns the tunnel URL to connect to cook-agent.
 func (b *ModalBackend) AgentAddr() string {
 	return b.agentTunnel
 }
+
+// AgentSecret returns the secret the sandbox's cook-agent requires.
+func (b *ModalBackend) AgentSecret() string {
+	return agentSecret("modal:" + b.sandboxID)
+}
+
+// applyModalResources maps r onto sandbox limits. Modal sizes disk itself,
+// so DiskGB is ignored.
+func applyModalResources(params *modal.SandboxCreateParams, r Resources) {
+	if r.CPUs > 0 {
+		params.CPU = float64(r.CPUs)
+	}
+	if r.MemoryMB > 0 {
+		params.MemoryMiB = r.MemoryMB
+	}
+	if r.HasGPU() {
+		params.GPU = r.GPU
+	}
+	if r.Timeout > 0 {
+		params.Timeout = r.Timeout
+	}
+}
@@ -31,6 +31,9 @@ func TestModalBackend_Integration(t *testing.T) {
 	defer backend.Teardown(context.Background())
 
 	t.Log("Setting up Modal sandbox...")
+	if err := envagent.LoadKey(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
 	if err := backend.Setup(ctx); err != nil {
 		t.Fatalf("Setup failed: %v", err)
 	}
@@ -86,7 +89,7 @@ func TestModalBackend_Integration(t *testing.T) {
 	t.Logf("Agent address: %s", agentAddr)
 
 	// Connect via envagent client
-	client, err := envagent.Dial(agentAddr)
+	client, err := envagent.Dial(agentAddr, backend.AgentSecret())
 	if err != nil {
 		t.Fatalf("Failed to connect to cook-agent via WebSocket: %v", err)
 	}
@@ -0,0 +1,729 @@
+package env
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Backend plugins are external programs that provide a backend type over
+// JSON-RPC 2.0, one message per line, on their stdin/stdout or a unix
+// socket. Methods mirror Backend, PTYAttacher and Stopper; every
+// environment method takes the opaque "state" the plugin returned from
+// setup, which cook persists (EnvironmentSpec.BackendState) to reconnect.
+//
+//	initialize   {protocol_version}             -> {name, protocol_version, capabilities}
+//	setup        {config}                       -> {state}
+//	exec         {state, command}               -> {output, exit_code}
+//	command      {state, name, args}            -> {argv, env, dir}  (run on the cook host)
+//	read_file    {state, path}                  -> {content}
+//	write_file   {state, path, content}         -> {}
+//	list_files   {state, dir}                   -> {files}
+//	status       {state}                        -> {state, message, id}
+//	teardown     {state}                        -> {}
+//	stop         {state}                        -> {}                 (capability "stop")
+//	start        {state}                        -> {}                 (capability "stop")
+//	agent_addr   {state}                        -> {addr, work_dir, secret}  (capability "agent")
+//	pty.attach   {state, pty, rows, cols}       -> {}                 (capability "pty")
+//	pty.input    {pty, data}                    -> {}
+//	pty.resize   {pty, rows, cols}              -> {}
+//	pty.close    {pty}                          -> {}
+//
+// The plugin sends pty.output {pty, data} and pty.exit {pty} notifications
+// for attached PTYs. []byte fields are base64, as in encoding/json.
+
+// PluginProtocolVersion is the backend plugin protocol version.
+const PluginProtocolVersion = 1
+
+// pluginCallTimeout bounds plugin calls whose context has no deadline.
+const pluginCallTimeout = 2 * time.Minute
+
+// pluginPTYBufferSize caps output buffered for a plugin terminal nobody is
+// reading. Past it the oldest output is dropped, as a terminal scrolls.
+const pluginPTYBufferSize = 1 << 20
+
+// PluginConfig says how to reach a backend plugin. Exactly one is set.
+type PluginConfig struct {
+	Command []string // started on first use; spoken to over stdin/stdout
+	Socket  string   // unix socket of an already running plugin
+}
+
+// PluginCapabilities are the optional methods a plugin implements.
+type PluginCapabilities struct {
+	PTY   bool `json:"pty"`
+	Stop  bool `json:"stop"`
+	Agent bool `json:"agent"`
+}
+
+var (
+	pluginsMu   sync.Mutex
+	plugins     = map[string]PluginConfig{}
+	pluginConns = map[string]*pluginConn{}
+	// pluginDials serializes connecting to each plugin, so starting one
+	// doesn't hold up calls to the others
+	pluginDials = map[string]*sync.Mutex{}
+)
+
+// builtinTypes are the backend types that can't be overridden by plugins.
+var builtinTypes = []Type{TypeLocal, TypeSandbox, TypeDocker, TypePodman, TypeModal, TypeSprites, TypeFlyMachines, TypeSSH}
+
+// RegisterPlugin makes a plugin available as backend type name.
+func RegisterPlugin(name string, cfg PluginConfig) error {
+	for _, t := range builtinTypes {
+		if Type(name) == t {
+			return fmt.Errorf("%s is a built-in backend", name)
+		}
+	}
+	if (len(cfg.Command) == 0) == (cfg.Socket == "") {
+		return fmt.Errorf("backend plugin %s needs exactly one of command or socket", name)
+	}
+
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	plugins[name] = cfg
+	return nil
+}
+
+// IsPlugin reports whether name is a registered plugin backend.
+func IsPlugin(name string) bool {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	_, ok := plugins[name]
+	return ok
+}
+
+// Plugins returns the registered plugin backend names, sorted.
+func Plugins() []string {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PluginBackend is a Backend provided by an external plugin.
+type PluginBackend struct {
+	name   string
+	config Config
+	state  json.RawMessage
+
+	agentWorkDir string
+	agentSecret  string
+
+	ptyMu sync.Mutex
+	pty   *pluginPTY
+}
+
+// NewPluginBackend creates a backend of plugin type name. cfg.State
+// reconnects to an existing environment; otherwise call Setup.
+func NewPluginBackend(name string, cfg Config) (*PluginBackend, error) {
+	if !IsPlugin(name) {
+		return nil, fmt.Errorf("unknown backend plugin: %s", name)
+	}
+	return &PluginBackend{name: name, config: cfg, state: cfg.State}, nil
+}
+
+// State returns the opaque state to persist for reconnecting.
+func (b *PluginBackend) State() json.RawMessage {
+	return b.state
+}
+
+// pluginEnvConfig is the config sent to a plugin's setup method.
+type pluginEnvConfig struct {
+	Name        string            `json:"name"`
+	RepoURL     string            `json:"repo_url"`
+	BranchName  string            `json:"branch_name"`
+	WorkDir     string            `json:"work_dir"`
+	Dotfiles    string            `json:"dotfiles,omitempty"`
+	SandboxName string            `json:"sandbox_name,omitempty"`
+	Resources   Resources         `json:"resources,omitzero"`
+	Secrets     map[string]string `json:"secrets,omitempty"`
+}
+
+func (b *PluginBackend) call(ctx context.Context, method string, params map[string]interface{}, result interface{}) error {
+	conn, err := getPluginConn(b.name)
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	if _, ok := params["state"]; !ok && b.state != nil {
+		params["state"] = b.state
+	}
+	return conn.call(ctx, method, params, result)
+}
+
+func (b *PluginBackend) capabilities() PluginCapabilities {
+	conn, err := getPluginConn(b.name)
+	if err != nil {
+		return PluginCapabilities{}
+	}
+	return conn.caps
+}
+
+// Setup provisions the environment and records the plugin's state.
+func (b *PluginBackend) Setup(ctx context.Context) error {
+	var result struct {
+		State json.RawMessage `json:"state"`
+	}
+	cfg := pluginEnvConfig{
+		Name:        b.config.Name,
+		RepoURL:     b.config.RepoURL,
+		BranchName:  b.config.BranchName,
+		WorkDir:     b.config.WorkDir,
+		Dotfiles:    b.config.Dotfiles,
+		SandboxName: b.config.SandboxName,
+		Resources:   b.config.Resources,
+		Secrets:     b.config.Secrets,
+	}
+	if err := b.call(ctx, "setup", map[string]interface{}{"config": cfg}, &result); err != nil {
+		return err
+	}
+	b.state = result.State
+	return nil
+}
+
+// Exec runs a command and returns combined output.
+func (b *PluginBackend) Exec(ctx context.Context, cmd string) ([]byte, error) {
+	var result struct {
+		Output   []byte `json:"output"`
+		ExitCode int    `json:"exit_code"`
+	}
+	if err := b.call(ctx, "exec", map[string]interface{}{"command": cmd}, &result); err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return result.Output, fmt.Errorf("exit status %d", result.ExitCode)
+	}
+	return result.Output, nil
+}
+
+// Command asks the plugin for a local command line (e.g. its own CLI's
+// exec subcommand) that runs name in the environment.
+func (b *PluginBackend) Command(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	var result struct {
+		Argv []string `json:"argv"`
+		Env  []string `json:"env"`
+		Dir  string   `json:"dir"`
+	}
+	if args == nil {
+		args = []string{}
+	}
+	if err := b.call(ctx, "command", map[string]interface{}{"name": name, "args": args}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Argv) == 0 {
+		return nil, fmt.Errorf("plugin %s returned an empty command", b.name)
+	}
+	cmd := exec.CommandContext(ctx, result.Argv[0], result.Argv[1:]...)
+	cmd.Env = append(os.Environ(), result.Env...)
+	cmd.Dir = result.Dir
+	return cmd, nil
+}
+
+// ReadFile reads a file from the environment.
+func (b *PluginBackend) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	var result struct {
+		Content []byte `json:"content"`
+	}
+	if err := b.call(ctx, "read_file", map[string]interface{}{"path": path}, &result); err != nil {
+		return nil, err
+	}
+	return result.Content, nil
+}
+
+// WriteFile writes a file to the environment.
+func (b *PluginBackend) WriteFile(ctx context.Context, path string, content []byte) error {
+	return b.call(ctx, "write_file", map[string]interface{}{"path": path, "content": content}, nil)
+}
+
+// ListFiles lists files in a directory.
+func (b *PluginBackend) ListFiles(ctx context.Context, dir string) ([]FileInfo, error) {
+	var result struct {
+		Files []FileInfo `json:"files"`
+	}
+	if err := b.call(ctx, "list_files", map[string]interface{}{"dir": dir}, &result); err != nil {
+		return nil, err
+	}
+	return result.Files, nil
+}
+
+// WorkDir returns the checkout path cook assigned the environment.
+func (b *PluginBackend) WorkDir() string {
+	return b.config.WorkDir
+}
+
+// Status returns the plugin-reported status.
+func (b *PluginBackend) Status(ctx context.Context) (Status, error) {
+	var status Status
+	if err := b.call(ctx, "status", nil, &status); err != nil {
+		return Status{State: StateError, Message: err.Error()}, nil
+	}
+	return status, nil
+}
+
+// CanStop reports whether the plugin advertised the stop capability.
+func (b *PluginBackend) CanStop() bool {
+	return b.capabilities().Stop
+}
+
+// Stop halts the environment without destroying it, if the plugin can.
+func (b *PluginBackend) Stop(ctx context.Context) error {
+	if !b.capabilities().Stop {
+		return fmt.Errorf("backend plugin %s does not support stop", b.name)
+	}
+	return b.call(ctx, "stop", nil, nil)
+}
+
+// Start brings back an environment Stop halted.
+func (b *PluginBackend) Start(ctx context.Context) error {
+	if !b.capabilities().Stop {
+		return fmt.Errorf("backend plugin %s does not support stop", b.name)
+	}
+	return b.call(ctx, "start", nil, nil)
+}
+
+// Teardown destroys the environment.
+func (b *PluginBackend) Teardown(ctx context.Context) error {
+	return b.call(ctx, "teardown", nil, nil)
+}
+
+// AgentAddr returns the address of a cook-agent the plugin runs in the
+// environment, or "" if it doesn't run one.
+func (b *PluginBackend) AgentAddr() string {
+	if !b.capabilities().Agent {
+		return ""
+	}
+	var result struct {
+		Addr    string `json:"addr"`
+		WorkDir string `json:"work_dir"`
+		Secret  string `json:"secret"`
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := b.call(ctx, "agent_addr", nil, &result); err != nil {
+		fmt.Printf("Warning: backend plugin %s: %v\n", b.name, err)
+		return ""
+	}
+	b.agentWorkDir = result.WorkDir
+	b.agentSecret = result.Secret
+	return result.Addr
+}
+
+// AgentSecret returns the secret the plugin started its cook-agent with,
+// as reported alongside the agent address.
+func (b *PluginBackend) AgentSecret() string {
+	return b.agentSecret
+}
+
+// AgentWorkDir is where cook-agent sessions should start, as reported
+// alongside the agent address.
+func (b *PluginBackend) AgentWorkDir() string {
+	if b.agentWorkDir != "" {
+		return b.agentWorkDir
+	}
+	return b.config.WorkDir
+}
+
+// AttachPTY starts an interactive terminal in the environment.
+func (b *PluginBackend) AttachPTY(ctx context.Context, rows, cols int) (io.ReadWriteCloser, error) {
+	if !b.capabilities().PTY {
+		return nil, fmt.Errorf("backend plugin %s does not support pty", b.name)
+	}
+	conn, err := getPluginConn(b.name)
+	if err != nil {
+		return nil, err
+	}
+
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	p := conn.newPTY(hex.EncodeToString(idBytes))
+	params := map[string]interface{}{"pty": p.id, "rows": rows, "cols": cols}
+	if err := b.call(ctx, "pty.attach", params, nil); err != nil {
+		conn.removePTY(p.id)
+		return nil, err
+	}
+
+	b.ptyMu.Lock()
+	b.pty = p
+	b.ptyMu.Unlock()
+	return p, nil
+}
+
+// ResizePTY resizes the attached terminal.
+func (b *PluginBackend) ResizePTY(rows, cols int) error {
+	b.ptyMu.Lock()
+	p := b.pty
+	b.ptyMu.Unlock()
+	if p == nil {
+		return fmt.Errorf("no pty attached")
+	}
+	return p.conn.call(context.Background(), "pty.resize", map[string]interface{}{"pty": p.id, "rows": rows, "cols": cols}, nil)
+}
+
+// pluginPTY is the cook side of a plugin terminal. Output is buffered
+// rather than piped so a slow reader never blocks the connection's read
+// loop (which also delivers the responses to Write); the buffer keeps the
+// last pluginPTYBufferSize bytes.
+type pluginPTY struct {
+	conn *pluginConn
+	id   string
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []byte
+	closed  bool
+	dropped bool
+}
+
+func (p *pluginPTY) Read(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.buf) == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if len(p.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(data, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+// output appends terminal output for Read.
+func (p *pluginPTY) output(data []byte) {
+	p.mu.Lock()
+	p.buf = append(p.buf, data...)
+	if over := len(p.buf) - pluginPTYBufferSize; over > 0 {
+		p.buf = p.buf[over:]
+		if !p.dropped {
+			p.dropped = true
+			log.Printf("backend plugin %s: terminal %s output not read, dropping the oldest", p.conn.name, p.id)
+		}
+	}
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// finish makes Read return EOF once buffered output is drained.
+func (p *pluginPTY) finish() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+func (p *pluginPTY) Write(data []byte) (int, error) {
+	if err := p.conn.call(context.Background(), "pty.input", map[string]interface{}{"pty": p.id, "data": data}, nil); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (p *pluginPTY) Close() error {
+	p.conn.removePTY(p.id)
+	return p.conn.call(context.Background(), "pty.close", map[string]interface{}{"pty": p.id}, nil)
+}
+
+// rpcMessage is any JSON-RPC 2.0 message: request, response or notification.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// pluginConn is a JSON-RPC connection to one plugin, shared by every
+// backend of that type. Calls may be concurrent.
+type pluginConn struct {
+	name string
+	rwc  io.ReadWriteCloser
+	caps PluginCapabilities
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan rpcMessage
+	ptys    map[string]*pluginPTY
+	done    chan struct{}
+	err     error
+}
+
+// getPluginConn returns the live connection to plugin name, starting the
+// plugin or dialing its socket if needed.
+func getPluginConn(name string) (*pluginConn, error) {
+	pluginsMu.Lock()
+	cfg, ok := plugins[name]
+	dial := pluginDials[name]
+	if dial == nil {
+		dial = &sync.Mutex{}
+		pluginDials[name] = dial
+	}
+	pluginsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend plugin: %s", name)
+	}
+
+	dial.Lock()
+	defer dial.Unlock()
+
+	pluginsMu.Lock()
+	conn, ok := pluginConns[name]
+	pluginsMu.Unlock()
+	if ok {
+		select {
+		case <-conn.done:
+		default:
+			return conn, nil
+		}
+	}
+
+	var rwc io.ReadWriteCloser
+	if cfg.Socket != "" {
+		c, err := net.Dial("unix", cfg.Socket)
+		if err != nil {
+			return nil, fmt.Errorf("backend plugin %s: %w", name, err)
+		}
+		rwc = c
+	} else {
+		cmd := exec.Command(cfg.Command[0], cfg.Command[1:]...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("failed to start backend plugin %s: %w", name, err)
+		}
+		rwc = &pluginProcess{Reader: stdout, stdin: stdin, cmd: cmd}
+	}
+
+	conn, err := newPluginConn(name, rwc)
+	if err != nil {
+		return nil, err
+	}
+	pluginsMu.Lock()
+	pluginConns[name] = conn
+	pluginsMu.Unlock()
+	return conn, nil
+}
+
+// pluginProcess adapts a plugin's stdio to an io.ReadWriteCloser.
+type pluginProcess struct {
+	io.Reader
+	stdin io.WriteCloser
+	cmd   *exec.Cmd
+}
+
+func (p *pluginProcess) Write(data []byte) (int, error) {
+	return p.stdin.Write(data)
+}
+
+func (p *pluginProcess) Close() error {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	return p.cmd.Wait()
+}
+
+// newPluginConn starts reading from rwc and performs the handshake.
+func newPluginConn(name string, rwc io.ReadWriteCloser) (*pluginConn, error) {
+	conn := &pluginConn{
+		name:    name,
+		rwc:     rwc,
+		pending: make(map[int64]chan rpcMessage),
+		ptys:    make(map[string]*pluginPTY),
+		done:    make(chan struct{}),
+	}
+	go conn.readLoop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var hello struct {
+		Name            string             `json:"name"`
+		ProtocolVersion int                `json:"protocol_version"`
+		Capabilities    PluginCapabilities `json:"capabilities"`
+	}
+	if err := conn.call(ctx, "initialize", map[string]interface{}{"protocol_version": PluginProtocolVersion}, &hello); err != nil {
+		rwc.Close()
+		return nil, fmt.Errorf("backend plugin %s: initialize: %w", name, err)
+	}
+	if hello.ProtocolVersion != PluginProtocolVersion {
+		rwc.Close()
+		return nil, fmt.Errorf("backend plugin %s speaks protocol %d, cook speaks %d", name, hello.ProtocolVersion, PluginProtocolVersion)
+	}
+	conn.caps = hello.Capabilities
+	return conn, nil
+}
+
+func (c *pluginConn) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pluginCallTimeout)
+		defer cancel()
+	}
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan rpcMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(rpcMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: rawParams}); err != nil {
+		return fmt.Errorf("backend plugin %s: %w", c.name, err)
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil && len(resp.Result) > 0 {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-c.done:
+		return fmt.Errorf("backend plugin %s exited: %v", c.name, c.err)
+	case <-ctx.Done():
+		return fmt.Errorf("backend plugin %s: %s: %w", c.name, method, ctx.Err())
+	}
+}
+
+func (c *pluginConn) write(msg rpcMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.rwc.Write(append(data, '\n'))
+	return err
+}
+
+func (c *pluginConn) readLoop() {
+	scanner := bufio.NewScanner(c.rwc)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var msg rpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Printf("backend plugin %s: ignoring malformed message: %v", c.name, err)
+			continue
+		}
+		if msg.Method != "" {
+			c.handleNotification(msg)
+			continue
+		}
+		if msg.ID == nil {
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[*msg.ID]
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- msg
+		}
+	}
+
+	c.err = scanner.Err()
+	if c.err == nil {
+		c.err = io.EOF
+	}
+	c.mu.Lock()
+	for id, p := range c.ptys {
+		p.finish()
+		delete(c.ptys, id)
+	}
+	c.mu.Unlock()
+	close(c.done)
+}
+
+func (c *pluginConn) handleNotification(msg rpcMessage) {
+	var params struct {
+		PTY  string `json:"pty"`
+		Data []byte `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		log.Printf("backend plugin %s: ignoring malformed %s notification: %v", c.name, msg.Method, err)
+		return
+	}
+
+	c.mu.Lock()
+	p := c.ptys[params.PTY]
+	c.mu.Unlock()
+	if p == nil {
+		return
+	}
+
+	switch msg.Method {
+	case "pty.output":
+		p.output(params.Data)
+	case "pty.exit":
+		c.removePTY(p.id)
+	}
+}
+
+func (c *pluginConn) newPTY(id string) *pluginPTY {
+	p := &pluginPTY{conn: c, id: id}
+	p.cond = sync.NewCond(&p.mu)
+	c.mu.Lock()
+	c.ptys[id] = p
+	c.mu.Unlock()
+	return p
+}
+
+func (c *pluginConn) removePTY(id string) {
+	c.mu.Lock()
+	p := c.ptys[id]
+	delete(c.ptys, id)
+	c.mu.Unlock()
+	if p != nil {
+		p.finish()
+	}
+}
+
+// Ensure PluginBackend implements Backend, PTYAttacher and Stopper
+var (
+	_ Backend     = (*PluginBackend)(nil)
+	_ PTYAttacher = (*PluginBackend)(nil)
+	_ Stopper     = (*PluginBackend)(nil)
+)
@@ -0,0 +1,300 @@
+package env
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// PluginServer serves the backend plugin protocol for Backends written in
+// Go, so a plugin binary can be as small as:
+//
+//	srv := &env.PluginServer{Name: "mybackend", New: ..., Open: ..., State: ...}
+//	srv.Serve(os.Stdin, os.Stdout)
+type PluginServer struct {
+	Name         string
+	Capabilities PluginCapabilities
+
+	// New creates a backend for a setup call; Serve calls Setup on it.
+	New func(cfg Config) (Backend, error)
+	// Open reconnects to the backend described by state.
+	Open func(state json.RawMessage) (Backend, error)
+	// State returns the state to persist for b after Setup.
+	State func(b Backend) (json.RawMessage, error)
+
+	writeMu sync.Mutex
+	w       io.Writer
+
+	ptyMu sync.Mutex
+	ptys  map[string]servedPTY
+}
+
+type servedPTY struct {
+	backend PTYAttacher
+	rwc     io.ReadWriteCloser
+}
+
+// pluginAgent is implemented by backends that run a cook-agent.
+type pluginAgent interface {
+	AgentAddr() string
+}
+
+// pluginAgentSecret is implemented by backends whose cook-agent requires a
+// secret, like the built-in ones. A plugin process serving them must load
+// an agent key first (see envagent.LoadKey).
+type pluginAgentSecret interface {
+	AgentSecret() string
+}
+
+// Serve reads requests from r and writes responses to w until r is closed.
+// Requests are handled concurrently.
+func (s *PluginServer) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	s.ptys = make(map[string]servedPTY)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var wg sync.WaitGroup
+	for scanner.Scan() {
+		var msg rpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			s.write(rpcMessage{JSONRPC: "2.0", Error: &rpcError{Code: -32700, Message: "parse error"}})
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := s.handle(msg.Method, msg.Params)
+			if msg.ID == nil {
+				return
+			}
+			resp := rpcMessage{JSONRPC: "2.0", ID: msg.ID}
+			if err != nil {
+				resp.Error = &rpcError{Code: -32000, Message: err.Error()}
+			} else {
+				raw, err := json.Marshal(result)
+				if err != nil {
+					resp.Error = &rpcError{Code: -32603, Message: err.Error()}
+				} else {
+					resp.Result = raw
+				}
+			}
+			s.write(resp)
+		}()
+	}
+	wg.Wait()
+
+	s.ptyMu.Lock()
+	for id, p := range s.ptys {
+		p.rwc.Close()
+		delete(s.ptys, id)
+	}
+	s.ptyMu.Unlock()
+	return scanner.Err()
+}
+
+func (s *PluginServer) write(msg rpcMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.w.Write(append(data, '\n'))
+}
+
+func (s *PluginServer) notify(method string, params interface{}) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	s.write(rpcMessage{JSONRPC: "2.0", Method: method, Params: raw})
+}
+
+// pluginParams is the union of all request parameters.
+type pluginParams struct {
+	ProtocolVersion int             `json:"protocol_version"`
+	Config          pluginEnvConfig `json:"config"`
+	State           json.RawMessage `json:"state"`
+	Command         string          `json:"command"`
+	Name            string          `json:"name"`
+	Args            []string        `json:"args"`
+	Path            string          `json:"path"`
+	Content         []byte          `json:"content"`
+	Dir             string          `json:"dir"`
+	PTY             string          `json:"pty"`
+	Data            []byte          `json:"data"`
+	Rows            int             `json:"rows"`
+	Cols            int             `json:"cols"`
+}
+
+func (s *PluginServer) handle(method string, raw json.RawMessage) (interface{}, error) {
+	var p pluginParams
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	ctx := context.Background()
+
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"name":             s.Name,
+			"protocol_version": PluginProtocolVersion,
+			"capabilities":     s.Capabilities,
+		}, nil
+	case "setup":
+		b, err := s.New(Config{
+			Name:        p.Config.Name,
+			RepoURL:     p.Config.RepoURL,
+			BranchName:  p.Config.BranchName,
+			WorkDir:     p.Config.WorkDir,
+			Dotfiles:    p.Config.Dotfiles,
+			SandboxName: p.Config.SandboxName,
+			Resources:   p.Config.Resources,
+			Secrets:     p.Config.Secrets,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := b.Setup(ctx); err != nil {
+			return nil, err
+		}
+		state, err := s.State(b)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"state": state}, nil
+	case "pty.input", "pty.resize", "pty.close":
+		return s.handlePTY(method, p)
+	}
+
+	b, err := s.Open(p.State)
+	if err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case "exec":
+		output, err := b.Exec(ctx, p.Command)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return map[string]interface{}{"output": output, "exit_code": exitErr.ExitCode()}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"output": output, "exit_code": 0}, nil
+	case "command":
+		cmd, err := b.Command(ctx, p.Name, p.Args...)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"argv": cmd.Args, "env": cmd.Env, "dir": cmd.Dir}, nil
+	case "read_file":
+		content, err := b.ReadFile(ctx, p.Path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"content": content}, nil
+	case "write_file":
+		return struct{}{}, b.WriteFile(ctx, p.Path, p.Content)
+	case "list_files":
+		files, err := b.ListFiles(ctx, p.Dir)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"files": files}, nil
+	case "status":
+		return b.Status(ctx)
+	case "teardown":
+		return struct{}{}, b.Teardown(ctx)
+	case "stop":
+		stopper, ok := b.(Stopper)
+		if !ok {
+			return nil, fmt.Errorf("stop not supported")
+		}
+		return struct{}{}, stopper.Stop(ctx)
+	case "start":
+		stopper, ok := b.(Stopper)
+		if !ok {
+			return nil, fmt.Errorf("stop not supported")
+		}
+		return struct{}{}, stopper.Start(ctx)
+	case "agent_addr":
+		agent, ok := b.(pluginAgent)
+		if !ok {
+			return nil, fmt.Errorf("agent not supported")
+		}
+		result := map[string]interface{}{"addr": agent.AgentAddr(), "work_dir": b.WorkDir()}
+		if s, ok := b.(pluginAgentSecret); ok {
+			result["secret"] = s.AgentSecret()
+		}
+		return result, nil
+	case "pty.attach":
+		attacher, ok := b.(PTYAttacher)
+		if !ok {
+			return nil, fmt.Errorf("pty not supported")
+		}
+		rwc, err := attacher.AttachPTY(ctx, p.Rows, p.Cols)
+		if err != nil {
+			return nil, err
+		}
+		s.ptyMu.Lock()
+		s.ptys[p.PTY] = servedPTY{backend: attacher, rwc: rwc}
+		s.ptyMu.Unlock()
+		go s.pumpPTY(p.PTY, rwc)
+		return struct{}{}, nil
+	default:
+		return nil, fmt.Errorf("unknown method: %s", method)
+	}
+}
+
+func (s *PluginServer) handlePTY(method string, p pluginParams) (interface{}, error) {
+	s.ptyMu.Lock()
+	pty, ok := s.ptys[p.PTY]
+	if method == "pty.close" {
+		delete(s.ptys, p.PTY)
+	}
+	s.ptyMu.Unlock()
+	if !ok {
+		if method == "pty.close" {
+			return struct{}{}, nil
+		}
+		return nil, fmt.Errorf("unknown pty: %s", p.PTY)
+	}
+
+	switch method {
+	case "pty.input":
+		_, err := pty.rwc.Write(p.Data)
+		return struct{}{}, err
+	case "pty.resize":
+		return struct{}{}, pty.backend.ResizePTY(p.Rows, p.Cols)
+	default:
+		return struct{}{}, pty.rwc.Close()
+	}
+}
+
+// pumpPTY forwards terminal output as pty.output notifications.
+func (s *PluginServer) pumpPTY(id string, rwc io.ReadWriteCloser) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := rwc.Read(buf)
+		if n > 0 {
+			s.notify("pty.output", map[string]interface{}{"pty": id, "data": buf[:n]})
+		}
+		if err != nil {
+			break
+		}
+	}
+	s.ptyMu.Lock()
+	delete(s.ptys, id)
+	s.ptyMu.Unlock()
+	s.notify("pty.exit", map[string]interface{}{"pty": id})
+}
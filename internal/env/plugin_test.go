@@ -0,0 +1,212 @@
+package env
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoPTYBackend is a LocalBackend whose "terminal" echoes its input.
+type echoPTYBackend struct {
+	*LocalBackend
+	rows, cols int
+}
+
+type echoPTY struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (b *echoPTYBackend) AttachPTY(ctx context.Context, rows, cols int) (io.ReadWriteCloser, error) {
+	r, w := io.Pipe()
+	return echoPTY{Reader: r, WriteCloser: w}, nil
+}
+
+func (b *echoPTYBackend) ResizePTY(rows, cols int) error {
+	b.rows, b.cols = rows, cols
+	return nil
+}
+
+// startTestPlugin serves a LocalBackend-based plugin on a unix socket and
+// registers it as backend type name.
+func startTestPlugin(t *testing.T, name string) {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	open := func(state json.RawMessage) (Backend, error) {
+		var workDir string
+		if err := json.Unmarshal(state, &workDir); err != nil {
+			return nil, err
+		}
+		return &echoPTYBackend{LocalBackend: NewLocalBackendFromPath(workDir)}, nil
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			srv := &PluginServer{
+				Name:         name,
+				Capabilities: PluginCapabilities{PTY: true},
+				New: func(cfg Config) (Backend, error) {
+					return &echoPTYBackend{LocalBackend: NewLocalBackendFromPath(cfg.WorkDir)}, nil
+				},
+				Open: open,
+				State: func(b Backend) (json.RawMessage, error) {
+					return json.Marshal(b.WorkDir())
+				},
+			}
+			go srv.Serve(conn, conn)
+		}
+	}()
+
+	if err := RegisterPlugin(name, PluginConfig{Socket: socket}); err != nil {
+		t.Fatalf("RegisterPlugin: %v", err)
+	}
+}
+
+func TestRegisterPlugin(t *testing.T) {
+	if err := RegisterPlugin("docker", PluginConfig{Command: []string{"x"}}); err == nil {
+		t.Error("expected error overriding a built-in backend")
+	}
+	if err := RegisterPlugin("both", PluginConfig{Command: []string{"x"}, Socket: "/tmp/x.sock"}); err == nil {
+		t.Error("expected error with both command and socket")
+	}
+	if IsPlugin("both") {
+		t.Error("rejected plugin was registered")
+	}
+}
+
+func TestPluginBackend(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	startTestPlugin(t, "test-plugin")
+	workDir := t.TempDir()
+
+	backend, err := NewBackend(Type("test-plugin"), Config{Name: "o/r/b", WorkDir: workDir})
+	if err != nil {
+		t.Fatalf("NewBackend: %v", err)
+	}
+	if err := backend.Setup(ctx); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	state := backend.(*PluginBackend).State()
+	if string(state) == "" {
+		t.Fatal("Setup returned no state")
+	}
+
+	// Reconnect from the persisted state, as Branch.Backend does
+	backend, err = NewPluginBackend("test-plugin", Config{WorkDir: workDir, State: state})
+	if err != nil {
+		t.Fatalf("NewPluginBackend: %v", err)
+	}
+
+	if err := backend.WriteFile(ctx, "dir/a.txt", []byte("hello")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	content, err := backend.ReadFile(ctx, "dir/a.txt")
+	if err != nil || string(content) != "hello" {
+		t.Fatalf("ReadFile = %q, %v", content, err)
+	}
+	files, err := backend.ListFiles(ctx, "dir")
+	if err != nil || len(files) != 1 || files[0].Name != "a.txt" {
+		t.Fatalf("ListFiles = %+v, %v", files, err)
+	}
+
+	output, err := backend.Exec(ctx, "cat dir/a.txt")
+	if err != nil || string(output) != "hello" {
+		t.Fatalf("Exec = %q, %v", output, err)
+	}
+	if _, err := backend.Exec(ctx, "exit 3"); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("Exec exit 3 error = %v", err)
+	}
+
+	cmd, err := backend.Command(ctx, "pwd")
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	output, err = cmd.Output()
+	if err != nil || strings.TrimSpace(string(output)) != workDir {
+		t.Fatalf("Command pwd = %q, %v", output, err)
+	}
+
+	status, err := backend.Status(ctx)
+	if err != nil || status.State != StateRunning {
+		t.Fatalf("Status = %+v, %v", status, err)
+	}
+
+	if _, ok := AsStopper(backend); ok {
+		t.Error("AsStopper succeeded without the stop capability")
+	}
+	if err := backend.(Stopper).Stop(ctx); err == nil {
+		t.Error("expected Stop to fail without the stop capability")
+	}
+	if addr := backend.(*PluginBackend).AgentAddr(); addr != "" {
+		t.Errorf("AgentAddr = %q without the agent capability", addr)
+	}
+}
+
+func TestPluginBackend_PTY(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	startTestPlugin(t, "test-pty-plugin")
+	state, _ := json.Marshal(t.TempDir())
+	backend, err := NewPluginBackend("test-pty-plugin", Config{State: state})
+	if err != nil {
+		t.Fatalf("NewPluginBackend: %v", err)
+	}
+
+	pty, err := backend.AttachPTY(ctx, 24, 80)
+	if err != nil {
+		t.Fatalf("AttachPTY: %v", err)
+	}
+	if err := backend.ResizePTY(40, 120); err != nil {
+		t.Fatalf("ResizePTY: %v", err)
+	}
+	if _, err := pty.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(pty, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Read = %q, %v", buf, err)
+	}
+
+	if err := pty.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := pty.Read(buf); err != io.EOF {
+		t.Fatalf("Read after close = %v, want EOF", err)
+	}
+}
+
+func TestPluginPTY_BufferCap(t *testing.T) {
+	p := (&pluginConn{name: "test", ptys: map[string]*pluginPTY{}}).newPTY("1")
+	p.output(make([]byte, pluginPTYBufferSize))
+	p.output([]byte("tail"))
+	p.finish()
+
+	data, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(data) != pluginPTYBufferSize {
+		t.Fatalf("buffered %d bytes, want %d", len(data), pluginPTYBufferSize)
+	}
+	if !strings.HasSuffix(string(data), "tail") {
+		t.Fatalf("buffer lost the newest output")
+	}
+}
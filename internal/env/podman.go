@@ -0,0 +1,406 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// podmanHome is HOME inside podman containers. With --userns=keep-id
+	// processes run as the host user, who cannot write /root.
+	podmanHome = "/home/cook"
+
+	// podmanAgentPortLabel records the cook-agent port on the container so
+	// a backend reconnected by ID can find it.
+	podmanAgentPortLabel = "cook.agent-port"
+)
+
+// PodmanBackend runs commands in a rootless Podman container. It uses the
+// podman CLI (no daemon) and maps the host user into the container with
+// --userns=keep-id, so files written to the bind-mounted checkout keep the
+// host user's ownership.
+type PodmanBackend struct {
+	config      Config
+	containerID string
+	workDir     string // path inside container
+	hostWorkDir string // path on host (for bind mount)
+	agentPort   int    // port cook-agent listens on (host network)
+	imageName   string
+
+	devContainer *DevContainer // the checkout's devcontainer.json, if any
+}
+
+// NewPodmanBackend creates a new Podman backend with the given config.
+func NewPodmanBackend(cfg Config) (*PodmanBackend, error) {
+	if _, err := exec.LookPath("podman"); err != nil {
+		return nil, fmt.Errorf("podman not found: %w", err)
+	}
+
+	return &PodmanBackend{
+		config:      cfg,
+		workDir:     "/workspace",
+		hostWorkDir: cfg.WorkDir,
+		imageName:   defaultDockerImage,
+	}, nil
+}
+
+// NewPodmanBackendFromExisting reconnects to an existing container.
+func NewPodmanBackendFromExisting(containerID, hostWorkDir string) (*PodmanBackend, error) {
+	if _, err := exec.LookPath("podman"); err != nil {
+		return nil, fmt.Errorf("podman not found: %w", err)
+	}
+
+	return &PodmanBackend{
+		containerID: containerID,
+		workDir:     "/workspace",
+		hostWorkDir: hostWorkDir,
+		imageName:   defaultDockerImage,
+	}, nil
+}
+
+// Setup provisions the Podman container with the repo cloned, built from
+// the repo's devcontainer.json if it has one.
+func (b *PodmanBackend) Setup(ctx context.Context) error {
+	if err := os.MkdirAll(b.hostWorkDir, 0755); err != nil {
+		return fmt.Errorf("failed to create host work dir: %w", err)
+	}
+
+	// Clone on the host so the checkout is owned by the host user
+	if b.config.RepoURL != "" {
+		if err := cloneToHost(ctx, b.config, b.hostWorkDir); err != nil {
+			return fmt.Errorf("failed to clone repo: %w", err)
+		}
+	}
+
+	dc, err := LoadDevContainer(b.hostWorkDir)
+	if err != nil {
+		return err
+	}
+	b.devContainer = dc
+
+	if err := b.prepareImage(ctx); err != nil {
+		return fmt.Errorf("failed to prepare image: %w", err)
+	}
+
+	if err := b.createContainer(ctx); err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+
+	if err := startContainerAgent(ctx, "podman", b.containerID, b.agentPort, b.Exec); err != nil {
+		return fmt.Errorf("failed to setup agent: %w", err)
+	}
+
+	if err := copyClaudeAuthTo(ctx, "podman", b.containerID, podmanHome, b.Exec, b.ExecAsRoot); err != nil {
+		fmt.Printf("Warning: failed to copy Claude auth: %v\n", err)
+	}
+
+	if b.config.Dotfiles != "" {
+		if err := setupContainerDotfiles(ctx, b.config.Dotfiles, podmanHome, b.Exec); err != nil {
+			return fmt.Errorf("failed to setup dotfiles: %w", err)
+		}
+	}
+
+	if b.devContainer != nil {
+		if err := runPostCreate(ctx, b.devContainer, b.Exec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareImage ensures the image exists in the user's podman storage.
+func (b *PodmanBackend) prepareImage(ctx context.Context) error {
+	if b.devContainer != nil {
+		image, err := prepareDevContainerImage(ctx, "podman", b.devContainer)
+		if err != nil {
+			return err
+		}
+		b.imageName = image
+		return nil
+	}
+
+	if err := exec.CommandContext(ctx, "podman", "image", "exists", b.imageName).Run(); err == nil {
+		fmt.Printf("Using existing image: %s\n", b.imageName)
+		return nil
+	}
+
+	fmt.Printf("Building Podman image from nix: %s\n", b.imageName)
+	return loadNixImage(ctx, "podman", b.imageName)
+}
+
+func (b *PodmanBackend) createContainer(ctx context.Context) error {
+	containerName := containerPrefix + b.config.Name
+
+	// Reuse an existing container with this name
+	if output, err := b.podman(ctx, "container", "inspect", "--format", "{{.Id}}", containerName); err == nil {
+		b.containerID = strings.TrimSpace(string(output))
+		if _, err := b.podman(ctx, "start", b.containerID); err != nil {
+			return fmt.Errorf("failed to start existing container: %w", err)
+		}
+		return b.loadAgentPort(ctx)
+	}
+
+	// Host networking keeps previews on localhost like docker, so each
+	// container needs its own agent port
+	port, err := freePort()
+	if err != nil {
+		return err
+	}
+	b.agentPort = port
+
+	args := []string{"run", "-d",
+		"--name", containerName,
+		"--userns=keep-id",
+		"--network", "host",
+		"--label", fmt.Sprintf("%s=%d", podmanAgentPortLabel, port),
+		"--label", managedLabel + "=true",
+		"--env", "HOME=" + podmanHome,
+		"-v", b.hostWorkDir + ":" + b.workDir + ":Z",
+		"-w", b.workDir,
+	}
+	if b.devContainer != nil {
+		for _, e := range b.devContainer.Env() {
+			args = append(args, "--env", e)
+		}
+	}
+	args = append(args, podmanResourceArgs(b.config.Resources)...)
+	args = append(args, b.imageName, "sleep", "infinity")
+	output, err := b.podman(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("podman run: %w", err)
+	}
+	b.containerID = strings.TrimSpace(string(output))
+
+	// Give the mapped user a writable home
+	mkHome := fmt.Sprintf("mkdir -p %s && chown %d:%d %s", podmanHome, os.Getuid(), os.Getgid(), podmanHome)
+	if _, err := b.ExecAsRoot(ctx, mkHome); err != nil {
+		return fmt.Errorf("failed to create home: %w", err)
+	}
+	return nil
+}
+
+// loadAgentPort reads the agent port recorded on the container.
+func (b *PodmanBackend) loadAgentPort(ctx context.Context) error {
+	output, err := b.podman(ctx, "container", "inspect", "--format",
+		fmt.Sprintf(`{{index .Config.Labels %q}}`, podmanAgentPortLabel), b.containerID)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return fmt.Errorf("container has no %s label", podmanAgentPortLabel)
+	}
+	b.agentPort = port
+	return nil
+}
+
+// freePort returns a currently unused TCP port on localhost.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// AgentAddr returns the address to connect to cook-agent.
+// Since we use host networking, it's just localhost:port.
+func (b *PodmanBackend) AgentAddr() string {
+	if b.agentPort == 0 && b.containerID != "" {
+		if err := b.loadAgentPort(context.Background()); err != nil {
+			fmt.Printf("Warning: podman %s: %v\n", b.containerID, err)
+			return ""
+		}
+	}
+	return fmt.Sprintf("localhost:%d", b.agentPort)
+}
+
+// AgentSecret returns the secret the container's cook-agent requires.
+func (b *PodmanBackend) AgentSecret() string {
+	return agentSecret("podman:" + b.containerID)
+}
+
+// podman runs a podman CLI command and returns its stdout.
+func (b *PodmanBackend) podman(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "podman", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return output, nil
+}
+
+// Exec runs a command in the container as the mapped host user and
+// returns combined output.
+func (b *PodmanBackend) Exec(ctx context.Context, cmdStr string) ([]byte, error) {
+	return b.execWithUser(ctx, cmdStr, "")
+}
+
+// ExecAsRoot runs a command in the container as (namespaced) root.
+func (b *PodmanBackend) ExecAsRoot(ctx context.Context, cmdStr string) ([]byte, error) {
+	return b.execWithUser(ctx, cmdStr, "root")
+}
+
+func (b *PodmanBackend) execWithUser(ctx context.Context, cmdStr string, user string) ([]byte, error) {
+	if b.containerID == "" {
+		return nil, fmt.Errorf("container not initialized")
+	}
+
+	args := []string{"exec", "-w", b.workDir}
+	if user != "" {
+		args = append(args, "--user", user)
+	}
+	args = append(args, b.containerID, "sh", "-c", cmdStr)
+
+	return exec.CommandContext(ctx, "podman", args...).CombinedOutput()
+}
+
+// Command returns a podman exec command that runs in the container.
+func (b *PodmanBackend) Command(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	if b.containerID == "" {
+		return nil, fmt.Errorf("container not initialized")
+	}
+
+	podmanArgs := []string{"exec", "-it", "-w", b.workDir, b.containerID, name}
+	podmanArgs = append(podmanArgs, args...)
+
+	return exec.CommandContext(ctx, "podman", podmanArgs...), nil
+}
+
+// ReadFile reads a file from the container (via bind mount on host).
+func (b *PodmanBackend) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	return readHostFile(b.hostWorkDir, path)
+}
+
+// WriteFile writes a file to the container (via bind mount on host).
+func (b *PodmanBackend) WriteFile(ctx context.Context, path string, content []byte) error {
+	return writeHostFile(b.hostWorkDir, path, content)
+}
+
+// ListFiles lists files in a directory.
+func (b *PodmanBackend) ListFiles(ctx context.Context, dir string) ([]FileInfo, error) {
+	return listHostFiles(b.hostWorkDir, dir)
+}
+
+// WorkDir returns the host checkout path.
+func (b *PodmanBackend) WorkDir() string {
+	return b.hostWorkDir
+}
+
+// DevContainer returns the devcontainer.json the container was built
+// from, or nil. It is only known to the backend that ran Setup.
+func (b *PodmanBackend) DevContainer() *DevContainer {
+	return b.devContainer
+}
+
+// ContainerID returns the Podman container ID.
+func (b *PodmanBackend) ContainerID() string {
+	return b.containerID
+}
+
+// Status returns the container status.
+func (b *PodmanBackend) Status(ctx context.Context) (Status, error) {
+	if b.containerID == "" {
+		return Status{State: StateStopped, Message: "container not created"}, nil
+	}
+
+	output, err := b.podman(ctx, "container", "inspect", "--format", "{{.State.Status}}", b.containerID)
+	if err != nil {
+		return Status{State: StateError, Message: err.Error()}, nil
+	}
+
+	status := strings.TrimSpace(string(output))
+	state := StateStopped
+	switch status {
+	case "running":
+		state = StateRunning
+	case "created", "initialized", "restarting":
+		state = StateStarting
+	case "dead":
+		state = StateError
+	}
+
+	id := b.containerID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return Status{State: state, Message: status, ID: id}, nil
+}
+
+// Stop stops the container but keeps it (and the host checkout) around.
+func (b *PodmanBackend) Stop(ctx context.Context) error {
+	if b.containerID == "" {
+		return nil
+	}
+	if _, err := b.podman(ctx, "stop", b.containerID); err != nil {
+		return fmt.Errorf("failed to stop container: %w", err)
+	}
+	return nil
+}
+
+// Start starts a stopped container and restarts cook-agent in it.
+func (b *PodmanBackend) Start(ctx context.Context) error {
+	if b.containerID == "" {
+		return fmt.Errorf("backend not initialized: call Setup() first")
+	}
+	if _, err := b.podman(ctx, "start", b.containerID); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+	if b.agentPort == 0 {
+		if err := b.loadAgentPort(ctx); err != nil {
+			return err
+		}
+	}
+	return startContainerAgent(ctx, "podman", b.containerID, b.agentPort, b.Exec)
+}
+
+// Teardown stops and removes the container.
+func (b *PodmanBackend) Teardown(ctx context.Context) error {
+	if b.containerID == "" {
+		return nil
+	}
+
+	if _, err := b.podman(ctx, "rm", "-f", b.containerID); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+
+	// Remove host work directory
+	if b.hostWorkDir != "" {
+		os.RemoveAll(b.hostWorkDir)
+	}
+
+	b.containerID = ""
+	return nil
+}
+
+// podmanResourceArgs maps r onto podman run flags. Like docker, DiskGB
+// and Timeout are ignored.
+func podmanResourceArgs(r Resources) []string {
+	var args []string
+	if r.CPUs > 0 {
+		args = append(args, "--cpus", strconv.Itoa(r.CPUs))
+	}
+	if r.MemoryMB > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%dm", r.MemoryMB))
+	}
+	if r.HasGPU() {
+		args = append(args, "--device", "nvidia.com/gpu=all")
+	}
+	return args
+}
+
+// Ensure PodmanBackend implements Backend and Stopper
+var (
+	_ Backend = (*PodmanBackend)(nil)
+	_ Stopper = (*PodmanBackend)(nil)
+)
@@ -0,0 +1,102 @@
+package env
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/justinmoon/cook/internal/envagent"
+)
+
+func TestResolveHostPath(t *testing.T) {
+	root := t.TempDir()
+
+	got, err := resolveHostPath(root, "src/main.go")
+	if err != nil {
+		t.Fatalf("resolveHostPath: %v", err)
+	}
+	realRoot, _ := filepath.EvalSymlinks(root)
+	if want := filepath.Join(realRoot, "src", "main.go"); got != want {
+		t.Errorf("resolveHostPath = %q, want %q", got, want)
+	}
+
+	// Leading ".." is clamped to the root rather than escaping it
+	got, err = resolveHostPath(root, "../../etc/passwd")
+	if err != nil {
+		t.Fatalf("resolveHostPath: %v", err)
+	}
+	if !strings.HasPrefix(got, realRoot+string(filepath.Separator)) {
+		t.Errorf("resolveHostPath escaped root: %q", got)
+	}
+}
+
+func TestPodmanBackend_Integration(t *testing.T) {
+	if _, err := exec.LookPath("podman"); err != nil {
+		t.Skip("podman not found, skipping Podman integration test")
+	}
+	if os.Getenv("COOK_TEST_PODMAN") == "" {
+		t.Skip("COOK_TEST_PODMAN not set, skipping Podman integration test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "src")
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master", src},
+		{"-C", src, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, output)
+		}
+	}
+
+	backend, err := NewPodmanBackend(Config{
+		Name:       "test-podman-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		RepoURL:    src,
+		BranchName: "feature",
+		WorkDir:    filepath.Join(tmp, "checkout"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Podman backend: %v", err)
+	}
+	defer backend.Teardown(context.Background())
+
+	if err := envagent.LoadKey(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Setup(ctx); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Files created in the container must be owned by the host user
+	if output, err := backend.Exec(ctx, "touch from-container"); err != nil {
+		t.Fatalf("Exec failed: %v: %s", err, output)
+	}
+	info, err := os.Stat(filepath.Join(tmp, "checkout", "from-container"))
+	if err != nil {
+		t.Fatalf("file not visible on host: %v", err)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		t.Errorf("file owned by uid %d, want %d", st.Uid, os.Getuid())
+	}
+
+	reconnected, err := NewPodmanBackendFromExisting(backend.ContainerID(), backend.WorkDir())
+	if err != nil {
+		t.Fatalf("NewPodmanBackendFromExisting: %v", err)
+	}
+	if reconnected.AgentAddr() != backend.AgentAddr() {
+		t.Errorf("reconnected agent addr = %q, want %q", reconnected.AgentAddr(), backend.AgentAddr())
+	}
+	status, err := reconnected.Status(ctx)
+	if err != nil || status.State != StateRunning {
+		t.Errorf("Status = %+v, %v", status, err)
+	}
+}
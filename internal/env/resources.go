@@ -0,0 +1,30 @@
+package env
+
+import (
+	"fmt"
+	"time"
+)
+
+// Resources are the limits an environment runs with, usually from a named
+// profile in the server config. Each backend maps them onto its native
+// limits; a zero field leaves the backend's default.
+type Resources struct {
+	CPUs     int           `toml:"cpus" json:"cpus,omitempty"`
+	MemoryMB int           `toml:"memory_mb" json:"memory_mb,omitempty"`
+	DiskGB   int           `toml:"disk_gb" json:"disk_gb,omitempty"`
+	GPU      string        `toml:"gpu" json:"gpu,omitempty"`         // "none" (or empty), or a backend GPU type, e.g. "a10", "A100:2"
+	Timeout  time.Duration `toml:"timeout" json:"timeout,omitempty"` // maximum environment lifetime
+}
+
+// HasGPU reports whether r asks for a GPU.
+func (r Resources) HasGPU() bool {
+	return r.GPU != "" && r.GPU != "none"
+}
+
+// Validate checks r for negative limits.
+func (r Resources) Validate() error {
+	if r.CPUs < 0 || r.MemoryMB < 0 || r.DiskGB < 0 || r.Timeout < 0 {
+		return fmt.Errorf("resource limits cannot be negative")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/modal-labs/libmodal/modal-go"
+)
+
+func TestResourceMapping(t *testing.T) {
+	r := Resources{CPUs: 4, MemoryMB: 8192, DiskGB: 50, GPU: "a10", Timeout: 2 * time.Hour}
+
+	docker := dockerResources(r)
+	if docker.NanoCPUs != 4e9 || docker.Memory != 8192<<20 || len(docker.DeviceRequests) != 1 {
+		t.Errorf("dockerResources = %+v", docker)
+	}
+	if got := dockerResources(Resources{GPU: "none"}); got.NanoCPUs != 0 || got.Memory != 0 || got.DeviceRequests != nil {
+		t.Errorf("dockerResources(zero) = %+v, want no limits", got)
+	}
+
+	wantArgs := []string{"--cpus", "4", "--memory", "8192m", "--device", "nvidia.com/gpu=all"}
+	if got := podmanResourceArgs(r); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("podmanResourceArgs = %v, want %v", got, wantArgs)
+	}
+
+	var params modal.SandboxCreateParams
+	applyModalResources(&params, r)
+	if params.CPU != 4 || params.MemoryMiB != 8192 || params.GPU != "a10" || params.Timeout != 2*time.Hour {
+		t.Errorf("applyModalResources = %+v", params)
+	}
+
+	guest := flyMachinesGuest(r)
+	if guest.CPUs != 4 || guest.MemoryMB != 8192 || guest.GPUKind != "a10" || guest.GPUs != 1 {
+		t.Errorf("flyMachinesGuest = %+v", guest)
+	}
+	if guest := flyMachinesGuest(Resources{}); guest.CPUs != 1 || guest.MemoryMB != 1024 || guest.GPUs != 0 {
+		t.Errorf("flyMachinesGuest(zero) = %+v, want 1 CPU and 1024MB", guest)
+	}
+
+	sprite := spriteConfig(r)
+	if sprite == nil || sprite.CPUs != 4 || sprite.RamMB != 8192 || sprite.StorageGB != 50 {
+		t.Errorf("spriteConfig = %+v", sprite)
+	}
+}
+
+func TestResourceEnvDefaults(t *testing.T) {
+	t.Setenv("FLY_MACHINES_CPUS", "2")
+	t.Setenv("FLY_MACHINES_MEMORY_MB", "2048")
+	t.Setenv("SPRITES_CPUS", "2")
+	t.Setenv("SPRITES_RAM_MB", "2048")
+	t.Setenv("SPRITES_STORAGE_GB", "20")
+
+	// The environment sizes machines without a profile
+	if guest := flyMachinesGuest(Resources{}); guest.CPUs != 2 || guest.MemoryMB != 2048 {
+		t.Errorf("flyMachinesGuest(zero) = %+v, want 2 CPUs and 2048MB", guest)
+	}
+	if sprite := spriteConfig(Resources{}); sprite == nil || sprite.CPUs != 2 || sprite.RamMB != 2048 || sprite.StorageGB != 20 {
+		t.Errorf("spriteConfig(zero) = %+v", sprite)
+	}
+
+	// and a profile overrides it field by field
+	if guest := flyMachinesGuest(Resources{CPUs: 8}); guest.CPUs != 8 || guest.MemoryMB != 2048 {
+		t.Errorf("flyMachinesGuest(8 CPUs) = %+v", guest)
+	}
+	if sprite := spriteConfig(Resources{MemoryMB: 8192}); sprite.CPUs != 2 || sprite.RamMB != 8192 || sprite.StorageGB != 20 {
+		t.Errorf("spriteConfig(8GB) = %+v", sprite)
+	}
+}
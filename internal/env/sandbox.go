@@ -0,0 +1,233 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// NetworkNone disables networking inside the sandbox entirely.
+	NetworkNone = "none"
+
+	// sandboxProxySocket is where the allowlist proxy socket is mounted
+	// inside the sandbox, and sandboxProxyPort where socat exposes it.
+	sandboxProxySocket = "/tmp/cook-proxy.sock"
+	sandboxProxyPort   = 3128
+)
+
+// SandboxBackend runs commands from a local checkout inside a bubblewrap
+// sandbox. Processes see the host's system directories and nix store
+// read-only, the checkout read-write, a private HOME (the checkout's .home
+// from LocalBackend.SetupHome) and nothing else of the host's home.
+//
+// Networking follows Config.Network: empty shares the host network,
+// ["none"] disables it, and a list of hosts ("github.com", "*.anthropic.com")
+// only allows HTTP(S) to those hosts through a filtering proxy.
+type SandboxBackend struct {
+	*LocalBackend
+	network []string
+}
+
+// NewSandboxBackend creates a new sandbox backend with the given config.
+func NewSandboxBackend(cfg Config) (*SandboxBackend, error) {
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		return nil, fmt.Errorf("bubblewrap (bwrap) not found: %w", err)
+	}
+	return &SandboxBackend{
+		LocalBackend: NewLocalBackend(cfg),
+		network:      cfg.Network,
+	}, nil
+}
+
+// Setup clones the checkout like the local backend and prepares the
+// sandbox's private home.
+func (b *SandboxBackend) Setup(ctx context.Context) error {
+	if err := b.LocalBackend.Setup(ctx); err != nil {
+		return err
+	}
+	return b.SetupHome(ctx)
+}
+
+// SetupHome creates the private home and copies in the host's agent
+// credentials and git identity, since the host home is not visible.
+func (b *SandboxBackend) SetupHome(ctx context.Context) error {
+	if err := b.LocalBackend.SetupHome(ctx); err != nil {
+		return err
+	}
+	if err := copyHostAuth(b.homeDir); err != nil {
+		fmt.Printf("Warning: failed to copy auth into sandbox home: %v\n", err)
+	}
+	return nil
+}
+
+// copyHostAuth copies Claude credentials and ~/.gitconfig into home.
+// Files are copied rather than symlinked so the sandbox can read them.
+func copyHostAuth(home string) error {
+	hostHome, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	files := []string{
+		".claude.json",
+		".gitconfig",
+		filepath.Join(".claude", ".credentials.json"),
+		filepath.Join(".claude", "settings.json"),
+		filepath.Join(".claude", "settings.local.json"),
+	}
+	for _, name := range files {
+		content, err := os.ReadFile(filepath.Join(hostHome, name))
+		if err != nil {
+			// Not an error - file might not exist
+			continue
+		}
+		dst := filepath.Join(home, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, content, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exec runs a command in the sandbox and returns combined output.
+func (b *SandboxBackend) Exec(ctx context.Context, cmdStr string) ([]byte, error) {
+	cmd, err := b.Command(ctx, "sh", "-c", cmdStr)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.CombinedOutput()
+}
+
+// Command returns an *exec.Cmd that runs name inside the sandbox.
+func (b *SandboxBackend) Command(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	if b.workDir == "" {
+		return nil, fmt.Errorf("backend not initialized: call Setup() first")
+	}
+	bwrapArgs, err := b.bwrapArgs(b.workDir, name, args)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "bwrap", bwrapArgs...)
+	cmd.Dir = b.workDir
+	cmd.Env = b.buildEnv()
+	return cmd, nil
+}
+
+// Wrap returns a copy of cmd (e.g. from agent.Spawn) that runs inside the
+// sandbox, keeping its arguments, directory and environment except HOME.
+func (b *SandboxBackend) Wrap(cmd *exec.Cmd) (*exec.Cmd, error) {
+	dir := cmd.Dir
+	if dir == "" {
+		dir = b.workDir
+	}
+	bwrapArgs, err := b.bwrapArgs(dir, cmd.Path, cmd.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	environ := cmd.Env
+	if environ == nil {
+		environ = os.Environ()
+	}
+	wrapped := exec.Command("bwrap", bwrapArgs...)
+	wrapped.Dir = dir
+	wrapped.Env = append(withoutEnv(environ, "HOME"), "HOME="+b.homeDir)
+	return wrapped, nil
+}
+
+// bwrapArgs builds the bubblewrap command line that runs name in dir.
+func (b *SandboxBackend) bwrapArgs(dir, name string, args []string) ([]string, error) {
+	bwrapArgs := []string{
+		"--die-with-parent",
+		"--unshare-pid", "--unshare-ipc", "--unshare-uts", "--unshare-cgroup-try",
+		"--proc", "/proc",
+		"--dev", "/dev",
+		"--tmpfs", "/tmp",
+		"--tmpfs", "/run",
+	}
+
+	// System directories and the nix store, read-only. /run/current-system
+	// is NixOS's profile; /run/systemd/resolve backs resolv.conf on systemd.
+	for _, dir := range []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/etc", "/opt", "/nix", "/run/current-system", "/run/systemd/resolve"} {
+		if _, err := os.Lstat(dir); err == nil {
+			bwrapArgs = append(bwrapArgs, "--ro-bind", dir, dir)
+		}
+	}
+
+	bwrapArgs = append(bwrapArgs,
+		"--bind", b.workDir, b.workDir,
+		"--setenv", "HOME", b.homeDir,
+		"--chdir", dir,
+	)
+
+	command := append([]string{name}, args...)
+	switch {
+	case len(b.network) == 0:
+		// Share the host network
+	case len(b.network) == 1 && b.network[0] == NetworkNone:
+		bwrapArgs = append(bwrapArgs, "--unshare-net")
+	default:
+		socket, err := startSandboxProxy(b.proxySocketPath(), b.network)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start network proxy: %w", err)
+		}
+		socat, err := exec.LookPath("socat")
+		if err != nil {
+			return nil, fmt.Errorf("socat is required for a network allowlist: %w", err)
+		}
+		// The sandbox has only loopback; socat bridges a loopback port to
+		// the proxy socket, and the proxy enforces the allowlist
+		proxyURL := fmt.Sprintf("http://127.0.0.1:%d", sandboxProxyPort)
+		bwrapArgs = append(bwrapArgs, "--unshare-net", "--bind", socket, sandboxProxySocket)
+		for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "http_proxy", "https_proxy", "all_proxy"} {
+			bwrapArgs = append(bwrapArgs, "--setenv", key, proxyURL)
+		}
+		bwrapArgs = append(bwrapArgs, "--setenv", "NO_PROXY", "localhost,127.0.0.1", "--setenv", "no_proxy", "localhost,127.0.0.1")
+		bridge := fmt.Sprintf(`%s TCP-LISTEN:%d,bind=127.0.0.1,fork,reuseaddr UNIX-CONNECT:%s & exec "$@"`,
+			socat, sandboxProxyPort, sandboxProxySocket)
+		command = append([]string{"/bin/sh", "-c", bridge, "sh"}, command...)
+	}
+
+	return append(append(bwrapArgs, "--"), command...), nil
+}
+
+// proxySocketPath is the host path of this checkout's allowlist proxy.
+// It lives in the temp dir because unix socket paths are length-limited.
+func (b *SandboxBackend) proxySocketPath() string {
+	h := fnv.New32a()
+	h.Write([]byte(b.workDir))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("cook-proxy-%08x.sock", h.Sum32()))
+}
+
+// Network returns the sandbox's network policy.
+func (b *SandboxBackend) Network() []string {
+	return b.network
+}
+
+// Teardown stops the network proxy and removes the checkout.
+func (b *SandboxBackend) Teardown(ctx context.Context) error {
+	stopSandboxProxy(b.proxySocketPath())
+	return b.LocalBackend.Teardown(ctx)
+}
+
+// withoutEnv returns environ without key.
+func withoutEnv(environ []string, key string) []string {
+	result := make([]string, 0, len(environ))
+	for _, e := range environ {
+		if !strings.HasPrefix(e, key+"=") {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Ensure SandboxBackend implements Backend
+var _ Backend = (*SandboxBackend)(nil)
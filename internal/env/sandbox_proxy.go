@@ -0,0 +1,144 @@
+package env
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sandboxProxies holds the running allowlist proxies, keyed by socket path.
+// They outlive individual backend values, which are created per request.
+var (
+	sandboxProxiesMu sync.Mutex
+	sandboxProxies   = map[string]*sandboxProxy{}
+)
+
+// sandboxProxy is an HTTP proxy on a unix socket that only lets requests
+// (plain or CONNECT) through to allowlisted hosts.
+type sandboxProxy struct {
+	listener net.Listener
+	allow    []string
+}
+
+// startSandboxProxy starts (or reuses) the proxy listening at socket.
+func startSandboxProxy(socket string, allow []string) (string, error) {
+	sandboxProxiesMu.Lock()
+	defer sandboxProxiesMu.Unlock()
+
+	if p, ok := sandboxProxies[socket]; ok {
+		p.allow = allow
+		return socket, nil
+	}
+
+	// A socket left behind by a previous server has no listener
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return "", err
+	}
+	p := &sandboxProxy{listener: l, allow: allow}
+	sandboxProxies[socket] = p
+	go p.serve()
+	return socket, nil
+}
+
+// stopSandboxProxy closes the proxy listening at socket, if any.
+func stopSandboxProxy(socket string) {
+	sandboxProxiesMu.Lock()
+	defer sandboxProxiesMu.Unlock()
+
+	if p, ok := sandboxProxies[socket]; ok {
+		p.listener.Close()
+		delete(sandboxProxies, socket)
+	}
+}
+
+func (p *sandboxProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *sandboxProxy) allowed(host string) bool {
+	sandboxProxiesMu.Lock()
+	allow := p.allow
+	sandboxProxiesMu.Unlock()
+	return hostAllowed(host, allow)
+}
+
+func (p *sandboxProxy) handle(conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+
+	host := req.URL.Hostname()
+	port := req.URL.Port()
+	if req.Method == http.MethodConnect {
+		host, port, _ = net.SplitHostPort(req.Host)
+	}
+	if port == "" {
+		port = "80"
+	}
+
+	if !p.allowed(host) {
+		log.Printf("sandbox: blocked %s %s", req.Method, host)
+		fmt.Fprintf(conn, "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\n\r\n%s is not in the sandbox network allowlist\n", host)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), 30*time.Second)
+	if err != nil {
+		fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer upstream.Close()
+
+	if req.Method == http.MethodConnect {
+		fmt.Fprintf(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		go io.Copy(upstream, br)
+		io.Copy(conn, upstream)
+		return
+	}
+
+	// Forward the plain request in origin form. The connection is closed
+	// after one response so a later request can't reach a different host.
+	req.RequestURI = ""
+	req.Header.Del("Proxy-Connection")
+	req.Header.Set("Connection", "close")
+	if err := req.Write(upstream); err != nil {
+		return
+	}
+	io.Copy(conn, upstream)
+}
+
+// hostAllowed reports whether host matches an allowlist entry. Entries are
+// exact hostnames or "*.example.com", which matches subdomains only.
+func hostAllowed(host string, allow []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range allow {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
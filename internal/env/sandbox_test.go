@@ -0,0 +1,127 @@
+package env
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHostAllowed(t *testing.T) {
+	allow := []string{"github.com", "*.anthropic.com"}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"github.com", true},
+		{"GitHub.com.", true},
+		{"api.github.com", false},
+		{"api.anthropic.com", true},
+		{"anthropic.com", false},
+		{"evilanthropic.com", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		if got := hostAllowed(tt.host, allow); got != tt.want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestSandboxProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer upstream.Close()
+
+	socket := filepath.Join(t.TempDir(), "proxy.sock")
+	if _, err := startSandboxProxy(socket, []string{"127.0.0.1"}); err != nil {
+		t.Fatalf("startSandboxProxy: %v", err)
+	}
+	defer stopSandboxProxy(socket)
+
+	get := func(url string) string {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			t.Fatalf("dial proxy: %v", err)
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: x\r\n\r\n", url)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		return resp.Status
+	}
+
+	if status := get(upstream.URL + "/"); !strings.HasPrefix(status, "200") {
+		t.Errorf("allowed host: status %q", status)
+	}
+	if status := get("http://localhost:1/"); !strings.HasPrefix(status, "403") {
+		t.Errorf("blocked host: status %q", status)
+	}
+}
+
+func TestSandboxBwrapArgs(t *testing.T) {
+	workDir := t.TempDir()
+	b := &SandboxBackend{LocalBackend: NewLocalBackendFromPath(workDir)}
+
+	args, err := b.bwrapArgs(workDir, "sh", []string{"-c", "true"})
+	if err != nil {
+		t.Fatalf("bwrapArgs: %v", err)
+	}
+	joined := strings.Join(args, " ")
+	for _, want := range []string{
+		"--bind " + workDir + " " + workDir,
+		"--setenv HOME " + filepath.Join(workDir, ".home"),
+		"-- sh -c true",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("bwrap args missing %q: %s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "--unshare-net") {
+		t.Errorf("host network should be shared by default: %s", joined)
+	}
+
+	b.network = []string{NetworkNone}
+	args, _ = b.bwrapArgs(workDir, "sh", nil)
+	if !strings.Contains(strings.Join(args, " "), "--unshare-net") {
+		t.Errorf("network none should unshare net: %v", args)
+	}
+}
+
+func TestSandboxBackend_Confined(t *testing.T) {
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not found, skipping sandbox test")
+	}
+
+	workDir := t.TempDir()
+	b, err := NewSandboxBackend(Config{WorkDir: workDir, Network: []string{NetworkNone}})
+	if err != nil {
+		t.Fatalf("NewSandboxBackend: %v", err)
+	}
+	if err := b.SetupHome(context.Background()); err != nil {
+		t.Fatalf("SetupHome: %v", err)
+	}
+
+	output, err := b.Exec(context.Background(), "echo $HOME && touch ok")
+	if err != nil {
+		t.Fatalf("Exec: %v: %s", err, output)
+	}
+	if !strings.HasPrefix(string(output), filepath.Join(workDir, ".home")) {
+		t.Errorf("HOME = %q", output)
+	}
+	if _, err := b.ReadFile(context.Background(), "ok"); err != nil {
+		t.Errorf("file written in sandbox not in checkout: %v", err)
+	}
+	if output, err := b.Exec(context.Background(), "echo > /etc/cook-test"); err == nil {
+		t.Errorf("write to /etc succeeded: %s", output)
+	}
+}
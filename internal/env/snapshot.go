@@ -0,0 +1,199 @@
+package env
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Snapshotter is an optional interface for backends that can checkpoint
+// their environment and roll back to it later.
+type Snapshotter interface {
+	// Snapshot checkpoints the environment and returns the snapshot's ID
+	Snapshot(ctx context.Context) (string, error)
+
+	// RestoreSnapshot rolls the environment back to a snapshot
+	RestoreSnapshot(ctx context.Context, id string) error
+
+	// DeleteSnapshot discards a snapshot
+	DeleteSnapshot(ctx context.Context, id string) error
+}
+
+// newSnapshotID returns a sortable, unique snapshot ID.
+func newSnapshotID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// SnapshotDir is where snapshots of the checkout at workDir are kept. It
+// sits next to the checkout so it survives restores and is on the same
+// filesystem for copy-on-write clones.
+func SnapshotDir(workDir string) string {
+	return filepath.Clean(workDir) + ".snapshots"
+}
+
+// snapshotWorkDir copies workDir into snapshot id. It uses a btrfs snapshot
+// if workDir is a subvolume, a reflink copy if the filesystem supports them
+// (btrfs, XFS, bcachefs) and a compressed tarball otherwise.
+func snapshotWorkDir(ctx context.Context, workDir, id string) error {
+	root := SnapshotDir(workDir)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot dir: %w", err)
+	}
+	dst := filepath.Join(root, id)
+
+	if isBtrfsSubvolume(ctx, workDir) {
+		output, err := exec.CommandContext(ctx, "btrfs", "subvolume", "snapshot", workDir, dst).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("Warning: btrfs snapshot failed, copying instead: %s\n", output)
+	}
+
+	if err := os.Mkdir(dst, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	if err := exec.CommandContext(ctx, "cp", "-a", "--reflink=always", workDir+"/.", dst+"/").Run(); err == nil {
+		return nil
+	}
+	os.RemoveAll(dst)
+
+	cmd := exec.CommandContext(ctx, "tar", "-C", workDir, "-czf", dst+".tar.gz", ".")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(dst + ".tar.gz")
+		return fmt.Errorf("tar failed: %s: %w", output, err)
+	}
+	return nil
+}
+
+// restoreWorkDir replaces the contents of workDir with snapshot id. The
+// snapshot is unpacked beside the checkout first and swapped in only once
+// that succeeds, so a failed restore leaves the checkout as it was. The
+// directory itself is kept so processes running in it see the rollback.
+func restoreWorkDir(ctx context.Context, workDir, id string) error {
+	root := SnapshotDir(workDir)
+	src := filepath.Join(root, id)
+
+	tarball := false
+	if _, err := os.Stat(src + ".tar.gz"); err == nil {
+		tarball = true
+	} else if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("snapshot %s not found", id)
+	}
+
+	staging, err := os.MkdirTemp(root, ".restore-")
+	if err != nil {
+		return fmt.Errorf("failed to create restore dir: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	cmd := exec.CommandContext(ctx, "cp", "-a", "--reflink=auto", src+"/.", staging+"/")
+	if tarball {
+		cmd = exec.CommandContext(ctx, "tar", "-C", staging, "-xzf", src+".tar.gz")
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("restore failed: %s: %w", output, err)
+	}
+
+	// Swap by renaming, which can't fail halfway through a file
+	replaced, err := os.MkdirTemp(root, ".replaced-")
+	if err != nil {
+		return fmt.Errorf("failed to set checkout aside: %w", err)
+	}
+	defer os.RemoveAll(replaced)
+	if err := moveEntries(workDir, replaced); err != nil {
+		moveEntries(replaced, workDir)
+		return fmt.Errorf("failed to set checkout aside: %w", err)
+	}
+	if err := moveEntries(staging, workDir); err != nil {
+		clearDir(workDir)
+		moveEntries(replaced, workDir)
+		return fmt.Errorf("failed to swap in snapshot: %w", err)
+	}
+	return nil
+}
+
+// moveEntries renames everything inside src into dst, which must be on
+// the same filesystem.
+func moveEntries(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteWorkDirSnapshot removes snapshot id of workDir.
+func deleteWorkDirSnapshot(ctx context.Context, workDir, id string) error {
+	src := filepath.Join(SnapshotDir(workDir), id)
+	if isBtrfsSubvolume(ctx, src) {
+		if err := exec.CommandContext(ctx, "btrfs", "subvolume", "delete", src).Run(); err == nil {
+			return nil
+		}
+	}
+	if err := os.RemoveAll(src); err != nil {
+		return err
+	}
+	return os.RemoveAll(src + ".tar.gz")
+}
+
+// isBtrfsSubvolume reports whether dir is the root of a btrfs subvolume.
+func isBtrfsSubvolume(ctx context.Context, dir string) bool {
+	if _, err := exec.LookPath("btrfs"); err != nil {
+		return false
+	}
+	return exec.CommandContext(ctx, "btrfs", "subvolume", "show", dir).Run() == nil
+}
+
+// clearDir removes everything inside dir, but not dir itself.
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot checkpoints the checkout, including .git and the private home.
+func (b *LocalBackend) Snapshot(ctx context.Context) (string, error) {
+	if b.workDir == "" {
+		return "", fmt.Errorf("backend not initialized: call Setup() first")
+	}
+	id := newSnapshotID()
+	if err := snapshotWorkDir(ctx, b.workDir, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// RestoreSnapshot rolls the checkout back to a snapshot.
+func (b *LocalBackend) RestoreSnapshot(ctx context.Context, id string) error {
+	return restoreWorkDir(ctx, b.workDir, id)
+}
+
+// DeleteSnapshot discards a snapshot.
+func (b *LocalBackend) DeleteSnapshot(ctx context.Context, id string) error {
+	return deleteWorkDirSnapshot(ctx, b.workDir, id)
+}
+
+// Ensure LocalBackend (and so SandboxBackend) implements Snapshotter
+var (
+	_ Snapshotter = (*LocalBackend)(nil)
+	_ Snapshotter = (*SandboxBackend)(nil)
+)
@@ -0,0 +1,84 @@
+package env
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalBackendSnapshot(t *testing.T) {
+	ctx := context.Background()
+	workDir := filepath.Join(t.TempDir(), "checkout")
+	if err := os.MkdirAll(filepath.Join(workDir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "src", "main.go"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewLocalBackendFromPath(workDir)
+	id, err := backend.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	// Change, add and delete files after the snapshot
+	os.WriteFile(filepath.Join(workDir, "src", "main.go"), []byte("v2"), 0644)
+	os.WriteFile(filepath.Join(workDir, "new.txt"), []byte("new"), 0644)
+	os.RemoveAll(filepath.Join(workDir, "src"))
+
+	if err := backend.RestoreSnapshot(ctx, id); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(workDir, "src", "main.go"))
+	if err != nil || string(content) != "v1" {
+		t.Errorf("main.go after restore = %q, %v; want v1", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("new.txt survived restore")
+	}
+
+	if err := backend.DeleteSnapshot(ctx, id); err != nil {
+		t.Fatalf("DeleteSnapshot: %v", err)
+	}
+	entries, _ := os.ReadDir(SnapshotDir(workDir))
+	if len(entries) != 0 {
+		t.Errorf("snapshot dir not empty after delete: %v", entries)
+	}
+	if err := backend.RestoreSnapshot(ctx, id); err == nil {
+		t.Error("expected error restoring a deleted snapshot")
+	}
+}
+
+func TestLocalBackendFailedRestoreKeepsCheckout(t *testing.T) {
+	ctx := context.Background()
+	workDir := filepath.Join(t.TempDir(), "checkout")
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "main.go"), []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A corrupt tarball fails to unpack
+	if err := os.MkdirAll(SnapshotDir(workDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(SnapshotDir(workDir), "broken.tar.gz"), []byte("not a tarball"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewLocalBackendFromPath(workDir)
+	if err := backend.RestoreSnapshot(ctx, "broken"); err == nil {
+		t.Fatal("expected error restoring a corrupt snapshot")
+	}
+	content, err := os.ReadFile(filepath.Join(workDir, "main.go"))
+	if err != nil || string(content) != "v2" {
+		t.Errorf("main.go after failed restore = %q, %v; want v2", content, err)
+	}
+	entries, _ := os.ReadDir(SnapshotDir(workDir))
+	if len(entries) != 1 {
+		t.Errorf("restore left files in the snapshot dir: %v", entries)
+	}
+}
@@ -2,6 +2,7 @@ package env
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -93,12 +94,31 @@ func NewSpritesBackendFromSpriteName(spriteName, hostWorkDir string) (*SpritesBa
 
 // Setup provisions the sprite with the repo cloned and tools installed.
 func (b *SpritesBackend) Setup(ctx context.Context) error {
+	if err := b.Warm(ctx); err != nil {
+		return err
+	}
+	if err := b.Claim(ctx, b.config); err != nil {
+		return err
+	}
+
+	if shouldAutoCheckpoint() {
+		if _, err := b.Checkpoint(ctx); err != nil {
+			fmt.Printf("Warning: failed to checkpoint sprite: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// Warm creates the sprite, installs tools and starts cook-agent without
+// cloning a repo.
+func (b *SpritesBackend) Warm(ctx context.Context) error {
 	if b.client == nil {
 		return fmt.Errorf("sprites client not initialized")
 	}
 
 	if b.sprite == nil {
-		sprite, err := b.client.CreateSprite(ctx, b.spriteName, spriteConfigFromEnv())
+		sprite, err := b.client.CreateSprite(ctx, b.spriteName, spriteConfig(b.config.Resources))
 		if err != nil {
 			return fmt.Errorf("failed to create sprite: %w", err)
 		}
@@ -122,12 +142,6 @@ func (b *SpritesBackend) Setup(ctx context.Context) error {
 		return fmt.Errorf("failed to setup tailnet: %w", err)
 	}
 
-	if b.config.RepoURL != "" {
-		if err := b.cloneRepo(ctx); err != nil {
-			return fmt.Errorf("failed to clone repo: %w", err)
-		}
-	}
-
 	if err := b.setupAgent(ctx); err != nil {
 		return fmt.Errorf("failed to setup agent: %w", err)
 	}
@@ -136,19 +150,26 @@ func (b *SpritesBackend) Setup(ctx context.Context) error {
 		fmt.Printf("Warning: failed to copy Claude auth: %v\n", err)
 	}
 
-	if b.config.Dotfiles != "" {
-		if err := b.setupDotfiles(ctx); err != nil {
-			return fmt.Errorf("failed to setup dotfiles: %w", err)
-		}
-	}
-
 	if err := b.ensureAgentProxy(ctx); err != nil {
 		return fmt.Errorf("failed to create agent proxy: %w", err)
 	}
 
-	if shouldAutoCheckpoint() {
-		if _, err := b.Checkpoint(ctx); err != nil {
-			fmt.Printf("Warning: failed to checkpoint sprite: %v\n", err)
+	return nil
+}
+
+// Claim clones cfg's repo into a warm sprite and applies its dotfiles.
+func (b *SpritesBackend) Claim(ctx context.Context, cfg Config) error {
+	b.config = cfg
+
+	if b.config.RepoURL != "" {
+		if err := b.cloneRepo(ctx); err != nil {
+			return fmt.Errorf("failed to clone repo: %w", err)
+		}
+	}
+
+	if b.config.Dotfiles != "" {
+		if err := b.setupDotfiles(ctx); err != nil {
+			return fmt.Errorf("failed to setup dotfiles: %w", err)
 		}
 	}
 
@@ -263,6 +284,11 @@ func (b *SpritesBackend) cloneRepo(ctx context.Context) error {
 }
 
 func (b *SpritesBackend) setupAgent(ctx context.Context) error {
+	secretEnv, err := agentSecretEnv("sprites:" + b.spriteName)
+	if err != nil {
+		return err
+	}
+
 	agentBinary, err := findAgentBinary()
 	if err != nil {
 		return fmt.Errorf("cook-agent binary not found: %w", err)
@@ -278,7 +304,7 @@ func (b *SpritesBackend) setupAgent(ctx context.Context) error {
 		return fmt.Errorf("failed to write cook-agent: %w", err)
 	}
 
-	startCmd := fmt.Sprintf("nohup /tmp/cook-agent -listen :%d > /tmp/cook-agent.log 2>&1 &", spritesAgentPort)
+	startCmd := fmt.Sprintf("%snohup /tmp/cook-agent -listen :%d -state %s > /tmp/cook-agent.log 2>&1 &", secretEnv, spritesAgentPort, agentStatePath)
 	if output, err := b.execWithEnv(ctx, "/", startCmd); err != nil {
 		return fmt.Errorf("failed to start cook-agent: %w: %s", err, string(output))
 	}
@@ -293,7 +319,9 @@ func (b *SpritesBackend) setupAgent(ctx context.Context) error {
 
 func (b *SpritesBackend) waitForAgent(ctx context.Context) error {
 	for i := 0; i < 20; i++ {
-		output, _ := b.execWithEnv(ctx, "/", fmt.Sprintf("nc -z localhost %d && echo OK || echo FAIL", spritesAgentPort))
+		output, _ := b.execWithEnv(ctx, "/", fmt.Sprintf(
+			"{ if command -v curl >/dev/null 2>&1; then curl -fsS http://localhost:%d/healthz; else nc -z localhost %d; fi; } && echo OK || echo FAIL",
+			spritesAgentPort, spritesAgentPort))
 		if strings.Contains(string(output), "OK") {
 			return nil
 		}
@@ -409,7 +437,11 @@ func (b *SpritesBackend) ensureAgentRunning(ctx context.Context) error {
 
 // Exec runs a command in the sprite and returns combined output.
 func (b *SpritesBackend) Exec(ctx context.Context, cmdStr string) ([]byte, error) {
-	output, err := b.execWithEnv(ctx, b.workDir, cmdStr)
+	output, err := agentExec(ctx, b.agentAddr, b.AgentSecret(), b.workDir, cmdStr, b.execEnv())
+	if !errors.Is(err, errAgentUnavailable) {
+		return output, err
+	}
+	output, err = b.execWithEnv(ctx, b.workDir, cmdStr)
 	if err != nil {
 		return output, err
 	}
@@ -424,6 +456,9 @@ func (b *SpritesBackend) Command(ctx context.Context, name string, args ...strin
 // ReadFile reads a file from the sprite.
 func (b *SpritesBackend) ReadFile(ctx context.Context, filePath string) ([]byte, error) {
 	resolved := b.resolvePath(filePath)
+	if data, err := agentReadFile(b.agentAddr, b.AgentSecret(), resolved); !errors.Is(err, errAgentUnavailable) {
+		return data, err
+	}
 	data, err := b.sprite.Filesystem().ReadFile(resolved)
 	if err != nil {
 		return nil, err
@@ -434,12 +469,18 @@ func (b *SpritesBackend) ReadFile(ctx context.Context, filePath string) ([]byte,
 // WriteFile writes a file to the sprite.
 func (b *SpritesBackend) WriteFile(ctx context.Context, filePath string, content []byte) error {
 	resolved := b.resolvePath(filePath)
+	if err := agentWriteFile(b.agentAddr, b.AgentSecret(), resolved, content); !errors.Is(err, errAgentUnavailable) {
+		return err
+	}
 	return b.sprite.Filesystem().WriteFile(resolved, content, 0644)
 }
 
 // ListFiles lists files in a directory.
 func (b *SpritesBackend) ListFiles(ctx context.Context, dir string) ([]FileInfo, error) {
 	resolved := b.resolvePath(dir)
+	if files, err := agentListFiles(b.agentAddr, b.AgentSecret(), resolved); !errors.Is(err, errAgentUnavailable) {
+		return files, err
+	}
 	entries, err := b.sprite.Filesystem().ReadDir(resolved)
 	if err != nil {
 		return nil, err
@@ -462,6 +503,11 @@ func (b *SpritesBackend) ListFiles(ctx context.Context, dir string) ([]FileInfo,
 	return files, nil
 }
 
+// WatchFiles reports changes under dir through the sprite's cook-agent.
+func (b *SpritesBackend) WatchFiles(ctx context.Context, dir string, fn func([]FileEvent)) error {
+	return agentWatch(ctx, b.agentAddr, b.AgentSecret(), b.resolvePath(dir), fn)
+}
+
 // WorkDir returns the working directory path.
 func (b *SpritesBackend) WorkDir() string {
 	return b.workDir
@@ -509,6 +555,31 @@ func (b *SpritesBackend) Teardown(ctx context.Context) error {
 	return b.sprite.Delete(ctx)
 }
 
+// Suspend closes cook's agent proxy. Sprites have no stop call: they
+// hibernate on their own once nothing is connected or running.
+func (b *SpritesBackend) Suspend(ctx context.Context) error {
+	if b.agentProxy != nil {
+		b.agentProxy.Close()
+		b.agentProxy = nil
+		b.agentAddr = ""
+	}
+	return nil
+}
+
+// Resume wakes the sprite, restarting cook-agent if hibernation stopped it.
+func (b *SpritesBackend) Resume(ctx context.Context) error {
+	if b.sprite == nil {
+		return fmt.Errorf("sprite not initialized")
+	}
+	if err := b.ensureAgentRunning(ctx); err != nil {
+		return fmt.Errorf("failed to ensure agent running: %w", err)
+	}
+	if err := b.ensureAgentProxy(ctx); err != nil {
+		return fmt.Errorf("failed to create agent proxy: %w", err)
+	}
+	return nil
+}
+
 // SpriteName returns the sprite name.
 func (b *SpritesBackend) SpriteName() string {
 	return b.spriteName
@@ -519,6 +590,11 @@ func (b *SpritesBackend) AgentAddr() string {
 	return b.agentAddr
 }
 
+// AgentSecret returns the secret the sprite's cook-agent requires.
+func (b *SpritesBackend) AgentSecret() string {
+	return agentSecret("sprites:" + b.spriteName)
+}
+
 // Checkpoint creates a checkpoint and returns its ID.
 func (b *SpritesBackend) Checkpoint(ctx context.Context) (string, error) {
 	comment := fmt.Sprintf("cook checkpoint %s", time.Now().UTC().Format(time.RFC3339Nano))
@@ -564,6 +640,28 @@ func (b *SpritesBackend) RestoreFromCheckpoint(ctx context.Context, checkpointID
 	})
 }
 
+// Snapshot checkpoints the sprite.
+func (b *SpritesBackend) Snapshot(ctx context.Context) (string, error) {
+	if b.sprite == nil {
+		return "", fmt.Errorf("sprite not initialized")
+	}
+	return b.Checkpoint(ctx)
+}
+
+// RestoreSnapshot restores the sprite from a checkpoint.
+func (b *SpritesBackend) RestoreSnapshot(ctx context.Context, id string) error {
+	if b.sprite == nil {
+		return fmt.Errorf("sprite not initialized")
+	}
+	return b.RestoreFromCheckpoint(ctx, id)
+}
+
+// DeleteSnapshot is a no-op: sprites manages checkpoint retention itself
+// and the API has no way to delete one.
+func (b *SpritesBackend) DeleteSnapshot(ctx context.Context, id string) error {
+	return nil
+}
+
 func (b *SpritesBackend) execWithEnv(ctx context.Context, dir, cmdStr string) ([]byte, error) {
 	if b.sprite == nil {
 		return nil, fmt.Errorf("sprite not initialized")
@@ -695,22 +793,25 @@ func spritesTarballURL(cfg Config) string {
 	return ""
 }
 
-func spriteConfigFromEnv() *sprites.SpriteConfig {
-	cfg := sprites.SpriteConfig{}
-	set := false
-
-	if v := envInt("SPRITES_RAM_MB", "COOK_SPRITES_RAM_MB"); v > 0 {
+// spriteConfig maps r onto the sprite's size. Unset fields default to
+// $SPRITES_RAM_MB, $SPRITES_CPUS and $SPRITES_STORAGE_GB. Sprites have no
+// GPUs or lifetime limit, so GPU and Timeout are ignored.
+func spriteConfig(r Resources) *sprites.SpriteConfig {
+	cfg := sprites.SpriteConfig{
+		RamMB:     r.MemoryMB,
+		CPUs:      r.CPUs,
+		StorageGB: r.DiskGB,
+	}
+	if v := envInt("SPRITES_RAM_MB", "COOK_SPRITES_RAM_MB"); cfg.RamMB == 0 && v > 0 {
 		cfg.RamMB = v
-		set = true
 	}
-	if v := envInt("SPRITES_CPUS", "COOK_SPRITES_CPUS"); v > 0 {
+	if v := envInt("SPRITES_CPUS", "COOK_SPRITES_CPUS"); cfg.CPUs == 0 && v > 0 {
 		cfg.CPUs = v
-		set = true
 	}
-	if v := envInt("SPRITES_STORAGE_GB", "COOK_SPRITES_STORAGE_GB"); v > 0 {
+	if v := envInt("SPRITES_STORAGE_GB", "COOK_SPRITES_STORAGE_GB"); cfg.StorageGB == 0 && v > 0 {
 		cfg.StorageGB = v
-		set = true
 	}
+	set := cfg.RamMB > 0 || cfg.CPUs > 0 || cfg.StorageGB > 0
 	if v := os.Getenv("SPRITES_REGION"); v != "" {
 		cfg.Region = v
 		set = true
@@ -792,5 +893,9 @@ func bytesTrim(data []byte) []byte {
 	return []byte(strings.TrimSpace(string(data)))
 }
 
-// Ensure SpritesBackend implements Backend
-var _ Backend = (*SpritesBackend)(nil)
+// Ensure SpritesBackend implements Backend, Suspender and Snapshotter
+var (
+	_ Backend     = (*SpritesBackend)(nil)
+	_ Suspender   = (*SpritesBackend)(nil)
+	_ Snapshotter = (*SpritesBackend)(nil)
+)
@@ -32,6 +32,9 @@ func TestSpritesBackend_Integration(t *testing.T) {
 	}
 	defer backend.Teardown(context.Background())
 
+	if err := envagent.LoadKey(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
 	if err := backend.Setup(ctx); err != nil {
 		t.Fatalf("Setup failed: %v", err)
 	}
@@ -82,7 +85,7 @@ func TestSpritesBackend_Integration(t *testing.T) {
 		t.Fatalf("AgentAddr is empty")
 	}
 
-	client, err := envagent.Dial(agentAddr)
+	client, err := envagent.Dial(agentAddr, backend.AgentSecret())
 	if err != nil {
 		t.Fatalf("Failed to connect to cook-agent: %v", err)
 	}
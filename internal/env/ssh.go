@@ -0,0 +1,706 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/justinmoon/cook/internal/envagent"
+)
+
+const (
+	// sshAgentBin is where cook-agent is installed, relative to the remote home.
+	sshAgentBin = ".cook/bin/cook-agent"
+
+	// sshAgentPortBase is the first remote port used for cook-agent. Each
+	// checkout gets its own port so several branches can share a host.
+	sshAgentPortBase  = 17422
+	sshAgentPortRange = 1000
+
+	// sshAgentPorts is where a host records which checkout holds each
+	// agent port, relative to the remote home: one directory per port,
+	// created atomically to claim it, holding the checkout path.
+	sshAgentPorts = ".cook/ports"
+)
+
+// SSHBackend runs commands on any machine reachable over SSH. The checkout
+// lives at workDir on the remote host (relative paths are relative to the
+// remote home). cook-agent listens on the remote loopback and is reached
+// through an SSH port forward, so it is never exposed on the network.
+type SSHBackend struct {
+	config  Config
+	host    string // ssh destination, e.g. "me@workstation"
+	workDir string
+
+	ptyMu sync.Mutex
+	pty   *os.File
+
+	portMu sync.Mutex
+	port   int // the checkout's agent port, once looked up
+}
+
+// NewSSHBackend creates a new SSH backend. cfg.Host is the ssh destination
+// and cfg.WorkDir the remote checkout path.
+func NewSSHBackend(cfg Config) (*SSHBackend, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("ssh backend requires a host")
+	}
+	if cfg.WorkDir == "" {
+		return nil, fmt.Errorf("ssh backend requires a remote work dir")
+	}
+	return &SSHBackend{
+		config:  cfg,
+		host:    cfg.Host,
+		workDir: cfg.WorkDir,
+	}, nil
+}
+
+// NewSSHBackendFromHost reconnects to an existing checkout on host.
+func NewSSHBackendFromHost(host, workDir string) (*SSHBackend, error) {
+	return NewSSHBackend(Config{Host: host, WorkDir: workDir})
+}
+
+// Setup provisions the checkout on the remote host and starts cook-agent.
+func (b *SSHBackend) Setup(ctx context.Context) error {
+	if _, err := b.execIn(ctx, "", "mkdir -p "+shellEscape(path.Dir(b.workDir))); err != nil {
+		return fmt.Errorf("failed to create remote dir: %w", err)
+	}
+
+	if _, err := b.execIn(ctx, "", "test -d "+shellEscape(path.Join(b.workDir, ".git"))); err != nil {
+		if b.config.RepoURL == "" {
+			return fmt.Errorf("no repo to clone and %s has no checkout", b.workDir)
+		}
+		if err := b.cloneRepo(ctx); err != nil {
+			return fmt.Errorf("failed to clone repo: %w", err)
+		}
+	}
+
+	if err := b.setupAgent(ctx); err != nil {
+		return fmt.Errorf("failed to setup agent: %w", err)
+	}
+
+	// Dotfiles and agent credentials are deliberately left alone: the remote
+	// is a machine the user already has set up, and its home is not ours.
+	return nil
+}
+
+// cloneRepo creates the remote checkout. A local bare repo is pushed over
+// ssh (the remote may not be able to reach cook); URLs are cloned remotely.
+func (b *SSHBackend) cloneRepo(ctx context.Context) error {
+	repoURL := b.config.RepoURL
+	branch := b.config.BranchName
+
+	if info, err := os.Stat(repoURL); err == nil && info.IsDir() {
+		if branch == "" {
+			branch = "master"
+		}
+		// Point HEAD at an unborn ref so the push may create any branch
+		// (git refuses to update the checked-out branch of a non-bare repo)
+		if _, err := b.execIn(ctx, "", fmt.Sprintf("git init -q %s && git -C %s symbolic-ref HEAD refs/heads/cook-seed",
+			shellEscape(b.workDir), shellEscape(b.workDir))); err != nil {
+			return err
+		}
+		push := exec.CommandContext(ctx, "git", "-C", repoURL, "push", "-q", b.host+":"+b.workDir, "master:refs/heads/"+branch)
+		push.Env = append(os.Environ(), "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+		if output, err := push.CombinedOutput(); err != nil {
+			return fmt.Errorf("git push failed: %s: %w", strings.TrimSpace(string(output)), err)
+		}
+		_, err := b.execIn(ctx, b.workDir, "git checkout -q "+shellEscape(branch))
+		return err
+	}
+
+	if _, err := b.execIn(ctx, "", fmt.Sprintf("git clone -q %s %s", shellEscape(repoURL), shellEscape(b.workDir))); err != nil {
+		return err
+	}
+	if branch != "" {
+		if _, err := b.execIn(ctx, b.workDir, "git checkout -q -b "+shellEscape(branch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupAgent installs cook-agent in the remote home and starts it on the
+// checkout's loopback port.
+func (b *SSHBackend) setupAgent(ctx context.Context) error {
+	port, err := b.agentPort(ctx)
+	if err != nil {
+		return err
+	}
+	if b.agentListening(ctx, port) {
+		trusted, err := b.agentTrusted(port)
+		if err != nil {
+			return err
+		}
+		if trusted {
+			return nil
+		}
+		// An agent from before secrets, or started with an older key:
+		// replace it so the checkout isn't left open to other host users
+		log.Printf("ssh %s: cook-agent on port %d does not require the current secret, restarting it", b.host, port)
+		b.stopAgent(ctx, port)
+		for i := 0; i < 20 && b.agentListening(ctx, port); i++ {
+			time.Sleep(250 * time.Millisecond)
+		}
+		if b.agentListening(ctx, port) {
+			return fmt.Errorf("cook-agent on port %d did not exit", port)
+		}
+	}
+
+	agentBinary, err := findAgentBinary()
+	if err != nil {
+		return fmt.Errorf("cook-agent binary not found: %w", err)
+	}
+	agentData, err := os.ReadFile(agentBinary)
+	if err != nil {
+		return fmt.Errorf("failed to read cook-agent: %w", err)
+	}
+
+	install := fmt.Sprintf("mkdir -p %s && cat > %s.tmp && chmod +x %s.tmp && mv %s.tmp %s",
+		path.Dir(sshAgentBin), sshAgentBin, sshAgentBin, sshAgentBin, sshAgentBin)
+	if output, err := b.ssh(ctx, bytes.NewReader(agentData), install); err != nil {
+		return fmt.Errorf("failed to install cook-agent: %w: %s", err, string(output))
+	}
+
+	// Pass the secret in a file written over stdin, not on a command line
+	// other users of the host could see; the agent removes it on start
+	secret, err := envagent.Secret(b.agentID())
+	if err != nil {
+		return fmt.Errorf("cannot start cook-agent: %w", err)
+	}
+	secretFile := fmt.Sprintf(".cook/agent-%d.secret", port)
+	if output, err := b.ssh(ctx, strings.NewReader(secret), "umask 077 && cat > "+secretFile); err != nil {
+		return fmt.Errorf("failed to write cook-agent secret: %w: %s", err, string(output))
+	}
+
+	start := fmt.Sprintf("nohup %s -listen 127.0.0.1:%d -secret-file %s -state .cook/agent-%d.sessions.json > .cook/agent-%d.log 2>&1 < /dev/null &",
+		sshAgentBin, port, secretFile, port, port)
+	if output, err := b.execIn(ctx, "", start); err != nil {
+		return fmt.Errorf("failed to start cook-agent: %w: %s", err, string(output))
+	}
+
+	for i := 0; i < 20; i++ {
+		if b.agentListening(ctx, port) {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	logs, _ := b.execIn(ctx, "", fmt.Sprintf("cat .cook/agent-%d.log", port))
+	return fmt.Errorf("agent did not become ready: %s", string(logs))
+}
+
+// agentListening checks whether something accepts connections on port.
+func (b *SSHBackend) agentListening(ctx context.Context, port int) bool {
+	_, err := b.execIn(ctx, "", "bash -c "+shellEscape(portInUse(strconv.Itoa(port))))
+	return err == nil
+}
+
+// portInUse is a bash check that succeeds if something accepts
+// connections on the remote loopback port.
+func portInUse(port string) string {
+	return fmt.Sprintf("(exec 3<>/dev/tcp/127.0.0.1/%s) 2>/dev/null || nc -z 127.0.0.1 %s 2>/dev/null", port, port)
+}
+
+// agentPort returns the checkout's cook-agent port, claiming a free one on
+// the host the first time.
+func (b *SSHBackend) agentPort(ctx context.Context) (int, error) {
+	b.portMu.Lock()
+	defer b.portMu.Unlock()
+	if b.port != 0 {
+		return b.port, nil
+	}
+
+	port, err := b.lookupAgentPort(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if port == 0 {
+		if port, err = b.claimAgentPort(ctx); err != nil {
+			return 0, err
+		}
+	}
+	b.port = port
+	return port, nil
+}
+
+// recordedAgentPort is agentPort without claiming one: 0 if the checkout
+// has none.
+func (b *SSHBackend) recordedAgentPort(ctx context.Context) (int, error) {
+	b.portMu.Lock()
+	defer b.portMu.Unlock()
+	if b.port != 0 {
+		return b.port, nil
+	}
+	port, err := b.lookupAgentPort(ctx)
+	b.port = port
+	return port, err
+}
+
+// lookupAgentPort returns the port the host records for the checkout, or 0
+// if it has none.
+func (b *SSHBackend) lookupAgentPort(ctx context.Context) (int, error) {
+	lookup := fmt.Sprintf(`for d in %s/*/; do [ "$(cat "$d/checkout" 2>/dev/null)" = %s ] && basename "$d"; done; true`,
+		sshAgentPorts, shellEscape(b.workDir))
+	output, err := b.execIn(ctx, "", lookup)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up agent port: %w: %s", err, string(output))
+	}
+	var port int
+	if fields := strings.Fields(string(output)); len(fields) > 0 {
+		port, _ = strconv.Atoi(fields[0])
+	}
+	return port, nil
+}
+
+// claimAgentPort claims a port no other checkout holds and nothing else
+// listens on, and records it for the checkout.
+func (b *SSHBackend) claimAgentPort(ctx context.Context) (int, error) {
+	claim := fmt.Sprintf(`mkdir -p %[1]s || exit 1
+for ((port = %[2]d; port < %[3]d; port++)); do
+	mkdir %[1]s/$port 2>/dev/null || continue
+	if %[4]s; then rmdir %[1]s/$port; continue; fi
+	printf '%%s' %[5]s > %[1]s/$port/checkout && echo $port && exit 0
+done
+exit 1`, sshAgentPorts, sshAgentPortBase, sshAgentPortBase+sshAgentPortRange, portInUse("$port"), shellEscape(b.workDir))
+	output, err := b.execIn(ctx, "", "bash -c "+shellEscape(claim))
+	if err != nil {
+		return 0, fmt.Errorf("no free agent port on %s: %w: %s", b.host, err, string(output))
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim agent port: %s", string(output))
+	}
+	return port, nil
+}
+
+// releaseAgentPort forgets the checkout's agent port.
+func (b *SSHBackend) releaseAgentPort(ctx context.Context, port int) {
+	b.execIn(ctx, "", fmt.Sprintf("rm -rf %s/%d", sshAgentPorts, port))
+	b.portMu.Lock()
+	b.port = 0
+	b.portMu.Unlock()
+}
+
+// agentID identifies the checkout's cook-agent for its secret.
+func (b *SSHBackend) agentID() string {
+	return "ssh:" + b.host + ":" + b.workDir
+}
+
+// AgentSecret returns the secret the checkout's cook-agent requires.
+func (b *SSHBackend) AgentSecret() string {
+	return agentSecret(b.agentID())
+}
+
+// AgentAddr returns a local address forwarded to the remote cook-agent,
+// starting the agent and the forward if needed. Empty if unreachable. Once
+// the agent is set up, its forward is reused for as long as the agent
+// answers through it.
+func (b *SSHBackend) AgentAddr() string {
+	key := b.host + "|" + b.workDir
+	sshAgentsMu.Lock()
+	fwd := sshAgents[key]
+	sshAgentsMu.Unlock()
+	if fwd != nil && fwd.alive() && agentHealthy(fwd.addr) {
+		return fwd.addr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := b.setupAgent(ctx); err != nil {
+		fmt.Printf("Warning: ssh %s: %v\n", b.host, err)
+		return ""
+	}
+	port, err := b.agentPort(ctx)
+	if err != nil {
+		fmt.Printf("Warning: ssh %s: %v\n", b.host, err)
+		return ""
+	}
+	fwd, err = openSSHForward(b.host, port)
+	if err != nil {
+		fmt.Printf("Warning: ssh %s: %v\n", b.host, err)
+		return ""
+	}
+	sshAgentsMu.Lock()
+	sshAgents[key] = fwd
+	sshAgentsMu.Unlock()
+	return fwd.addr
+}
+
+// agentHealthy reports whether a cook-agent answers its health check at addr.
+func agentHealthy(addr string) bool {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + addr + "/healthz")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// Exec runs a command in the remote checkout and returns combined output.
+func (b *SSHBackend) Exec(ctx context.Context, cmdStr string) ([]byte, error) {
+	return b.execIn(ctx, b.workDir, cmdStr)
+}
+
+// execIn runs cmdStr with sh in dir ("" for the remote home).
+func (b *SSHBackend) execIn(ctx context.Context, dir, cmdStr string) ([]byte, error) {
+	remote := "sh -c " + shellEscape(cmdStr)
+	if dir != "" {
+		remote = "cd " + shellEscape(dir) + " && " + remote
+	}
+	return b.ssh(ctx, nil, remote)
+}
+
+func (b *SSHBackend) ssh(ctx context.Context, stdin io.Reader, remote string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ssh", append(sshOptions(), b.host, remote)...)
+	cmd.Stdin = stdin
+	return cmd.CombinedOutput()
+}
+
+// Command returns an ssh command that runs name in the remote checkout
+// with a remote TTY allocated.
+func (b *SSHBackend) Command(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	quoted := []string{shellEscape(name)}
+	for _, a := range args {
+		quoted = append(quoted, shellEscape(a))
+	}
+	remote := "cd " + shellEscape(b.workDir) + " && exec " + strings.Join(quoted, " ")
+	sshArgs := append(sshOptions(), "-tt", b.host, remote)
+	return exec.CommandContext(ctx, "ssh", sshArgs...), nil
+}
+
+// AttachPTY starts a login shell in the remote checkout on a local PTY.
+func (b *SSHBackend) AttachPTY(ctx context.Context, rows, cols int) (io.ReadWriteCloser, error) {
+	cmd, err := b.Command(ctx, "sh", "-c", `exec "${SHELL:-sh}" -l`)
+	if err != nil {
+		return nil, err
+	}
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start ssh pty: %w", err)
+	}
+
+	b.ptyMu.Lock()
+	b.pty = f
+	b.ptyMu.Unlock()
+
+	return &sshPTY{File: f, cmd: cmd}, nil
+}
+
+// ResizePTY resizes the attached PTY; ssh forwards the change to the remote.
+func (b *SSHBackend) ResizePTY(rows, cols int) error {
+	b.ptyMu.Lock()
+	defer b.ptyMu.Unlock()
+	if b.pty == nil {
+		return fmt.Errorf("no pty attached")
+	}
+	return pty.Setsize(b.pty, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
+}
+
+type sshPTY struct {
+	*os.File
+	cmd *exec.Cmd
+}
+
+func (p *sshPTY) Close() error {
+	err := p.File.Close()
+	if p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+	}
+	return err
+}
+
+// ReadFile reads a file from the remote checkout.
+func (b *SSHBackend) ReadFile(ctx context.Context, filePath string) ([]byte, error) {
+	resolved, err := b.resolvePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "ssh", append(sshOptions(), b.host, "cat "+shellEscape(resolved))...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", filePath, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// WriteFile writes a file to the remote checkout.
+func (b *SSHBackend) WriteFile(ctx context.Context, filePath string, content []byte) error {
+	resolved, err := b.resolvePath(filePath)
+	if err != nil {
+		return err
+	}
+	remote := fmt.Sprintf("mkdir -p %s && cat > %s", shellEscape(path.Dir(resolved)), shellEscape(resolved))
+	if output, err := b.ssh(ctx, bytes.NewReader(content), remote); err != nil {
+		return fmt.Errorf("failed to write %s: %w: %s", filePath, err, string(output))
+	}
+	return nil
+}
+
+// ListFiles lists files in a directory of the remote checkout.
+func (b *SSHBackend) ListFiles(ctx context.Context, dir string) ([]FileInfo, error) {
+	resolved, err := b.resolvePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	// name, type and size per line, in POSIX sh so it works on Linux and macOS hosts
+	list := "cd " + shellEscape(resolved) + ` && for f in * .[!.]* ..?*; do [ -e "$f" ] || continue; ` +
+		`if [ -d "$f" ]; then printf '%s\td\t0\n' "$f"; else printf '%s\tf\t%s\n' "$f" "$(wc -c < "$f")"; fi; done`
+	output, err := b.execIn(ctx, "", list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w: %s", err, string(output))
+	}
+
+	var files []FileInfo
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.Split(line, "\t")
+		if len(parts) != 3 {
+			continue
+		}
+		var size int64
+		fmt.Sscanf(strings.TrimSpace(parts[2]), "%d", &size)
+		relPath := parts[0]
+		if dir != "" && dir != "." {
+			relPath = path.Join(dir, parts[0])
+		}
+		files = append(files, FileInfo{
+			Name:  parts[0],
+			Path:  relPath,
+			IsDir: parts[1] == "d",
+			Size:  size,
+		})
+	}
+	return files, nil
+}
+
+// WorkDir returns the remote checkout path.
+func (b *SSHBackend) WorkDir() string {
+	return b.workDir
+}
+
+// Host returns the ssh destination.
+func (b *SSHBackend) Host() string {
+	return b.host
+}
+
+// Status reports whether the host is reachable and the checkout exists.
+func (b *SSHBackend) Status(ctx context.Context) (Status, error) {
+	if _, err := b.execIn(ctx, "", "test -d "+shellEscape(b.workDir)); err != nil {
+		return Status{State: StateError, Message: err.Error(), ID: b.host}, nil
+	}
+	return Status{State: StateRunning, ID: b.host}, nil
+}
+
+// Stop stops cook-agent (and any agent PTYs it owns) but keeps the checkout.
+func (b *SSHBackend) Stop(ctx context.Context) error {
+	port, err := b.recordedAgentPort(ctx)
+	if err != nil || port == 0 {
+		return err
+	}
+	b.stopAgent(ctx, port)
+	return nil
+}
+
+// stopAgent kills the cook-agent listening on port and drops its forward.
+func (b *SSHBackend) stopAgent(ctx context.Context, port int) {
+	sshAgentsMu.Lock()
+	delete(sshAgents, b.host+"|"+b.workDir)
+	sshAgentsMu.Unlock()
+	closeSSHForward(b.host, port)
+	b.execIn(ctx, "", fmt.Sprintf("pkill -f %s", shellEscape(fmt.Sprintf("cook-agent -listen 127.0.0.1:%d", port))))
+}
+
+// agentTrusted reports whether the agent on port requires the checkout's
+// current secret: it must refuse a connection without one and accept the
+// secret. Errors other than a refusal are returned rather than treated as
+// untrusted, so a flaky forward doesn't kill running sessions.
+func (b *SSHBackend) agentTrusted(port int) (bool, error) {
+	addr, err := sshForward(b.host, port)
+	if err != nil {
+		return false, err
+	}
+	if client, err := envagent.Dial(addr, ""); err == nil {
+		client.Close()
+		return false, nil
+	} else if !errors.Is(err, envagent.ErrUnauthorized) {
+		return false, err
+	}
+	client, err := envagent.Dial(addr, b.AgentSecret())
+	if errors.Is(err, envagent.ErrUnauthorized) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	client.Close()
+	return true, nil
+}
+
+// Start restarts cook-agent after Stop.
+func (b *SSHBackend) Start(ctx context.Context) error {
+	return b.setupAgent(ctx)
+}
+
+// Teardown stops cook-agent and removes the remote checkout.
+func (b *SSHBackend) Teardown(ctx context.Context) error {
+	b.Stop(ctx)
+	if port, err := b.recordedAgentPort(ctx); err == nil && port != 0 {
+		b.releaseAgentPort(ctx, port)
+	}
+	if _, err := b.execIn(ctx, "", "rm -rf "+shellEscape(b.workDir)); err != nil {
+		return fmt.Errorf("failed to remove remote checkout: %w", err)
+	}
+	return nil
+}
+
+// resolvePath maps a path to the remote checkout. Relative paths are under
+// workDir, and paths already under workDir are used as-is. Other absolute
+// paths and paths containing ".." are rejected.
+func (b *SSHBackend) resolvePath(p string) (string, error) {
+	if p == "" || p == "." {
+		return b.workDir, nil
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("path escapes working directory: %s", p)
+		}
+	}
+	if p == b.workDir || strings.HasPrefix(p, b.workDir+"/") {
+		return p, nil
+	}
+	if path.IsAbs(p) {
+		return "", fmt.Errorf("path escapes working directory: %s", p)
+	}
+	return path.Join(b.workDir, p), nil
+}
+
+func sshOptions() []string {
+	return []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10", "-o", "ServerAliveInterval=30"}
+}
+
+// sshForwards holds long-lived `ssh -L` processes, keyed by host and
+// remote port, shared by every backend instance for that checkout.
+var (
+	sshForwardsMu sync.Mutex
+	sshForwards   = map[string]*sshForwardProc{}
+)
+
+// sshAgents holds the forward to each checkout's set-up cook-agent, keyed by
+// host and checkout, so AgentAddr doesn't redo the setup checks (and spend
+// credentials on them) on every call.
+var (
+	sshAgentsMu sync.Mutex
+	sshAgents   = map[string]*sshForwardProc{}
+)
+
+type sshForwardProc struct {
+	cmd  *exec.Cmd
+	addr string
+	done chan struct{}
+}
+
+// alive reports whether the forward's ssh process is still running.
+func (f *sshForwardProc) alive() bool {
+	select {
+	case <-f.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// sshForward returns a local address forwarded to remotePort on host's loopback.
+func sshForward(host string, remotePort int) (string, error) {
+	fwd, err := openSSHForward(host, remotePort)
+	if err != nil {
+		return "", err
+	}
+	return fwd.addr, nil
+}
+
+// openSSHForward returns the forward to remotePort on host's loopback,
+// starting one if there is none.
+func openSSHForward(host string, remotePort int) (*sshForwardProc, error) {
+	key := fmt.Sprintf("%s|%d", host, remotePort)
+
+	sshForwardsMu.Lock()
+	defer sshForwardsMu.Unlock()
+
+	if fwd, ok := sshForwards[key]; ok {
+		if fwd.alive() {
+			return fwd, nil
+		}
+		delete(sshForwards, key)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	args := append(sshOptions(), "-N", "-o", "ExitOnForwardFailure=yes",
+		"-L", fmt.Sprintf("%s:127.0.0.1:%d", addr, remotePort), host)
+	cmd := exec.Command("ssh", args...)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ssh forward: %w", err)
+	}
+	fwd := &sshForwardProc{cmd: cmd, addr: addr, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(fwd.done)
+	}()
+
+	for i := 0; i < 50; i++ {
+		select {
+		case <-fwd.done:
+			return nil, fmt.Errorf("ssh forward to %s exited", host)
+		default:
+		}
+		if conn, err := net.DialTimeout("tcp", addr, 200*time.Millisecond); err == nil {
+			conn.Close()
+			sshForwards[key] = fwd
+			return fwd, nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	cmd.Process.Kill()
+	return nil, fmt.Errorf("ssh forward to %s did not come up", host)
+}
+
+func closeSSHForward(host string, remotePort int) {
+	key := fmt.Sprintf("%s|%d", host, remotePort)
+
+	sshForwardsMu.Lock()
+	defer sshForwardsMu.Unlock()
+
+	if fwd, ok := sshForwards[key]; ok {
+		fwd.cmd.Process.Kill()
+		delete(sshForwards, key)
+	}
+}
+
+// Ensure SSHBackend implements Backend, PTYAttacher and Stopper
+var (
+	_ Backend     = (*SSHBackend)(nil)
+	_ PTYAttacher = (*SSHBackend)(nil)
+	_ Stopper     = (*SSHBackend)(nil)
+)
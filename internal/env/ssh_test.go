@@ -0,0 +1,149 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinmoon/cook/internal/envagent"
+)
+
+func TestSSHBackend_Paths(t *testing.T) {
+	b, err := NewSSHBackendFromHost("me@box", "cook/checkouts/o/r/feature")
+	if err != nil {
+		t.Fatalf("NewSSHBackendFromHost: %v", err)
+	}
+
+	tests := []struct{ in, want string }{
+		{"", "cook/checkouts/o/r/feature"},
+		{".", "cook/checkouts/o/r/feature"},
+		{"src/main.go", "cook/checkouts/o/r/feature/src/main.go"},
+		{"cook/checkouts/o/r/feature/TASK.md", "cook/checkouts/o/r/feature/TASK.md"},
+	}
+	for _, tt := range tests {
+		if got, err := b.resolvePath(tt.in); err != nil || got != tt.want {
+			t.Errorf("resolvePath(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, p := range []string{"/etc/hosts", "../other/secret", "src/../../other", "cook/checkouts/o/r/feature/../other"} {
+		if got, err := b.resolvePath(p); err == nil {
+			t.Errorf("resolvePath(%q) = %q, want error", p, got)
+		}
+	}
+
+	if _, err := NewSSHBackend(Config{WorkDir: "x"}); err == nil {
+		t.Error("expected error without host")
+	}
+}
+
+// TestSSHBackend_Integration runs against a real sshd, e.g. COOK_TEST_SSH_HOST=localhost
+// with the current user's key in authorized_keys.
+func TestAgentHealthy(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer healthy.Close()
+	if !agentHealthy(healthy.Listener.Addr().String()) {
+		t.Error("agentHealthy = false for an answering agent")
+	}
+
+	addr := healthy.Listener.Addr().String()
+	healthy.Close()
+	if agentHealthy(addr) {
+		t.Error("agentHealthy = true after the agent went away")
+	}
+}
+
+func TestSSHBackend_Integration(t *testing.T) {
+	host := os.Getenv("COOK_TEST_SSH_HOST")
+	if host == "" {
+		t.Skip("COOK_TEST_SSH_HOST not set, skipping SSH integration test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	// Seed a local bare repo the backend pushes from
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "src")
+	bare := filepath.Join(tmp, "repo.git")
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master", src},
+		{"-C", src, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init"},
+		{"clone", "-q", "--bare", src, bare},
+	} {
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, output)
+		}
+	}
+
+	workDir := fmt.Sprintf("cook-test-ssh/%d/feature", time.Now().UnixNano())
+	backend, err := NewSSHBackend(Config{
+		Host:       host,
+		RepoURL:    bare,
+		BranchName: "feature",
+		WorkDir:    workDir,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SSH backend: %v", err)
+	}
+	defer backend.Teardown(context.Background())
+
+	if err := envagent.LoadKey(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Setup(ctx); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	output, err := backend.Exec(ctx, "git rev-parse --abbrev-ref HEAD")
+	if err != nil {
+		t.Fatalf("Exec failed: %v: %s", err, output)
+	}
+	if got := string(output); got != "feature\n" {
+		t.Fatalf("checked out %q, want feature", got)
+	}
+
+	if err := backend.WriteFile(ctx, "dir/test.txt", []byte("hello from test")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	data, err := backend.ReadFile(ctx, "dir/test.txt")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != "hello from test" {
+		t.Fatalf("ReadFile got %q", string(data))
+	}
+
+	// Reconnect from the recorded host and path, as Branch.Backend does
+	reconnected, err := NewSSHBackendFromHost(host, workDir)
+	if err != nil {
+		t.Fatalf("NewSSHBackendFromHost: %v", err)
+	}
+	agentAddr := reconnected.AgentAddr()
+	if agentAddr == "" {
+		t.Fatalf("AgentAddr is empty")
+	}
+	port, _ := backend.agentPort(ctx)
+	if got, err := reconnected.agentPort(ctx); err != nil || got != port {
+		t.Errorf("reconnected agentPort() = %d, %v, want %d", got, err, port)
+	}
+
+	client, err := envagent.Dial(agentAddr, backend.AgentSecret())
+	if err != nil {
+		t.Fatalf("Failed to connect to cook-agent: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.CreateSession("test-session-1", "/bin/sh", workDir, 24, 80); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package env
+
+import (
+	"context"
+	"fmt"
+)
+
+// Warmer is an optional interface for remote backends whose setup can be
+// split in two: Warm does the slow, repo-agnostic part (machine, tools,
+// tailnet, cook-agent) ahead of time, and Claim does the repo-specific part
+// once a branch needs the environment. Setup is Warm followed by Claim.
+type Warmer interface {
+	Backend
+
+	// Warm provisions the environment without a repo
+	Warm(ctx context.Context) error
+
+	// Claim clones and checks out cfg's repo and branch and applies its
+	// dotfiles in a warm environment
+	Claim(ctx context.Context, cfg Config) error
+
+	// EnvID identifies the environment for OpenWarmer
+	EnvID() string
+}
+
+// Warmable reports whether backendType implements Warmer.
+func Warmable(backendType Type) bool {
+	switch backendType {
+	case TypeModal, TypeSprites, TypeFlyMachines:
+		return true
+	}
+	return false
+}
+
+// NewWarmer creates an unprovisioned environment of backendType from cfg,
+// for Warm to provision. Only cfg's name and resources matter until Claim.
+func NewWarmer(backendType Type, cfg Config) (Warmer, error) {
+	switch backendType {
+	case TypeModal:
+		mb, err := NewModalBackend(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return mb, nil
+	case TypeSprites:
+		sb, err := NewSpritesBackend(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return sb, nil
+	case TypeFlyMachines:
+		// Not NewFlyMachinesBackend: machine reuse would hand out a
+		// machine that may belong to a branch
+		client, appName, err := newFlyMachinesClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &FlyMachinesBackend{
+			config:      cfg,
+			flapsClient: client,
+			workDir:     flyWorkDir,
+			appName:     appName,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s backend does not support warm pools", backendType)
+	}
+}
+
+// OpenWarmer reconnects to a warm environment by its EnvID.
+func OpenWarmer(backendType Type, id string) (Warmer, error) {
+	var w Warmer
+	var err error
+	switch backendType {
+	case TypeModal:
+		w, err = NewModalBackendFromSandboxID(id, "")
+	case TypeSprites:
+		w, err = NewSpritesBackendFromSpriteName(id, "")
+	case TypeFlyMachines:
+		w, err = NewFlyMachinesBackendFromMachineID(id, "")
+	default:
+		return nil, fmt.Errorf("%s backend does not support warm pools", backendType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// EnvID returns the Modal sandbox ID.
+func (b *ModalBackend) EnvID() string {
+	return b.sandboxID
+}
+
+// EnvID returns the sprite name.
+func (b *SpritesBackend) EnvID() string {
+	return b.spriteName
+}
+
+// EnvID returns the machine ID.
+func (b *FlyMachinesBackend) EnvID() string {
+	return b.machineID
+}
+
+// Ensure the remote backends implement Warmer
+var (
+	_ Warmer = (*ModalBackend)(nil)
+	_ Warmer = (*SpritesBackend)(nil)
+	_ Warmer = (*FlyMachinesBackend)(nil)
+)
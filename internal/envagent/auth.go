@@ -0,0 +1,167 @@
+package envagent
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cook-agent only accepts connections from the cook server. Each
+// environment's agent is started with its own secret, derived from a key
+// only the server holds and the environment's ID, so a secret read inside
+// one environment opens no other. Clients never send the secret: every
+// connection presents a new credential, "<unix time>.<nonce>.<mac>", which
+// the agent accepts once and only within CredentialWindow of its clock.
+
+const (
+	// AuthHeader carries the credential in the WebSocket handshake.
+	AuthHeader = "X-Cook-Agent-Auth"
+
+	// SecretEnv passes the agent its secret when it starts. The agent
+	// removes it from its environment so sessions don't inherit it.
+	SecretEnv = "COOK_AGENT_SECRET"
+
+	// CredentialWindow is how far a credential's time may be from the
+	// agent's clock.
+	CredentialWindow = 5 * time.Minute
+
+	keyFile = "agent.key"
+	keySize = 32
+)
+
+var (
+	keyMu sync.RWMutex
+	key   []byte
+)
+
+// LoadKey loads the key agent secrets are derived from, dataDir/agent.key,
+// creating it on first use.
+func LoadKey(dataDir string) error {
+	path := filepath.Join(dataDir, keyFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data = make([]byte, keySize)
+		if _, err := rand.Read(data); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			return LoadKey(dataDir) // created concurrently
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create agent key: %w", err)
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write agent key: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read agent key: %w", err)
+	}
+	if len(data) != keySize {
+		return fmt.Errorf("agent key %s must be %d bytes", path, keySize)
+	}
+	SetKey(data)
+	return nil
+}
+
+// SetKey sets the key agent secrets are derived from.
+func SetKey(k []byte) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	key = append([]byte(nil), k...)
+}
+
+// Secret returns the secret for the agent in the environment envID, e.g.
+// "docker:<container ID>".
+func Secret(envID string) (string, error) {
+	keyMu.RLock()
+	defer keyMu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("agent key not loaded")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("cook-agent:" + envID))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// NewCredential returns a single-use credential for an agent's secret.
+func NewCredential(secret string, now time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	msg := strconv.FormatInt(now.Unix(), 10) + "." + hex.EncodeToString(nonce)
+	return msg + "." + sign(secret, msg), nil
+}
+
+func sign(secret, msg string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msg))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier checks credentials for one agent secret and remembers the
+// ones it accepted until they expire, so none is accepted twice.
+type Verifier struct {
+	secret string
+
+	mu   sync.Mutex
+	seen map[string]time.Time // nonce -> credential time
+}
+
+// NewVerifier returns a verifier for secret.
+func NewVerifier(secret string) *Verifier {
+	return &Verifier{secret: secret, seen: make(map[string]time.Time)}
+}
+
+// Verify accepts cred if it was made with the secret, within
+// CredentialWindow of now, and not seen before.
+func (v *Verifier) Verify(cred string, now time.Time) error {
+	if cred == "" {
+		return fmt.Errorf("missing credential")
+	}
+	parts := strings.Split(cred, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed credential")
+	}
+	msg := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(v.secret, msg))) {
+		return fmt.Errorf("bad signature")
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed credential")
+	}
+	at := time.Unix(unix, 0)
+	if skew := now.Sub(at); skew > CredentialWindow || skew < -CredentialWindow {
+		return fmt.Errorf("credential expired (clock skew %v)", skew.Round(time.Second))
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for nonce, t := range v.seen {
+		if now.Sub(t) > CredentialWindow {
+			delete(v.seen, nonce)
+		}
+	}
+	if _, ok := v.seen[parts[1]]; ok {
+		return fmt.Errorf("credential reused")
+	}
+	v.seen[parts[1]] = at
+	return nil
+}
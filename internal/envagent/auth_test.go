@@ -0,0 +1,59 @@
+package envagent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	if err := LoadKey(dir); err != nil {
+		t.Fatalf("LoadKey: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, keyFile))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("agent key = %v, %v; want a 0600 file", info, err)
+	}
+	a, _ := Secret("docker:abc")
+	b, _ := Secret("docker:def")
+	if a == "" || a == b {
+		t.Errorf("secrets for different environments: %q, %q", a, b)
+	}
+
+	// Reloading keeps the key, and so every environment's secret
+	if err := LoadKey(dir); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := Secret("docker:abc"); again != a {
+		t.Error("secret changed after reloading the key")
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	v := NewVerifier("s3cret")
+
+	cred, err := NewCredential("s3cret", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(cred, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := v.Verify(cred, now.Add(time.Minute)); err == nil || !strings.Contains(err.Error(), "reused") {
+		t.Errorf("reused credential: %v", err)
+	}
+
+	other, _ := NewCredential("wrong", now)
+	old, _ := NewCredential("s3cret", now.Add(-CredentialWindow-time.Second))
+	parts := strings.Split(cred, ".")
+	tampered := "1700000100." + parts[1] + "." + parts[2]
+	for name, c := range map[string]string{"missing": "", "malformed": "x.y", "wrong secret": other, "expired": old, "tampered": tampered} {
+		if err := v.Verify(c, now); err == nil {
+			t.Errorf("%s credential accepted", name)
+		}
+	}
+}
@@ -5,55 +5,38 @@ package envagent
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/justinmoon/cook/internal/agentproto"
+	"github.com/justinmoon/cook/internal/terminal"
 )
 
-// Message types - must match cook-agent
-const (
-	MsgCreate = "create"
-	MsgAttach = "attach"
-	MsgDetach = "detach"
-	MsgInput  = "input"
-	MsgOutput = "output"
-	MsgResize = "resize"
-	MsgList   = "list"
-	MsgOK     = "ok"
-	MsgError  = "error"
-)
-
-// Message is the wire format for agent communication
-type Message struct {
-	Type      string   `json:"type"`
-	SessionID string   `json:"session_id,omitempty"`
-	Command   string   `json:"command,omitempty"`
-	WorkDir   string   `json:"workdir,omitempty"`
-	Data      []byte   `json:"data,omitempty"`
-	Rows      int      `json:"rows,omitempty"`
-	Cols      int      `json:"cols,omitempty"`
-	Error     string   `json:"error,omitempty"`
-	Sessions  []string `json:"sessions,omitempty"`
-}
+// ErrUnauthorized is returned by Dial when the agent rejects the secret.
+var ErrUnauthorized = errors.New("not authorized")
 
 // Client connects to a cook-agent instance via WebSocket
 type Client struct {
-	conn     *websocket.Conn
+	conn     *agentproto.Conn
 	mu       sync.Mutex
 	onOutput func(sessionID string, data []byte)
+	onExit   func(sessionID string, exitCode int)
+	features []string // from the agent's hello
 }
 
-// Dial connects to a cook-agent at the given address.
-// The address can be:
+// Dial connects to a cook-agent at the given address, authenticating with
+// the agent's secret (see Secret). The address can be:
 //   - "host:port" (converted to ws://host:port)
 //   - "ws://..." or "wss://..." (used as-is)
 //   - "https://..." (converted to wss://...)
-func Dial(addr string) (*Client, error) {
+func Dial(addr, secret string) (*Client, error) {
 	// Convert address to WebSocket URL
 	wsURL := addr
 	if strings.HasPrefix(addr, "https://") {
@@ -94,18 +77,67 @@ func Dial(addr string) (*Client, error) {
 		}
 	}
 
-	conn, _, err := dialer.Dial(wsURL, nil)
+	header := http.Header{}
+	if secret != "" {
+		cred, err := NewCredential(secret, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		header.Set(AuthHeader, cred)
+	}
+
+	// Offer the binary protocol; agents that predate it accept none
+	withProto := *dialer
+	withProto.Subprotocols = []string{agentproto.Subprotocol}
+
+	ws, resp, err := withProto.Dial(wsURL, header)
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("cook-agent at %s rejected the connection: %w", wsURL, ErrUnauthorized)
+		}
 		return nil, fmt.Errorf("failed to connect to cook-agent at %s: %w", wsURL, err)
 	}
 
 	c := &Client{
-		conn: conn,
+		conn: agentproto.NewConn(ws),
+	}
+	if c.conn.Version() >= 2 {
+		if err := c.hello(); err != nil {
+			ws.Close()
+			return nil, fmt.Errorf("cook-agent at %s: handshake failed: %w", wsURL, err)
+		}
 	}
 
 	return c, nil
 }
 
+// hello confirms the protocol version with the agent.
+func (c *Client) hello() error {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgHello, Version: agentproto.Version}); err != nil {
+		return err
+	}
+	resp, err := c.readMessage()
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.Type == agentproto.MsgError:
+		return fmt.Errorf("agent error: %s", resp.Error)
+	case resp.Type != agentproto.MsgHello:
+		return fmt.Errorf("unexpected %q reply", resp.Type)
+	case resp.Version < 2:
+		return fmt.Errorf("agent negotiated %s but speaks version %d", agentproto.Subprotocol, resp.Version)
+	}
+	c.features = resp.Features
+	return nil
+}
+
+// Version returns the protocol version in use: 2 for binary terminal
+// frames, 1 for agents that only speak JSON.
+func (c *Client) Version() int {
+	return c.conn.Version()
+}
+
 func isEnvTrue(key string) bool {
 	value := strings.TrimSpace(os.Getenv(key))
 	if value == "" {
@@ -128,40 +160,30 @@ func (c *Client) SetOutputHandler(handler func(sessionID string, data []byte)) {
 }
 
 // send sends a message to the agent
-func (c *Client) send(msg Message) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	encoded, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-
-	return c.conn.WriteMessage(websocket.TextMessage, encoded)
+func (c *Client) send(msg agentproto.Message) error {
+	return c.conn.Send(msg)
 }
 
 // readMessage reads and parses a single message
-func (c *Client) readMessage() (*Message, error) {
-	_, data, err := c.conn.ReadMessage()
-	if err != nil {
-		return nil, err
-	}
-
-	var msg Message
-	if err := json.Unmarshal(data, &msg); err != nil {
-		return nil, err
-	}
-
-	return &msg, nil
+func (c *Client) readMessage() (*agentproto.Message, error) {
+	return c.conn.Read()
 }
 
 // CreateSession creates a new session in the agent
 func (c *Client) CreateSession(id, command, workDir string, rows, cols int) error {
-	if err := c.send(Message{
-		Type:      MsgCreate,
+	return c.CreateSessionWithEnv(id, command, workDir, nil, rows, cols)
+}
+
+// CreateSessionWithEnv is CreateSession with extra KEY=value environment
+// variables for the session's process. The agent treats their values as
+// secrets and masks them in the session's output.
+func (c *Client) CreateSessionWithEnv(id, command, workDir string, env []string, rows, cols int) error {
+	if err := c.send(agentproto.Message{
+		Type:      agentproto.MsgCreate,
 		SessionID: id,
 		Command:   command,
 		WorkDir:   workDir,
+		Env:       env,
 		Rows:      rows,
 		Cols:      cols,
 	}); err != nil {
@@ -173,7 +195,7 @@ func (c *Client) CreateSession(id, command, workDir string, rows, cols int) erro
 		return err
 	}
 
-	if resp.Type == MsgError {
+	if resp.Type == agentproto.MsgError {
 		return fmt.Errorf("agent error: %s", resp.Error)
 	}
 
@@ -182,8 +204,8 @@ func (c *Client) CreateSession(id, command, workDir string, rows, cols int) erro
 
 // AttachSession attaches to an existing session
 func (c *Client) AttachSession(id string) error {
-	if err := c.send(Message{
-		Type:      MsgAttach,
+	if err := c.send(agentproto.Message{
+		Type:      agentproto.MsgAttach,
 		SessionID: id,
 	}); err != nil {
 		return err
@@ -194,7 +216,7 @@ func (c *Client) AttachSession(id string) error {
 		return err
 	}
 
-	if resp.Type == MsgError {
+	if resp.Type == agentproto.MsgError {
 		return fmt.Errorf("agent error: %s", resp.Error)
 	}
 
@@ -203,8 +225,8 @@ func (c *Client) AttachSession(id string) error {
 
 // SendInput sends input to a session
 func (c *Client) SendInput(sessionID string, data []byte) error {
-	return c.send(Message{
-		Type:      MsgInput,
+	return c.send(agentproto.Message{
+		Type:      agentproto.MsgInput,
 		SessionID: sessionID,
 		Data:      data,
 	})
@@ -212,17 +234,24 @@ func (c *Client) SendInput(sessionID string, data []byte) error {
 
 // Resize resizes a session's terminal
 func (c *Client) Resize(sessionID string, rows, cols int) error {
-	return c.send(Message{
-		Type:      MsgResize,
+	return c.send(agentproto.Message{
+		Type:      agentproto.MsgResize,
 		SessionID: sessionID,
 		Rows:      rows,
 		Cols:      cols,
 	})
 }
 
+// SetExitHandler sets a callback for sessions exiting while attached
+func (c *Client) SetExitHandler(handler func(sessionID string, exitCode int)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onExit = handler
+}
+
 // ListSessions lists all active sessions
 func (c *Client) ListSessions() ([]string, error) {
-	if err := c.send(Message{Type: MsgList}); err != nil {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgList}); err != nil {
 		return nil, err
 	}
 
@@ -231,13 +260,69 @@ func (c *Client) ListSessions() ([]string, error) {
 		return nil, err
 	}
 
-	if resp.Type == MsgError {
+	if resp.Type == agentproto.MsgError {
 		return nil, fmt.Errorf("agent error: %s", resp.Error)
 	}
 
 	return resp.Sessions, nil
 }
 
+// SessionInfo lists every session the agent knows of, including ended
+// ones. Agents that predate it report only running session IDs.
+func (c *Client) SessionInfo() ([]agentproto.SessionInfo, error) {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgList}); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Type == agentproto.MsgError {
+		return nil, fmt.Errorf("agent error: %s", resp.Error)
+	}
+	if resp.Info == nil {
+		info := make([]agentproto.SessionInfo, len(resp.Sessions))
+		for i, id := range resp.Sessions {
+			info[i] = agentproto.SessionInfo{ID: id, State: agentproto.SessionRunning}
+		}
+		return info, nil
+	}
+
+	return resp.Info, nil
+}
+
+// Activity asks the agent whether a session looks stuck.
+// idleAfter of zero uses the agent's default threshold.
+func (c *Client) Activity(sessionID string, idleAfter time.Duration) (*terminal.StuckReport, error) {
+	if err := c.send(agentproto.Message{
+		Type:      agentproto.MsgActivity,
+		SessionID: sessionID,
+		IdleAfter: int(idleAfter / time.Second),
+	}); err != nil {
+		return nil, err
+	}
+
+	// Older agents silently ignore unknown message types; don't wait forever.
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	resp, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Type == agentproto.MsgError {
+		return nil, fmt.Errorf("agent error: %s", resp.Error)
+	}
+	if resp.Activity == nil {
+		return nil, fmt.Errorf("agent returned no activity report")
+	}
+
+	return resp.Activity, nil
+}
+
 // ReadLoop reads messages from the agent and dispatches output.
 // This should be called in a goroutine. It blocks until the connection is closed.
 func (c *Client) ReadLoop() error {
@@ -251,7 +336,8 @@ func (c *Client) ReadLoop() error {
 			return err
 		}
 
-		if msg.Type == MsgOutput {
+		switch msg.Type {
+		case agentproto.MsgOutput:
 			c.mu.Lock()
 			handler := c.onOutput
 			c.mu.Unlock()
@@ -259,6 +345,14 @@ func (c *Client) ReadLoop() error {
 			if handler != nil {
 				handler(msg.SessionID, msg.Data)
 			}
+		case agentproto.MsgExit:
+			c.mu.Lock()
+			handler := c.onExit
+			c.mu.Unlock()
+
+			if handler != nil && msg.ExitCode != nil {
+				handler(msg.SessionID, *msg.ExitCode)
+			}
 		}
 	}
 }
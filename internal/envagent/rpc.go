@@ -0,0 +1,254 @@
+package envagent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+)
+
+// writeChunkSize is how much of a file each data message carries.
+const writeChunkSize = 256 * 1024
+
+// AgentError is an error reported by the agent, as opposed to one reaching
+// it. Errors for missing files match fs.ErrNotExist.
+type AgentError struct {
+	Message string
+	Code    string
+}
+
+func (e *AgentError) Error() string {
+	return "agent error: " + e.Message
+}
+
+func (e *AgentError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.Code == agentproto.CodeNotExist
+}
+
+func agentError(msg *agentproto.Message) error {
+	return &AgentError{Message: msg.Error, Code: msg.Code}
+}
+
+// HasFeature reports whether the agent serves the RPCs of feature, one of
+// the agentproto.Feature constants. Agents that predate an RPC ignore it,
+// so check before calling.
+func (c *Client) HasFeature(feature string) bool {
+	return slices.Contains(c.features, feature)
+}
+
+// Stat describes a file.
+func (c *Client) Stat(path string) (*agentproto.FileInfo, error) {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgStat, Path: path}); err != nil {
+		return nil, err
+	}
+	resp, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == agentproto.MsgError {
+		return nil, agentError(resp)
+	}
+	if resp.File == nil {
+		return nil, fmt.Errorf("agent returned no file info")
+	}
+	return resp.File, nil
+}
+
+// ReadFile streams a file's contents to w.
+func (c *Client) ReadFile(path string, w io.Writer) error {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgReadFile, Path: path}); err != nil {
+		return err
+	}
+	for {
+		resp, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		switch resp.Type {
+		case agentproto.MsgData:
+			if _, err := w.Write(resp.Data); err != nil {
+				// Drain the rest so the connection stays usable
+				c.drainUntil(agentproto.MsgEOF)
+				return err
+			}
+		case agentproto.MsgEOF:
+			return nil
+		case agentproto.MsgError:
+			return agentError(resp)
+		}
+	}
+}
+
+// WriteFile streams r to a file, creating its directory if needed. The
+// file is replaced once r is written in full. Mode 0 means 0644.
+func (c *Client) WriteFile(path string, mode os.FileMode, r io.Reader) error {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgWriteFile, Path: path, Mode: uint32(mode.Perm())}); err != nil {
+		return err
+	}
+	return c.sendFile(r)
+}
+
+// sendFile streams r as data messages, then waits for the agent to
+// confirm it stored them.
+func (c *Client) sendFile(r io.Reader) error {
+	buf := make([]byte, writeChunkSize)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if err := c.send(agentproto.Message{Type: agentproto.MsgData, Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			// The agent discards the partial file at the next write_file
+			// or update, or when the connection closes
+			return readErr
+		}
+	}
+	if err := c.send(agentproto.Message{Type: agentproto.MsgEOF}); err != nil {
+		return err
+	}
+
+	resp, err := c.readMessage()
+	if err != nil {
+		return err
+	}
+	if resp.Type == agentproto.MsgError {
+		return agentError(resp)
+	}
+	return nil
+}
+
+// ListFiles lists a directory, or with recursive, everything under it
+// that git doesn't ignore.
+func (c *Client) ListFiles(dir string, recursive bool) ([]agentproto.FileInfo, error) {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgListFiles, Path: dir, Recursive: recursive}); err != nil {
+		return nil, err
+	}
+	resp, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == agentproto.MsgError {
+		return nil, agentError(resp)
+	}
+	return resp.Files, nil
+}
+
+// Exec runs command with sh in workDir, with env added to the agent's
+// environment, and streams its output to stdout and stderr. It returns
+// the command's exit code. If ctx ends first the client is closed, which
+// kills the command.
+func (c *Client) Exec(ctx context.Context, command, workDir string, env []string, stdout, stderr io.Writer) (int, error) {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgExec, Command: command, WorkDir: workDir, Env: env}); err != nil {
+		return -1, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+
+	for {
+		resp, err := c.readMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
+			return -1, err
+		}
+		switch resp.Type {
+		case agentproto.MsgStdout:
+			stdout.Write(resp.Data)
+		case agentproto.MsgStderr:
+			stderr.Write(resp.Data)
+		case agentproto.MsgExit:
+			if resp.ExitCode == nil {
+				return -1, fmt.Errorf("agent returned no exit code")
+			}
+			return *resp.ExitCode, nil
+		case agentproto.MsgError:
+			return -1, agentError(resp)
+		}
+	}
+}
+
+// Watch calls fn with changes under dir, as the agent notices them, until
+// ctx ends.
+func (c *Client) Watch(ctx context.Context, dir string, fn func([]agentproto.FileEvent)) error {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgWatch, Path: dir}); err != nil {
+		return err
+	}
+	resp, err := c.readMessage()
+	if err != nil {
+		return err
+	}
+	if resp.Type == agentproto.MsgError {
+		return agentError(resp)
+	}
+
+	// The agent confirms unwatch once no more events will follow
+	stop := context.AfterFunc(ctx, func() {
+		c.send(agentproto.Message{Type: agentproto.MsgUnwatch})
+	})
+	defer stop()
+
+	for {
+		resp, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		switch resp.Type {
+		case agentproto.MsgFileEvents:
+			if ctx.Err() == nil {
+				fn(resp.Events)
+			}
+		case agentproto.MsgOK:
+			return ctx.Err()
+		}
+	}
+}
+
+// Status reports on the agent and its sessions.
+func (c *Client) Status() (*agentproto.AgentStatus, error) {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgStatus}); err != nil {
+		return nil, err
+	}
+	resp, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == agentproto.MsgError {
+		return nil, agentError(resp)
+	}
+	if resp.Status == nil {
+		return nil, fmt.Errorf("agent returned no status")
+	}
+	return resp.Status, nil
+}
+
+// Update replaces the agent's binary with r, whose hex SHA-256 is
+// checksum. The agent checks the binary runs before installing it, and
+// restarts into it once no session or exec is running.
+func (c *Client) Update(r io.Reader, checksum string) error {
+	if err := c.send(agentproto.Message{Type: agentproto.MsgUpdate, Checksum: checksum}); err != nil {
+		return err
+	}
+	return c.sendFile(r)
+}
+
+// drainUntil reads and discards messages up to one of type typ or an
+// error.
+func (c *Client) drainUntil(typ string) {
+	for {
+		resp, err := c.readMessage()
+		if err != nil || resp.Type == typ || resp.Type == agentproto.MsgError {
+			return
+		}
+	}
+}
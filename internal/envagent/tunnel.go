@@ -0,0 +1,271 @@
+package envagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/justinmoon/cook/internal/agentproto"
+)
+
+// tunnelBuffer is how many messages of a tunnel's data are queued for its
+// reader before the connection's other tunnels wait on it.
+const tunnelBuffer = 64
+
+// Forwarder opens TCP connections inside an environment through its
+// cook-agent. Connections are multiplexed over one WebSocket, redialed if
+// it drops.
+type Forwarder struct {
+	addr, secret string
+
+	mu  sync.Mutex
+	mux *tunnelMux
+}
+
+// NewForwarder returns a forwarder through the agent at addr.
+func NewForwarder(addr, secret string) *Forwarder {
+	return &Forwarder{addr: addr, secret: secret}
+}
+
+// Dial connects to target, a host:port as seen from inside the
+// environment, e.g. "127.0.0.1:3000".
+func (f *Forwarder) Dial(ctx context.Context, target string) (net.Conn, error) {
+	mux, err := f.connect()
+	if err != nil {
+		return nil, err
+	}
+	return mux.open(ctx, target)
+}
+
+// Close closes the forwarder's WebSocket and every connection over it.
+func (f *Forwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mux != nil {
+		f.mux.client.Close()
+		f.mux = nil
+	}
+	return nil
+}
+
+func (f *Forwarder) connect() (*tunnelMux, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mux != nil {
+		select {
+		case <-f.mux.done:
+		default:
+			return f.mux, nil
+		}
+	}
+
+	client, err := Dial(f.addr, f.secret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.HasFeature(agentproto.FeatureTunnel) {
+		client.Close()
+		return nil, fmt.Errorf("cook-agent at %s does not support port forwarding (restart the environment to update it)", f.addr)
+	}
+	f.mux = newTunnelMux(client)
+	return f.mux, nil
+}
+
+// tunnelMux owns a client connection, reading it for every tunnel.
+type tunnelMux struct {
+	client *Client
+	done   chan struct{} // closed when the connection fails
+
+	mu      sync.Mutex
+	next    uint64
+	pending map[string]chan opened // tunnel_open awaiting a reply
+	tunnels map[string]*tunnel
+}
+
+// opened is the result of opening a tunnel.
+type opened struct {
+	conn net.Conn
+	err  error
+}
+
+// tunnel is the agent's end of a net.Pipe handed to the caller.
+type tunnel struct {
+	conn net.Conn
+	in   chan []byte   // data from the agent, closed when the agent closes the tunnel
+	done chan struct{} // closed with the tunnel
+	once sync.Once
+}
+
+func (t *tunnel) close() {
+	t.once.Do(func() {
+		close(t.done)
+		t.conn.Close()
+	})
+}
+
+func newTunnelMux(client *Client) *tunnelMux {
+	m := &tunnelMux{
+		client:  client,
+		done:    make(chan struct{}),
+		pending: make(map[string]chan opened),
+		tunnels: make(map[string]*tunnel),
+	}
+	go m.readLoop()
+	return m
+}
+
+func (m *tunnelMux) open(ctx context.Context, target string) (net.Conn, error) {
+	m.mu.Lock()
+	m.next++
+	id := strconv.FormatUint(m.next, 10)
+	reply := make(chan opened, 1)
+	m.pending[id] = reply
+	m.mu.Unlock()
+
+	if err := m.client.send(agentproto.Message{Type: agentproto.MsgTunnelOpen, Tunnel: id, Addr: target}); err != nil {
+		m.mu.Lock()
+		delete(m.pending, id)
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case result := <-reply:
+		return result.conn, result.err
+	case <-ctx.Done():
+		// A late reply finds nothing pending and closes the tunnel
+		m.mu.Lock()
+		delete(m.pending, id)
+		m.mu.Unlock()
+		return nil, ctx.Err()
+	case <-m.done:
+		return nil, errors.New("connection to cook-agent closed")
+	}
+}
+
+func (m *tunnelMux) readLoop() {
+	defer func() {
+		m.mu.Lock()
+		for id, t := range m.tunnels {
+			t.close()
+			delete(m.tunnels, id)
+		}
+		m.mu.Unlock()
+		close(m.done)
+	}()
+
+	for {
+		msg, err := m.client.readMessage()
+		if errors.Is(err, agentproto.ErrMalformed) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case agentproto.MsgOK, agentproto.MsgError:
+			m.mu.Lock()
+			reply, ok := m.pending[msg.Tunnel]
+			delete(m.pending, msg.Tunnel)
+			if ok && msg.Type == agentproto.MsgError {
+				m.mu.Unlock()
+				reply <- opened{err: fmt.Errorf("agent error: %s", msg.Error)}
+				continue
+			}
+			if !ok {
+				m.mu.Unlock()
+				if msg.Type == agentproto.MsgOK {
+					m.client.send(agentproto.Message{Type: agentproto.MsgTunnelClose, Tunnel: msg.Tunnel})
+				}
+				continue
+			}
+			// Set the tunnel up before reading on, since its data may follow
+			local, remote := net.Pipe()
+			t := &tunnel{conn: remote, in: make(chan []byte, tunnelBuffer), done: make(chan struct{})}
+			m.tunnels[msg.Tunnel] = t
+			m.mu.Unlock()
+			go m.writeTunnel(t)
+			go m.readTunnel(msg.Tunnel, t)
+			reply <- opened{conn: local}
+
+		case agentproto.MsgTunnelData:
+			m.mu.Lock()
+			t := m.tunnels[msg.Tunnel]
+			m.mu.Unlock()
+			if t != nil {
+				select {
+				case t.in <- msg.Data:
+				case <-t.done:
+				}
+			}
+
+		case agentproto.MsgTunnelClose:
+			// The writer closes the tunnel once it has passed on the data
+			m.mu.Lock()
+			t := m.tunnels[msg.Tunnel]
+			delete(m.tunnels, msg.Tunnel)
+			m.mu.Unlock()
+			if t != nil {
+				close(t.in)
+			}
+		}
+	}
+}
+
+// writeTunnel passes the agent's data to the caller's end.
+func (m *tunnelMux) writeTunnel(t *tunnel) {
+	for {
+		select {
+		case data, ok := <-t.in:
+			if !ok {
+				t.close()
+				return
+			}
+			if _, err := t.conn.Write(data); err != nil {
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// readTunnel sends what the caller writes to the agent, until either side
+// closes.
+func (m *tunnelMux) readTunnel(id string, t *tunnel) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := t.conn.Read(buf)
+		if n > 0 {
+			data := append([]byte(nil), buf[:n]...)
+			if err := m.client.send(agentproto.Message{Type: agentproto.MsgTunnelData, Tunnel: id, Data: data}); err != nil {
+				m.closeTunnel(id, false)
+				return
+			}
+		}
+		if err != nil {
+			m.closeTunnel(id, true)
+			return
+		}
+	}
+}
+
+// closeTunnel closes a tunnel, telling the agent if notify is set and the
+// tunnel was still open.
+func (m *tunnelMux) closeTunnel(id string, notify bool) {
+	m.mu.Lock()
+	t, ok := m.tunnels[id]
+	delete(m.tunnels, id)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	t.close()
+	if notify {
+		m.client.send(agentproto.Message{Type: agentproto.MsgTunnelClose, Tunnel: id})
+	}
+}
@@ -16,6 +16,8 @@ const (
 	EventBranchCreated   EventType = "branch.created"
 	EventBranchMerged    EventType = "branch.merged"
 	EventBranchAbandoned EventType = "branch.abandoned"
+	EventBranchSuspended EventType = "branch.suspended"
+	EventBranchResumed   EventType = "branch.resumed"
 
 	// Gate events
 	EventGateStarted EventType = "gate.started"
@@ -25,6 +27,20 @@ const (
 	// Agent events
 	EventAgentStarted   EventType = "agent.started"
 	EventAgentCompleted EventType = "agent.completed"
+	EventAgentNeedsHelp EventType = "agent.needs_help"
+	EventAgentResumed   EventType = "agent.resumed"
+
+	// Fix loop events (agent asked to repair failing gates)
+	EventFixAttempt   EventType = "fix.attempt"
+	EventFixExhausted EventType = "fix.exhausted"
+
+	// Budget events
+	EventBudgetWarning  EventType = "budget.warning"
+	EventBudgetExceeded EventType = "budget.exceeded"
+
+	// Review events
+	EventReviewComment   EventType = "review.comment"
+	EventReviewSubmitted EventType = "review.submitted"
 
 	// Task events
 	EventTaskCreated EventType = "task.created"
@@ -133,11 +149,13 @@ func (b *Bus) subjectFor(event Event) string {
 	// Repo format is "owner/name" which we encode as "owner.name" for NATS subjects
 	repoKey := strings.ReplaceAll(event.Repo, "/", ".")
 	switch event.Type {
-	case EventBranchCreated, EventBranchMerged, EventBranchAbandoned:
+	case EventBranchCreated, EventBranchMerged, EventBranchAbandoned,
+		EventReviewComment, EventReviewSubmitted:
 		return fmt.Sprintf("cook.branch.%s.%s.%s", repoKey, event.Branch, event.Type)
 	case EventGateStarted, EventGatePassed, EventGateFailed:
 		return fmt.Sprintf("cook.gate.%s.%s.%s.%s", repoKey, event.Branch, event.GateName, event.Type)
-	case EventAgentStarted, EventAgentCompleted:
+	case EventAgentStarted, EventAgentCompleted, EventAgentNeedsHelp, EventAgentResumed,
+		EventFixAttempt, EventFixExhausted, EventBudgetWarning, EventBudgetExceeded:
 		return fmt.Sprintf("cook.agent.%s.%s.%s", repoKey, event.Branch, event.Type)
 	case EventTaskCreated, EventTaskClosed:
 		return fmt.Sprintf("cook.task.%s.%s.%s", repoKey, event.TaskID, event.Type)
@@ -40,6 +40,14 @@ func TestSubjectFor(t *testing.T) {
 			Event{Type: EventAgentStarted, Repo: "alice/myrepo", Branch: "feature-x"},
 			"cook.agent.alice.myrepo.feature-x.agent.started",
 		},
+		{
+			Event{Type: EventAgentNeedsHelp, Repo: "alice/myrepo", Branch: "feature-x"},
+			"cook.agent.alice.myrepo.feature-x.agent.needs_help",
+		},
+		{
+			Event{Type: EventBudgetExceeded, Repo: "alice/myrepo", Branch: "feature-x"},
+			"cook.agent.alice.myrepo.feature-x.budget.exceeded",
+		},
 
 		// Task events
 		{
@@ -4,12 +4,87 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/justinmoon/cook/internal/budget"
+	"github.com/justinmoon/cook/internal/db"
+	"github.com/justinmoon/cook/internal/secret"
 )
 
 type RepoConfig struct {
-	Gates []Gate `toml:"gates"`
+	Gates     []Gate            `toml:"gates"`
+	Budget    budget.RepoBudget `toml:"budget"`
+	Prompts   map[string]string `toml:"prompts"` // inline prompt templates by name
+	FixLoop   FixLoopConfig     `toml:"fix_loop"`
+	Review    ReviewConfig      `toml:"review"`
+	Resources ResourcesConfig   `toml:"resources"`
+	Secrets   SecretsConfig     `toml:"secrets"`
+}
+
+// ResourcesConfig selects resource profiles, defined in the server config,
+// for the repo's branches.
+type ResourcesConfig struct {
+	Profile  string            `toml:"profile"`  // default for the repo's branches
+	Branches map[string]string `toml:"branches"` // by branch name
+	Tasks    map[string]string `toml:"tasks"`    // by task slug
+}
+
+// ProfileFor returns the profile for a branch started from task (which may
+// be empty): the branch's own, then the task's, then the repo default.
+func (c ResourcesConfig) ProfileFor(branch, task string) string {
+	if profile := c.Branches[branch]; profile != "" {
+		return profile
+	}
+	if profile := c.Tasks[task]; task != "" && profile != "" {
+		return profile
+	}
+	return c.Profile
+}
+
+// SecretsConfig selects the stored secrets (see 'cook secret set') the
+// repo's environments and gates get.
+type SecretsConfig struct {
+	Env   []string          `toml:"env"`   // names set as environment variables
+	Files map[string]string `toml:"files"` // name -> path, relative to the checkout, to write the value to
+}
+
+// ResolveSecrets returns the secrets cfg selects for repoRef, or nil if
+// it selects none. key is the server's secrets_key.
+func ResolveSecrets(database *db.DB, key, repoRef string, cfg SecretsConfig) (*secret.Resolved, error) {
+	if len(cfg.Env) == 0 && len(cfg.Files) == 0 {
+		return nil, nil
+	}
+	store, err := secret.NewStore(database, key)
+	if err != nil {
+		return nil, err
+	}
+	return store.ResolveFor(repoRef, cfg.Env, cfg.Files)
+}
+
+// ReviewConfig sets the code review requirements for merging.
+type ReviewConfig struct {
+	RequiredApprovals int `toml:"required_approvals"` // distinct approving reviewers needed to merge
+}
+
+// DefaultFixLoopIterations is used when fix_loop.max_iterations is unset.
+const DefaultFixLoopIterations = 3
+
+// FixLoopConfig opts a repo into feeding failing gate output back to the
+// branch's agent and re-running gates.
+type FixLoopConfig struct {
+	Enabled       bool          `toml:"enabled"`
+	MaxIterations int           `toml:"max_iterations"` // attempts before the task needs a human (default 3)
+	Template      string        `toml:"template"`       // prompt template name (default "fix")
+	Timeout       time.Duration `toml:"timeout"`        // how long to wait for the agent per attempt (default 30m)
+}
+
+// Iterations returns MaxIterations or the default.
+func (c FixLoopConfig) Iterations() int {
+	if c.MaxIterations > 0 {
+		return c.MaxIterations
+	}
+	return DefaultFixLoopIterations
 }
 
 // LoadRepoConfig loads gate configuration from cook.toml in the checkout
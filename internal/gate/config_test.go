@@ -0,0 +1,25 @@
+package gate
+
+import "testing"
+
+func TestResourcesConfig_ProfileFor(t *testing.T) {
+	cfg := ResourcesConfig{
+		Profile:  "small",
+		Branches: map[string]string{"big-refactor": "large"},
+		Tasks:    map[string]string{"train": "gpu", "big-refactor": "medium"},
+	}
+
+	tests := []struct {
+		branch, task, want string
+	}{
+		{"feature", "", "small"},
+		{"feature", "train", "gpu"},
+		{"big-refactor", "big-refactor", "large"},
+		{"train", "", "small"},
+	}
+	for _, tt := range tests {
+		if got := cfg.ProfileFor(tt.branch, tt.task); got != tt.want {
+			t.Errorf("ProfileFor(%q, %q) = %q, want %q", tt.branch, tt.task, got, tt.want)
+		}
+	}
+}
@@ -3,19 +3,50 @@ package gate
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/justinmoon/cook/internal/db"
 	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/redact"
+	"github.com/justinmoon/cook/internal/secret"
 )
 
 type Gate struct {
 	Name    string `json:"name" toml:"name"`
+	Kind    string `json:"kind,omitempty" toml:"kind"` // "" (command), "review" or "transform"
 	Command string `json:"command" toml:"command"`
+
+	// Review gates
+	Agent        string `json:"agent,omitempty" toml:"agent"`               // agent type (default claude)
+	FailOn       string `json:"fail_on,omitempty" toml:"fail_on"`           // lowest failing severity (default error)
+	Instructions string `json:"instructions,omitempty" toml:"instructions"` // extra guidance for the reviewer
+}
+
+const (
+	KindCommand   = "command"
+	KindReview    = "review"
+	KindTransform = "transform"
+)
+
+// IsReview returns true for agent code review gates.
+func (g Gate) IsReview() bool {
+	return g.Kind == KindReview
+}
+
+// IsTransform returns true for gates whose command may rewrite the tree.
+func (g Gate) IsTransform() bool {
+	return g.Kind == KindTransform
+}
+
+// Runnable returns true if cook can execute the gate (as opposed to e.g. human approval).
+func (g Gate) Runnable() bool {
+	return g.IsReview() || g.Command != ""
 }
 
 type GateRun struct {
@@ -29,6 +60,7 @@ type GateRun struct {
 	FinishedAt *time.Time `json:"finished_at,omitempty"`
 	ExitCode   *int       `json:"exit_code,omitempty"`
 	LogPath    string     `json:"log_path,omitempty"`
+	Findings   []Finding  `json:"findings,omitempty"` // review gates only
 }
 
 // BranchFullName returns repo/name format
@@ -46,12 +78,22 @@ const (
 type Store struct {
 	db      *db.DB
 	dataDir string
+
+	secretEnv []string // KEY=VALUE pairs for command gates
+	redactor  *redact.Redactor
 }
 
 func NewStore(database *db.DB, dataDir string) *Store {
 	return &Store{db: database, dataDir: dataDir}
 }
 
+// SetSecrets passes resolved secrets to command gates as environment
+// variables and masks their values in gate logs.
+func (s *Store) SetSecrets(res *secret.Resolved) {
+	s.secretEnv = res.Environ()
+	s.redactor = res.Redactor()
+}
+
 func (s *Store) CreateRun(run *GateRun) error {
 	err := s.db.QueryRow(`
 		INSERT INTO gate_runs (branch_repo, branch_name, gate_name, rev, status, started_at, log_path)
@@ -66,17 +108,24 @@ func (s *Store) CreateRun(run *GateRun) error {
 }
 
 func (s *Store) UpdateRun(run *GateRun) error {
-	_, err := s.db.Exec(`
+	findingsJSON, err := json.Marshal(run.Findings)
+	if err != nil {
+		return err
+	}
+	if run.Findings == nil {
+		findingsJSON = []byte("[]")
+	}
+	_, err = s.db.Exec(`
 		UPDATE gate_runs 
-		SET status = $1, finished_at = $2, exit_code = $3
-		WHERE id = $4
-	`, run.Status, run.FinishedAt, run.ExitCode, run.ID)
+		SET status = $1, finished_at = $2, exit_code = $3, findings_json = $4
+		WHERE id = $5
+	`, run.Status, run.FinishedAt, run.ExitCode, string(findingsJSON), run.ID)
 	return err
 }
 
 func (s *Store) GetLatestRun(repo, branchName, gateName string) (*GateRun, error) {
 	row := s.db.QueryRow(`
-		SELECT id, branch_repo, branch_name, gate_name, rev, status, started_at, finished_at, exit_code, log_path
+		SELECT id, branch_repo, branch_name, gate_name, rev, status, started_at, finished_at, exit_code, log_path, findings_json
 		FROM gate_runs 
 		WHERE branch_repo = $1 AND branch_name = $2 AND gate_name = $3
 		ORDER BY id DESC
@@ -88,7 +137,7 @@ func (s *Store) GetLatestRun(repo, branchName, gateName string) (*GateRun, error
 
 func (s *Store) ListRuns(repo, branchName string) ([]GateRun, error) {
 	rows, err := s.db.Query(`
-		SELECT id, branch_repo, branch_name, gate_name, rev, status, started_at, finished_at, exit_code, log_path
+		SELECT id, branch_repo, branch_name, gate_name, rev, status, started_at, finished_at, exit_code, log_path, findings_json
 		FROM gate_runs 
 		WHERE branch_repo = $1 AND branch_name = $2
 		ORDER BY id DESC
@@ -143,12 +192,17 @@ func (s *Store) RunGate(gate Gate, repo, branchName, rev, checkoutPath string) (
 	defer logFile.Close()
 
 	// Run the command
+	out := s.redactor.Writer(logFile)
 	cmd := exec.Command("sh", "-c", gate.Command)
 	cmd.Dir = checkoutPath
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if len(s.secretEnv) > 0 {
+		cmd.Env = append(os.Environ(), s.secretEnv...)
+	}
 
 	err = cmd.Run()
+	out.Close()
 
 	finishedAt := time.Now()
 	run.FinishedAt = &finishedAt
@@ -201,10 +255,10 @@ func (s *Store) RunGateRemote(gate Gate, repo, branchName, rev string, backend e
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-	output, err := backend.Exec(ctx, gate.Command)
+	output, err := backend.Exec(ctx, secretExports(s.secretEnv)+gate.Command)
 
 	// Write output to log file
-	if writeErr := os.WriteFile(logPath, output, 0644); writeErr != nil {
+	if writeErr := os.WriteFile(logPath, s.redactor.Redact(output), 0644); writeErr != nil {
 		fmt.Printf("failed to write gate log: %v\n", writeErr)
 	}
 
@@ -228,15 +282,27 @@ func (s *Store) RunGateRemote(gate Gate, repo, branchName, rev string, backend e
 	return run, nil
 }
 
+// secretExports returns a shell prefix exporting env, for commands run
+// through a backend's Exec.
+func secretExports(env []string) string {
+	var b strings.Builder
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(&b, "export %s='%s'; ", name, strings.ReplaceAll(value, "'", `'\''`))
+	}
+	return b.String()
+}
+
 func scanGateRun(row *sql.Row) (*GateRun, error) {
 	var run GateRun
 	var startedAt, finishedAt sql.NullTime
 	var exitCode sql.NullInt64
 	var logPath sql.NullString
+	var findingsJSON string
 
 	err := row.Scan(
 		&run.ID, &run.BranchRepo, &run.BranchName, &run.GateName, &run.Rev, &run.Status,
-		&startedAt, &finishedAt, &exitCode, &logPath,
+		&startedAt, &finishedAt, &exitCode, &logPath, &findingsJSON,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -258,6 +324,9 @@ func scanGateRun(row *sql.Row) (*GateRun, error) {
 	if logPath.Valid {
 		run.LogPath = logPath.String
 	}
+	if err := json.Unmarshal([]byte(findingsJSON), &run.Findings); err != nil {
+		return nil, err
+	}
 
 	return &run, nil
 }
@@ -267,10 +336,11 @@ func scanGateRunRows(rows *sql.Rows) (*GateRun, error) {
 	var startedAt, finishedAt sql.NullTime
 	var exitCode sql.NullInt64
 	var logPath sql.NullString
+	var findingsJSON string
 
 	err := rows.Scan(
 		&run.ID, &run.BranchRepo, &run.BranchName, &run.GateName, &run.Rev, &run.Status,
-		&startedAt, &finishedAt, &exitCode, &logPath,
+		&startedAt, &finishedAt, &exitCode, &logPath, &findingsJSON,
 	)
 	if err != nil {
 		return nil, err
@@ -289,6 +359,33 @@ func scanGateRunRows(rows *sql.Rows) (*GateRun, error) {
 	if logPath.Valid {
 		run.LogPath = logPath.String
 	}
+	if err := json.Unmarshal([]byte(findingsJSON), &run.Findings); err != nil {
+		return nil, err
+	}
 
 	return &run, nil
 }
+
+// Run executes any runnable gate kind in a local checkout. baseRev is only
+// used by review gates. For transform gates the returned run's Rev may be a
+// new commit.
+func (s *Store) Run(gate Gate, repo, branchName, baseRev, rev, checkoutPath string) (*GateRun, error) {
+	switch {
+	case gate.IsReview():
+		return s.RunReviewGate(gate, repo, branchName, baseRev, rev, LocalExecutor(checkoutPath))
+	case gate.IsTransform():
+		return s.RunTransformGate(gate, repo, branchName, rev, LocalExecutor(checkoutPath))
+	}
+	return s.RunGate(gate, repo, branchName, rev, checkoutPath)
+}
+
+// RunOnBackend executes any runnable gate kind on a remote backend.
+func (s *Store) RunOnBackend(gate Gate, repo, branchName, baseRev, rev string, backend env.Backend) (*GateRun, error) {
+	switch {
+	case gate.IsReview():
+		return s.RunReviewGate(gate, repo, branchName, baseRev, rev, backend.Exec)
+	case gate.IsTransform():
+		return s.RunTransformGate(gate, repo, branchName, rev, backend.Exec)
+	}
+	return s.RunGateRemote(gate, repo, branchName, rev, backend)
+}
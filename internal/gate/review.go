@@ -0,0 +1,195 @@
+package gate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agent"
+)
+
+// reviewTimeout bounds a single headless review.
+const reviewTimeout = 20 * time.Minute
+
+// Severity of a review finding.
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Finding is a single problem reported by a review gate.
+type Finding struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func severityRank(severity string) int {
+	switch strings.ToLower(severity) {
+	case SeverityInfo:
+		return 0
+	case SeverityError:
+		return 2
+	default:
+		return 1 // unknown severities count as warnings
+	}
+}
+
+// ReviewPasses returns true if no finding is at or above failOn
+// (default "error").
+func ReviewPasses(findings []Finding, failOn string) bool {
+	if failOn == "" {
+		failOn = SeverityError
+	}
+	threshold := severityRank(failOn)
+	for _, f := range findings {
+		if severityRank(f.Severity) >= threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// Executor runs a shell command in a branch checkout and returns its combined output.
+type Executor func(ctx context.Context, command string) ([]byte, error)
+
+// LocalExecutor runs commands in a checkout on this host.
+func LocalExecutor(checkoutPath string) Executor {
+	return func(ctx context.Context, command string) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Dir = checkoutPath
+		return cmd.CombinedOutput()
+	}
+}
+
+// ReviewPrompt is the instruction given to the headless reviewer.
+func ReviewPrompt(g Gate, baseRev, headRev string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Review the changes in this repository between %s and %s. ", baseRev, headRev)
+	fmt.Fprintf(&b, "Run `git diff %s..%s` to see them. Do not modify any files.\n\n", baseRev, headRev)
+	if g.Instructions != "" {
+		b.WriteString(strings.TrimSpace(g.Instructions))
+		b.WriteString("\n\n")
+	}
+	b.WriteString("Reply with only a JSON array of findings, one per problem, in this form:\n")
+	b.WriteString(`[{"file": "path/to/file.go", "line": 42, "severity": "error", "message": "what is wrong and why"}]`)
+	b.WriteString("\nSeverity is one of \"error\", \"warning\" or \"info\". Reply with [] if there are no problems.\n")
+	return b.String()
+}
+
+// ParseFindings extracts the JSON findings array from reviewer output.
+// It accepts a bare array, a ```json fenced block, or {"findings": [...]}.
+func ParseFindings(output []byte) ([]Finding, error) {
+	text := output
+	if i := bytes.Index(text, []byte("```json")); i >= 0 {
+		text = text[i+len("```json"):]
+		if j := bytes.Index(text, []byte("```")); j >= 0 {
+			text = text[:j]
+		}
+	}
+	text = bytes.TrimSpace(text)
+
+	var wrapped struct {
+		Findings []Finding `json:"findings"`
+	}
+	if bytes.HasPrefix(text, []byte("{")) && json.Unmarshal(text, &wrapped) == nil {
+		return wrapped.Findings, nil
+	}
+
+	start := bytes.IndexByte(text, '[')
+	end := bytes.LastIndexByte(text, ']')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no findings array in review output")
+	}
+
+	var findings []Finding
+	if err := json.Unmarshal(text[start:end+1], &findings); err != nil {
+		return nil, fmt.Errorf("invalid findings JSON: %w", err)
+	}
+	return findings, nil
+}
+
+// RunReviewGate runs a headless agent over baseRev..headRev and records
+// its findings. The run fails if any finding reaches the gate's fail_on
+// severity or the reviewer's output can't be parsed.
+func (s *Store) RunReviewGate(gate Gate, repo, branchName, baseRev, headRev string, run Executor) (*GateRun, error) {
+	logDir := filepath.Join(s.dataDir, "logs", repo, branchName)
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log dir: %w", err)
+	}
+
+	logPath := filepath.Join(logDir, fmt.Sprintf("%s-%s.log", gate.Name, time.Now().Format("20060102-150405")))
+
+	now := time.Now()
+	gateRun := &GateRun{
+		BranchRepo: repo,
+		BranchName: branchName,
+		GateName:   gate.Name,
+		Rev:        headRev,
+		Status:     StatusRunning,
+		StartedAt:  &now,
+		LogPath:    logPath,
+	}
+
+	if err := s.CreateRun(gateRun); err != nil {
+		return nil, err
+	}
+
+	agentType := agent.AgentClaude
+	if gate.Agent != "" {
+		agentType = agent.AgentType(gate.Agent)
+	}
+
+	var output []byte
+	var err error
+	if baseRev == headRev {
+		output = []byte("[]") // nothing to review
+	} else {
+		var command string
+		command, err = agent.HeadlessCommand(agentType, ReviewPrompt(gate, baseRev, headRev))
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), reviewTimeout)
+			output, err = run(ctx, command)
+			cancel()
+		}
+	}
+
+	var findings []Finding
+	if err == nil {
+		findings, err = ParseFindings(output)
+	}
+
+	logContent := output
+	if err != nil {
+		logContent = append(logContent, []byte("\nreview error: "+err.Error()+"\n")...)
+	}
+	if writeErr := os.WriteFile(logPath, logContent, 0644); writeErr != nil {
+		fmt.Printf("failed to write gate log: %v\n", writeErr)
+	}
+
+	finishedAt := time.Now()
+	gateRun.FinishedAt = &finishedAt
+	gateRun.Findings = findings
+
+	code := 0
+	gateRun.Status = StatusPassed
+	if err != nil || !ReviewPasses(findings, gate.FailOn) {
+		code = 1
+		gateRun.Status = StatusFailed
+	}
+	gateRun.ExitCode = &code
+
+	if err := s.UpdateRun(gateRun); err != nil {
+		return gateRun, fmt.Errorf("failed to update run: %w", err)
+	}
+
+	return gateRun, nil
+}
@@ -0,0 +1,53 @@
+package gate
+
+import "testing"
+
+func TestParseFindings(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   int
+	}{
+		{"bare array", `[{"file": "a.go", "line": 3, "severity": "error", "message": "nil deref"}]`, 1},
+		{"empty", "[]", 0},
+		{"with prose", "Here is my review:\n[{\"file\": \"a.go\", \"severity\": \"info\", \"message\": \"ok\"}]\nDone.", 1},
+		{"fenced", "Review:\n```json\n[{\"file\": \"a.go\", \"severity\": \"warning\", \"message\": \"x\"}, {\"file\": \"b.go\", \"severity\": \"info\", \"message\": \"y\"}]\n```\n", 2},
+		{"wrapped", `{"findings": [{"file": "a.go", "severity": "error", "message": "x"}]}`, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := ParseFindings([]byte(tt.output))
+			if err != nil {
+				t.Fatalf("ParseFindings: %v", err)
+			}
+			if len(findings) != tt.want {
+				t.Errorf("got %d findings, want %d", len(findings), tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseFindings([]byte("looks good to me")); err == nil {
+		t.Error("expected error for output without findings")
+	}
+}
+
+func TestReviewPasses(t *testing.T) {
+	findings := []Finding{
+		{File: "a.go", Severity: SeverityInfo},
+		{File: "b.go", Severity: SeverityWarning},
+	}
+
+	if !ReviewPasses(findings, "") {
+		t.Error("warnings should pass the default error threshold")
+	}
+	if ReviewPasses(findings, SeverityWarning) {
+		t.Error("warnings should fail fail_on = warning")
+	}
+	if !ReviewPasses(nil, SeverityInfo) {
+		t.Error("no findings should always pass")
+	}
+	if ReviewPasses([]Finding{{Severity: "critical"}}, SeverityWarning) {
+		t.Error("unknown severities should count as warnings")
+	}
+}
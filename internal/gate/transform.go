@@ -0,0 +1,191 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// transformTimeout bounds a transform command plus its commit.
+const transformTimeout = 10 * time.Minute
+
+// Ordered returns gates in execution order: transform gates first (in their
+// configured order), then the rest, so validation always sees the final rev.
+func Ordered(gates []Gate) []Gate {
+	ordered := make([]Gate, 0, len(gates))
+	for _, g := range gates {
+		if g.IsTransform() {
+			ordered = append(ordered, g)
+		}
+	}
+	for _, g := range gates {
+		if !g.IsTransform() {
+			ordered = append(ordered, g)
+		}
+	}
+	return ordered
+}
+
+// TransformCommitMessage is the message cook uses when committing a
+// transform gate's changes.
+func TransformCommitMessage(g Gate) string {
+	return fmt.Sprintf("cook: apply %s\n\nGenerated by the %s transform gate:\n\n    %s\n", g.Name, g.Name, g.Command)
+}
+
+// RunTransformGate runs a gate command that may modify the working tree and
+// commits the files it changed on the branch. The checkout must have no
+// uncommitted changes to tracked files, so the commit can't pick up the
+// agent's work in progress. The run's Rev is the resulting head: rev if
+// nothing changed, otherwise the new commit. Callers should record a
+// changed rev with branch.Store.UpdateHeadRev.
+func (s *Store) RunTransformGate(gate Gate, repo, branchName, rev string, run Executor) (*GateRun, error) {
+	logDir := filepath.Join(s.dataDir, "logs", repo, branchName)
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log dir: %w", err)
+	}
+
+	logPath := filepath.Join(logDir, fmt.Sprintf("%s-%s.log", gate.Name, time.Now().Format("20060102-150405")))
+
+	now := time.Now()
+	gateRun := &GateRun{
+		BranchRepo: repo,
+		BranchName: branchName,
+		GateName:   gate.Name,
+		Rev:        rev,
+		Status:     StatusRunning,
+		StartedAt:  &now,
+		LogPath:    logPath,
+	}
+
+	if err := s.CreateRun(gateRun); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), transformTimeout)
+	defer cancel()
+
+	var logContent []byte
+	var before string
+	err := checkClean(ctx, run)
+	if err == nil {
+		before, err = snapshotTree(ctx, run)
+	}
+	if err != nil {
+		logContent = []byte("transform not run: " + err.Error() + "\n")
+	} else {
+		logContent, err = run(ctx, gate.Command)
+	}
+
+	var newRev string
+	if err == nil {
+		newRev, err = commitTransform(ctx, gate, run, before)
+		if err != nil {
+			logContent = append(logContent, []byte("\ntransform commit failed: "+err.Error()+"\n")...)
+		} else if newRev != rev {
+			logContent = append(logContent, []byte("\ncommitted changes as "+newRev+"\n")...)
+		}
+	}
+
+	if writeErr := os.WriteFile(logPath, logContent, 0644); writeErr != nil {
+		fmt.Printf("failed to write gate log: %v\n", writeErr)
+	}
+
+	finishedAt := time.Now()
+	gateRun.FinishedAt = &finishedAt
+
+	if err != nil {
+		code := 1
+		gateRun.ExitCode = &code
+		gateRun.Status = StatusFailed
+	} else {
+		code := 0
+		gateRun.ExitCode = &code
+		gateRun.Status = StatusPassed
+		gateRun.Rev = newRev
+	}
+
+	if err := s.UpdateRun(gateRun); err != nil {
+		return gateRun, fmt.Errorf("failed to update run: %w", err)
+	}
+
+	return gateRun, nil
+}
+
+// checkClean fails if the checkout has uncommitted changes to tracked
+// files.
+func checkClean(ctx context.Context, run Executor) error {
+	status, err := run(ctx, "git status --porcelain --untracked-files=no")
+	if err != nil {
+		return fmt.Errorf("git status: %w: %s", err, strings.TrimSpace(string(status)))
+	}
+	if strings.TrimSpace(string(status)) != "" {
+		return fmt.Errorf("checkout has uncommitted changes; commit or discard them first:\n%s", status)
+	}
+	return nil
+}
+
+// snapshotTree returns a tree of everything in the checkout, untracked
+// files included, for commitTransform to diff against. The tree is built
+// in a scratch index, leaving the checkout's own untouched.
+func snapshotTree(ctx context.Context, run Executor) (string, error) {
+	output, err := run(ctx, `idx="$(git rev-parse --git-path cook-snapshot-index)" && rm -f "$idx" && `+
+		`GIT_INDEX_FILE="$idx" git add -A >/dev/null 2>&1 && GIT_INDEX_FILE="$idx" git write-tree; `+
+		`status=$?; rm -f "$idx"; exit $status`)
+	if err != nil {
+		return "", fmt.Errorf("snapshot: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// commitTransform commits the files that changed since the snapshot
+// before, and returns HEAD. Files that were untracked before the transform
+// (TASK.md, say) are left out even if it changed them.
+func commitTransform(ctx context.Context, g Gate, run Executor, before string) (string, error) {
+	after, err := snapshotTree(ctx, run)
+	if err != nil {
+		return "", err
+	}
+
+	changed, err := run(ctx, "git diff-tree -r -z --no-renames --name-only "+before+" "+after)
+	if err != nil {
+		return "", fmt.Errorf("git diff-tree: %w: %s", err, strings.TrimSpace(string(changed)))
+	}
+	untracked, err := run(ctx, "git diff-tree -r -z --no-renames --name-only --diff-filter=A HEAD "+before)
+	if err != nil {
+		return "", fmt.Errorf("git diff-tree: %w: %s", err, strings.TrimSpace(string(untracked)))
+	}
+	skip := make(map[string]bool)
+	for _, path := range strings.Split(string(untracked), "\x00") {
+		skip[path] = true
+	}
+	var paths []string
+	for _, path := range strings.Split(string(changed), "\x00") {
+		if path != "" && !skip[path] {
+			paths = append(paths, shellQuote(path))
+		}
+	}
+
+	if len(paths) > 0 {
+		msg := shellQuote(TransformCommitMessage(g))
+		// Fall back to a cook identity if the checkout has none configured
+		commit := "git --literal-pathspecs add -A -- " + strings.Join(paths, " ") +
+			" && if git config user.email >/dev/null; then git commit -q -m " + msg +
+			"; else git -c user.name=cook -c user.email=cook@localhost commit -q -m " + msg + "; fi"
+		if output, err := run(ctx, commit); err != nil {
+			return "", fmt.Errorf("git commit: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	head, err := run(ctx, "git rev-parse HEAD")
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse: %w", err)
+	}
+	return strings.TrimSpace(string(head)), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "'\"'\"'") + "'"
+}
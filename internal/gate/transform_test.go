@@ -0,0 +1,100 @@
+package gate
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOrdered(t *testing.T) {
+	gates := []Gate{
+		{Name: "test", Command: "go test ./..."},
+		{Name: "fmt", Kind: KindTransform, Command: "gofmt -w ."},
+		{Name: "review", Kind: KindReview},
+		{Name: "gen", Kind: KindTransform, Command: "go generate ./..."},
+	}
+
+	var names []string
+	for _, g := range Ordered(gates) {
+		names = append(names, g.Name)
+	}
+	if got := strings.Join(names, ","); got != "fmt,gen,test,review" {
+		t.Errorf("Ordered = %s", got)
+	}
+}
+
+func TestCommitTransform(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("config", "user.email", "test@example.com")
+	git("config", "user.name", "test")
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\n"), 0644)
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+	base := git("rev-parse", "HEAD")
+	os.WriteFile(filepath.Join(dir, "TASK.md"), []byte("task\n"), 0644)
+
+	// The transform touches the untracked TASK.md too, which stays out of
+	// the commit
+	g := Gate{Name: "fmt", Kind: KindTransform, Command: "echo b >> a.txt && echo gen > gen.txt && echo more >> TASK.md"}
+	run := LocalExecutor(dir)
+	ctx := context.Background()
+
+	if err := checkClean(ctx, run); err != nil {
+		t.Fatalf("checkClean: %v", err)
+	}
+	before, err := snapshotTree(ctx, run)
+	if err != nil {
+		t.Fatalf("snapshotTree: %v", err)
+	}
+
+	// No changes: HEAD stays put
+	rev, err := commitTransform(ctx, g, run, before)
+	if err != nil {
+		t.Fatalf("commitTransform: %v", err)
+	}
+	if rev != base {
+		t.Errorf("clean tree moved HEAD to %s", rev)
+	}
+
+	if _, err := run(ctx, g.Command); err != nil {
+		t.Fatalf("transform: %v", err)
+	}
+	rev, err = commitTransform(ctx, g, run, before)
+	if err != nil {
+		t.Fatalf("commitTransform: %v", err)
+	}
+	if rev == base {
+		t.Fatal("expected a new commit")
+	}
+	if msg := git("log", "-1", "--format=%s"); msg != "cook: apply fmt" {
+		t.Errorf("commit subject = %q", msg)
+	}
+	if files := git("show", "--name-only", "--format=", "HEAD"); files != "a.txt\ngen.txt" {
+		t.Errorf("committed files = %q, want a.txt and gen.txt", files)
+	}
+	if status := git("status", "--porcelain"); status != "?? TASK.md" {
+		t.Errorf("status after commit = %q, want only the untracked TASK.md", status)
+	}
+
+	// Uncommitted changes to tracked files stop the transform
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("wip\n"), 0644)
+	if err := checkClean(ctx, run); err == nil {
+		t.Error("expected checkClean to refuse a dirty checkout")
+	}
+}
@@ -0,0 +1,354 @@
+// Package pool keeps warm, repo-agnostic remote environments on hand so
+// that creating a branch only pays for the clone and checkout.
+package pool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/justinmoon/cook/internal/db"
+	"github.com/justinmoon/cook/internal/env"
+)
+
+const (
+	// refillInterval is how often the pool is topped up and aged out, on
+	// top of the refill that follows every claim.
+	refillInterval = time.Minute
+
+	// maxWarmAge is how long a warm environment waits to be claimed before
+	// it is replaced, give or take a refillInterval. Backends with a fixed
+	// lifetime (modal) extend it by that much; see env.Config.WarmFor.
+	maxWarmAge = 45 * time.Minute
+
+	// drainTimeout bounds tearing down the pool on shutdown.
+	drainTimeout = 30 * time.Second
+)
+
+// Stats describes one backend's pool.
+type Stats struct {
+	Backend  string  `json:"backend"`
+	Size     int     `json:"size"`    // target number of warm environments
+	Ready    int     `json:"ready"`   // warm and waiting to be claimed
+	Warming  int     `json:"warming"` // being provisioned
+	Hits     int64   `json:"hits"`    // claims served from the pool
+	Misses   int64   `json:"misses"`  // claims that fell back to a full setup
+	HitRate  float64 `json:"hit_rate"`
+	Failures int64   `json:"failures"` // environments that failed to warm
+}
+
+type entry struct {
+	id       int64 // env_pool row
+	env      env.Warmer
+	warmedAt time.Time
+}
+
+// Pool keeps Size warm environments per backend and hands them out to new
+// branches.
+type Pool struct {
+	db    *db.DB
+	sizes map[string]int
+
+	// newWarmer and openWarmer are replaced in tests
+	newWarmer  func(backendType env.Type, cfg env.Config) (env.Warmer, error)
+	openWarmer func(backendType env.Type, id string) (env.Warmer, error)
+
+	mu      sync.Mutex
+	ready   map[string][]*entry
+	warming map[string]int
+	stats   map[string]*Stats
+	refill  chan struct{}
+}
+
+// New creates a pool that keeps sizes[backend] warm environments for each
+// backend. Backends must support env.Warmer.
+func New(database *db.DB, sizes map[string]int) (*Pool, error) {
+	p := &Pool{
+		db:         database,
+		sizes:      make(map[string]int),
+		newWarmer:  env.NewWarmer,
+		openWarmer: env.OpenWarmer,
+		ready:      make(map[string][]*entry),
+		warming:    make(map[string]int),
+		stats:      make(map[string]*Stats),
+		refill:     make(chan struct{}, 1),
+	}
+	for backend, size := range sizes {
+		if !env.Warmable(env.Type(backend)) {
+			return nil, fmt.Errorf("%s backend does not support warm pools", backend)
+		}
+		if size < 0 {
+			return nil, fmt.Errorf("invalid pool size for %s: %d", backend, size)
+		}
+		if size == 0 {
+			continue
+		}
+		p.sizes[backend] = size
+		p.stats[backend] = &Stats{Backend: backend, Size: size}
+	}
+	return p, nil
+}
+
+// Run tears down environments left over from a previous run, then keeps
+// the pool full until ctx is done, when the warm environments are torn down.
+func (p *Pool) Run(ctx context.Context) {
+	p.reclaim()
+	p.fill(ctx)
+
+	ticker := time.NewTicker(refillInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.drain()
+			return
+		case <-ticker.C:
+			p.expire()
+			p.fill(ctx)
+		case <-p.refill:
+			p.fill(ctx)
+		}
+	}
+}
+
+// Claim hands out a warm environment of backendType prepared for cfg, or
+// nil if none is ready. An environment that fails to claim is torn down and
+// counted as a miss, so callers can always fall back to a full Setup.
+//
+// The environment's env_pool row is marked claimed rather than deleted:
+// it keeps gc off the environment until the caller has recorded its ID on
+// the branch and deleted the row (see branch.Store).
+func (p *Pool) Claim(ctx context.Context, backendType string, cfg env.Config) env.Backend {
+	if p.sizes[backendType] == 0 {
+		return nil
+	}
+	defer p.kick()
+
+	e := p.take(backendType)
+	if e == nil {
+		p.record(backendType, false)
+		log.Printf("pool: no warm %s environment for %s", backendType, cfg.Name)
+		return nil
+	}
+	p.markClaimed(e.id)
+
+	start := time.Now()
+	if err := e.env.Claim(ctx, cfg); err != nil {
+		p.record(backendType, false)
+		log.Printf("pool: failed to claim %s environment %s: %v", backendType, e.env.EnvID(), err)
+		e.env.Teardown(context.Background())
+		p.remove(e.id)
+		return nil
+	}
+	p.record(backendType, true)
+	log.Printf("pool: claimed %s environment %s for %s (took %v)", backendType, e.env.EnvID(), cfg.Name, time.Since(start).Round(time.Millisecond))
+	return e.env
+}
+
+// Stats returns each backend's pool stats, sorted by backend.
+func (p *Pool) Stats() []Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]Stats, 0, len(p.stats))
+	for backend, st := range p.stats {
+		s := *st
+		s.Ready = len(p.ready[backend])
+		s.Warming = p.warming[backend]
+		if total := s.Hits + s.Misses; total > 0 {
+			s.HitRate = float64(s.Hits) / float64(total)
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Backend < stats[j].Backend })
+	return stats
+}
+
+// kick asks Run to refill the pool.
+func (p *Pool) kick() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// take removes the oldest ready environment of backendType from the pool.
+func (p *Pool) take(backendType string) *entry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ready := p.ready[backendType]
+	if len(ready) == 0 {
+		return nil
+	}
+	e := ready[0]
+	p.ready[backendType] = ready[1:]
+	return e
+}
+
+func (p *Pool) record(backendType string, hit bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if hit {
+		p.stats[backendType].Hits++
+	} else {
+		p.stats[backendType].Misses++
+	}
+}
+
+// fill starts warming environments for every backend below its size.
+func (p *Pool) fill(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for backend, size := range p.sizes {
+		for n := len(p.ready[backend]) + p.warming[backend]; n < size; n++ {
+			p.warming[backend]++
+			go p.warm(ctx, backend)
+		}
+	}
+}
+
+// warm provisions one environment and adds it to the pool.
+func (p *Pool) warm(ctx context.Context, backend string) {
+	start := time.Now()
+	name := newEnvName()
+	w, err := p.newWarmer(env.Type(backend), env.Config{Name: name, SandboxName: name, WarmFor: maxWarmAge + refillInterval})
+	if err == nil {
+		err = w.Warm(ctx)
+	}
+	var id int64
+	if err == nil {
+		id, err = p.insert(backend, w.EnvID())
+	}
+
+	p.mu.Lock()
+	p.warming[backend]--
+	if err != nil {
+		p.stats[backend].Failures++
+		p.mu.Unlock()
+		log.Printf("pool: failed to warm %s environment: %v", backend, err)
+		if w != nil {
+			w.Teardown(context.Background())
+		}
+		return
+	}
+	p.ready[backend] = append(p.ready[backend], &entry{id: id, env: w, warmedAt: time.Now()})
+	p.mu.Unlock()
+
+	log.Printf("pool: warmed %s environment %s (took %v)", backend, w.EnvID(), time.Since(start).Round(time.Second))
+}
+
+// expire tears down environments that have waited longer than maxWarmAge.
+func (p *Pool) expire() {
+	var stale []*entry
+	p.mu.Lock()
+	for backend, ready := range p.ready {
+		fresh := ready[:0]
+		for _, e := range ready {
+			if time.Since(e.warmedAt) > maxWarmAge {
+				stale = append(stale, e)
+			} else {
+				fresh = append(fresh, e)
+			}
+		}
+		p.ready[backend] = fresh
+	}
+	p.mu.Unlock()
+
+	for _, e := range stale {
+		e.env.Teardown(context.Background())
+		p.remove(e.id)
+	}
+}
+
+// drain tears down every ready environment.
+func (p *Pool) drain() {
+	p.mu.Lock()
+	var all []*entry
+	for backend, ready := range p.ready {
+		all = append(all, ready...)
+		delete(p.ready, backend)
+	}
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	for _, e := range all {
+		if err := e.env.Teardown(ctx); err != nil {
+			log.Printf("pool: failed to tear down %s: %v", e.env.EnvID(), err)
+			continue
+		}
+		p.remove(e.id)
+	}
+}
+
+// reclaim tears down environments recorded by a previous run that didn't
+// shut down cleanly. They aren't reused: the tailnet proxy and other setup
+// state only live in memory. Rows of claimed environments are dropped
+// without a teardown: the branch that claimed them may have recorded them,
+// and gc finds them if it didn't.
+func (p *Pool) reclaim() {
+	if _, err := p.db.Exec(`DELETE FROM env_pool WHERE claimed_at IS NOT NULL`); err != nil {
+		log.Printf("pool: failed to forget claimed environments: %v", err)
+	}
+	rows, err := p.db.Query(`SELECT id, backend, env_id FROM env_pool`)
+	if err != nil {
+		log.Printf("pool: failed to list leftover environments: %v", err)
+		return
+	}
+	type leftover struct {
+		id      int64
+		backend string
+		envID   string
+	}
+	var leftovers []leftover
+	for rows.Next() {
+		var l leftover
+		if err := rows.Scan(&l.id, &l.backend, &l.envID); err != nil {
+			rows.Close()
+			log.Printf("pool: failed to list leftover environments: %v", err)
+			return
+		}
+		leftovers = append(leftovers, l)
+	}
+	rows.Close()
+
+	for _, l := range leftovers {
+		if w, err := p.openWarmer(env.Type(l.backend), l.envID); err == nil {
+			if err := w.Teardown(context.Background()); err != nil {
+				log.Printf("pool: failed to tear down leftover %s environment %s: %v", l.backend, l.envID, err)
+			}
+		}
+		p.remove(l.id)
+	}
+}
+
+func (p *Pool) insert(backend, envID string) (int64, error) {
+	var id int64
+	err := p.db.QueryRow(`INSERT INTO env_pool (backend, env_id) VALUES ($1, $2) RETURNING id`, backend, envID).Scan(&id)
+	return id, err
+}
+
+func (p *Pool) remove(id int64) {
+	p.db.Exec(`DELETE FROM env_pool WHERE id = $1`, id)
+}
+
+func (p *Pool) markClaimed(id int64) {
+	p.db.Exec(`UPDATE env_pool SET claimed_at = NOW() WHERE id = $1`, id)
+}
+
+// newEnvName returns a name for a warm environment. It can't name the
+// branch: that isn't known until the environment is claimed.
+func newEnvName() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return "cook-pool-" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,149 @@
+package pool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/testutil"
+)
+
+// fakeWarmer is a local backend that records its pool lifecycle.
+type fakeWarmer struct {
+	*env.LocalBackend
+	id string
+
+	mu       sync.Mutex
+	claimed  *env.Config
+	tornDown bool
+}
+
+func (f *fakeWarmer) Warm(ctx context.Context) error { return nil }
+
+func (f *fakeWarmer) Claim(ctx context.Context, cfg env.Config) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.claimed = &cfg
+	return nil
+}
+
+func (f *fakeWarmer) EnvID() string { return f.id }
+
+func (f *fakeWarmer) Teardown(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tornDown = true
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil, map[string]int{"docker": 1}); err == nil {
+		t.Error("expected error for a backend without warm support")
+	}
+	if _, err := New(nil, map[string]int{"modal": -1}); err == nil {
+		t.Error("expected error for a negative size")
+	}
+
+	p, err := New(nil, map[string]int{"modal": 0, "sprites": 2})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	stats := p.Stats()
+	if len(stats) != 1 || stats[0].Backend != "sprites" || stats[0].Size != 2 {
+		t.Errorf("Stats = %+v, want only sprites with size 2", stats)
+	}
+	if got := p.Claim(context.Background(), "modal", env.Config{}); got != nil {
+		t.Errorf("Claim from an unpooled backend = %v, want nil", got)
+	}
+}
+
+func TestPool(t *testing.T) {
+	database, cleanup := testutil.OpenTestDB(t)
+	defer cleanup()
+
+	p, err := New(database, map[string]int{"modal": 2})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var mu sync.Mutex
+	warmers := map[string]*fakeWarmer{}
+	p.newWarmer = func(backendType env.Type, cfg env.Config) (env.Warmer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		w := &fakeWarmer{LocalBackend: env.NewLocalBackendFromPath(t.TempDir()), id: cfg.Name}
+		warmers[cfg.Name] = w
+		return w, nil
+	}
+	p.openWarmer = func(backendType env.Type, id string) (env.Warmer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return warmers[id], nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	waitReady := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if stats := p.Stats(); stats[0].Ready == n {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("pool never reached %d ready: %+v", n, p.Stats())
+	}
+	waitReady(2)
+
+	claimed := p.Claim(ctx, "modal", env.Config{Name: "o-r-feature", BranchName: "feature"})
+	w, ok := claimed.(*fakeWarmer)
+	if !ok {
+		t.Fatalf("Claim = %v, want a warm environment", claimed)
+	}
+	if w.claimed == nil || w.claimed.BranchName != "feature" {
+		t.Errorf("claimed env got config %+v", w.claimed)
+	}
+
+	// The claim triggers a refill back to size
+	waitReady(2)
+	stats := p.Stats()[0]
+	if stats.Hits != 1 || stats.Misses != 0 || stats.HitRate != 1 {
+		t.Errorf("Stats after a hit = %+v", stats)
+	}
+
+	// Shutdown tears down the warm environments but not the claimed one
+	cancel()
+	<-done
+	mu.Lock()
+	for id, fw := range warmers {
+		if fw == w {
+			if fw.tornDown {
+				t.Errorf("claimed env %s was torn down", id)
+			}
+		} else if !fw.tornDown {
+			t.Errorf("warm env %s survived shutdown", id)
+		}
+	}
+	mu.Unlock()
+
+	// The claimed environment's row stays, keeping gc off it until its
+	// branch records it
+	var rows int
+	database.QueryRow(`SELECT COUNT(*) FROM env_pool`).Scan(&rows)
+	if rows != 1 {
+		t.Errorf("env_pool has %d rows after shutdown, want the claimed one", rows)
+	}
+	var claimedID string
+	database.QueryRow(`SELECT env_id FROM env_pool WHERE claimed_at IS NOT NULL`).Scan(&claimedID)
+	if claimedID != w.EnvID() {
+		t.Errorf("claimed row = %q, want %s", claimedID, w.EnvID())
+	}
+}
@@ -0,0 +1,146 @@
+package prompt
+
+import (
+	"os"
+	"strings"
+
+	"github.com/justinmoon/cook/internal/gate"
+	"github.com/justinmoon/cook/internal/task"
+)
+
+// maxGateOutputBytes caps how much of a failing gate's log is included.
+const maxGateOutputBytes = 8 * 1024
+
+// Data is what prompt templates can reference.
+type Data struct {
+	Task               *task.Task
+	Repo               string
+	Branch             string
+	AcceptanceCriteria []string
+	Dependencies       []Dependency
+	FailingGates       []GateFailure
+	Conventions        string
+}
+
+// Dependency summarizes a task the current task depends on.
+type Dependency struct {
+	Ref     string // repo/slug
+	Title   string
+	Status  string
+	Summary string // first paragraph of the body
+}
+
+// GateFailure is the latest failed run of a gate on the branch.
+type GateFailure struct {
+	Name     string
+	ExitCode int
+	Output   string // tail of the gate log
+}
+
+// Gather builds template data for a task on a branch. gateStore may be nil
+// (e.g. for a branch that hasn't been created yet).
+func Gather(taskStore *task.Store, gateStore *gate.Store, t *task.Task, branchName string, src Source) (*Data, error) {
+	data := &Data{
+		Task:               t,
+		Repo:               t.Repo,
+		Branch:             branchName,
+		AcceptanceCriteria: AcceptanceCriteria(t.Body),
+		Conventions:        LoadConventions(src),
+	}
+
+	for _, ref := range t.DependsOn {
+		depRepo, depSlug := splitRef(ref, t.Repo)
+		dep, err := taskStore.Get(depRepo, depSlug)
+		if err != nil {
+			return nil, err
+		}
+		if dep == nil {
+			data.Dependencies = append(data.Dependencies, Dependency{Ref: ref, Status: "missing"})
+			continue
+		}
+		data.Dependencies = append(data.Dependencies, Dependency{
+			Ref:     dep.FullName(),
+			Title:   dep.Title,
+			Status:  dep.Status,
+			Summary: firstParagraph(dep.Body),
+		})
+	}
+
+	if gateStore != nil {
+		failures, err := FailingGates(gateStore, t.Repo, branchName)
+		if err != nil {
+			return nil, err
+		}
+		data.FailingGates = failures
+	}
+
+	return data, nil
+}
+
+// FailingGates returns the latest run of each gate on a branch that failed.
+func FailingGates(gateStore *gate.Store, repo, branchName string) ([]GateFailure, error) {
+	runs, err := gateStore.ListRuns(repo, branchName)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]*gate.GateRun)
+	var order []string
+	for i := range runs {
+		r := &runs[i]
+		existing, ok := latest[r.GateName]
+		if !ok {
+			order = append(order, r.GateName)
+		}
+		if !ok || r.ID > existing.ID {
+			latest[r.GateName] = r
+		}
+	}
+
+	var failures []GateFailure
+	for _, name := range order {
+		r := latest[name]
+		if r.Status != gate.StatusFailed {
+			continue
+		}
+		f := GateFailure{Name: name, Output: tailFile(r.LogPath, maxGateOutputBytes)}
+		if r.ExitCode != nil {
+			f.ExitCode = *r.ExitCode
+		}
+		failures = append(failures, f)
+	}
+	return failures, nil
+}
+
+// splitRef parses "repo/slug" (owner/name/slug) or a bare slug in defaultRepo.
+func splitRef(ref, defaultRepo string) (string, string) {
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return defaultRepo, ref
+}
+
+func firstParagraph(body string) string {
+	body = strings.TrimSpace(body)
+	if i := strings.Index(body, "\n\n"); i >= 0 {
+		body = body[:i]
+	}
+	return strings.Join(strings.Fields(body), " ")
+}
+
+func tailFile(path string, maxBytes int) string {
+	if path == "" {
+		return ""
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if len(content) > maxBytes {
+		content = content[len(content)-maxBytes:]
+		if i := strings.IndexByte(string(content), '\n'); i >= 0 {
+			content = content[i+1:]
+		}
+	}
+	return strings.TrimRight(string(content), "\n")
+}
@@ -0,0 +1,243 @@
+// Package prompt renders per-repo Go templates into agent prompts: the
+// task brief (TASK.md) written when a task starts, and the fix-it prompt
+// sent when gates fail.
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultName is the template used when none is selected. A repo can
+// override it with a "default" entry in cook.toml or .cook/prompts/default.md.
+const DefaultName = "default"
+
+// FixName is the template used by the fix-failing-gates loop.
+const FixName = "fix"
+
+// PromptsDir is where file-based templates live, relative to the repo root.
+const PromptsDir = ".cook/prompts"
+
+// builtinDefault matches the TASK.md format cook has always written, plus
+// optional sections that only render when there is something to show.
+const builtinDefault = `# {{.Task.Title}}
+
+{{.Task.Body}}
+{{- if .Dependencies}}
+
+## Dependencies
+{{range .Dependencies}}
+- {{.Ref}} ({{.Status}}): {{.Title}}{{if .Summary}} - {{.Summary}}{{end}}
+{{- end}}
+{{- end}}
+{{- if .FailingGates}}
+
+## Failing gates
+{{range .FailingGates}}
+### {{.Name}}{{if .ExitCode}} (exit {{.ExitCode}}){{end}}
+
+` + "```" + `
+{{.Output}}
+` + "```" + `
+{{end}}
+{{- end}}
+{{- if .Conventions}}
+
+## Repo conventions
+
+{{.Conventions}}
+{{- end}}
+`
+
+// builtinFix asks the agent to repair the gates that failed on its branch.
+const builtinFix = `The following gates failed on branch {{.Branch}}. Fix the problems, then commit your changes.
+{{range .FailingGates}}
+## {{.Name}}{{if .ExitCode}} (exit {{.ExitCode}}){{end}}
+
+` + "```" + `
+{{.Output}}
+` + "```" + `
+{{end}}`
+
+// builtins are used when a repo doesn't define a template of the same name.
+var builtins = map[string]string{
+	DefaultName: builtinDefault,
+	FixName:     builtinFix,
+}
+
+// conventionFiles are checked in order for repo conventions.
+var conventionFiles = []string{".cook/conventions.md", "CONVENTIONS.md", "AGENTS.md"}
+
+// Source reads repo files for templates and conventions.
+type Source interface {
+	// ReadFile returns the contents of a repo-relative file.
+	ReadFile(name string) ([]byte, error)
+	// ReadDir returns the file names (not paths) in a repo-relative directory.
+	ReadDir(dir string) ([]string, error)
+}
+
+// DirSource reads from a checkout on disk.
+type DirSource string
+
+func (d DirSource) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+func (d DirSource) ReadDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(string(d), filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// BareRepoSource reads from HEAD of a bare repo.
+type BareRepoSource string
+
+func (b BareRepoSource) ReadFile(name string) ([]byte, error) {
+	return exec.Command("git", "-C", string(b), "show", "HEAD:"+name).Output()
+}
+
+func (b BareRepoSource) ReadDir(dir string) ([]string, error) {
+	output, err := exec.Command("git", "-C", string(b), "ls-tree", "--name-only", "HEAD", dir+"/").Output()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line != "" {
+			names = append(names, path.Base(line))
+		}
+	}
+	return names, nil
+}
+
+// Set is the collection of templates available to a repo.
+type Set struct {
+	templates map[string]string
+}
+
+// Load collects templates from .cook/prompts/*.md and the [prompts] table of
+// cook.toml. Inline templates win over files with the same name.
+func Load(src Source, inline map[string]string) *Set {
+	set := &Set{templates: make(map[string]string)}
+
+	if src != nil {
+		if names, err := src.ReadDir(PromptsDir); err == nil {
+			for _, name := range names {
+				if !strings.HasSuffix(name, ".md") {
+					continue
+				}
+				content, err := src.ReadFile(PromptsDir + "/" + name)
+				if err != nil {
+					continue
+				}
+				set.templates[strings.TrimSuffix(name, ".md")] = string(content)
+			}
+		}
+	}
+
+	for name, content := range inline {
+		set.templates[name] = content
+	}
+
+	return set
+}
+
+// Names returns the repo's template names, sorted.
+func (s *Set) Names() []string {
+	names := make([]string, 0, len(s.templates))
+	for name := range s.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render executes the named template. An empty name selects the default.
+// "default" and "fix" fall back to built-ins if the repo doesn't define them.
+func (s *Set) Render(name string, data *Data) (string, error) {
+	if name == "" {
+		name = DefaultName
+	}
+
+	source, ok := s.templates[name]
+	if !ok {
+		if source, ok = builtins[name]; !ok {
+			return "", fmt.Errorf("prompt template %q not found", name)
+		}
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse prompt template %q: %w", name, err)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template %q: %w", name, err)
+	}
+	return out.String(), nil
+}
+
+// LoadConventions returns the first repo conventions file found, or "".
+func LoadConventions(src Source) string {
+	if src == nil {
+		return ""
+	}
+	for _, name := range conventionFiles {
+		if content, err := src.ReadFile(name); err == nil {
+			return strings.TrimSpace(string(content))
+		}
+	}
+	return ""
+}
+
+// AcceptanceCriteria extracts list items under an "Acceptance Criteria"
+// heading in a task body. Checkbox markers are stripped.
+func AcceptanceCriteria(body string) []string {
+	var criteria []string
+	inSection := false
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "#") {
+			heading := strings.ToLower(strings.TrimSpace(strings.TrimLeft(line, "#")))
+			inSection = strings.HasPrefix(heading, "acceptance criteria")
+			continue
+		}
+		if !inSection {
+			continue
+		}
+
+		var item string
+		switch {
+		case strings.HasPrefix(line, "- "), strings.HasPrefix(line, "* "):
+			item = line[2:]
+		default:
+			continue
+		}
+		item = strings.TrimPrefix(item, "[ ] ")
+		item = strings.TrimPrefix(item, "[x] ")
+		item = strings.TrimPrefix(item, "[X] ")
+		if item = strings.TrimSpace(item); item != "" {
+			criteria = append(criteria, item)
+		}
+	}
+	return criteria
+}
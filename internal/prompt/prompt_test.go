@@ -0,0 +1,106 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/justinmoon/cook/internal/task"
+)
+
+func TestRenderBuiltinDefault(t *testing.T) {
+	set := Load(nil, nil)
+	data := &Data{Task: &task.Task{Title: "Add login", Body: "Users need to log in."}}
+
+	got, err := set.Render("", data)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	// With no extras, the built-in template matches the legacy TASK.md format.
+	want := "# Add login\n\nUsers need to log in.\n"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	data.Dependencies = []Dependency{{Ref: "alice/app/schema", Title: "Schema", Status: "closed"}}
+	data.FailingGates = []GateFailure{{Name: "ci", ExitCode: 2, Output: "FAIL: TestLogin"}}
+	got, err = set.Render("", data)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	for _, s := range []string{"## Dependencies", "alice/app/schema (closed): Schema", "### ci (exit 2)", "FAIL: TestLogin"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("Render() missing %q:\n%s", s, got)
+		}
+	}
+}
+
+func TestLoadFilesAndInline(t *testing.T) {
+	dir := t.TempDir()
+	promptsDir := filepath.Join(dir, ".cook", "prompts")
+	if err := os.MkdirAll(promptsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(promptsDir, "bugfix.md"), []byte("Fix: {{.Task.Title}} on {{.Branch}}"), 0644)
+	os.WriteFile(filepath.Join(promptsDir, "feature.md"), []byte("from file"), 0644)
+	os.WriteFile(filepath.Join(dir, "AGENTS.md"), []byte("Use tabs.\n"), 0644)
+
+	set := Load(DirSource(dir), map[string]string{"feature": "Build {{.Task.Title}}"})
+	if names := set.Names(); !reflect.DeepEqual(names, []string{"bugfix", "feature"}) {
+		t.Errorf("Names() = %v", names)
+	}
+
+	data := &Data{Task: &task.Task{Title: "crash"}, Branch: "fix-crash"}
+	if got, _ := set.Render("bugfix", data); got != "Fix: crash on fix-crash" {
+		t.Errorf("Render(bugfix) = %q", got)
+	}
+	if got, _ := set.Render("feature", data); got != "Build crash" {
+		t.Errorf("inline template should override file, got %q", got)
+	}
+	if _, err := set.Render("missing", data); err == nil {
+		t.Error("expected error for unknown template")
+	}
+
+	if got := LoadConventions(DirSource(dir)); got != "Use tabs." {
+		t.Errorf("LoadConventions() = %q", got)
+	}
+}
+
+func TestAcceptanceCriteria(t *testing.T) {
+	body := `Some context.
+
+## Acceptance Criteria
+
+- [ ] login form renders
+- [x] password is hashed
+* errors are shown
+
+## Notes
+
+- not a criterion
+`
+	got := AcceptanceCriteria(body)
+	want := []string{"login form renders", "password is hashed", "errors are shown"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AcceptanceCriteria() = %v, want %v", got, want)
+	}
+}
+
+func TestRenderBuiltinFix(t *testing.T) {
+	data := &Data{
+		Task:         &task.Task{Title: "Add login"},
+		Branch:       "add-login",
+		FailingGates: []GateFailure{{Name: "lint", ExitCode: 1, Output: "main.go:3: unused import"}},
+	}
+	got, err := Load(nil, nil).Render(FixName, data)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	for _, s := range []string{"branch add-login", "## lint (exit 1)", "main.go:3: unused import"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("Render(fix) missing %q:\n%s", s, got)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package redact masks secret values in output: logs, terminal streams
+// and anything else a secret could be echoed to.
+package redact
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Mask replaces secret values in redacted output.
+const Mask = "[REDACTED]"
+
+// minRedactLen is the shortest value redacted; masking every "1" or "y"
+// in a log would make it unreadable without protecting anything.
+const minRedactLen = 4
+
+// Redactor masks a set of secret values in output.
+type Redactor struct {
+	values [][]byte // longest first, so a value containing another is masked whole
+	maxLen int
+}
+
+// New returns a redactor for values, or nil if none is long enough to
+// redact. A nil *Redactor passes output through unchanged.
+func New(values []string) *Redactor {
+	r := &Redactor{}
+	for _, v := range values {
+		if len(v) < minRedactLen {
+			continue
+		}
+		r.values = append(r.values, []byte(v))
+		r.maxLen = max(r.maxLen, len(v))
+	}
+	if len(r.values) == 0 {
+		return nil
+	}
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+	return r
+}
+
+// Redact returns data with every secret value masked.
+func (r *Redactor) Redact(data []byte) []byte {
+	if r == nil {
+		return data
+	}
+	for _, v := range r.values {
+		if bytes.Contains(data, v) {
+			data = bytes.ReplaceAll(data, v, []byte(Mask))
+		}
+	}
+	return data
+}
+
+// Writer returns a writer that masks secret values before writing to w,
+// including values split across writes. Close flushes the held-back tail;
+// it does not close w.
+func (r *Redactor) Writer(w io.Writer) io.WriteCloser {
+	return &redactWriter{r: r, w: w}
+}
+
+type redactWriter struct {
+	r *Redactor
+	w io.Writer
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	if rw.r == nil {
+		return rw.w.Write(p)
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.buf = rw.r.Redact(append(rw.buf, p...))
+	// Hold back enough bytes to catch a value that continues in the next
+	// write
+	keep := min(len(rw.buf), rw.r.maxLen-1)
+	if _, err := rw.w.Write(rw.buf[:len(rw.buf)-keep]); err != nil {
+		return 0, err
+	}
+	rw.buf = append(rw.buf[:0], rw.buf[len(rw.buf)-keep:]...)
+	return len(p), nil
+}
+
+func (rw *redactWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if len(rw.buf) == 0 {
+		return nil
+	}
+	_, err := rw.w.Write(rw.buf)
+	rw.buf = nil
+	return err
+}
+
+// Stream returns a Stream that masks secret values in r's output chunk by
+// chunk.
+func (r *Redactor) Stream() *Stream {
+	return &Stream{r: r}
+}
+
+// Stream masks secret values in a stream of chunks, including values
+// split across chunks. Unlike Writer, it holds back only a tail that could
+// be the start of a value, so interactive output such as a shell prompt
+// isn't delayed. A Stream is not safe for concurrent use.
+type Stream struct {
+	r    *Redactor
+	tail []byte
+}
+
+// Redact returns the masked output that chunk completes. It may be empty.
+func (s *Stream) Redact(chunk []byte) []byte {
+	if s.r == nil {
+		return chunk
+	}
+	buf := s.r.Redact(append(s.tail, chunk...))
+	keep := s.r.partialSuffix(buf)
+	s.tail = append([]byte(nil), buf[len(buf)-keep:]...)
+	return buf[:len(buf)-keep]
+}
+
+// Flush returns the held-back tail, at the end of the stream.
+func (s *Stream) Flush() []byte {
+	tail := s.tail
+	s.tail = nil
+	return tail
+}
+
+// partialSuffix returns the length of the longest suffix of data that is
+// a proper prefix of a secret value.
+func (r *Redactor) partialSuffix(data []byte) int {
+	longest := 0
+	for _, v := range r.values {
+		for n := min(len(v)-1, len(data)); n > longest; n-- {
+			if bytes.HasSuffix(data, v[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
@@ -0,0 +1,61 @@
+package redact
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r := New([]string{"hunter2hunter2", "hunter2", "y"})
+	got := string(r.Redact([]byte("pw=hunter2hunter2 short=hunter2 y")))
+	if want := "pw=[REDACTED] short=[REDACTED] y"; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+
+	if New([]string{"y", ""}) != nil {
+		t.Error("expected nil redactor when no value is long enough")
+	}
+	var none *Redactor
+	if got := string(none.Redact([]byte("hunter2"))); got != "hunter2" {
+		t.Errorf("nil Redact = %q", got)
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := New([]string{"s3cr3t-token"}).Writer(&buf)
+	for _, chunk := range []string{"token: s3c", "r3t-to", "ken\nnext s3cr3t", "-token"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "token: [REDACTED]\nnext [REDACTED]"; buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestRedactStream(t *testing.T) {
+	stream := New([]string{"s3cr3t-token"}).Stream()
+	var out []string
+	for _, chunk := range []string{"$ ", "echo s3c", "r3t-to", "ken\n$ ", "s3"} {
+		out = append(out, string(stream.Redact([]byte(chunk))))
+	}
+	// The prompt isn't held back; only a possible start of the value is
+	want := []string{"$ ", "echo ", "", "[REDACTED]\n$ ", ""}
+	for i := range want {
+		if out[i] != want[i] {
+			t.Errorf("chunk %d = %q, want %q", i, out[i], want[i])
+		}
+	}
+	if tail := string(stream.Flush()); tail != "s3" {
+		t.Errorf("Flush = %q, want s3", tail)
+	}
+
+	var none *Redactor
+	if got := string(none.Stream().Redact([]byte("s3cr3t-token"))); got != "s3cr3t-token" {
+		t.Errorf("nil stream = %q", got)
+	}
+}
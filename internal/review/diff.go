@@ -0,0 +1,251 @@
+package review
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// File change statuses
+const (
+	FileAdded    = "added"
+	FileDeleted  = "deleted"
+	FileModified = "modified"
+	FileRenamed  = "renamed"
+)
+
+// FileDiff is one file's changes between two revs.
+type FileDiff struct {
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"` // set for renames
+	Status  string `json:"status"`
+	Binary  bool   `json:"binary,omitempty"`
+	Hunks   []Hunk `json:"hunks"`
+}
+
+// Hunk is a contiguous block of changes.
+type Hunk struct {
+	Header   string `json:"header"`
+	OldStart int    `json:"old_start"`
+	OldLines int    `json:"old_lines"`
+	NewStart int    `json:"new_start"`
+	NewLines int    `json:"new_lines"`
+	Lines    []Line `json:"lines"`
+}
+
+// Line kinds
+const (
+	LineContext = "context"
+	LineAdded   = "added"
+	LineDeleted = "deleted"
+)
+
+// Line is a single diff line. OldLine/NewLine are 0 when the line doesn't
+// exist on that side.
+type Line struct {
+	Kind    string `json:"kind"`
+	OldLine int    `json:"old_line,omitempty"`
+	NewLine int    `json:"new_line,omitempty"`
+	Content string `json:"content"`
+}
+
+// DiffCommand is the git invocation whose output ParseDiff expects.
+func DiffCommand(baseRev, headRev string, paths ...string) []string {
+	args := []string{"diff", "--no-color", "--no-ext-diff", "-M", baseRev, headRev}
+	if len(paths) > 0 {
+		args = append(args, "--")
+		args = append(args, paths...)
+	}
+	return args
+}
+
+// ParseDiff parses `git diff` output into per-file hunks.
+func ParseDiff(text string) ([]FileDiff, error) {
+	var files []FileDiff
+	var file *FileDiff
+	var hunk *Hunk
+	var oldLine, newLine int
+
+	flushHunk := func() {
+		if file != nil && hunk != nil {
+			file.Hunks = append(file.Hunks, *hunk)
+		}
+		hunk = nil
+	}
+	flushFile := func() {
+		flushHunk()
+		if file != nil {
+			files = append(files, *file)
+		}
+		file = nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "diff --git ") {
+			flushFile()
+			file = &FileDiff{Status: FileModified, Hunks: []Hunk{}}
+			if a, b, ok := splitGitPaths(strings.TrimPrefix(line, "diff --git ")); ok {
+				file.OldPath, file.Path = a, b
+			}
+			continue
+		}
+		if file == nil {
+			continue
+		}
+
+		if hunk != nil {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				hunk.Lines = append(hunk.Lines, Line{Kind: LineAdded, NewLine: newLine, Content: line[1:]})
+				newLine++
+				continue
+			case strings.HasPrefix(line, "-"):
+				hunk.Lines = append(hunk.Lines, Line{Kind: LineDeleted, OldLine: oldLine, Content: line[1:]})
+				oldLine++
+				continue
+			case strings.HasPrefix(line, " "), line == "":
+				content := ""
+				if line != "" {
+					content = line[1:]
+				}
+				hunk.Lines = append(hunk.Lines, Line{Kind: LineContext, OldLine: oldLine, NewLine: newLine, Content: content})
+				oldLine++
+				newLine++
+				continue
+			case strings.HasPrefix(line, `\`):
+				continue // "\ No newline at end of file"
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			flushHunk()
+			h, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			hunk = h
+			oldLine, newLine = h.OldStart, h.NewStart
+		case strings.HasPrefix(line, "new file mode"):
+			file.Status = FileAdded
+		case strings.HasPrefix(line, "deleted file mode"):
+			file.Status = FileDeleted
+		case strings.HasPrefix(line, "rename from "):
+			file.Status = FileRenamed
+			file.OldPath = strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			file.Path = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "Binary files "):
+			file.Binary = true
+		case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+			// Paths already taken from the diff --git line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flushFile()
+
+	for i := range files {
+		f := &files[i]
+		if f.Status != FileRenamed {
+			f.OldPath = ""
+		}
+		if f.Status == FileDeleted && f.Path == "" {
+			f.Path = f.OldPath
+		}
+	}
+	return files, nil
+}
+
+// splitGitPaths splits "a/foo b/foo" from a diff --git header.
+func splitGitPaths(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, "a/") {
+		return "", "", false
+	}
+	i := strings.Index(s, " b/")
+	if i < 0 {
+		return "", "", false
+	}
+	return s[2:i], s[i+3:], true
+}
+
+// parseHunkHeader parses "@@ -a,b +c,d @@ section".
+func parseHunkHeader(line string) (*Hunk, error) {
+	h := &Hunk{Header: line, Lines: []Line{}}
+	end := strings.Index(line[2:], "@@")
+	if end < 0 {
+		return nil, fmt.Errorf("invalid hunk header: %q", line)
+	}
+	fields := strings.Fields(line[2 : end+2])
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid hunk header: %q", line)
+	}
+	var err error
+	if h.OldStart, h.OldLines, err = parseRange(fields[0], "-"); err != nil {
+		return nil, err
+	}
+	if h.NewStart, h.NewLines, err = parseRange(fields[1], "+"); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func parseRange(s, prefix string) (int, int, error) {
+	if !strings.HasPrefix(s, prefix) {
+		return 0, 0, fmt.Errorf("invalid hunk range: %q", s)
+	}
+	s = s[len(prefix):]
+	start, count := 0, 1
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		if _, err := fmt.Sscanf(s[i+1:], "%d", &count); err != nil {
+			return 0, 0, fmt.Errorf("invalid hunk range: %q", s)
+		}
+		s = s[:i]
+	}
+	if _, err := fmt.Sscanf(s, "%d", &start); err != nil {
+		return 0, 0, fmt.Errorf("invalid hunk range: %q", s)
+	}
+	return start, count, nil
+}
+
+// MapLine maps a line number in the old side of a file diff to the new side.
+// It returns false if the line itself was changed or deleted.
+func MapLine(f *FileDiff, line int) (int, bool) {
+	if f.Status == FileDeleted {
+		return 0, false
+	}
+	offset := 0
+	for _, h := range f.Hunks {
+		if h.OldLines == 0 {
+			// Pure insertion after OldStart
+			if line <= h.OldStart {
+				break
+			}
+			offset += h.NewLines
+			continue
+		}
+		if line < h.OldStart {
+			break
+		}
+		if line >= h.OldStart+h.OldLines {
+			offset += h.NewLines - h.OldLines
+			continue
+		}
+		// Inside the hunk: only context lines survive
+		for _, l := range h.Lines {
+			if l.OldLine == line {
+				if l.Kind == LineContext {
+					return l.NewLine, true
+				}
+				return 0, false
+			}
+		}
+		return 0, false
+	}
+	return line + offset, true
+}
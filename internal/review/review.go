@@ -0,0 +1,254 @@
+// Package review stores code review state for branches: inline comments
+// anchored to file/line/rev, and per-reviewer verdicts keyed by nostr pubkey.
+package review
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/justinmoon/cook/internal/db"
+)
+
+// Review states
+const (
+	StateComment        = "comment"
+	StateApprove        = "approve"
+	StateRequestChanges = "request_changes"
+)
+
+// ValidState returns true for a known review state.
+func ValidState(state string) bool {
+	switch state {
+	case StateComment, StateApprove, StateRequestChanges:
+		return true
+	}
+	return false
+}
+
+// Comment is an inline comment on a branch's diff. Path/Line/Rev track the
+// comment's current position; they move as the branch is rebased or amended.
+type Comment struct {
+	ID         int64     `json:"id"`
+	BranchRepo string    `json:"branch_repo"`
+	BranchName string    `json:"branch_name"`
+	Path       string    `json:"path"`
+	Line       int       `json:"line"`
+	Rev        string    `json:"rev"`
+	LineText   string    `json:"line_text"` // content of the commented line, used to re-find it
+	Body       string    `json:"body"`
+	Author     string    `json:"author"` // nostr pubkey
+	Outdated   bool      `json:"outdated"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Review is a reviewer's verdict on a branch at a rev.
+type Review struct {
+	ID         int64     `json:"id"`
+	BranchRepo string    `json:"branch_repo"`
+	BranchName string    `json:"branch_name"`
+	Reviewer   string    `json:"reviewer"` // nostr pubkey
+	State      string    `json:"state"`
+	Rev        string    `json:"rev"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Summary is the current verdict of each reviewer on a branch.
+type Summary struct {
+	Approvals        []string `json:"approvals"`         // pubkeys
+	ChangesRequested []string `json:"changes_requested"` // pubkeys
+}
+
+// CheckApprovals returns an error if the branch lacks required approvals or
+// a reviewer has outstanding requested changes.
+func (s Summary) CheckApprovals(required int) error {
+	if len(s.ChangesRequested) > 0 {
+		return fmt.Errorf("changes requested by %s", strings.Join(s.ChangesRequested, ", "))
+	}
+	if len(s.Approvals) < required {
+		return fmt.Errorf("%d of %d required approvals", len(s.Approvals), required)
+	}
+	return nil
+}
+
+type Store struct {
+	db *db.DB
+}
+
+func NewStore(database *db.DB) *Store {
+	return &Store{db: database}
+}
+
+func (s *Store) AddComment(c *Comment) error {
+	return s.db.QueryRow(`
+		INSERT INTO review_comments (branch_repo, branch_name, path, line, rev, line_text, body, author)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`, c.BranchRepo, c.BranchName, c.Path, c.Line, c.Rev, c.LineText, c.Body, c.Author).Scan(&c.ID, &c.CreatedAt)
+}
+
+func (s *Store) ListComments(repo, branchName string) ([]Comment, error) {
+	rows, err := s.db.Query(`
+		SELECT id, branch_repo, branch_name, path, line, rev, line_text, body, author, outdated, created_at
+		FROM review_comments
+		WHERE branch_repo = $1 AND branch_name = $2
+		ORDER BY path, line, id
+	`, repo, branchName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.BranchRepo, &c.BranchName, &c.Path, &c.Line, &c.Rev,
+			&c.LineText, &c.Body, &c.Author, &c.Outdated, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// UpdateAnchor records a comment's position at a new rev.
+func (s *Store) UpdateAnchor(c *Comment) error {
+	_, err := s.db.Exec(`
+		UPDATE review_comments SET path = $1, line = $2, rev = $3, outdated = $4
+		WHERE id = $5
+	`, c.Path, c.Line, c.Rev, c.Outdated, c.ID)
+	return err
+}
+
+func (s *Store) Submit(r *Review) error {
+	if !ValidState(r.State) {
+		return fmt.Errorf("invalid review state %q", r.State)
+	}
+	return s.db.QueryRow(`
+		INSERT INTO reviews (branch_repo, branch_name, reviewer, state, rev, body)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, r.BranchRepo, r.BranchName, r.Reviewer, r.State, r.Rev, r.Body).Scan(&r.ID, &r.CreatedAt)
+}
+
+func (s *Store) ListReviews(repo, branchName string) ([]Review, error) {
+	rows, err := s.db.Query(`
+		SELECT id, branch_repo, branch_name, reviewer, state, rev, body, created_at
+		FROM reviews
+		WHERE branch_repo = $1 AND branch_name = $2
+		ORDER BY id
+	`, repo, branchName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviews []Review
+	for rows.Next() {
+		var r Review
+		if err := rows.Scan(&r.ID, &r.BranchRepo, &r.BranchName, &r.Reviewer, &r.State, &r.Rev, &r.Body, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+// Summarize returns each reviewer's latest approve/request_changes verdict.
+// Plain comments don't change a reviewer's verdict, and an approval only
+// counts while head is the rev it was given at: new commits need a fresh
+// look. Requested changes stand until the reviewer withdraws them.
+func Summarize(reviews []Review, head string) Summary {
+	latest := make(map[string]Review)
+	for _, r := range reviews {
+		if r.State != StateComment {
+			latest[r.Reviewer] = r
+		}
+	}
+
+	var sum Summary
+	for reviewer, r := range latest {
+		switch r.State {
+		case StateApprove:
+			if r.Rev == head {
+				sum.Approvals = append(sum.Approvals, reviewer)
+			}
+		case StateRequestChanges:
+			sum.ChangesRequested = append(sum.ChangesRequested, reviewer)
+		}
+	}
+	sort.Strings(sum.Approvals)
+	sort.Strings(sum.ChangesRequested)
+	return sum
+}
+
+// Summary loads reviews for a branch and summarizes them at head.
+func (s *Store) Summary(repo, branchName, head string) (Summary, error) {
+	reviews, err := s.ListReviews(repo, branchName)
+	if err != nil {
+		return Summary{}, err
+	}
+	return Summarize(reviews, head), nil
+}
+
+// DeleteForBranch removes all review state for a branch.
+func (s *Store) DeleteForBranch(repo, branchName string) error {
+	if _, err := s.db.Exec(`DELETE FROM review_comments WHERE branch_repo = $1 AND branch_name = $2`, repo, branchName); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM reviews WHERE branch_repo = $1 AND branch_name = $2`, repo, branchName)
+	return err
+}
+
+// Relocate moves a comment to newRev using the diff from its current rev.
+// diffs must be the output of DiffCommand(c.Rev, newRev). A comment whose
+// line was changed is marked outdated and keeps its original anchor.
+func Relocate(c *Comment, diffs []FileDiff, newRev string) {
+	for i := range diffs {
+		f := &diffs[i]
+		oldPath := f.Path
+		if f.Status == FileRenamed {
+			oldPath = f.OldPath
+		}
+		if oldPath != c.Path {
+			continue
+		}
+		line, ok := MapLine(f, c.Line)
+		if !ok {
+			c.Outdated = true
+			return
+		}
+		c.Path, c.Line, c.Rev = f.Path, line, newRev
+		return
+	}
+	// File untouched between revs: position is unchanged
+	c.Rev = newRev
+}
+
+// FindLine returns the occurrence of text in content nearest to near, for
+// re-anchoring a comment when its original rev is no longer available.
+func FindLine(content, text string, near int) (int, bool) {
+	if strings.TrimSpace(text) == "" {
+		return 0, false
+	}
+	best, found := 0, false
+	for i, line := range strings.Split(content, "\n") {
+		if line != text {
+			continue
+		}
+		n := i + 1
+		if !found || abs(n-near) < abs(best-near) {
+			best, found = n, true
+		}
+	}
+	return best, found
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
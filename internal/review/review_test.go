@@ -0,0 +1,154 @@
+package review
+
+import "testing"
+
+const sampleDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,5 +1,6 @@
+ package main
+
++import "fmt"
+ func main() {
+-	println("hi")
++	fmt.Println("hi")
+ }
+@@ -20,2 +21,4 @@ func helper() {
+ 	x := 1
++	y := 2
++	z := 3
+ 	return
+diff --git a/old.txt b/new.txt
+similarity index 100%
+rename from old.txt
+rename to new.txt
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+index 3333333..0000000
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+`
+
+func TestParseDiff(t *testing.T) {
+	files, err := ParseDiff(sampleDiff)
+	if err != nil {
+		t.Fatalf("ParseDiff: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want 3", len(files))
+	}
+
+	main := files[0]
+	if main.Path != "main.go" || main.Status != FileModified || len(main.Hunks) != 2 {
+		t.Errorf("main.go parsed as %+v", main)
+	}
+	h := main.Hunks[0]
+	if h.OldStart != 1 || h.OldLines != 5 || h.NewStart != 1 || h.NewLines != 6 {
+		t.Errorf("hunk range = %+v", h)
+	}
+	if added := h.Lines[2]; added.Kind != LineAdded || added.NewLine != 3 || added.Content != `import "fmt"` {
+		t.Errorf("added line = %+v", added)
+	}
+
+	if files[1].Status != FileRenamed || files[1].OldPath != "old.txt" || files[1].Path != "new.txt" {
+		t.Errorf("rename parsed as %+v", files[1])
+	}
+	if files[2].Status != FileDeleted || files[2].Path != "gone.txt" {
+		t.Errorf("delete parsed as %+v", files[2])
+	}
+}
+
+func TestMapLine(t *testing.T) {
+	files, err := ParseDiff(sampleDiff)
+	if err != nil {
+		t.Fatalf("ParseDiff: %v", err)
+	}
+	f := &files[0]
+
+	tests := []struct {
+		old  int
+		want int
+		ok   bool
+	}{
+		{1, 1, true},   // context before insertion
+		{3, 4, true},   // context shifted by the import
+		{4, 0, false},  // changed line
+		{10, 11, true}, // between hunks
+		{21, 24, true}, // context after the second hunk's insertions
+		{30, 33, true}, // after all hunks
+	}
+	for _, tt := range tests {
+		got, ok := MapLine(f, tt.old)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("MapLine(%d) = %d, %v; want %d, %v", tt.old, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	files, err := ParseDiff(sampleDiff)
+	if err != nil {
+		t.Fatalf("ParseDiff: %v", err)
+	}
+
+	c := &Comment{Path: "main.go", Line: 10, Rev: "old"}
+	Relocate(c, files, "new")
+	if c.Line != 11 || c.Rev != "new" || c.Outdated {
+		t.Errorf("moved comment = %+v", c)
+	}
+
+	c = &Comment{Path: "old.txt", Line: 2, Rev: "old"}
+	Relocate(c, files, "new")
+	if c.Path != "new.txt" || c.Line != 2 {
+		t.Errorf("renamed comment = %+v", c)
+	}
+
+	c = &Comment{Path: "main.go", Line: 4, Rev: "old"}
+	Relocate(c, files, "new")
+	if !c.Outdated || c.Rev != "old" {
+		t.Errorf("outdated comment = %+v", c)
+	}
+}
+
+func TestFindLine(t *testing.T) {
+	content := "a\nreturn nil\nb\nc\nreturn nil\n"
+	if line, ok := FindLine(content, "return nil", 4); !ok || line != 5 {
+		t.Errorf("FindLine = %d, %v; want 5, true", line, ok)
+	}
+	if _, ok := FindLine(content, "missing", 1); ok {
+		t.Error("expected no match")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	reviews := []Review{
+		{Reviewer: "alice", State: StateRequestChanges, Rev: "a1"},
+		{Reviewer: "bob", State: StateApprove, Rev: "b2"},
+		{Reviewer: "alice", State: StateApprove, Rev: "b2"},
+		{Reviewer: "bob", State: StateComment, Rev: "b2"},
+	}
+	sum := Summarize(reviews, "b2")
+	if len(sum.Approvals) != 2 || len(sum.ChangesRequested) != 0 {
+		t.Errorf("summary = %+v", sum)
+	}
+	if err := sum.CheckApprovals(2); err != nil {
+		t.Errorf("CheckApprovals(2): %v", err)
+	}
+	if err := sum.CheckApprovals(3); err == nil {
+		t.Error("expected error for 3 required approvals")
+	}
+
+	sum = Summarize(append(reviews, Review{Reviewer: "carol", State: StateRequestChanges, Rev: "a1"}), "b2")
+	if err := sum.CheckApprovals(1); err == nil {
+		t.Error("expected requested changes to block")
+	}
+
+	// New commits make approvals stale
+	sum = Summarize(reviews, "c3")
+	if len(sum.Approvals) != 0 {
+		t.Errorf("approvals at a new head = %v, want none", sum.Approvals)
+	}
+}
@@ -0,0 +1,270 @@
+// Package secret stores secrets (API tokens, credentials) encrypted with
+// the server's secrets key and resolves the ones a repo asks for.
+package secret
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/justinmoon/cook/internal/db"
+	"github.com/justinmoon/cook/internal/redact"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// KeySize is the length of a secrets key in bytes.
+const KeySize = 32
+
+const nonceSize = 24
+
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Secret is a stored secret's metadata. Values are only returned by Get
+// and Resolve.
+type Secret struct {
+	Scope     string    `json:"scope"` // owner, or owner/repo
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store reads and writes secrets in the database.
+type Store struct {
+	db  *db.DB
+	key *[KeySize]byte
+}
+
+// NewStore returns a store using key, the base64 secrets_key from the
+// server config.
+func NewStore(database *db.DB, key string) (*Store, error) {
+	k, err := ParseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: database, key: k}, nil
+}
+
+// GenerateKey returns a new random base64 secrets key.
+func GenerateKey() (string, error) {
+	var k [KeySize]byte
+	if _, err := rand.Read(k[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(k[:]), nil
+}
+
+// ParseKey decodes a base64 secrets key.
+func ParseKey(key string) (*[KeySize]byte, error) {
+	if key == "" {
+		return nil, fmt.Errorf("secrets_key not set; generate one with 'cook secret keygen' and add it to the server config")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(raw) != KeySize {
+		return nil, fmt.Errorf("secrets_key must be %d base64-encoded bytes", KeySize)
+	}
+	var k [KeySize]byte
+	copy(k[:], raw)
+	return &k, nil
+}
+
+// ValidateScope checks that scope is an owner or an owner/repo.
+func ValidateScope(scope string) error {
+	parts := strings.Split(scope, "/")
+	if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+		return fmt.Errorf("invalid scope %q (use owner or owner/repo)", scope)
+	}
+	return nil
+}
+
+// ValidateName checks that name can be used as an environment variable.
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q (use letters, digits and underscores)", name)
+	}
+	return nil
+}
+
+func (s *Store) seal(value string) ([]byte, error) {
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], []byte(value), &nonce, s.key), nil
+}
+
+func (s *Store) open(ciphertext []byte) (string, error) {
+	if len(ciphertext) < nonceSize {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], ciphertext)
+	value, ok := secretbox.Open(nil, ciphertext[nonceSize:], &nonce, s.key)
+	if !ok {
+		return "", fmt.Errorf("decryption failed (was secrets_key changed?)")
+	}
+	return string(value), nil
+}
+
+// Set creates or replaces a secret.
+func (s *Store) Set(scope, name, value string) error {
+	if err := ValidateScope(scope); err != nil {
+		return err
+	}
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	ciphertext, err := s.seal(value)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO secrets (scope, name, ciphertext) VALUES ($1, $2, $3)
+		ON CONFLICT (scope, name) DO UPDATE SET ciphertext = EXCLUDED.ciphertext, updated_at = NOW()
+	`, scope, name, ciphertext)
+	return err
+}
+
+// Get returns a secret's value, or ok false if there is none.
+func (s *Store) Get(scope, name string) (value string, ok bool, err error) {
+	var ciphertext []byte
+	err = s.db.QueryRow(`SELECT ciphertext FROM secrets WHERE scope = $1 AND name = $2`, scope, name).Scan(&ciphertext)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	value, err = s.open(ciphertext)
+	if err != nil {
+		return "", false, fmt.Errorf("secret %s/%s: %w", scope, name, err)
+	}
+	return value, true, nil
+}
+
+// List returns the secrets in scope by name, without their values.
+func (s *Store) List(scope string) ([]Secret, error) {
+	rows, err := s.db.Query(`
+		SELECT scope, name, created_at, updated_at FROM secrets WHERE scope = $1 ORDER BY name
+	`, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var secrets []Secret
+	for rows.Next() {
+		var sec Secret
+		if err := rows.Scan(&sec.Scope, &sec.Name, &sec.CreatedAt, &sec.UpdatedAt); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, sec)
+	}
+	return secrets, rows.Err()
+}
+
+// Delete removes a secret.
+func (s *Store) Delete(scope, name string) error {
+	res, err := s.db.Exec(`DELETE FROM secrets WHERE scope = $1 AND name = $2`, scope, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("secret %s/%s not found", scope, name)
+	}
+	return nil
+}
+
+// Resolve returns the values of names for repoRef ("owner/repo"). A
+// secret set on the repo wins over one set on its owner. It fails if any
+// name is set on neither.
+func (s *Store) Resolve(repoRef string, names []string) (map[string]string, error) {
+	owner, _, _ := strings.Cut(repoRef, "/")
+	values := make(map[string]string, len(names))
+	var missing []string
+	for _, name := range names {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		found := false
+		for _, scope := range []string{repoRef, owner} {
+			value, ok, err := s.Get(scope, name)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				values[name] = value
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("secrets not set for %s: %s (use 'cook secret set')", repoRef, strings.Join(missing, ", "))
+	}
+	return values, nil
+}
+
+// Resolved is the secrets resolved for a repo's environments and gates.
+type Resolved struct {
+	Env   map[string]string // name -> value, set as environment variables
+	Files map[string]string // checkout-relative path -> value
+}
+
+// ResolveFor resolves the env names and file secrets (name -> path) for
+// repoRef, as Resolve does.
+func (s *Store) ResolveFor(repoRef string, env []string, files map[string]string) (*Resolved, error) {
+	names := append([]string(nil), env...)
+	for name := range files {
+		names = append(names, name)
+	}
+	values, err := s.Resolve(repoRef, names)
+	if err != nil {
+		return nil, err
+	}
+	res := &Resolved{Env: make(map[string]string), Files: make(map[string]string)}
+	for _, name := range env {
+		res.Env[name] = values[name]
+	}
+	for name, path := range files {
+		res.Files[path] = values[name]
+	}
+	return res, nil
+}
+
+// Environ returns the env secrets as sorted KEY=value pairs. It is nil
+// for a nil *Resolved.
+func (r *Resolved) Environ() []string {
+	if r == nil {
+		return nil
+	}
+	var env []string
+	for name, value := range r.Env {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Redactor returns a redactor for every resolved value.
+func (r *Resolved) Redactor() *redact.Redactor {
+	if r == nil {
+		return nil
+	}
+	var values []string
+	for _, v := range r.Env {
+		values = append(values, v)
+	}
+	for _, v := range r.Files {
+		values = append(values, v)
+	}
+	return redact.New(values)
+}
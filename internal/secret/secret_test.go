@@ -0,0 +1,109 @@
+package secret
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/justinmoon/cook/internal/testutil"
+)
+
+func TestSeal(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := ParseKey(key)
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	s := &Store{key: k}
+
+	ciphertext, err := s.seal("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(ciphertext), "hunter2") {
+		t.Error("ciphertext contains the value")
+	}
+	if value, err := s.open(ciphertext); err != nil || value != "hunter2" {
+		t.Errorf("open = %q, %v", value, err)
+	}
+
+	other, _ := GenerateKey()
+	k2, _ := ParseKey(other)
+	if _, err := (&Store{key: k2}).open(ciphertext); err == nil {
+		t.Error("expected error opening with another key")
+	}
+
+	for _, bad := range []string{"", "not base64!", "c2hvcnQ="} {
+		if _, err := ParseKey(bad); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, scope := range []string{"alice", "alice/app"} {
+		if err := ValidateScope(scope); err != nil {
+			t.Errorf("ValidateScope(%q): %v", scope, err)
+		}
+	}
+	for _, scope := range []string{"", "alice/", "/app", "a/b/c"} {
+		if ValidateScope(scope) == nil {
+			t.Errorf("ValidateScope(%q) succeeded", scope)
+		}
+	}
+	if ValidateName("NPM_TOKEN") != nil || ValidateName("1X") == nil || ValidateName("A-B") == nil {
+		t.Error("ValidateName accepted or rejected the wrong names")
+	}
+}
+
+func TestStore(t *testing.T) {
+	database, cleanup := testutil.OpenTestDB(t)
+	defer cleanup()
+
+	key, _ := GenerateKey()
+	store, err := NewStore(database, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(store.Set("alice", "NPM_TOKEN", "owner-token"))
+	must(store.Set("alice", "GCP", "owner-gcp"))
+	must(store.Set("alice/app", "NPM_TOKEN", "repo-token"))
+	must(store.Set("alice/app", "NPM_TOKEN", "repo-token-2"))
+
+	secrets, err := store.List("alice")
+	must(err)
+	if len(secrets) != 2 || secrets[0].Name != "GCP" || secrets[1].Name != "NPM_TOKEN" {
+		t.Errorf("List = %+v", secrets)
+	}
+
+	res, err := store.ResolveFor("alice/app", []string{"NPM_TOKEN"}, map[string]string{"GCP": "gcp.json"})
+	must(err)
+	if want := []string{"NPM_TOKEN=repo-token-2"}; !reflect.DeepEqual(res.Environ(), want) {
+		t.Errorf("Environ = %v, want %v", res.Environ(), want)
+	}
+	if res.Files["gcp.json"] != "owner-gcp" {
+		t.Errorf("Files = %v", res.Files)
+	}
+
+	if _, err := store.Resolve("alice/app", []string{"MISSING"}); err == nil || !strings.Contains(err.Error(), "MISSING") {
+		t.Errorf("Resolve missing = %v", err)
+	}
+
+	must(store.Delete("alice/app", "NPM_TOKEN"))
+	if _, ok, _ := store.Get("alice/app", "NPM_TOKEN"); ok {
+		t.Error("secret still set after Delete")
+	}
+	if err := store.Delete("alice/app", "NPM_TOKEN"); err == nil {
+		t.Error("expected error deleting a missing secret")
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"log"
+
+	"github.com/justinmoon/cook/internal/env"
+)
+
+// agentKey identifies a cook-agent. The secret changes when an
+// environment is recreated at the same address.
+type agentKey struct {
+	addr, secret string
+}
+
+// checkAgentVersion updates the cook-agent at addr in the background if
+// it runs a different binary from this server's, once per agent while the
+// server runs. Agents in long-lived environments would otherwise keep the
+// binary they started with across server upgrades.
+func (s *Server) checkAgentVersion(addr, secret string) {
+	key := agentKey{addr, secret}
+	s.agentUpdateMu.Lock()
+	checked := s.agentsChecked[key]
+	s.agentsChecked[key] = true
+	s.agentUpdateMu.Unlock()
+	if checked {
+		return
+	}
+
+	go func() {
+		updated, err := env.UpdateAgent(addr, secret)
+		if err != nil {
+			log.Printf("Failed to update cook-agent at %s: %v", addr, err)
+			return
+		}
+		if updated {
+			log.Printf("Updated cook-agent at %s; it restarts once its sessions end", addr)
+		}
+	}()
+}
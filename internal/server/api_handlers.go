@@ -3,9 +3,14 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/justinmoon/cook/internal/agent"
 	"github.com/justinmoon/cook/internal/auth"
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/budget"
+	"github.com/justinmoon/cook/internal/pool"
 	"github.com/justinmoon/cook/internal/repo"
 	"github.com/justinmoon/cook/internal/task"
 )
@@ -227,6 +232,94 @@ func (s *Server) apiTaskGet(w http.ResponseWriter, r *http.Request) {
 	}, http.StatusOK)
 }
 
+// Budget API handlers
+
+// apiBranchUsage records tokens and spend reported by the branch's running
+// agent and returns the totals with the current budget verdict.
+func (s *Server) apiBranchUsage(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "owner")
+	repoName := chi.URLParam(r, "repo")
+	name := chi.URLParam(r, "name")
+	repoRef := owner + "/" + repoName
+
+	if s.requireOwner(w, r, repoRef) == "" {
+		return
+	}
+
+	var req struct {
+		Tokens  int64   `json:"tokens"`
+		CostUSD float64 `json:"cost_usd"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Tokens < 0 || req.CostUSD < 0 {
+		apiError(w, "tokens and cost_usd must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	b, err := branchStore.Get(repoRef, name)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if b == nil {
+		apiError(w, "Branch not found", http.StatusNotFound)
+		return
+	}
+
+	agentStore := agent.NewStore(s.db)
+	session, err := agentStore.GetByBranch(repoRef, name)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if session == nil {
+		apiError(w, "No running agent on branch", http.StatusNotFound)
+		return
+	}
+
+	if err := agentStore.AddUsage(session.ID, req.Tokens, req.CostUSD); err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session.TokensUsed += req.Tokens
+	session.CostUSD += req.CostUSD
+
+	var repoBudget budget.RepoBudget
+	if cfg := s.repoConfigForBranch(b); cfg != nil {
+		repoBudget = cfg.Budget
+	}
+	limits := budget.Resolve(s.cfg.Server.Budget, repoBudget, name)
+	verdict := budget.Evaluate(limits, budget.Usage{
+		Tokens:    session.TokensUsed,
+		Dollars:   session.CostUSD,
+		WallClock: time.Since(session.StartedAt),
+	})
+
+	jsonResponse(w, map[string]interface{}{
+		"session_id":  session.ID,
+		"tokens_used": session.TokensUsed,
+		"cost_usd":    session.CostUSD,
+		"limits":      limits,
+		"budget":      verdict,
+	}, http.StatusOK)
+}
+
+// Pool API handlers
+
+// apiPoolStats returns each warm environment pool's size, occupancy and
+// claim hit rate.
+func (s *Server) apiPoolStats(w http.ResponseWriter, r *http.Request) {
+	stats := []pool.Stats{}
+	if s.pool != nil {
+		stats = s.pool.Stats()
+	}
+	jsonResponse(w, stats, http.StatusOK)
+}
+
 // SSH Key API handlers
 
 func (s *Server) apiSSHKeyList(w http.ResponseWriter, r *http.Request) {
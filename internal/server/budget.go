@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agent"
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/budget"
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/events"
+	"github.com/justinmoon/cook/internal/gate"
+	"github.com/justinmoon/cook/internal/repo"
+	"github.com/justinmoon/cook/internal/task"
+)
+
+// budgetCheckInterval is how often the watcher re-evaluates agent usage.
+const budgetCheckInterval = time.Minute
+
+// runBudgetWatcher periodically checks every running agent session against
+// its branch's budget until ctx is cancelled.
+func (s *Server) runBudgetWatcher(ctx context.Context) {
+	ticker := time.NewTicker(budgetCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkBudgets(ctx)
+		}
+	}
+}
+
+func (s *Server) checkBudgets(ctx context.Context) {
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	branches, err := branchStore.List("", branch.StatusActive)
+	if err != nil {
+		log.Printf("budget: failed to list branches: %v", err)
+		return
+	}
+
+	agentStore := agent.NewStore(s.db)
+	for i := range branches {
+		b := &branches[i]
+		if b.Environment.Provisioning || b.Environment.StoppedReason != "" {
+			continue
+		}
+
+		session, err := agentStore.GetByBranch(b.Repo, b.Name)
+		if err != nil || session == nil {
+			continue
+		}
+
+		var repoBudget budget.RepoBudget
+		if cfg := s.repoConfigForBranch(b); cfg != nil {
+			repoBudget = cfg.Budget
+		}
+		limits := budget.Resolve(s.cfg.Server.Budget, repoBudget, b.Name)
+		if limits.IsZero() {
+			continue
+		}
+
+		verdict := budget.Evaluate(limits, budget.Usage{
+			Tokens:    session.TokensUsed,
+			Dollars:   session.CostUSD,
+			WallClock: time.Since(session.StartedAt),
+		})
+
+		switch verdict.State {
+		case budget.StateWarning:
+			if s.markBudgetWarned(session.ID) {
+				s.eventBus.Publish(events.Event{
+					Type:   events.EventBudgetWarning,
+					Repo:   b.Repo,
+					Branch: b.Name,
+					Data:   verdict,
+				})
+			}
+		case budget.StateExceeded:
+			s.enforceBudget(ctx, b, session, verdict)
+		}
+	}
+}
+
+// markBudgetWarned records that a warning was sent for a session and
+// reports whether this is the first one.
+func (s *Server) markBudgetWarned(sessionID int64) bool {
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+	if s.budgetWarned[sessionID] {
+		return false
+	}
+	s.budgetWarned[sessionID] = true
+	return true
+}
+
+// enforceBudget suspends the agent session, stops the branch environment
+// and flags the linked task for a human.
+func (s *Server) enforceBudget(ctx context.Context, b *branch.Branch, session *agent.Session, verdict budget.Verdict) {
+	log.Printf("budget: %s exceeded budget (%s), suspending agent", b.FullName(), verdict.Reason)
+
+	s.termMgr.Remove(b.FullName())
+
+	agentStore := agent.NewStore(s.db)
+	now := time.Now()
+	session.Status = agent.StatusSuspended
+	session.EndedAt = &now
+	if err := agentStore.Update(session); err != nil {
+		log.Printf("budget: failed to suspend agent session %d: %v", session.ID, err)
+	}
+
+	// Local checkouts have nothing left running once the PTY is gone, and
+	// tearing one down would delete the agent's uncommitted work.
+	if !env.IsLocalCheckout(env.Type(b.Environment.Backend)) {
+		if backend, err := b.Backend(); err != nil {
+			log.Printf("budget: failed to get backend for %s: %v", b.FullName(), err)
+		} else if stopper, ok := env.AsStopper(backend); ok {
+			if err := stopper.Stop(ctx); err != nil {
+				log.Printf("budget: failed to stop environment for %s: %v", b.FullName(), err)
+			}
+		} else if b.Environment.Named == "" { // named environments outlive their branches
+			if err := backend.Teardown(ctx); err != nil {
+				log.Printf("budget: failed to tear down environment for %s: %v", b.FullName(), err)
+			}
+		}
+	}
+
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	b.Environment.StoppedReason = "budget exceeded: " + verdict.Reason
+	if err := branchStore.UpdateEnvironment(b.Repo, b.Name, b.Environment); err != nil {
+		log.Printf("budget: failed to record stopped environment for %s: %v", b.FullName(), err)
+	}
+
+	if b.TaskRepo != nil && b.TaskSlug != nil {
+		taskStore := task.NewStore(s.db)
+		if err := taskStore.UpdateStatus(*b.TaskRepo, *b.TaskSlug, task.StatusNeedsHuman); err != nil {
+			log.Printf("budget: failed to update task %s: %v", b.TaskFullName(), err)
+		}
+	}
+
+	s.eventBus.Publish(events.Event{
+		Type:   events.EventBudgetExceeded,
+		Repo:   b.Repo,
+		Branch: b.Name,
+		Data:   verdict,
+	})
+}
+
+// repoConfigForBranch loads cook.toml from the branch checkout, falling back
+// to the bare repo for remote backends. Returns nil if none can be read.
+func (s *Server) repoConfigForBranch(b *branch.Branch) *gate.RepoConfig {
+	if b.Environment.Path != "" {
+		if _, err := os.Stat(b.Environment.Path); err == nil {
+			if cfg, err := gate.LoadRepoConfig(b.Environment.Path); err == nil {
+				return cfg
+			}
+			return nil
+		}
+	}
+
+	owner, name, err := repo.ParseRepoRef(b.Repo)
+	if err != nil {
+		return nil
+	}
+	rp, err := repo.NewStore(s.cfg.Server.DataDir).Get(owner, name)
+	if err != nil || rp == nil {
+		return nil
+	}
+	cfg, err := gate.LoadRepoConfigFromBareRepo(rp.Path)
+	if err != nil {
+		return nil
+	}
+	return cfg
+}
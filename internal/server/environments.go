@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/justinmoon/cook/internal/auth"
+	"github.com/justinmoon/cook/internal/branch"
+)
+
+// runEnvironmentWatcher suspends named environments that have gone
+// without a branch longer than their idle timeout.
+func (s *Server) runEnvironmentWatcher(ctx context.Context) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.suspendIdleEnvironments(ctx)
+		}
+	}
+}
+
+func (s *Server) suspendIdleEnvironments(ctx context.Context) {
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	envs, err := branchStore.ListEnvironments()
+	if err != nil {
+		log.Printf("environments: failed to list: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range envs {
+		e := &envs[i]
+		if !e.Idle(now) {
+			continue
+		}
+		destroyed, err := branchStore.SuspendEnvironment(ctx, e)
+		switch {
+		case err != nil:
+			log.Printf("environments: failed to suspend idle %s/%s: %v", e.Owner, e.Name, err)
+		case destroyed:
+			log.Printf("environments: destroyed idle %s/%s (%s can't suspend)", e.Owner, e.Name, e.Backend)
+		default:
+			log.Printf("environments: suspended idle %s/%s", e.Owner, e.Name)
+		}
+	}
+}
+
+func (s *Server) apiListEnvironments(w http.ResponseWriter, r *http.Request) {
+	pubkey := auth.GetPubkey(r.Context())
+	if pubkey == "" {
+		apiError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	envs, err := branchStore.ListOwnerEnvironments(pubkey)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if envs == nil {
+		envs = []branch.NamedEnvironment{}
+	}
+	jsonResponse(w, envs, http.StatusOK)
+}
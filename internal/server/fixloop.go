@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agent"
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/envagent"
+	"github.com/justinmoon/cook/internal/events"
+	"github.com/justinmoon/cook/internal/gate"
+	"github.com/justinmoon/cook/internal/prompt"
+	"github.com/justinmoon/cook/internal/repo"
+	"github.com/justinmoon/cook/internal/task"
+)
+
+const (
+	// defaultFixTimeout bounds how long one fix attempt may take.
+	defaultFixTimeout = 30 * time.Minute
+
+	// fixPollInterval is how often we check whether an interactive agent has committed.
+	fixPollInterval = 15 * time.Second
+)
+
+// runFixLoop feeds failing gate output to the branch's agent, waits for a
+// fix, and re-runs gates until they pass or the repo's iteration limit is
+// reached, at which point the task is handed to a human. The count of
+// attempts carries over between runs until gates pass or someone other
+// than the loop commits.
+func (s *Server) runFixLoop(b branch.Branch, cfg *gate.RepoConfig) {
+	key := b.FullName()
+	if !s.claimFixLoop(key) {
+		return // already fixing this branch
+	}
+	defer s.releaseFixLoop(key)
+
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	gateStore := gate.NewStore(s.db, s.cfg.Server.DataDir)
+
+	timeout := cfg.FixLoop.Timeout
+	if timeout <= 0 {
+		timeout = defaultFixTimeout
+	}
+
+	for {
+		failures, err := prompt.FailingGates(gateStore, b.Repo, b.Name)
+		if err != nil {
+			log.Printf("fix loop: %s: failed to list gate runs: %v", key, err)
+			return
+		}
+		if len(failures) == 0 {
+			branchStore.ResetFixIterations(b.Repo, b.Name)
+			return
+		}
+
+		n, err := branchStore.IncrementFixIterations(b.Repo, b.Name, branchHead(&b))
+		if err != nil {
+			log.Printf("fix loop: %s: %v", key, err)
+			return
+		}
+		if n > cfg.FixLoop.Iterations() {
+			s.fixLoopExhausted(&b, fmt.Sprintf("gates still failing after %d fix attempts", n-1))
+			return
+		}
+
+		gateNames := make([]string, len(failures))
+		for i, f := range failures {
+			gateNames[i] = f.Name
+		}
+		log.Printf("fix loop: %s: attempt %d/%d for %s", key, n, cfg.FixLoop.Iterations(), strings.Join(gateNames, ", "))
+		s.eventBus.Publish(events.Event{
+			Type:   events.EventFixAttempt,
+			Repo:   b.Repo,
+			Branch: b.Name,
+			Data:   map[string]interface{}{"iteration": n, "gates": gateNames},
+		})
+
+		text, err := s.renderFixPrompt(&b, cfg, failures)
+		if err != nil {
+			s.fixLoopExhausted(&b, err.Error())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(s.bgCtx, timeout)
+		err = s.deliverFixPrompt(ctx, &b, text)
+		cancel()
+		if err != nil {
+			s.fixLoopExhausted(&b, "fix attempt failed: "+err.Error())
+			return
+		}
+
+		// Pick up head/environment changes made while the agent worked
+		fresh, err := branchStore.Get(b.Repo, b.Name)
+		if err != nil || fresh == nil || fresh.Status != branch.StatusActive {
+			return
+		}
+		b = *fresh
+
+		if _, err := s.runBranchGates(&b, cfg); err != nil {
+			log.Printf("fix loop: %s: failed to re-run gates: %v", key, err)
+			return
+		}
+		// The agent's commit, and any a transform gate added, count as the
+		// loop's own
+		if err := branchStore.RecordFixRev(b.Repo, b.Name, branchHead(&b)); err != nil {
+			log.Printf("fix loop: %s: %v", key, err)
+			return
+		}
+	}
+}
+
+func (s *Server) claimFixLoop(key string) bool {
+	s.fixMu.Lock()
+	defer s.fixMu.Unlock()
+	if s.fixLoops[key] {
+		return false
+	}
+	s.fixLoops[key] = true
+	return true
+}
+
+func (s *Server) releaseFixLoop(key string) {
+	s.fixMu.Lock()
+	defer s.fixMu.Unlock()
+	delete(s.fixLoops, key)
+}
+
+// fixLoopExhausted gives up on the loop and flags the task for a human.
+func (s *Server) fixLoopExhausted(b *branch.Branch, reason string) {
+	log.Printf("fix loop: %s: %s", b.FullName(), reason)
+
+	if b.TaskRepo != nil && b.TaskSlug != nil {
+		taskStore := task.NewStore(s.db)
+		if err := taskStore.UpdateStatus(*b.TaskRepo, *b.TaskSlug, task.StatusNeedsHuman); err != nil {
+			log.Printf("fix loop: failed to update task %s: %v", b.TaskFullName(), err)
+		}
+	}
+
+	agentStore := agent.NewStore(s.db)
+	if session, _ := agentStore.GetByBranch(b.Repo, b.Name); session != nil {
+		session.Status = agent.StatusNeedsHelp
+		session.HelpReason = reason
+		agentStore.Update(session)
+	}
+
+	s.eventBus.Publish(events.Event{
+		Type:   events.EventFixExhausted,
+		Repo:   b.Repo,
+		Branch: b.Name,
+		Data:   reason,
+	})
+}
+
+// renderFixPrompt renders the repo's fix template (or the built-in one).
+func (s *Server) renderFixPrompt(b *branch.Branch, cfg *gate.RepoConfig, failures []prompt.GateFailure) (string, error) {
+	var src prompt.Source
+	if _, err := os.Stat(b.Environment.Path); err == nil {
+		src = prompt.DirSource(b.Environment.Path)
+	} else if owner, name, err := repo.ParseRepoRef(b.Repo); err == nil {
+		if rp, _ := repo.NewStore(s.cfg.Server.DataDir).Get(owner, name); rp != nil {
+			src = prompt.BareRepoSource(rp.Path)
+		}
+	}
+
+	data := &prompt.Data{
+		Repo:         b.Repo,
+		Branch:       b.Name,
+		FailingGates: failures,
+		Conventions:  prompt.LoadConventions(src),
+	}
+	if b.TaskRepo != nil && b.TaskSlug != nil {
+		if t, _ := task.NewStore(s.db).Get(*b.TaskRepo, *b.TaskSlug); t != nil {
+			data.Task = t
+			data.AcceptanceCriteria = prompt.AcceptanceCriteria(t.Body)
+		}
+	}
+
+	templateName := cfg.FixLoop.Template
+	if templateName == "" {
+		templateName = prompt.FixName
+	}
+	return prompt.Load(src, cfg.Prompts).Render(templateName, data)
+}
+
+// deliverFixPrompt types the prompt into the branch's running agent PTY and
+// waits for a new commit, or runs a headless agent session if none is live.
+func (s *Server) deliverFixPrompt(ctx context.Context, b *branch.Branch, text string) error {
+	sessionKey := b.FullName()
+	// Bracketed paste so multi-line prompts arrive as one message, then Enter.
+	input := []byte("\x1b[200~" + text + "\x1b[201~\r")
+
+	if env.IsLocalCheckout(env.Type(b.Environment.Backend)) {
+		if sess := s.termMgr.Get(sessionKey); sess != nil {
+			if _, closed := sess.ClosedAt(); !closed {
+				startRev, err := getWorkdirHead(b.Environment.Path)
+				if err != nil {
+					return err
+				}
+				if _, err := sess.Write(input); err != nil {
+					return err
+				}
+				return s.waitForNewCommit(ctx, b, nil, startRev)
+			}
+		}
+		return s.runHeadlessFix(ctx, b, nil, text)
+	}
+
+	backend, err := b.Backend()
+	if err != nil {
+		return err
+	}
+	if addr, secret, ok := env.CookAgent(backend); ok {
+		if client, err := envagent.Dial(addr, secret); err == nil {
+			attached := client.AttachSession(sessionKey) == nil
+			if attached {
+				startRev, err := remoteHead(ctx, backend)
+				if err == nil {
+					err = client.SendInput(sessionKey, input)
+				}
+				client.Close()
+				if err != nil {
+					return err
+				}
+				return s.waitForNewCommit(ctx, b, backend, startRev)
+			}
+			client.Close()
+		}
+	}
+	return s.runHeadlessFix(ctx, b, backend, text)
+}
+
+// runHeadlessFix runs a one-shot agent on the prompt and records it as an
+// agent session. backend is nil for local checkouts.
+func (s *Server) runHeadlessFix(ctx context.Context, b *branch.Branch, backend env.Backend, text string) error {
+	agentStore := agent.NewStore(s.db)
+	agentType := agent.AgentClaude
+	if latest, _ := agentStore.GetLatest(b.Repo, b.Name); latest != nil {
+		agentType = latest.AgentType
+	}
+
+	session := &agent.Session{
+		BranchRepo: b.Repo,
+		BranchName: b.Name,
+		AgentType:  agentType,
+		Prompt:     text,
+	}
+	if err := agentStore.Create(session); err != nil {
+		return fmt.Errorf("failed to create agent session: %w", err)
+	}
+	session.Status = agent.StatusRunning
+	agentStore.Update(session)
+
+	var runErr error
+	if backend == nil {
+		cmd, err := agent.SpawnHeadless(agentType, b.Environment.Path, text, b.Repo, b.Name)
+		if err == nil {
+			cmd, err = sandboxCommand(b, cmd)
+		}
+		if err != nil {
+			runErr = err
+		} else {
+			s.addSecretEnv(b, cmd)
+			runErr = runUntilDone(ctx, cmd)
+			if cmd.ProcessState != nil {
+				code := cmd.ProcessState.ExitCode()
+				session.ExitCode = &code
+			}
+		}
+	} else {
+		shellCmd, err := agent.HeadlessCommand(agentType, text)
+		if err != nil {
+			runErr = err
+		} else {
+			_, runErr = backend.Exec(ctx, shellCmd)
+		}
+	}
+
+	now := time.Now()
+	session.EndedAt = &now
+	session.Status = agent.StatusCompleted
+	if runErr != nil {
+		session.Status = agent.StatusFailed
+	}
+	agentStore.Update(session)
+	return runErr
+}
+
+// runUntilDone runs cmd and kills it if ctx ends first.
+func runUntilDone(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// waitForNewCommit polls the branch HEAD until it moves past startRev.
+func (s *Server) waitForNewCommit(ctx context.Context, b *branch.Branch, backend env.Backend, startRev string) error {
+	ticker := time.NewTicker(fixPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("agent made no new commit: %w", ctx.Err())
+		case <-ticker.C:
+			var rev string
+			var err error
+			if backend == nil {
+				rev, err = getWorkdirHead(b.Environment.Path)
+			} else {
+				rev, err = remoteHead(ctx, backend)
+			}
+			if err == nil && rev != startRev {
+				return nil
+			}
+		}
+	}
+}
+
+func remoteHead(ctx context.Context, backend env.Backend) (string, error) {
+	output, err := backend.Exec(ctx, "git rev-parse HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/justinmoon/cook/internal/branch"
+)
+
+// runGC periodically destroys leaked containers, sandboxes, sprites and
+// machines, if gc.interval is set.
+func (s *Server) runGC(ctx context.Context) {
+	interval := s.cfg.Server.GC.Interval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.collectGarbage(ctx)
+		}
+	}
+}
+
+func (s *Server) collectGarbage(ctx context.Context) {
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	report, err := branchStore.GC(ctx, branch.GCOptions{
+		DryRun: s.cfg.Server.GC.DryRun,
+		MinAge: s.cfg.Server.GC.MinAge,
+	})
+	if err != nil {
+		log.Printf("gc: %v", err)
+		return
+	}
+
+	for _, o := range report.Orphans {
+		switch {
+		case o.Destroyed:
+			log.Printf("gc: destroyed leaked %s %s", o.Backend, o.ID)
+		case o.Error != "":
+			log.Printf("gc: failed to destroy leaked %s %s: %s", o.Backend, o.ID, o.Error)
+		default:
+			log.Printf("gc: found leaked %s %s (dry run)", o.Backend, o.ID)
+		}
+	}
+}
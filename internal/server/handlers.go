@@ -15,7 +15,6 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/justinmoon/cook/internal/agent"
@@ -23,9 +22,12 @@ import (
 	"github.com/justinmoon/cook/internal/branch"
 	"github.com/justinmoon/cook/internal/dotfiles"
 	"github.com/justinmoon/cook/internal/editor"
+	"github.com/justinmoon/cook/internal/env"
 	"github.com/justinmoon/cook/internal/events"
 	"github.com/justinmoon/cook/internal/gate"
+	"github.com/justinmoon/cook/internal/prompt"
 	"github.com/justinmoon/cook/internal/repo"
+	"github.com/justinmoon/cook/internal/review"
 	"github.com/justinmoon/cook/internal/task"
 	"github.com/justinmoon/cook/internal/terminal"
 )
@@ -104,6 +106,64 @@ func (s *Server) repoCloneURL(owner, name string) (string, error) {
 	return fmt.Sprintf("%s/git/%s/%s.git", base, owner, name), nil
 }
 
+// createSSHBranch records a branch on the configured ssh host and provisions
+// it in the background. The host is seeded by pushing from bareRepoPath, so
+// unlike the other remote backends it does not need a public clone URL.
+func (s *Server) createSSHBranch(branchStore *branch.Store, b *branch.Branch, bareRepoPath, taskMdContent string) error {
+	if err := branchStore.CreateProvisioningSSHBranch(b, bareRepoPath, s.cfg.Server.SSH.Host, s.cfg.Server.SSH.WorkDir); err != nil {
+		return err
+	}
+	s.provisionInBackground(branchStore, *b, bareRepoPath, "", taskMdContent)
+	return nil
+}
+
+// createPluginBranch records a branch on a plugin backend and provisions it
+// in the background. Plugins get the public clone URL when one is set and
+// the bare repo path otherwise, which is enough for plugins on this host.
+func (s *Server) createPluginBranch(branchStore *branch.Store, b *branch.Branch, owner, repoName, bareRepoPath, backendType, dotfiles, taskMdContent string) error {
+	repoURL, _ := s.repoCloneURL(owner, repoName)
+	if err := branchStore.CreateProvisioningRemoteBranch(b, bareRepoPath, backendType, dotfiles); err != nil {
+		return err
+	}
+	s.provisionInBackground(branchStore, *b, bareRepoPath, repoURL, taskMdContent)
+	return nil
+}
+
+// namedEnvBackend is the backend form value prefix that attaches a branch
+// to a named environment, e.g. "env:gpu-box".
+const namedEnvBackend = "env:"
+
+// createEnvironmentBranch records a branch attached to a named environment
+// and checks it out there in the background.
+func (s *Server) createEnvironmentBranch(branchStore *branch.Store, b *branch.Branch, owner, repoName, bareRepoPath, envName, dotfiles, taskMdContent string) error {
+	repoURL, err := s.repoCloneURL(owner, repoName)
+	if err != nil {
+		return err
+	}
+	if err := branchStore.CreateProvisioningEnvironmentBranch(b, bareRepoPath, envName, dotfiles); err != nil {
+		return err
+	}
+	s.provisionInBackground(branchStore, *b, bareRepoPath, repoURL, taskMdContent)
+	return nil
+}
+
+// sandboxCommand confines a locally spawned command (e.g. from agent.Spawn)
+// to the branch's bubblewrap sandbox, if it uses the sandbox backend.
+func sandboxCommand(b *branch.Branch, cmd *exec.Cmd) (*exec.Cmd, error) {
+	if b.Environment.Backend != string(env.TypeSandbox) {
+		return cmd, nil
+	}
+	backend, err := b.Backend()
+	if err != nil {
+		return nil, err
+	}
+	sandbox, ok := backend.(*env.SandboxBackend)
+	if !ok {
+		return nil, fmt.Errorf("branch %s is recorded as a sandbox but its backend is %T", b.FullName(), backend)
+	}
+	return sandbox.Wrap(cmd)
+}
+
 //go:embed templates/*
 var templatesFS embed.FS
 
@@ -541,6 +601,11 @@ func (s *Server) handleRepoBranchCreate(w http.ResponseWriter, r *http.Request)
 		b.TaskSlug = &taskSlug
 	}
 
+	if err := s.selectProfile(b, rp.Path); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Create branch with appropriate backend
 	switch backendType {
 	case "docker":
@@ -548,6 +613,16 @@ func (s *Server) handleRepoBranchCreate(w http.ResponseWriter, r *http.Request)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+	case "podman":
+		if err := branchStore.CreateWithPodmanCheckout(b, rp.Path, dotfiles); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "sandbox":
+		if err := branchStore.CreateWithSandboxCheckout(b, rp.Path, dotfiles, s.cfg.Server.Sandbox.Network); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	case "modal":
 		repoURL, err := s.repoCloneURL(owner, repoName)
 		if err != nil {
@@ -578,13 +653,38 @@ func (s *Server) handleRepoBranchCreate(w http.ResponseWriter, r *http.Request)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+	case "ssh":
+		if err := s.createSSHBranch(branchStore, b, rp.Path, ""); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	default:
+		if envName, ok := strings.CutPrefix(backendType, namedEnvBackend); ok {
+			if err := s.createEnvironmentBranch(branchStore, b, owner, repoName, rp.Path, envName, dotfiles, ""); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			break
+		}
+		if env.IsPlugin(backendType) {
+			if err := s.createPluginBranch(branchStore, b, owner, repoName, rp.Path, backendType, dotfiles, ""); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			break
+		}
 		if err := branchStore.CreateWithCheckout(b, rp.Path, dotfiles); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
+	if !b.Environment.Provisioning {
+		if err := s.injectSecrets(r.Context(), b); err != nil {
+			log.Printf("Failed to inject secrets for %s/%s: %v", b.Repo, b.Name, err)
+		}
+	}
+
 	// If linked to a task, set task to in_progress
 	if taskSlug != "" {
 		taskStore := task.NewStore(s.db)
@@ -657,10 +757,14 @@ func (s *Server) handleRepoDetail(w http.ResponseWriter, r *http.Request) {
 	data["Branches"] = branches
 	data["ActiveBranches"] = activeBranches
 	data["Tasks"] = tasks
+	data["BackendPlugins"] = env.Plugins()
 	data["Commits"] = commits
 	// Check if current user owns this repo
 	user := s.getTemplateUser(r)
 	data["IsOwner"] = user != nil && user.Pubkey == owner
+	if user != nil && user.Pubkey == owner {
+		data["NamedEnvironments"], _ = branchStore.ListOwnerEnvironments(owner)
+	}
 
 	// Get user's saved dotfiles for dropdown
 	if user != nil {
@@ -705,9 +809,13 @@ func (s *Server) handleTaskDetail(w http.ResponseWriter, r *http.Request) {
 	data := s.baseTemplateData(r, t.Title)
 	data["Task"] = t
 	data["LinkedBranch"] = linkedBranch
+	data["BackendPlugins"] = env.Plugins()
 	// Check if current user owns this repo
 	user := s.getTemplateUser(r)
 	data["IsOwner"] = user != nil && user.Pubkey == owner
+	if user != nil && user.Pubkey == owner {
+		data["NamedEnvironments"], _ = branchStore.ListOwnerEnvironments(owner)
+	}
 
 	// Get user's saved dotfiles for dropdown
 	if user != nil {
@@ -716,6 +824,13 @@ func (s *Server) handleTaskDetail(w http.ResponseWriter, r *http.Request) {
 		data["Dotfiles"] = userDotfiles
 	}
 
+	// Get repo prompt templates for the start dialog
+	if rp, _ := repo.NewStore(s.cfg.Server.DataDir).Get(owner, repoName); rp != nil {
+		if repoCfg, err := gate.LoadRepoConfigFromBareRepo(rp.Path); err == nil {
+			data["PromptTemplates"] = prompt.Load(prompt.BareRepoSource(rp.Path), repoCfg.Prompts).Names()
+		}
+	}
+
 	if err := renderTemplate(w, "task_detail.html", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -828,11 +943,12 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid agent type", http.StatusBadRequest)
 		return
 	}
-	if backendType != "local" && backendType != "docker" && backendType != "modal" && backendType != "sprites" && backendType != "fly-machines" {
+	namedEnv := strings.HasPrefix(backendType, namedEnvBackend)
+	if backendType != "local" && backendType != "sandbox" && backendType != "docker" && backendType != "podman" && backendType != "modal" && backendType != "sprites" && backendType != "fly-machines" && backendType != "ssh" && !env.IsPlugin(backendType) && !namedEnv {
 		http.Error(w, "Invalid backend type", http.StatusBadRequest)
 		return
 	}
-	asyncProvisioning := backendType == "modal" || backendType == "sprites" || backendType == "fly-machines"
+	asyncProvisioning := backendType == "modal" || backendType == "sprites" || backendType == "fly-machines" || backendType == "ssh" || env.IsPlugin(backendType) || namedEnv
 
 	// Get the repo
 	repoStore := repo.NewStore(s.cfg.Server.DataDir)
@@ -850,6 +966,13 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Render the task brief up front so a bad template fails before provisioning
+	taskMdContent, err := s.renderTaskBrief(rp, t, slug, r.FormValue("template"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Check if branch already exists
 	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
 	existingBranch, _ := branchStore.Get(repoRef, slug)
@@ -873,6 +996,11 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 		TaskSlug: &slug,
 	}
 
+	if err := s.selectProfile(b, rp.Path); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Create with appropriate backend
 	switch backendType {
 	case "docker":
@@ -880,6 +1008,16 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+	case "podman":
+		if err := branchStore.CreateWithPodmanCheckout(b, rp.Path, dotfiles); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "sandbox":
+		if err := branchStore.CreateWithSandboxCheckout(b, rp.Path, dotfiles, s.cfg.Server.Sandbox.Network); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	case "modal", "sprites", "fly-machines":
 		repoURL, err := s.repoCloneURL(owner, repoName)
 		if err != nil {
@@ -890,28 +1028,37 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		branchCopy := *b
-		bareRepoPath := rp.Path
-		taskMdContent := fmt.Sprintf("# %s\n\n%s\n", t.Title, t.Body)
-		go func(branchCopy branch.Branch, repoURL, taskMdContent string) {
-			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
-			defer cancel()
-			if err := branchStore.ProvisionRemoteBranch(ctx, &branchCopy, bareRepoPath, repoURL, taskMdContent); err != nil {
-				log.Printf("Provisioning failed for %s/%s: %v", branchCopy.Repo, branchCopy.Name, err)
-			}
-		}(branchCopy, repoURL, taskMdContent)
+		s.provisionInBackground(branchStore, *b, rp.Path, repoURL, taskMdContent)
+	case "ssh":
+		if err := s.createSSHBranch(branchStore, b, rp.Path, taskMdContent); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	default:
+		if envName, ok := strings.CutPrefix(backendType, namedEnvBackend); ok {
+			if err := s.createEnvironmentBranch(branchStore, b, owner, repoName, rp.Path, envName, dotfiles, taskMdContent); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			break
+		}
+		if env.IsPlugin(backendType) {
+			if err := s.createPluginBranch(branchStore, b, owner, repoName, rp.Path, backendType, dotfiles, taskMdContent); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			break
+		}
 		if err := branchStore.CreateWithCheckout(b, rp.Path, dotfiles); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
-	// Write TASK.md with task description
-	taskMdContent := fmt.Sprintf("# %s\n\n%s\n", t.Title, t.Body)
+	// Write TASK.md with the rendered task brief
 	taskMdPath := filepath.Join(b.Environment.Path, "TASK.md")
 	if !asyncProvisioning {
-		if backendType == "docker" {
+		if backendType == "docker" || backendType == "podman" {
 			backend, err := b.Backend()
 			if err != nil {
 				http.Error(w, "Failed to get backend: "+err.Error(), http.StatusInternalServerError)
@@ -929,6 +1076,12 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if !asyncProvisioning {
+		if err := s.injectSecrets(r.Context(), b); err != nil {
+			log.Printf("Failed to inject secrets for %s/%s: %v", b.Repo, b.Name, err)
+		}
+	}
+
 	// Create agent session record
 	agentStore := agent.NewStore(s.db)
 	session := &agent.Session{
@@ -942,7 +1095,7 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if backendType != "local" {
+	if backendType != "local" && backendType != "sandbox" {
 		// Remote backends start the agent when the terminal websocket connects.
 		taskStore.UpdateStatus(repoRef, slug, task.StatusInProgress)
 		http.Redirect(w, r, "/branches/"+owner+"/"+repoName+"/"+slug, http.StatusSeeOther)
@@ -951,6 +1104,9 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 
 	// Create the agent command
 	cmd, err := agent.Spawn(session.AgentType, b.Environment.Path, session.Prompt, repoRef, slug)
+	if err == nil {
+		cmd, err = sandboxCommand(b, cmd)
+	}
 	if err != nil {
 		log.Printf("Failed to create agent command: %v", err)
 		http.Error(w, "Failed to create agent command: "+err.Error(), http.StatusInternalServerError)
@@ -960,12 +1116,16 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 
 	// Start agent in a PTY so "Open Terminal" can attach to it
 	sessionKey := repoRef + "/" + slug
+	redactor := s.addSecretEnv(b, cmd)
 	termSession, err := s.termMgr.Create(sessionKey, cmd)
 	if err != nil {
 		log.Printf("Failed to start agent PTY: %v", err)
 		http.Error(w, "Failed to start agent PTY: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if redactor != nil {
+		termSession.SetRedact(redactor.Redact)
+	}
 	log.Printf("Started agent terminal session for %s, PID: %d", sessionKey, termSession.PID())
 
 	// Set initial terminal size - Claude needs this to render properly
@@ -984,6 +1144,22 @@ func (s *Server) handleTaskStartBranch(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/branches/"+owner+"/"+repoName+"/"+slug, http.StatusSeeOther)
 }
 
+// renderTaskBrief renders TASK.md for a task from the repo's prompt
+// templates (cook.toml [prompts] or .cook/prompts/*.md on master).
+func (s *Server) renderTaskBrief(rp *repo.Repo, t *task.Task, branchName, templateName string) (string, error) {
+	repoCfg, err := gate.LoadRepoConfigFromBareRepo(rp.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to load cook.toml: %w", err)
+	}
+	src := prompt.BareRepoSource(rp.Path)
+
+	data, err := prompt.Gather(task.NewStore(s.db), gate.NewStore(s.db, s.cfg.Server.DataDir), t, branchName, src)
+	if err != nil {
+		return "", err
+	}
+	return prompt.Load(src, repoCfg.Prompts).Render(templateName, data)
+}
+
 func (s *Server) handleBranchDetail(w http.ResponseWriter, r *http.Request) {
 	owner := chi.URLParam(r, "owner")
 	repoName := chi.URLParam(r, "repo")
@@ -1059,20 +1235,29 @@ func (s *Server) handleBranchDetail(w http.ResponseWriter, r *http.Request) {
 	// Get configured gates from cook.toml
 	// For remote backends, load from bare repo since local checkout doesn't exist
 	var configuredGates []gate.Gate
+	requiredApprovals := 0
 	if b.Environment.Path != "" {
 		if _, err := os.Stat(b.Environment.Path); err == nil {
 			// Local checkout exists
 			if cfg, err := gate.LoadRepoConfig(b.Environment.Path); err == nil {
 				configuredGates = cfg.Gates
+				requiredApprovals = cfg.Review.RequiredApprovals
 			}
 		} else {
 			// Remote backend - load from bare repo
 			if cfg, err := gate.LoadRepoConfigFromBareRepo(rp.Path); err == nil {
 				configuredGates = cfg.Gates
+				requiredApprovals = cfg.Review.RequiredApprovals
 			}
 		}
 	}
 
+	// Review approvals
+	reviewSummary, _ := review.NewStore(s.db).Summary(repoRef, name, currentHead)
+	if requiredApprovals > 0 && reviewSummary.CheckApprovals(requiredApprovals) != nil {
+		canMerge = false
+	}
+
 	// Get linked task
 	var linkedTask *task.Task
 	if b.TaskRepo != nil && b.TaskSlug != nil {
@@ -1086,8 +1271,12 @@ func (s *Server) handleBranchDetail(w http.ResponseWriter, r *http.Request) {
 		needsRebase = isBranchBehindMaster(b.Environment.Path)
 	}
 
+	// Get current agent session (for needs_help status)
+	agentSession, _ := agent.NewStore(s.db).GetByBranch(repoRef, name)
+
 	data := s.baseTemplateData(r, b.FullName())
 	data["Branch"] = b
+	data["AgentSession"] = agentSession
 	data["GateRuns"] = gateRuns
 	data["ConfiguredGates"] = configuredGates
 	data["Task"] = linkedTask
@@ -1097,6 +1286,8 @@ func (s *Server) handleBranchDetail(w http.ResponseWriter, r *http.Request) {
 	data["CurrentHead"] = currentHead
 	data["GatesStale"] = gatesStale
 	data["CanMerge"] = canMerge
+	data["ReviewSummary"] = reviewSummary
+	data["RequiredApprovals"] = requiredApprovals
 	// Check if current user owns this repo
 	user := s.getTemplateUser(r)
 	data["IsOwner"] = user != nil && user.Pubkey == owner
@@ -1163,43 +1354,107 @@ func (s *Server) handleBranchRunGates(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	runs, err := s.runBranchGates(b, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Hand failures to the agent if the repo opted into the fix loop
+	if cfg.FixLoop.Enabled && anyGateFailed(runs) {
+		go s.runFixLoop(*b, cfg)
+	}
+
+	http.Redirect(w, r, "/branches/"+owner+"/"+repoName+"/"+name, http.StatusSeeOther)
+}
+
+// runBranchGates runs every command gate at the branch's current HEAD,
+// locally or through the branch's remote backend.
+func (s *Server) runBranchGates(b *branch.Branch, cfg *gate.RepoConfig) ([]*gate.GateRun, error) {
+	isRemoteBackend := false
+	if _, err := os.Stat(b.Environment.Path); os.IsNotExist(err) {
+		isRemoteBackend = true
+	}
+
+	// Wake the environment if it was suspended while idle
+	if err := s.touchBranch(context.Background(), b); err != nil {
+		return nil, fmt.Errorf("failed to resume environment: %w", err)
+	}
+
 	// Get current HEAD rev
 	var rev string
 	if isRemoteBackend {
 		rev = b.HeadRev
 	} else {
+		var err error
 		rev, err = getWorkdirHead(b.Environment.Path)
 		if err != nil {
-			http.Error(w, "Failed to get current HEAD: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, fmt.Errorf("failed to get current HEAD: %w", err)
 		}
 	}
 
-	// Run all gates
+	// Run all gates. Transform gates go first; when one commits, the branch
+	// head moves and later gates run on the new rev.
+	var runs []*gate.GateRun
 	gateStore := gate.NewStore(s.db, s.cfg.Server.DataDir)
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	secrets, err := gate.ResolveSecrets(s.db, s.cfg.Server.SecretsKey, b.Repo, cfg.Secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+	gateStore.SetSecrets(secrets)
+
+	var backend env.Backend
 	if isRemoteBackend {
 		// For remote backends, get the backend and run gates through it
-		backend, err := b.Backend()
+		var err error
+		backend, err = b.Backend()
 		if err != nil {
-			http.Error(w, "Failed to connect to backend: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, fmt.Errorf("failed to connect to backend: %w", err)
 		}
-		for _, g := range cfg.Gates {
-			if g.Command == "" {
-				continue
-			}
-			gateStore.RunGateRemote(g, repoRef, name, rev, backend)
+	}
+
+	for _, g := range gate.Ordered(cfg.Gates) {
+		if !g.Runnable() {
+			continue
 		}
-	} else {
-		for _, g := range cfg.Gates {
-			if g.Command == "" {
-				continue
+		var run *gate.GateRun
+		if isRemoteBackend {
+			run, _ = gateStore.RunOnBackend(g, b.Repo, b.Name, b.BaseRev, rev, backend)
+		} else {
+			run, _ = gateStore.Run(g, b.Repo, b.Name, b.BaseRev, rev, b.Environment.Path)
+		}
+		if run == nil {
+			continue
+		}
+		runs = append(runs, run)
+
+		if g.IsTransform() && run.Status == gate.StatusPassed && run.Rev != rev {
+			log.Printf("gates: %s: %s committed %s", b.FullName(), g.Name, run.Rev)
+			if err := branchStore.UpdateHeadRev(b.Repo, b.Name, run.Rev); err != nil {
+				return runs, fmt.Errorf("failed to update head rev: %w", err)
 			}
-			gateStore.RunGate(g, repoRef, name, rev, b.Environment.Path)
+			rev = run.Rev
+			b.HeadRev = rev
 		}
 	}
 
-	http.Redirect(w, r, "/branches/"+owner+"/"+repoName+"/"+name, http.StatusSeeOther)
+	if !anyGateFailed(runs) {
+		if err := branchStore.ResetFixIterations(b.Repo, b.Name); err != nil {
+			log.Printf("gates: %s: failed to reset fix iterations: %v", b.FullName(), err)
+		}
+	}
+
+	return runs, nil
+}
+
+func anyGateFailed(runs []*gate.GateRun) bool {
+	for _, r := range runs {
+		if r.Status == gate.StatusFailed {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *Server) handleBranchMerge(w http.ResponseWriter, r *http.Request) {
@@ -1267,7 +1522,7 @@ func (s *Server) handleBranchMerge(w http.ResponseWriter, r *http.Request) {
 		}
 		requiredGates := make(map[string]bool)
 		for _, g := range cfg.Gates {
-			if g.Command != "" {
+			if g.Runnable() {
 				requiredGates[g.Name] = true
 			}
 		}
@@ -1297,6 +1552,25 @@ func (s *Server) handleBranchMerge(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
+
+		// The approval requirement comes from the base branch, so the branch
+		// under review can't lower it
+		baseCfg, err := gate.LoadRepoConfigFromBareRepo(rp.Path)
+		if err != nil {
+			http.Error(w, "Failed to load review requirement: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if required := baseCfg.Review.RequiredApprovals; required > 0 {
+			summary, err := review.NewStore(s.db).Summary(repoRef, name, currentHead)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := summary.CheckApprovals(required); err != nil {
+				http.Error(w, "Review: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 	}
 
 	// Fast-forward merge: check if master hasn't changed since base rev
@@ -1425,6 +1699,7 @@ func (s *Server) handleBranchAbandon(w http.ResponseWriter, r *http.Request) {
 	s.db.Exec(`DELETE FROM gate_runs WHERE branch_repo = $1 AND branch_name = $2`, repoRef, name)
 	s.db.Exec(`DELETE FROM agent_sessions WHERE branch_repo = $1 AND branch_name = $2`, repoRef, name)
 	s.db.Exec(`DELETE FROM terminal_tabs WHERE branch_repo = $1 AND branch_name = $2`, repoRef, name)
+	review.NewStore(s.db).DeleteForBranch(repoRef, name)
 
 	// Delete branch from DB
 	if err := branchStore.Delete(repoRef, name); err != nil {
@@ -1846,6 +2121,10 @@ func (s *Server) handleBranchFileGet(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Branch has no checkout", http.StatusBadRequest)
 		return
 	}
+	if err := s.touchBranch(r.Context(), b); err != nil {
+		http.Error(w, "Failed to resume environment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	root, err := filepath.EvalSymlinks(b.Environment.Path)
 	if err != nil {
@@ -1914,6 +2193,10 @@ func (s *Server) handleBranchFilePut(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Branch has no checkout", http.StatusBadRequest)
 		return
 	}
+	if err := s.touchBranch(r.Context(), b); err != nil {
+		http.Error(w, "Failed to resume environment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	root, err := filepath.EvalSymlinks(b.Environment.Path)
 	if err != nil {
@@ -1982,6 +2265,10 @@ func (s *Server) handleBranchFileList(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Branch has no checkout", http.StatusBadRequest)
 		return
 	}
+	if err := s.touchBranch(r.Context(), b); err != nil {
+		http.Error(w, "Failed to resume environment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// List all files (excluding .git and common ignored dirs)
 	var files []string
@@ -2043,6 +2330,10 @@ func (s *Server) handleBranchFileSearch(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Branch has no checkout", http.StatusBadRequest)
 		return
 	}
+	if err := s.touchBranch(r.Context(), b); err != nil {
+		http.Error(w, "Failed to resume environment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	query := r.URL.Query().Get("q")
 	if query == "" {
@@ -2407,13 +2698,23 @@ func (s *Server) apiActiveBranches(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type branchInfo struct {
-		Repo string `json:"repo"`
-		Name string `json:"name"`
+		Repo        string `json:"repo"`
+		Name        string `json:"name"`
+		AgentStatus string `json:"agent_status,omitempty"`
+		NeedsHelp   bool   `json:"needs_help,omitempty"`
+		HelpReason  string `json:"help_reason,omitempty"`
 	}
 
+	agentStore := agent.NewStore(s.db)
 	result := make([]branchInfo, 0, len(branches))
 	for _, b := range branches {
-		result = append(result, branchInfo{Repo: b.Repo, Name: b.Name})
+		info := branchInfo{Repo: b.Repo, Name: b.Name}
+		if session, _ := agentStore.GetByBranch(b.Repo, b.Name); session != nil {
+			info.AgentStatus = string(session.Status)
+			info.NeedsHelp = session.Status == agent.StatusNeedsHelp
+			info.HelpReason = session.HelpReason
+		}
+		result = append(result, info)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agent"
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/events"
+	"github.com/justinmoon/cook/internal/gate"
+)
+
+// idleCheckInterval is how often branches are checked for idleness.
+const idleCheckInterval = time.Minute
+
+// gateRunStaleAfter is how long a gate run can stay "running" before the
+// idle watcher stops waiting on it. Gates are bounded well under this, so an
+// older run was orphaned (e.g. by a server restart) and never finished.
+const gateRunStaleAfter = time.Hour
+
+// idleSuspendable reports whether the idle watcher suspends branches on
+// backendType. Their backends implement env.Suspender.
+func idleSuspendable(backendType string) bool {
+	switch backendType {
+	case "docker", "fly-machines", "sprites":
+		return true
+	}
+	return false
+}
+
+// runIdleWatcher periodically suspends environments nobody is using, if
+// suspend_idle_after is set.
+func (s *Server) runIdleWatcher(ctx context.Context) {
+	if s.cfg.Server.SuspendIdleAfter <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.suspendIdleBranches(ctx)
+		}
+	}
+}
+
+func (s *Server) suspendIdleBranches(ctx context.Context) {
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	branches, err := branchStore.List("", branch.StatusActive)
+	if err != nil {
+		log.Printf("idle: failed to list branches: %v", err)
+		return
+	}
+
+	for i := range branches {
+		b := &branches[i]
+		if !idleSuspendable(b.Environment.Backend) || b.Environment.Provisioning ||
+			b.Environment.ProvisioningError != "" || b.Environment.StoppedReason != "" || b.Environment.SuspendedAt != nil {
+			continue
+		}
+		s.suspendIfIdle(ctx, branchStore, b)
+	}
+}
+
+// suspendIfIdle suspends b's environment if it is still idle once no
+// request is resuming it.
+func (s *Server) suspendIfIdle(ctx context.Context, branchStore *branch.Store, b *branch.Branch) {
+	lock := s.branchLock(b.FullName())
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !s.branchIdle(b) {
+		return
+	}
+	if err := branchStore.Suspend(ctx, b); err != nil {
+		log.Printf("idle: failed to suspend %s: %v", b.FullName(), err)
+		return
+	}
+	log.Printf("idle: suspended %s (%s)", b.FullName(), b.Environment.Backend)
+	s.eventBus.Publish(events.Event{
+		Type:   events.EventBranchSuspended,
+		Repo:   b.Repo,
+		Branch: b.Name,
+	})
+}
+
+// branchIdle reports whether b has gone suspend_idle_after without a
+// terminal, file, review or gate touching it, and has no terminal open,
+// agent running or gate running (runs older than gateRunStaleAfter don't
+// count).
+func (s *Server) branchIdle(b *branch.Branch) bool {
+	key := b.FullName()
+
+	s.idleMu.Lock()
+	conns := s.terminalConns[key]
+	last, seen := s.lastActivity[key]
+	if !seen {
+		// Start the clock at the first check after a restart
+		s.lastActivity[key] = time.Now()
+	}
+	s.idleMu.Unlock()
+
+	if !seen || conns > 0 || time.Since(last) < s.cfg.Server.SuspendIdleAfter {
+		return false
+	}
+
+	if session, err := agent.NewStore(s.db).GetByBranch(b.Repo, b.Name); err != nil || session != nil {
+		return false
+	}
+
+	runs, err := gate.NewStore(s.db, s.cfg.Server.DataDir).ListRuns(b.Repo, b.Name)
+	if err != nil {
+		return false
+	}
+	for _, run := range runs {
+		if run.Status == gate.StatusRunning && run.StartedAt != nil && time.Since(*run.StartedAt) < gateRunStaleAfter {
+			return false
+		}
+	}
+	return true
+}
+
+// touchBranch records use of b and, if its environment was suspended while
+// idle, resumes it first so the caller can use it as usual.
+func (s *Server) touchBranch(ctx context.Context, b *branch.Branch) error {
+	key := b.FullName()
+	s.idleMu.Lock()
+	s.lastActivity[key] = time.Now()
+	s.idleMu.Unlock()
+
+	if !idleSuspendable(b.Environment.Backend) {
+		return nil
+	}
+
+	// Wait out a suspend in progress, then check the stored state
+	lock := s.branchLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	latest, err := branchStore.Get(b.Repo, b.Name)
+	if err != nil {
+		return err
+	}
+	if latest == nil {
+		return fmt.Errorf("branch %s not found", key)
+	}
+	if latest.Environment.SuspendedAt == nil {
+		return nil
+	}
+
+	start := time.Now()
+	if err := branchStore.Resume(ctx, latest); err != nil {
+		return err
+	}
+	b.Environment = latest.Environment
+	log.Printf("idle: resumed %s (took %v)", key, time.Since(start).Round(time.Millisecond))
+	s.eventBus.Publish(events.Event{
+		Type:   events.EventBranchResumed,
+		Repo:   b.Repo,
+		Branch: b.Name,
+	})
+	return nil
+}
+
+// trackTerminal counts an open terminal on the branch until the returned
+// func is called. The idle clock starts when the last one closes.
+func (s *Server) trackTerminal(key string) func() {
+	s.idleMu.Lock()
+	s.terminalConns[key]++
+	s.idleMu.Unlock()
+
+	return func() {
+		s.idleMu.Lock()
+		defer s.idleMu.Unlock()
+		s.terminalConns[key]--
+		if s.terminalConns[key] <= 0 {
+			delete(s.terminalConns, key)
+		}
+		s.lastActivity[key] = time.Now()
+	}
+}
+
+// branchLock returns the mutex that serializes suspending and resuming
+// the branch with the given key.
+func (s *Server) branchLock(key string) *sync.Mutex {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	lock, ok := s.resumeLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.resumeLocks[key] = lock
+	}
+	return lock
+}
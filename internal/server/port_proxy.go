@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,6 +13,8 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/justinmoon/cook/internal/auth"
 	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/envagent"
 )
 
 func parsePortParam(portStr string) (int, error) {
@@ -90,25 +93,69 @@ func (s *Server) handleBranchPortProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch b.Environment.Backend {
-	case "local", "docker":
-		// ok
-	case "modal", "sprites", "fly-machines":
-		http.Error(w, fmt.Sprintf("Port proxy not supported for backend %q yet (MVP supports local and docker only)", b.Environment.Backend), http.StatusNotImplemented)
-		return
-	default:
-		http.Error(w, fmt.Sprintf("Port proxy not supported for backend %q", b.Environment.Backend), http.StatusNotImplemented)
-		return
-	}
-
 	stripPrefix := fmt.Sprintf("/branches/%s/%s/%s/ports/%d", owner, repoName, branchName, port)
 	target := &url.URL{
 		Scheme: "http",
 		Host:   net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
 	}
 	proxy := newStripPrefixReverseProxy(target, stripPrefix)
+
+	switch b.Environment.Backend {
+	case "local", "sandbox", "docker", "podman":
+		// The port is on this host (containers use host networking)
+	default:
+		// Remote environments are reached through their cook-agent
+		backend, err := b.Backend()
+		if err != nil {
+			http.Error(w, "Failed to get backend: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		addr, secret, ok := env.CookAgent(backend)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Port proxy not supported for backend %q", b.Environment.Backend), http.StatusNotImplemented)
+			return
+		}
+		fwd := s.agentForwarder(addr, secret)
+		proxy.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, hostport string) (net.Conn, error) {
+				return fwd.Dial(ctx, hostport)
+			},
+			DisableKeepAlives: true,
+		}
+	}
+
 	proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
 		http.Error(rw, "Failed to proxy to localhost:"+strconv.Itoa(port)+": "+err.Error(), http.StatusBadGateway)
 	}
 	proxy.ServeHTTP(w, r)
 }
+
+// agentForwarder returns the forwarder the port proxy uses to reach the
+// cook-agent at addr, so requests to an environment share one connection.
+func (s *Server) agentForwarder(addr, secret string) *envagent.Forwarder {
+	s.forwardMu.Lock()
+	defer s.forwardMu.Unlock()
+	key := agentKey{addr, secret}
+	if fwd, ok := s.forwarders[key]; ok {
+		return fwd
+	}
+	for old, fwd := range s.forwarders {
+		if old.addr == addr {
+			fwd.Close()
+			delete(s.forwarders, old)
+		}
+	}
+	fwd := envagent.NewForwarder(addr, secret)
+	s.forwarders[key] = fwd
+	return fwd
+}
+
+// closeForwarders closes the port proxy's agent connections.
+func (s *Server) closeForwarders() {
+	s.forwardMu.Lock()
+	defer s.forwardMu.Unlock()
+	for key, fwd := range s.forwarders {
+		fwd.Close()
+		delete(s.forwarders, key)
+	}
+}
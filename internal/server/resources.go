@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/gate"
+)
+
+// defaultProfile is the resource profile used when cook.toml selects none.
+const defaultProfile = "default"
+
+// selectProfile sets the resource profile cook.toml selects for b (by
+// branch, then task, then repo default) from the server's profiles.
+func (s *Server) selectProfile(b *branch.Branch, bareRepoPath string) error {
+	var name string
+	if repoCfg, err := gate.LoadRepoConfigFromBareRepo(bareRepoPath); err == nil {
+		var taskSlug string
+		if b.TaskSlug != nil {
+			taskSlug = *b.TaskSlug
+		}
+		name = repoCfg.Resources.ProfileFor(b.Name, taskSlug)
+	}
+	if name == "" {
+		if _, ok := s.cfg.Server.Profiles[defaultProfile]; !ok {
+			return nil
+		}
+		name = defaultProfile
+	}
+
+	resources, ok := s.cfg.Server.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown resource profile %q (define it under [server.profiles])", name)
+	}
+	if err := resources.Validate(); err != nil {
+		return fmt.Errorf("resource profile %q: %w", name, err)
+	}
+	b.SetProfile(name, resources)
+	return nil
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/justinmoon/cook/internal/auth"
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/events"
+	"github.com/justinmoon/cook/internal/review"
+)
+
+// reviewGitTimeout bounds git commands run for the review API.
+const reviewGitTimeout = 30 * time.Second
+
+// branchGit runs git in the branch's checkout, locally or on its backend.
+func branchGit(ctx context.Context, b *branch.Branch, args ...string) ([]byte, error) {
+	if _, err := os.Stat(b.Environment.Path); err == nil {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", b.Environment.Path}, args...)...)
+		output, err := cmd.Output()
+		if err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return nil, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			return nil, err
+		}
+		return output, nil
+	}
+
+	backend, err := b.Backend()
+	if err != nil {
+		return nil, err
+	}
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", "'\"'\"'") + "'"
+	}
+	return backend.Exec(ctx, "git "+strings.Join(quoted, " "))
+}
+
+// resolveRev resolves a user-supplied rev to a commit SHA in the branch's
+// checkout. Revs starting with "-" are refused so git can't read them as
+// options.
+func resolveRev(ctx context.Context, b *branch.Branch, rev string) (string, error) {
+	if rev == "" || strings.HasPrefix(rev, "-") {
+		return "", fmt.Errorf("invalid rev %q", rev)
+	}
+	output, err := branchGit(ctx, b, "rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("unknown rev %q", rev)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// branchHead returns the checkout's HEAD, falling back to the recorded HeadRev.
+func branchHead(b *branch.Branch) string {
+	if _, err := os.Stat(b.Environment.Path); err == nil {
+		if head, err := getWorkdirHead(b.Environment.Path); err == nil {
+			return head
+		}
+	}
+	return b.HeadRev
+}
+
+// reviewBranch loads the branch named in the URL, writing an API error if
+// missing. Review endpoints read the checkout, so it counts as use and
+// resumes a suspended environment.
+func (s *Server) reviewBranch(w http.ResponseWriter, r *http.Request) *branch.Branch {
+	repoRef := chi.URLParam(r, "owner") + "/" + chi.URLParam(r, "repo")
+	name := chi.URLParam(r, "name")
+
+	b, err := branch.NewStore(s.db, s.cfg.Server.DataDir).Get(repoRef, name)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if b == nil {
+		apiError(w, "Branch not found", http.StatusNotFound)
+		return nil
+	}
+	if err := s.touchBranch(r.Context(), b); err != nil {
+		apiError(w, "Failed to resume environment: "+err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return b
+}
+
+func (s *Server) apiBranchDiff(w http.ResponseWriter, r *http.Request) {
+	b := s.reviewBranch(w, r)
+	if b == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), reviewGitTimeout)
+	defer cancel()
+
+	head := branchHead(b)
+	var paths []string
+	if p := r.URL.Query().Get("path"); p != "" {
+		paths = append(paths, p)
+	}
+	output, err := branchGit(ctx, b, review.DiffCommand(b.BaseRev, head, paths...)...)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	files, err := review.ParseDiff(string(output))
+	if err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if files == nil {
+		files = []review.FileDiff{}
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"base_rev": b.BaseRev,
+		"head_rev": head,
+		"files":    files,
+	}, http.StatusOK)
+}
+
+func (s *Server) apiBranchComments(w http.ResponseWriter, r *http.Request) {
+	b := s.reviewBranch(w, r)
+	if b == nil {
+		return
+	}
+
+	comments, err := s.currentComments(r.Context(), b)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if comments == nil {
+		comments = []review.Comment{}
+	}
+	jsonResponse(w, comments, http.StatusOK)
+}
+
+func (s *Server) apiBranchCommentCreate(w http.ResponseWriter, r *http.Request) {
+	pubkey := auth.GetPubkey(r.Context())
+	if pubkey == "" {
+		apiError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	b := s.reviewBranch(w, r)
+	if b == nil {
+		return
+	}
+
+	var req struct {
+		Path string `json:"path"`
+		Line int    `json:"line"`
+		Rev  string `json:"rev"` // defaults to the branch head
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" || req.Line < 1 || strings.TrimSpace(req.Body) == "" {
+		apiError(w, "path, line and body are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), reviewGitTimeout)
+	defer cancel()
+
+	head := branchHead(b)
+	rev := head
+	if req.Rev != "" {
+		resolved, err := resolveRev(ctx, b, req.Rev)
+		if err != nil {
+			apiError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rev = resolved
+	}
+	content, err := branchGit(ctx, b, "show", rev+":"+req.Path)
+	if err != nil {
+		apiError(w, fmt.Sprintf("%s not found at %s", req.Path, rev), http.StatusBadRequest)
+		return
+	}
+	lines := strings.Split(string(content), "\n")
+	if req.Line > len(lines) {
+		apiError(w, fmt.Sprintf("%s has no line %d", req.Path, req.Line), http.StatusBadRequest)
+		return
+	}
+
+	c := &review.Comment{
+		BranchRepo: b.Repo,
+		BranchName: b.Name,
+		Path:       req.Path,
+		Line:       req.Line,
+		Rev:        rev,
+		LineText:   lines[req.Line-1],
+		Body:       req.Body,
+		Author:     pubkey,
+	}
+	if rev != head {
+		// Anchor to head right away so later relocations start from here
+		s.relocateComments(ctx, b, head, []*review.Comment{c})
+	}
+
+	if err := review.NewStore(s.db).AddComment(c); err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.eventBus.Publish(events.Event{
+		Type:   events.EventReviewComment,
+		Repo:   b.Repo,
+		Branch: b.Name,
+		Data:   c,
+	})
+	jsonResponse(w, c, http.StatusCreated)
+}
+
+func (s *Server) apiBranchReviews(w http.ResponseWriter, r *http.Request) {
+	b := s.reviewBranch(w, r)
+	if b == nil {
+		return
+	}
+
+	reviews, err := review.NewStore(s.db).ListReviews(b.Repo, b.Name)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reviews == nil {
+		reviews = []review.Review{}
+	}
+
+	required := 0
+	if cfg := s.repoConfigForBranch(b); cfg != nil {
+		required = cfg.Review.RequiredApprovals
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"reviews":            reviews,
+		"summary":            review.Summarize(reviews, branchHead(b)),
+		"required_approvals": required,
+	}, http.StatusOK)
+}
+
+func (s *Server) apiBranchReviewSubmit(w http.ResponseWriter, r *http.Request) {
+	pubkey := auth.GetPubkey(r.Context())
+	if pubkey == "" {
+		apiError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	b := s.reviewBranch(w, r)
+	if b == nil {
+		return
+	}
+
+	var req struct {
+		State string `json:"state"`
+		Body  string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !review.ValidState(req.State) {
+		apiError(w, "state must be comment, approve or request_changes", http.StatusBadRequest)
+		return
+	}
+	if owner, _, _ := strings.Cut(b.Repo, "/"); req.State == review.StateApprove && owner == pubkey {
+		apiError(w, "You can't approve your own branch", http.StatusForbidden)
+		return
+	}
+
+	rv := &review.Review{
+		BranchRepo: b.Repo,
+		BranchName: b.Name,
+		Reviewer:   pubkey,
+		State:      req.State,
+		Rev:        branchHead(b),
+		Body:       req.Body,
+	}
+	if err := review.NewStore(s.db).Submit(rv); err != nil {
+		apiError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.eventBus.Publish(events.Event{
+		Type:   events.EventReviewSubmitted,
+		Repo:   b.Repo,
+		Branch: b.Name,
+		Data:   rv,
+	})
+	jsonResponse(w, rv, http.StatusCreated)
+}
+
+// currentComments returns the branch's comments, re-anchored to its head.
+func (s *Server) currentComments(ctx context.Context, b *branch.Branch) ([]review.Comment, error) {
+	store := review.NewStore(s.db)
+	comments, err := store.ListComments(b.Repo, b.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reviewGitTimeout)
+	defer cancel()
+
+	head := branchHead(b)
+	var stale []*review.Comment
+	for i := range comments {
+		if !comments[i].Outdated && comments[i].Rev != head {
+			stale = append(stale, &comments[i])
+		}
+	}
+	for _, c := range s.relocateComments(ctx, b, head, stale) {
+		if err := store.UpdateAnchor(c); err != nil {
+			log.Printf("review: failed to update comment %d: %v", c.ID, err)
+		}
+	}
+	return comments, nil
+}
+
+// relocateComments moves comments to head by diffing from each comment's
+// rev. If that rev is gone (e.g. after a rebase and gc), the commented line
+// is searched for by content. Returns the comments that changed.
+func (s *Server) relocateComments(ctx context.Context, b *branch.Branch, head string, comments []*review.Comment) []*review.Comment {
+	diffs := make(map[string][]review.FileDiff) // by rev
+	var changed []*review.Comment
+
+	for _, c := range comments {
+		files, ok := diffs[c.Rev]
+		// Revs are resolved SHAs, but never hand git an option
+		if !ok && !strings.HasPrefix(c.Rev, "-") {
+			if output, err := branchGit(ctx, b, review.DiffCommand(c.Rev, head)...); err == nil {
+				files, _ = review.ParseDiff(string(output))
+				ok = true
+			}
+			if ok {
+				diffs[c.Rev] = files
+			}
+		}
+
+		if ok {
+			review.Relocate(c, files, head)
+		} else if content, err := branchGit(ctx, b, "show", head+":"+c.Path); err == nil {
+			if line, found := review.FindLine(string(content), c.LineText, c.Line); found {
+				c.Line, c.Rev = line, head
+			} else {
+				c.Outdated = true
+			}
+		} else {
+			c.Outdated = true
+		}
+		changed = append(changed, c)
+	}
+	return changed
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/gate"
+	"github.com/justinmoon/cook/internal/redact"
+	"github.com/justinmoon/cook/internal/repo"
+	"github.com/justinmoon/cook/internal/secret"
+)
+
+// branchSecrets resolves the secrets the master cook.toml of b's repo
+// selects, or nil if it selects none. Using master's config keeps a branch
+// from asking for secrets the repo does not.
+func (s *Server) branchSecrets(b *branch.Branch) (*secret.Resolved, error) {
+	owner, name, _ := strings.Cut(b.Repo, "/")
+	rp, err := repo.NewStore(s.cfg.Server.DataDir).Get(owner, name)
+	if err != nil || rp == nil {
+		return nil, fmt.Errorf("repository %s not found", b.Repo)
+	}
+	repoCfg, err := gate.LoadRepoConfigFromBareRepo(rp.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cook.toml: %w", err)
+	}
+	return gate.ResolveSecrets(s.db, s.cfg.Server.SecretsKey, b.Repo, repoCfg.Secrets)
+}
+
+// injectSecrets writes b's file secrets into its checkout and lists them
+// in .git/info/exclude so they are not committed.
+func (s *Server) injectSecrets(ctx context.Context, b *branch.Branch) error {
+	secrets, err := s.branchSecrets(b)
+	if err != nil || secrets == nil || len(secrets.Files) == 0 {
+		return err
+	}
+	backend, err := b.Backend()
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(secrets.Files))
+	for path := range secrets.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if filepath.IsAbs(path) || strings.HasPrefix(filepath.Clean(path), "..") {
+			return fmt.Errorf("secret file path %q must be inside the checkout", path)
+		}
+		if err := backend.WriteFile(ctx, filepath.Join(b.Environment.Path, path), []byte(secrets.Files[path])); err != nil {
+			return fmt.Errorf("failed to write secret file %s: %w", path, err)
+		}
+	}
+
+	excludePath := filepath.Join(b.Environment.Path, ".git", "info", "exclude")
+	exclude, _ := backend.ReadFile(ctx, excludePath)
+	updated := exclude
+	for _, path := range paths {
+		line := "/" + filepath.ToSlash(filepath.Clean(path))
+		if !bytes.Contains(exclude, []byte(line+"\n")) {
+			if len(updated) > 0 && !bytes.HasSuffix(updated, []byte("\n")) {
+				updated = append(updated, '\n')
+			}
+			updated = append(updated, line+"\n"...)
+		}
+	}
+	if len(updated) == len(exclude) {
+		return nil
+	}
+	return backend.WriteFile(ctx, excludePath, updated)
+}
+
+// provisionInBackground finishes provisioning a remote branch recorded by
+// one of the CreateProvisioning* methods, then injects its secrets.
+func (s *Server) provisionInBackground(branchStore *branch.Store, b branch.Branch, bareRepoPath, repoURL, taskMdContent string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+		defer cancel()
+		if err := branchStore.ProvisionRemoteBranch(ctx, &b, bareRepoPath, repoURL, taskMdContent); err != nil {
+			log.Printf("Provisioning failed for %s/%s: %v", b.Repo, b.Name, err)
+			return
+		}
+		// Reload for the provisioned environment
+		latest, err := branchStore.Get(b.Repo, b.Name)
+		if err != nil || latest == nil || latest.Status != branch.StatusActive {
+			return
+		}
+		if err := s.injectSecrets(ctx, latest); err != nil {
+			log.Printf("Failed to inject secrets for %s/%s: %v", b.Repo, b.Name, err)
+		}
+	}()
+}
+
+// addSecretEnv adds b's env secrets to cmd, a command started locally for
+// the branch, and returns the redactor for its terminal output. Failing to
+// resolve secrets is logged rather than keeping the terminal from opening.
+func (s *Server) addSecretEnv(b *branch.Branch, cmd *exec.Cmd) *redact.Redactor {
+	secrets, err := s.branchSecrets(b)
+	if err != nil {
+		log.Printf("Failed to resolve secrets for %s/%s: %v", b.Repo, b.Name, err)
+		return nil
+	}
+	if secrets == nil {
+		return nil
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, secrets.Environ()...)
+	return secrets.Redactor()
+}
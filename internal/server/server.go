@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinmoon/cook/internal/auth"
+	"github.com/justinmoon/cook/internal/branch"
 	"github.com/justinmoon/cook/internal/config"
 	"github.com/justinmoon/cook/internal/db"
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/envagent"
 	"github.com/justinmoon/cook/internal/events"
+	"github.com/justinmoon/cook/internal/pool"
 	"github.com/justinmoon/cook/internal/terminal"
 )
 
@@ -49,6 +54,29 @@ type Server struct {
 	termMgr        *terminal.Manager
 	sessionStore   *auth.SessionStore
 	challengeStore *auth.ChallengeStore
+
+	// Background jobs (budget, stuck and idle watchers) run until Shutdown cancels bgCtx
+	bgCtx    context.Context
+	bgCancel context.CancelFunc
+
+	budgetMu     sync.Mutex
+	budgetWarned map[int64]bool // agent session IDs already warned
+
+	fixMu    sync.Mutex
+	fixLoops map[string]bool // branches with a fix loop in progress
+
+	pool *pool.Pool // warm remote environments (nil if no pools are configured)
+
+	idleMu        sync.Mutex
+	lastActivity  map[string]time.Time   // branch -> last terminal, file or gate use
+	terminalConns map[string]int         // branch -> open terminal websockets
+	resumeLocks   map[string]*sync.Mutex // branch -> lock serializing suspend and resume
+
+	forwardMu  sync.Mutex
+	forwarders map[agentKey]*envagent.Forwarder // port proxy connections to cook-agents
+
+	agentUpdateMu sync.Mutex
+	agentsChecked map[agentKey]bool // cook-agents checked for drift from this server's binary
 }
 
 func New(cfg *config.Config, database *db.DB) (*Server, error) {
@@ -57,6 +85,16 @@ func New(cfg *config.Config, database *db.DB) (*Server, error) {
 		return nil, fmt.Errorf("failed to create event bus: %w", err)
 	}
 
+	for name, plugin := range cfg.Server.Backends {
+		if err := env.RegisterPlugin(name, env.PluginConfig{Command: plugin.Command, Socket: plugin.Socket}); err != nil {
+			return nil, fmt.Errorf("invalid backend plugin: %w", err)
+		}
+	}
+
+	if err := envagent.LoadKey(cfg.Server.DataDir); err != nil {
+		return nil, err
+	}
+
 	s := &Server{
 		cfg:            cfg,
 		db:             database,
@@ -65,6 +103,23 @@ func New(cfg *config.Config, database *db.DB) (*Server, error) {
 		termMgr:        terminal.NewManager(),
 		sessionStore:   auth.NewSessionStore(database),
 		challengeStore: auth.NewChallengeStore(),
+		budgetWarned:   make(map[int64]bool),
+		fixLoops:       make(map[string]bool),
+		lastActivity:   make(map[string]time.Time),
+		terminalConns:  make(map[string]int),
+		resumeLocks:    make(map[string]*sync.Mutex),
+		forwarders:     make(map[agentKey]*envagent.Forwarder),
+		agentsChecked:  make(map[agentKey]bool),
+	}
+	s.bgCtx, s.bgCancel = context.WithCancel(context.Background())
+
+	if len(cfg.Server.Pools) > 0 {
+		p, err := pool.New(database, cfg.Server.Pools)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pools config: %w", err)
+		}
+		s.pool = p
+		branch.SetWarmPool(p)
 	}
 
 	s.setupRoutes()
@@ -169,6 +224,10 @@ func (s *Server) setupRoutes() {
 		r.Post("/tasks", s.apiTaskCreateJSON)
 		r.Get("/tasks/{owner}/{repo}/{slug}", s.apiTaskGet)
 
+		// Warm environment pools
+		r.Get("/pools", s.apiPoolStats)
+		r.Get("/environments", s.apiListEnvironments)
+
 		// SSH Keys
 		r.Get("/ssh-keys", s.apiSSHKeyList)
 		r.Post("/ssh-keys", s.apiSSHKeyAdd)
@@ -176,6 +235,16 @@ func (s *Server) setupRoutes() {
 
 		// Branch preview control
 		r.Post("/branches/{owner}/{repo}/{name}/preview", s.apiBranchPreviewNavigate)
+
+		// Agent usage reporting (for budgets)
+		r.Post("/branches/{owner}/{repo}/{name}/usage", s.apiBranchUsage)
+
+		// Code review
+		r.Get("/branches/{owner}/{repo}/{name}/diff", s.apiBranchDiff)
+		r.Get("/branches/{owner}/{repo}/{name}/comments", s.apiBranchComments)
+		r.Post("/branches/{owner}/{repo}/{name}/comments", s.apiBranchCommentCreate)
+		r.Get("/branches/{owner}/{repo}/{name}/reviews", s.apiBranchReviews)
+		r.Post("/branches/{owner}/{repo}/{name}/reviews", s.apiBranchReviewSubmit)
 	})
 
 	// SSE endpoint for real-time updates
@@ -198,14 +267,27 @@ func (s *Server) Start() error {
 		Handler: s.router,
 	}
 
+	go s.runBudgetWatcher(s.bgCtx)
+	go s.runStuckWatcher(s.bgCtx)
+	go s.runIdleWatcher(s.bgCtx)
+	go s.runGC(s.bgCtx)
+	go s.runEnvironmentWatcher(s.bgCtx)
+	if s.pool != nil {
+		go s.pool.Run(s.bgCtx)
+	}
+
 	fmt.Printf("Server starting on http://%s\n", addr)
 	return s.server.ListenAndServe()
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.bgCancel != nil {
+		s.bgCancel()
+	}
 	if s.termMgr != nil {
 		s.termMgr.CloseAll()
 	}
+	s.closeForwarders()
 	if s.eventBus != nil {
 		s.eventBus.Close()
 	}
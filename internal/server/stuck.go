@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/justinmoon/cook/internal/agent"
+	"github.com/justinmoon/cook/internal/agentproto"
+	"github.com/justinmoon/cook/internal/branch"
+	"github.com/justinmoon/cook/internal/env"
+	"github.com/justinmoon/cook/internal/envagent"
+	"github.com/justinmoon/cook/internal/events"
+	"github.com/justinmoon/cook/internal/terminal"
+)
+
+// stuckCheckInterval is how often agent PTYs are checked for stuck heuristics.
+const stuckCheckInterval = 30 * time.Second
+
+// runStuckWatcher periodically flags agent sessions whose PTY looks stuck
+// as needs_help, and flips them back once output resumes. Remote agents
+// that have exited are recorded as ended, whether or not a browser was
+// attached to see it.
+func (s *Server) runStuckWatcher(ctx context.Context) {
+	ticker := time.NewTicker(stuckCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkStuckAgents()
+		}
+	}
+}
+
+func (s *Server) checkStuckAgents() {
+	branchStore := branch.NewStore(s.db, s.cfg.Server.DataDir)
+	branches, err := branchStore.List("", branch.StatusActive)
+	if err != nil {
+		log.Printf("stuck: failed to list branches: %v", err)
+		return
+	}
+
+	agentStore := agent.NewStore(s.db)
+	for i := range branches {
+		b := &branches[i]
+		if b.Environment.Provisioning || b.Environment.StoppedReason != "" {
+			continue
+		}
+
+		session, err := agentStore.GetByBranch(b.Repo, b.Name)
+		if err != nil || session == nil {
+			continue
+		}
+
+		report, ok := s.agentActivity(b, session)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case report.Stuck() && session.Status != agent.StatusNeedsHelp:
+			// The detail quotes the agent's output, which may echo a secret
+			if secrets, err := s.branchSecrets(b); err == nil {
+				report.Detail = string(secrets.Redactor().Redact([]byte(report.Detail)))
+			}
+			session.Status = agent.StatusNeedsHelp
+			session.HelpReason = string(report.Reason)
+			if report.Detail != "" {
+				session.HelpReason += ": " + report.Detail
+			}
+			if err := agentStore.Update(session); err != nil {
+				log.Printf("stuck: failed to update agent session %d: %v", session.ID, err)
+				continue
+			}
+			log.Printf("stuck: %s needs help (%s)", b.FullName(), session.HelpReason)
+			s.eventBus.Publish(events.Event{
+				Type:   events.EventAgentNeedsHelp,
+				Repo:   b.Repo,
+				Branch: b.Name,
+				Data:   report,
+			})
+
+		case !report.Stuck() && session.Status == agent.StatusNeedsHelp:
+			session.Status = agent.StatusRunning
+			session.HelpReason = ""
+			if err := agentStore.Update(session); err != nil {
+				log.Printf("stuck: failed to update agent session %d: %v", session.ID, err)
+				continue
+			}
+			s.eventBus.Publish(events.Event{
+				Type:   events.EventAgentResumed,
+				Repo:   b.Repo,
+				Branch: b.Name,
+			})
+		}
+	}
+}
+
+// agentActivity checks the branch's agent PTY, either in-process (local) or
+// via cook-agent (remote backends). Returns false if no PTY is running,
+// recording the end of a remote session that has exited.
+func (s *Server) agentActivity(b *branch.Branch, session *agent.Session) (terminal.StuckReport, bool) {
+	cfg := terminal.StuckConfig{IdleAfter: s.cfg.Server.StuckIdleAfter}
+	sessionKey := b.FullName()
+
+	if env.IsLocalCheckout(env.Type(b.Environment.Backend)) {
+		sess := s.termMgr.Get(sessionKey)
+		if sess == nil {
+			return terminal.StuckReport{}, false
+		}
+		if _, closed := sess.ClosedAt(); closed {
+			return terminal.StuckReport{}, false
+		}
+		return sess.CheckStuck(cfg), true
+	}
+
+	backend, err := b.Backend()
+	if err != nil {
+		return terminal.StuckReport{}, false
+	}
+	addr, secret, ok := env.CookAgent(backend)
+	if !ok {
+		return terminal.StuckReport{}, false
+	}
+	client, err := envagent.Dial(addr, secret)
+	if err != nil {
+		return terminal.StuckReport{}, false
+	}
+	defer client.Close()
+
+	// A session still starting waits for its terminal to create it
+	if session.Status != agent.StatusStarting {
+		info, err := remoteAgentSession(client, sessionKey, session)
+		if err != nil {
+			return terminal.StuckReport{}, false
+		}
+		if info != nil && info.State != agentproto.SessionRunning {
+			log.Printf("stuck: %s: %s", b.FullName(), describeSessionEnd(*info))
+			s.recordRemoteAgentEnd(b, *info)
+			return terminal.StuckReport{}, false
+		}
+	}
+
+	report, err := client.Activity(sessionKey, cfg.IdleAfter)
+	if err != nil {
+		return terminal.StuckReport{}, false
+	}
+	return *report, true
+}
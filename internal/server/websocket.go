@@ -11,14 +11,17 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
 	"github.com/justinmoon/cook/internal/agent"
+	"github.com/justinmoon/cook/internal/agentproto"
 	"github.com/justinmoon/cook/internal/auth"
 	"github.com/justinmoon/cook/internal/branch"
 	"github.com/justinmoon/cook/internal/env"
 	"github.com/justinmoon/cook/internal/envagent"
+	"github.com/justinmoon/cook/internal/redact"
 )
 
 var upgrader = websocket.Upgrader{
@@ -96,8 +99,15 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// For Docker, Modal, Sprites, and Fly Machines backends, use cook-agent protocol
-	if b.Environment.Backend == "docker" || b.Environment.Backend == "modal" || b.Environment.Backend == "sprites" || b.Environment.Backend == "fly-machines" {
+	// Wake the environment if it was suspended while idle
+	if err := s.touchBranch(r.Context(), b); err != nil {
+		http.Error(w, "Failed to resume environment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer s.trackTerminal(b.FullName())()
+
+	// For Docker, Podman, Modal, Sprites, Fly Machines, SSH and plugin backends, use cook-agent protocol
+	if b.Environment.Backend == "docker" || b.Environment.Backend == "podman" || b.Environment.Backend == "modal" || b.Environment.Backend == "sprites" || b.Environment.Backend == "fly-machines" || b.Environment.Backend == "ssh" || env.IsPlugin(b.Environment.Backend) {
 		s.handleRemoteTerminalWS(w, r, b, sessionKey, isAgentSession, initialRows, initialCols)
 		return
 	}
@@ -106,7 +116,14 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 	errNoShell := errors.New("no shell found")
 
 	// Get existing session or create new one (with initial size from URL)
-	sess, created, err := s.termMgr.GetOrCreate(sessionKey, func() (*exec.Cmd, error) {
+	var redactor *redact.Redactor
+	sess, created, err := s.termMgr.GetOrCreate(sessionKey, func() (cmd *exec.Cmd, err error) {
+		defer func() {
+			if err == nil {
+				redactor = s.addSecretEnv(b, cmd)
+			}
+		}()
+
 		// Only check for agent session on the main (non-tab) terminal
 		if isAgentSession {
 			agentStore := agent.NewStore(s.db)
@@ -114,7 +131,11 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 			if agentSession != nil {
 				// Resume the agent session instead of creating a shell
 				log.Printf("Resuming agent session for %s (type: %s)", sessionKey, agentSession.AgentType)
-				return agent.SpawnResume(agentSession.AgentType, b.Environment.Path, repoRef, branchName)
+				cmd, err := agent.SpawnResume(agentSession.AgentType, b.Environment.Path, repoRef, branchName)
+				if err != nil {
+					return nil, err
+				}
+				return sandboxCommand(b, cmd)
 			}
 		}
 
@@ -124,12 +145,12 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 			return nil, err
 		}
 
-		lb := backend.(*env.LocalBackend)
-		// Create a shell PTY using the backend's Command method (gets proper env with isolated HOME)
+		// Create a shell PTY using the backend's Command method (gets proper env
+		// with isolated HOME, and runs inside bubblewrap for sandbox branches)
 		shells := []string{"/bin/zsh", "/bin/bash", "/bin/sh"}
 		for _, shell := range shells {
 			if _, err := os.Stat(shell); err == nil {
-				cmd, err := lb.Command(context.Background(), shell, "-l")
+				cmd, err := backend.Command(context.Background(), shell, "-l")
 				if err != nil {
 					return nil, err
 				}
@@ -148,8 +169,16 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if created {
+		if redactor != nil {
+			sess.SetRedact(redactor.Redact)
+		}
 		log.Printf("Created new terminal session for %s (initial size: %dx%d)", sessionKey, initialCols, initialRows)
 	} else {
+		if secrets, err := s.branchSecrets(b); err != nil {
+			log.Printf("Failed to resolve secrets for %s: %v", sessionKey, err)
+		} else {
+			redactor = secrets.Redactor()
+		}
 		log.Printf("Attaching to existing terminal session for %s (client size: %dx%d)", sessionKey, initialCols, initialRows)
 	}
 
@@ -169,9 +198,13 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 	// This ensures the terminal is properly sized so line wrapping is correct.
 	snapshotSent := false
 
-	// Stream output to WebSocket.
+	// Stream output to WebSocket, masking secrets the terminal prints
+	stream := redactor.Stream()
 	go func() {
 		for chunk := range outCh {
+			if chunk = stream.Redact(chunk); len(chunk) == 0 {
+				continue
+			}
 			if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
 				log.Printf("WebSocket write error: %v", err)
 				return
@@ -231,6 +264,19 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 
 // StartTerminalSession is no longer needed - PTY is created on WebSocket connect
 
+// agentWorkDir is the directory cook-agent sessions start in. Container
+// and sandbox backends mount the checkout at /workspace; the ssh backend
+// uses the checkout path on the remote host, and plugins report their own.
+func agentWorkDir(backend env.Backend) string {
+	switch be := backend.(type) {
+	case *env.SSHBackend:
+		return be.WorkDir()
+	case *env.PluginBackend:
+		return be.AgentWorkDir()
+	}
+	return "/workspace"
+}
+
 // handleRemoteTerminalWS handles terminal connections for Docker/Modal backends via cook-agent
 func (s *Server) handleRemoteTerminalWS(w http.ResponseWriter, r *http.Request, b *branch.Branch, sessionKey string, isAgentSession bool, initialRows, initialCols uint16) {
 	// Get the backend to find agent address
@@ -244,36 +290,34 @@ func (s *Server) handleRemoteTerminalWS(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	// Get agent address from backend (works for both Docker and Modal)
-	var agentAddr string
-	switch be := backend.(type) {
-	case *env.DockerBackend:
-		agentAddr = be.AgentAddr()
-	case *env.ModalBackend:
-		agentAddr = be.AgentAddr()
-	case *env.SpritesBackend:
-		agentAddr = be.AgentAddr()
-	case *env.FlyMachinesBackend:
-		agentAddr = be.AgentAddr()
-	default:
+	// Look the agent up once: on ssh hosts it sets up the agent and tunnel
+	agentAddr, agentSecret, ok := env.CookAgent(backend)
+	if !ok {
+		// Plugins without a cook-agent may still provide a PTY
+		if pa, isPTY := backend.(env.PTYAttacher); isPTY {
+			s.handlePTYAttachWS(w, r, pa, initialRows, initialCols)
+			return
+		}
 		http.Error(w, "Backend does not support cook-agent", http.StatusBadRequest)
 		return
 	}
 
 	// Connect to cook-agent
-	agentClient, err := envagent.Dial(agentAddr)
+	agentClient, err := envagent.Dial(agentAddr, agentSecret)
 	if err != nil {
 		log.Printf("Failed to connect to cook-agent at %s: %v", agentAddr, err)
 		http.Error(w, "Failed to connect to container agent", http.StatusInternalServerError)
 		return
 	}
 	defer agentClient.Close()
+	s.checkAgentVersion(agentAddr, agentSecret)
 
 	// Determine the command to run
 	var command string
+	agentStore := agent.NewStore(s.db)
+	var agentSession *agent.Session
 	if isAgentSession {
-		agentStore := agent.NewStore(s.db)
-		agentSession, _ := agentStore.GetLatest(b.Repo, b.Name)
+		agentSession, _ = agentStore.GetLatest(b.Repo, b.Name)
 		if agentSession != nil {
 			// Build command with prompt if available
 			prompt := agentSession.Prompt
@@ -302,18 +346,49 @@ func (s *Server) handleRemoteTerminalWS(w http.ResponseWriter, r *http.Request,
 		command = "bash -l"
 	}
 
+	// Secrets are passed to a new session and masked in its output
+	secrets, err := s.branchSecrets(b)
+	if err != nil {
+		log.Printf("Failed to resolve secrets for %s: %v", sessionKey, err)
+	}
+
 	// Try to attach to existing session, or create new one
 	sessionID := sessionKey
 	err = agentClient.AttachSession(sessionID)
+	if err != nil && agentSession != nil && agentSession.Status != agent.StatusStarting {
+		// The agent already ran: report how it ended rather than run it again
+		info, infoErr := remoteAgentSession(agentClient, sessionID, agentSession)
+		switch {
+		case infoErr != nil:
+			log.Printf("Failed to list agent sessions for %s: %v", sessionID, infoErr)
+			http.Error(w, "Failed to reach container agent", http.StatusBadGateway)
+			return
+		case info == nil:
+			http.Error(w, "Agent session is no longer available", http.StatusGone)
+			return
+		case info.State != agentproto.SessionRunning:
+			s.recordRemoteAgentEnd(b, *info)
+			http.Error(w, describeSessionEnd(*info), http.StatusGone)
+			return
+		}
+		// Started since the attach failed
+		err = agentClient.AttachSession(sessionID)
+	}
 	if err != nil {
 		// Session doesn't exist, create it
 		log.Printf("Creating new agent session %s: %s (size: %dx%d)", sessionID, command, initialCols, initialRows)
-		err = agentClient.CreateSession(sessionID, command, "/workspace", int(initialRows), int(initialCols))
+		err = agentClient.CreateSessionWithEnv(sessionID, command, agentWorkDir(backend), secrets.Environ(), int(initialRows), int(initialCols))
 		if err != nil {
 			log.Printf("Failed to create agent session: %v", err)
 			http.Error(w, "Failed to create session in container", http.StatusInternalServerError)
 			return
 		}
+		if agentSession != nil {
+			agentSession.Status = agent.StatusRunning
+			if err := agentStore.Update(agentSession); err != nil {
+				log.Printf("Failed to mark agent session %d running: %v", agentSession.ID, err)
+			}
+		}
 	} else {
 		log.Printf("Attached to existing agent session %s", sessionID)
 	}
@@ -331,15 +406,30 @@ func (s *Server) handleRemoteTerminalWS(w http.ResponseWriter, r *http.Request,
 		agentClient.Resize(sessionID, int(initialRows), int(initialCols))
 	}
 
-	// Forward agent output to WebSocket
+	// Forward agent output to WebSocket, masking secrets. The agent masks
+	// its own replay buffer with the secrets it started the session with,
+	// so this catches secrets added since.
+	stream := secrets.Redactor().Stream()
 	agentClient.SetOutputHandler(func(sid string, data []byte) {
 		if sid == sessionID {
+			if data = stream.Redact(data); len(data) == 0 {
+				return
+			}
 			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
 				log.Printf("WebSocket write error: %v", err)
 			}
 		}
 	})
 
+	// Record how the agent's process ended
+	if isAgentSession {
+		agentClient.SetExitHandler(func(sid string, code int) {
+			if sid == sessionID {
+				s.recordAgentExit(b, code)
+			}
+		})
+	}
+
 	// Start reading from agent in background
 	go func() {
 		if err := agentClient.ReadLoop(); err != nil {
@@ -382,3 +472,140 @@ func (s *Server) handleRemoteTerminalWS(w http.ResponseWriter, r *http.Request,
 		}
 	}
 }
+
+// recordAgentExit marks b's agent session ended with the exit status
+// cook-agent reported, unless it already ended for another reason.
+func (s *Server) recordAgentExit(b *branch.Branch, code int) {
+	s.recordAgentEnd(b, &code)
+}
+
+// recordRemoteAgentEnd records how cook-agent reports b's agent session
+// ended. A session the agent lost failed with no exit status.
+func (s *Server) recordRemoteAgentEnd(b *branch.Branch, info agentproto.SessionInfo) {
+	s.recordAgentEnd(b, info.ExitCode)
+}
+
+func (s *Server) recordAgentEnd(b *branch.Branch, code *int) {
+	agentStore := agent.NewStore(s.db)
+	session, err := agentStore.GetLatest(b.Repo, b.Name)
+	if err != nil || session == nil || session.EndedAt != nil {
+		return
+	}
+	now := time.Now()
+	session.ExitCode = code
+	session.EndedAt = &now
+	session.Status = agent.StatusCompleted
+	if code == nil || *code != 0 {
+		session.Status = agent.StatusFailed
+	}
+	if err := agentStore.Update(session); err != nil {
+		log.Printf("Failed to record agent exit for %s: %v", b.FullName(), err)
+	}
+}
+
+// sessionClockSkew allows for cook-agent's clock running behind cook's
+// when matching its sessions to agent session records.
+const sessionClockSkew = time.Minute
+
+// remoteAgentSession looks up the cook-agent session sessionID that runs
+// the agent session record session. It returns nil if the agent has none,
+// or only one from before the record (e.g. the record is a headless fix
+// run, and the session an earlier agent's).
+func remoteAgentSession(client *envagent.Client, sessionID string, session *agent.Session) (*agentproto.SessionInfo, error) {
+	infos, err := client.SessionInfo()
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		info := &infos[i]
+		if info.ID != sessionID {
+			continue
+		}
+		// Agents that predate session info don't report start times
+		if !info.StartedAt.IsZero() && info.StartedAt.Before(session.StartedAt.Add(-sessionClockSkew)) {
+			return nil, nil
+		}
+		return info, nil
+	}
+	return nil, nil
+}
+
+// describeSessionEnd says how an agent session ended, for a terminal that
+// can't attach to it.
+func describeSessionEnd(info agentproto.SessionInfo) string {
+	if info.State == agentproto.SessionExited && info.ExitCode != nil {
+		return fmt.Sprintf("Agent exited with status %d", *info.ExitCode)
+	}
+	return "Agent session was lost; its process is no longer running"
+}
+
+// handlePTYAttachWS bridges a WebSocket to a backend's PTY. Unlike
+// cook-agent sessions the terminal lives only as long as the connection.
+func (s *Server) handlePTYAttachWS(w http.ResponseWriter, r *http.Request, pa env.PTYAttacher, initialRows, initialCols uint16) {
+	rows, cols := int(initialRows), int(initialCols)
+	if rows == 0 || cols == 0 {
+		rows, cols = 24, 80
+	}
+	pty, err := pa.AttachPTY(r.Context(), rows, cols)
+	if err != nil {
+		http.Error(w, "Failed to attach terminal: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer pty.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Forward PTY output to WebSocket
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := pty.Read(buf)
+			if n > 0 {
+				if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		conn.Close()
+	}()
+
+	// Read from WebSocket and forward to the PTY
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			return
+		}
+
+		switch messageType {
+		case websocket.BinaryMessage, websocket.TextMessage:
+			var msg wsMessage
+			if err := json.Unmarshal(data, &msg); err == nil && msg.Type != "" {
+				switch msg.Type {
+				case "resize":
+					var resize resizeMsg
+					if err := json.Unmarshal(msg.Data, &resize); err == nil {
+						pa.ResizePTY(int(resize.Rows), int(resize.Cols))
+					}
+				case "input":
+					var input string
+					if err := json.Unmarshal(msg.Data, &input); err == nil {
+						pty.Write([]byte(input))
+					}
+				}
+			} else {
+				pty.Write(data)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package terminal
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIdleAfter is how long a PTY may stay silent before it is considered stuck.
+	DefaultIdleAfter = 10 * time.Minute
+
+	// DefaultRepeatThreshold is how many times the same line may be printed in a row
+	// before the output is considered looping.
+	DefaultRepeatThreshold = 20
+
+	// activityTailBytes is how much recent (ANSI-stripped) output we keep for prompt matching.
+	activityTailBytes = 2048
+)
+
+// StuckReason explains why a PTY looks stuck.
+type StuckReason string
+
+const (
+	StuckNone         StuckReason = ""
+	StuckIdle         StuckReason = "idle"
+	StuckConfirmation StuckReason = "awaiting_confirmation"
+	StuckRepeating    StuckReason = "repeating_output"
+)
+
+// StuckConfig tunes the stuck heuristics. Zero values use the defaults.
+type StuckConfig struct {
+	IdleAfter       time.Duration
+	RepeatThreshold int
+}
+
+// StuckReport is the result of checking an ActivityMonitor.
+type StuckReport struct {
+	Reason       StuckReason `json:"reason,omitempty"`
+	Detail       string      `json:"detail,omitempty"`
+	LastOutputAt time.Time   `json:"last_output_at"`
+}
+
+// Stuck returns true if any heuristic fired.
+func (r StuckReport) Stuck() bool { return r.Reason != StuckNone }
+
+var (
+	// ansiPattern matches CSI/OSC escape sequences and other two-byte escapes.
+	ansiPattern = regexp.MustCompile(`\x1b(\[[0-9;?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+
+	// confirmationPatterns match prompts that block until a human answers.
+	confirmationPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)[\[(]y(es)?/n(o)?[\])]\s*:?\s*$`),
+		regexp.MustCompile(`(?i)do you want to (proceed|continue|allow|make this edit|run this)[^\n]*\?\s*$`),
+		regexp.MustCompile(`(?i)press (enter|any key) to continue[^\n]*$`),
+		regexp.MustCompile(`(?i)(are you sure|allow this)[^\n]*\?\s*$`),
+	}
+)
+
+// ActivityMonitor watches a PTY output stream for signs that the process
+// is stuck: no output for a while, a confirmation prompt, or a loop
+// printing the same line over and over.
+type ActivityMonitor struct {
+	mu           sync.Mutex
+	lastOutputAt time.Time
+	tail         []byte
+	partial      []byte // current unterminated line
+	lastLine     string
+	repeats      int
+}
+
+// NewActivityMonitor returns a monitor that treats now as the last output time.
+func NewActivityMonitor(now time.Time) *ActivityMonitor {
+	return &ActivityMonitor{lastOutputAt: now}
+}
+
+// Observe records a chunk of PTY output.
+func (m *ActivityMonitor) Observe(chunk []byte, now time.Time) {
+	text := ansiPattern.ReplaceAll(chunk, nil)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastOutputAt = now
+
+	m.tail = append(m.tail, text...)
+	if len(m.tail) > activityTailBytes {
+		m.tail = append([]byte(nil), m.tail[len(m.tail)-activityTailBytes:]...)
+	}
+
+	m.partial = append(m.partial, text...)
+	for {
+		i := bytes.IndexByte(m.partial, '\n')
+		if i < 0 {
+			break
+		}
+		m.observeLine(string(bytes.TrimSpace(m.partial[:i])))
+		m.partial = m.partial[i+1:]
+	}
+	if len(m.partial) > activityTailBytes {
+		m.partial = m.partial[len(m.partial)-activityTailBytes:]
+	}
+}
+
+func (m *ActivityMonitor) observeLine(line string) {
+	if line == "" {
+		return
+	}
+	if line == m.lastLine {
+		m.repeats++
+		return
+	}
+	m.lastLine = line
+	m.repeats = 1
+}
+
+// Check evaluates the heuristics at time now.
+func (m *ActivityMonitor) Check(cfg StuckConfig, now time.Time) StuckReport {
+	idleAfter := cfg.IdleAfter
+	if idleAfter <= 0 {
+		idleAfter = DefaultIdleAfter
+	}
+	repeatThreshold := cfg.RepeatThreshold
+	if repeatThreshold <= 0 {
+		repeatThreshold = DefaultRepeatThreshold
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := StuckReport{LastOutputAt: m.lastOutputAt}
+
+	// A prompt is only "waiting" if nothing has been printed after it for a bit;
+	// otherwise we'd flag prompts the agent answers itself.
+	if now.Sub(m.lastOutputAt) >= time.Minute {
+		tail := bytes.TrimRight(m.tail, " \r\n")
+		if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+			tail = tail[i+1:]
+		}
+		for _, p := range confirmationPatterns {
+			if p.Match(tail) {
+				report.Reason = StuckConfirmation
+				report.Detail = string(bytes.TrimSpace(tail))
+				return report
+			}
+		}
+	}
+
+	if m.repeats >= repeatThreshold {
+		report.Reason = StuckRepeating
+		report.Detail = m.lastLine
+		return report
+	}
+
+	if idle := now.Sub(m.lastOutputAt); idle >= idleAfter {
+		report.Reason = StuckIdle
+		report.Detail = "no output for " + idle.Truncate(time.Second).String()
+		return report
+	}
+
+	return report
+}
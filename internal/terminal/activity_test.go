@@ -0,0 +1,62 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActivityMonitorIdle(t *testing.T) {
+	start := time.Now()
+	m := NewActivityMonitor(start)
+	m.Observe([]byte("working...\n"), start)
+
+	cfg := StuckConfig{IdleAfter: 5 * time.Minute}
+	if r := m.Check(cfg, start.Add(time.Minute)); r.Stuck() {
+		t.Fatalf("expected not stuck after 1m, got %+v", r)
+	}
+	if r := m.Check(cfg, start.Add(6*time.Minute)); r.Reason != StuckIdle {
+		t.Fatalf("expected idle after 6m, got %+v", r)
+	}
+}
+
+func TestActivityMonitorConfirmation(t *testing.T) {
+	start := time.Now()
+	m := NewActivityMonitor(start)
+	// Prompt wrapped in color codes, as a TUI would render it.
+	m.Observe([]byte("Overwrite config.json? \x1b[1m[y/N]\x1b[0m "), start)
+
+	if r := m.Check(StuckConfig{}, start.Add(10*time.Second)); r.Stuck() {
+		t.Fatalf("prompt should get a grace period, got %+v", r)
+	}
+	r := m.Check(StuckConfig{}, start.Add(2*time.Minute))
+	if r.Reason != StuckConfirmation {
+		t.Fatalf("expected awaiting_confirmation, got %+v", r)
+	}
+	if !strings.Contains(r.Detail, "[y/N]") {
+		t.Errorf("detail should contain the prompt, got %q", r.Detail)
+	}
+}
+
+func TestActivityMonitorRepeating(t *testing.T) {
+	start := time.Now()
+	m := NewActivityMonitor(start)
+	for i := 0; i < 5; i++ {
+		m.Observe([]byte("Error: connection refused\r\n"), start)
+	}
+	if r := m.Check(StuckConfig{RepeatThreshold: 10}, start); r.Stuck() {
+		t.Fatalf("expected not stuck after 5 repeats, got %+v", r)
+	}
+	for i := 0; i < 5; i++ {
+		m.Observe([]byte("Error: connection refused\r\n"), start)
+	}
+	if r := m.Check(StuckConfig{RepeatThreshold: 10}, start); r.Reason != StuckRepeating {
+		t.Fatalf("expected repeating_output, got %+v", r)
+	}
+
+	// New output resets the loop counter.
+	m.Observe([]byte("retrying with backoff\n"), start)
+	if r := m.Check(StuckConfig{RepeatThreshold: 10}, start); r.Stuck() {
+		t.Fatalf("expected reset after new output, got %+v", r)
+	}
+}
@@ -1,20 +1,23 @@
 package terminal
 
-// ringBuffer keeps the last N bytes appended.
+// RingBuffer keeps the last N bytes appended.
 // It stores raw terminal output (including escape sequences) so late attachers can replay state.
-type ringBuffer struct {
+// It is not safe for concurrent use.
+type RingBuffer struct {
 	maxBytes int
 	buf      []byte
 }
 
-func newRingBuffer(maxBytes int) ringBuffer {
+// NewRingBuffer returns a buffer keeping the last maxBytes (1 MB if <= 0).
+func NewRingBuffer(maxBytes int) RingBuffer {
 	if maxBytes <= 0 {
 		maxBytes = 1024 * 1024
 	}
-	return ringBuffer{maxBytes: maxBytes}
+	return RingBuffer{maxBytes: maxBytes}
 }
 
-func (r *ringBuffer) appendBytes(p []byte) {
+// Append adds p, dropping the oldest bytes beyond the limit.
+func (r *RingBuffer) Append(p []byte) {
 	if len(p) == 0 {
 		return
 	}
@@ -36,6 +39,7 @@ func (r *ringBuffer) appendBytes(p []byte) {
 	r.buf = append(r.buf[drop:], p...)
 }
 
-func (r *ringBuffer) bytes() []byte {
+// Bytes returns a copy of the buffered bytes.
+func (r *RingBuffer) Bytes() []byte {
 	return append([]byte(nil), r.buf...)
 }
@@ -0,0 +1,31 @@
+package terminal
+
+import "testing"
+
+func TestRingBuffer(t *testing.T) {
+	r := NewRingBuffer(8)
+	r.Append([]byte("abc"))
+	r.Append([]byte("defg"))
+	if got := string(r.Bytes()); got != "abcdefg" {
+		t.Fatalf("Bytes() = %q, want %q", got, "abcdefg")
+	}
+
+	// Oldest bytes are dropped to stay within the limit
+	r.Append([]byte("hij"))
+	if got := string(r.Bytes()); got != "cdefghij" {
+		t.Fatalf("Bytes() = %q, want %q", got, "cdefghij")
+	}
+
+	// A chunk larger than the buffer keeps only its tail
+	r.Append([]byte("0123456789"))
+	if got := string(r.Bytes()); got != "23456789" {
+		t.Fatalf("Bytes() = %q, want %q", got, "23456789")
+	}
+
+	// Bytes returns a copy
+	b := r.Bytes()
+	b[0] = 'x'
+	if got := string(r.Bytes()); got != "23456789" {
+		t.Fatalf("Bytes() after modifying copy = %q", got)
+	}
+}
@@ -22,7 +22,7 @@ type Session struct {
 	pty *PTY
 
 	mu        sync.Mutex
-	out       ringBuffer
+	out       RingBuffer
 	subs      map[int]chan []byte
 	nextSubID int
 
@@ -32,15 +32,19 @@ type Session struct {
 	closedAt  time.Time
 
 	startedAt time.Time
+	activity  *ActivityMonitor
+	redact    func([]byte) []byte // masks secrets in replayed output
 }
 
 func newSession(key string, pty *PTY) *Session {
+	now := time.Now()
 	s := &Session{
 		key:       key,
 		pty:       pty,
-		out:       newRingBuffer(DefaultReplayBufferBytes),
+		out:       NewRingBuffer(DefaultReplayBufferBytes),
 		subs:      make(map[int]chan []byte),
-		startedAt: time.Now(),
+		startedAt: now,
+		activity:  NewActivityMonitor(now),
 	}
 	s.startPumps()
 	return s
@@ -67,10 +71,33 @@ func (s *Session) CloseErr() error {
 	return s.closeErr
 }
 
+// CheckStuck runs the stuck heuristics against this session's output.
+func (s *Session) CheckStuck(cfg StuckConfig) StuckReport {
+	return s.activity.Check(cfg, time.Now())
+}
+
+// SetRedact sets a function applied to buffered output before it is
+// replayed, e.g. to mask secret values. Live output is not affected;
+// subscribers mask it as it arrives.
+func (s *Session) SetRedact(redact func([]byte) []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.redact = redact
+}
+
 func (s *Session) Snapshot() []byte {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.out.bytes()
+	return s.replay()
+}
+
+// replay returns the buffered output, redacted. Callers hold s.mu.
+func (s *Session) replay() []byte {
+	out := s.out.Bytes()
+	if s.redact != nil {
+		out = s.redact(out)
+	}
+	return out
 }
 
 // Subscribe returns a snapshot of buffered output and a channel that receives future output.
@@ -79,7 +106,7 @@ func (s *Session) Subscribe() (subID int, snapshot []byte, ch <-chan []byte) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	snapshot = s.out.bytes()
+	snapshot = s.replay()
 
 	subID = s.nextSubID
 	s.nextSubID++
@@ -146,8 +173,9 @@ func (s *Session) pumpOutput() {
 		n, err := s.pty.Read(buf)
 		if n > 0 {
 			chunk := append([]byte(nil), buf[:n]...)
+			s.activity.Observe(chunk, time.Now())
 			s.mu.Lock()
-			s.out.appendBytes(chunk)
+			s.out.Append(chunk)
 			for _, sub := range s.subs {
 				select {
 				case sub <- chunk:
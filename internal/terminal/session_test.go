@@ -37,3 +37,21 @@ func TestSessionReplayAfterReconnect(t *testing.T) {
 		t.Fatalf("expected snapshot to include output while detached; got %q", string(snapshot))
 	}
 }
+
+func TestSessionReplayRedacted(t *testing.T) {
+	mgr := NewManager()
+
+	cmd := exec.Command("sh", "-c", "echo token=hunter2; sleep 0.2")
+	sess, err := mgr.Create("redact-session", cmd)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	defer mgr.Remove("redact-session")
+	sess.SetRedact(func(b []byte) []byte { return bytes.ReplaceAll(b, []byte("hunter2"), []byte("***")) })
+
+	time.Sleep(100 * time.Millisecond)
+	snapshot := sess.Snapshot()
+	if bytes.Contains(snapshot, []byte("hunter2")) || !bytes.Contains(snapshot, []byte("token=***")) {
+		t.Fatalf("expected redacted snapshot; got %q", string(snapshot))
+	}
+}